@@ -3,18 +3,24 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
+	// Embed the time zone database: the alpine runtime image has none, and
+	// the time message type accepts IANA zones.
+	_ "time/tzdata"
 
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 
+	"github.com/marcosartorato/myapp/internal/capture"
 	cfg "github.com/marcosartorato/myapp/internal/config"
 	httpSrv "github.com/marcosartorato/myapp/internal/httpserver"
+	"github.com/marcosartorato/myapp/internal/logging"
 	metricsSrv "github.com/marcosartorato/myapp/internal/metricsserver"
 )
 
@@ -25,6 +31,65 @@ const (
 	envHTTPReadTimeout          = "HTTP_READ_TIMEOUT"
 	envHTTPTimeoutHandler       = "HTTP_TIMEOUT_HANDLER"
 	envHTTPIdleTimeout          = "HTTP_IDLE_TIMEOUT"
+	envHTTPRouteTimeouts        = "HTTP_ROUTE_TIMEOUTS"
+	envHTTPMaxBodyBytes         = "HTTP_MAX_BODY_BYTES"
+	envHTTPRouteMaxBodyBytes    = "HTTP_ROUTE_MAX_BODY_BYTES"
+	envHTTPDocsUI               = "HTTP_DOCS_UI"
+	envHTTPDocsRedocFile        = "HTTP_DOCS_REDOC_FILE"
+	envHTTPValidateResponses    = "HTTP_VALIDATE_RESPONSES"
+	envHTTPRateLimits           = "HTTP_RATE_LIMITS"
+	envHTTPRateLimitKey         = "HTTP_RATE_LIMIT_KEY"
+	envHTTPRateLimitMaxKeys     = "HTTP_RATE_LIMIT_MAX_KEYS"
+	envHTTPConcurrencyLimits    = "HTTP_CONCURRENCY_LIMITS"
+	envHTTPIdempotencyTTL       = "HTTP_IDEMPOTENCY_TTL"
+	envHTTPIdempotencyMaxKeys   = "HTTP_IDEMPOTENCY_MAX_KEYS"
+	envMessageStorePath         = "MESSAGE_STORE_PATH"
+	envMessageStoreMaxMessages  = "MESSAGE_STORE_MAX_MESSAGES"
+	envScheduleStorePath        = "SCHEDULE_STORE_PATH"
+	envScheduleWebhookURL       = "SCHEDULE_WEBHOOK_URL"
+	envScheduleMaxPending       = "SCHEDULE_MAX_PENDING"
+	envWebhooks                 = "WEBHOOKS"
+	envWebhookSigningKeyFile    = "WEBHOOK_SIGNING_KEY_FILE"
+	envWebhookMaxAttempts       = "WEBHOOK_MAX_ATTEMPTS"
+	envWebhookBackoff           = "WEBHOOK_BACKOFF"
+	envWebhookMaxDeadLetters    = "WEBHOOK_MAX_DEAD_LETTERS"
+	envPubSubMaxSubscriptions   = "PUBSUB_MAX_SUBSCRIPTIONS"
+	envPubSubMaxPerPrincipal    = "PUBSUB_MAX_SUBSCRIPTIONS_PER_PRINCIPAL"
+	envHelloTemplatesFile       = "HELLO_TEMPLATES_FILE"
+	envCORSAllowedOrigins       = "CORS_ALLOWED_ORIGINS"
+	envCORSRouteOrigins         = "CORS_ROUTE_ORIGINS"
+	envCORSAllowedMethods       = "CORS_ALLOWED_METHODS"
+	envCORSAllowedHeaders       = "CORS_ALLOWED_HEADERS"
+	envCORSExposedHeaders       = "CORS_EXPOSED_HEADERS"
+	envCORSAllowCredentials     = "CORS_ALLOW_CREDENTIALS"
+	envCORSMaxAge               = "CORS_MAX_AGE"
+	envTLSCertFile              = "TLS_CERT_FILE"
+	envTLSKeyFile               = "TLS_KEY_FILE"
+	envHSTSMaxAge               = "HSTS_MAX_AGE"
+	envHSTSIncludeSubdomains    = "HSTS_INCLUDE_SUBDOMAINS"
+	envFrameOptions             = "FRAME_OPTIONS"
+	envReferrerPolicy           = "REFERRER_POLICY"
+	envPermissionsPolicy        = "PERMISSIONS_POLICY"
+	envCSPDirectives            = "CSP_DIRECTIVES"
+	envCSPReportOnly            = "CSP_REPORT_ONLY"
+	envAccessLogFormat          = "ACCESS_LOG_FORMAT"
+	envAccessLogSampleRate      = "ACCESS_LOG_SAMPLE_RATE"
+	envAccessLogSlowThreshold   = "ACCESS_LOG_SLOW_THRESHOLD"
+	envCaptureFile              = "CAPTURE_FILE"
+	envCaptureSampleRate        = "CAPTURE_SAMPLE_RATE"
+	envCaptureRoutes            = "CAPTURE_ROUTES"
+	envCaptureMaxBodyBytes      = "CAPTURE_MAX_BODY_BYTES"
+	envCaptureRedactHeaders     = "CAPTURE_REDACT_HEADERS"
+	envAuthAPIKeysFile          = "AUTH_API_KEYS_FILE"
+	envAuthJWKS                 = "AUTH_JWKS"
+	envAuthIssuer               = "AUTH_ISSUER"
+	envAuthAudience             = "AUTH_AUDIENCE"
+	envAuthClockSkew            = "AUTH_CLOCK_SKEW"
+	envAuthHMACKeysFile         = "AUTH_HMAC_KEYS_FILE"
+	envAuthHMACWindow           = "AUTH_HMAC_WINDOW"
+	envCompressionEncodings     = "COMPRESSION_ENCODINGS"
+	envCompressionMinSize       = "COMPRESSION_MIN_SIZE"
+	envCompressionTypes         = "COMPRESSION_TYPES"
 	envMetricsPort              = "METRICS_PORT"
 	envMetricsHost              = "METRICS_HOST"
 	envMetricsReadHeaderTimeout = "METRICS_READ_HEADER_TIMEOUT"
@@ -32,6 +97,32 @@ const (
 	envMetricsTimeoutHandler    = "METRICS_TIMEOUT_HANDLER"
 	envMetricsIdleTimeout       = "METRICS_IDLE_TIMEOUT"
 	envLogLevel                 = "LOG_LEVEL"
+	envLogEncoding              = "LOG_ENCODING"
+	envLogOutputs               = "LOG_OUTPUTS"
+	envLogFileMaxSize           = "LOG_FILE_MAX_SIZE"
+	envLogFileMaxAge            = "LOG_FILE_MAX_AGE"
+	envLogFileMaxBackups        = "LOG_FILE_MAX_BACKUPS"
+	envLogFileCompress          = "LOG_FILE_COMPRESS"
+	envLogSampling              = "LOG_SAMPLING"
+	envLogFieldNames            = "LOG_FIELD_NAMES"
+	envLogTimeEncoding          = "LOG_TIME_ENCODING"
+	envLogCaller                = "LOG_CALLER"
+	envLogStacktraceLevel       = "LOG_STACKTRACE_LEVEL"
+	envLogRedactKeys            = "LOG_REDACT_KEYS"
+	envLogRedactValues          = "LOG_REDACT_VALUES"
+	envLogRedactRegexp          = "LOG_REDACT_REGEXP"
+	envLogPayloadKeys           = "LOG_PAYLOAD_KEYS"
+	envLogPayloadMode           = "LOG_PAYLOAD_MODE"
+	envLogPayloadMaxLength      = "LOG_PAYLOAD_MAX_LENGTH"
+	envLokiURL                  = "LOKI_URL"
+	envLokiTenantID             = "LOKI_TENANT_ID"
+	envLokiLabels               = "LOKI_LABELS"
+	envLokiBatchSize            = "LOKI_BATCH_SIZE"
+	envLokiFlushInterval        = "LOKI_FLUSH_INTERVAL"
+	envLokiBufferSize           = "LOKI_BUFFER_SIZE"
+	envLokiDropPolicy           = "LOKI_DROP_POLICY"
+	envLokiMaxRetries           = "LOKI_MAX_RETRIES"
+	envLokiBackoff              = "LOKI_BACKOFF"
 	defaultTimeoutMs            = 1000
 )
 
@@ -55,7 +146,35 @@ func envOrDefaultInt64(key string, def int64) int64 {
 	return def
 }
 
+// envOrDefaultFloat64 like envOrDefaultString but also check the env value is a valid float64.
+func envOrDefaultFloat64(key string, def float64) float64 {
+	if vStr := os.Getenv(key); vStr != "" {
+		vFloat, err := strconv.ParseFloat(vStr, 64)
+		if err == nil {
+			return vFloat
+		}
+		// else fall through to return def
+	}
+	return def
+}
+
+// envOrDefaultBool like envOrDefaultString but also check the env value is a valid bool.
+func envOrDefaultBool(key string, def bool) bool {
+	if vStr := os.Getenv(key); vStr != "" {
+		vBool, err := strconv.ParseBool(vStr)
+		if err == nil {
+			return vBool
+		}
+		// else fall through to return def
+	}
+	return def
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay(os.Args[2:]))
+	}
+
 	// Get configuration
 	httpHost := flag.String("http-host", envOrDefaultStr(envHTTPHost, "localhost"), "host for the HTTP server (also via "+envHTTPHost+")")
 	httpPort := flag.String("http-port", envOrDefaultStr(envHTTPPort, "8080"), "port for the HTTP server (also via "+envHTTPPort+")")
@@ -63,6 +182,70 @@ func main() {
 	httpReadTimeout := flag.Int64("http-read-timeout", envOrDefaultInt64(envHTTPReadTimeout, defaultTimeoutMs), "max amount of time to read the entire request (also via "+envHTTPReadTimeout+")")
 	httpTimeoutHandler := flag.Int64("http-timeout-handler", envOrDefaultInt64(envHTTPTimeoutHandler, defaultTimeoutMs), "max amount of time for a handler to complete (also via "+envHTTPTimeoutHandler+")")
 	httpIdleTimeout := flag.Int64("http-idle-timeout", envOrDefaultInt64(envHTTPIdleTimeout, defaultTimeoutMs), "max amount of time to wait for the next request when keep-alives are enabled (also via "+envHTTPIdleTimeout+")")
+	httpRouteTimeouts := flag.String("http-route-timeouts", envOrDefaultStr(envHTTPRouteTimeouts, ""), "per-route handler timeouts as route=duration[,...], e.g. /api/message=5s (also via "+envHTTPRouteTimeouts+")")
+	httpMaxBodyBytes := flag.Int64("http-max-body-bytes", envOrDefaultInt64(envHTTPMaxBodyBytes, 1<<20), "max size of request bodies in bytes, 0 for no limit (also via "+envHTTPMaxBodyBytes+")")
+	httpRouteMaxBodyBytes := flag.String("http-route-max-body-bytes", envOrDefaultStr(envHTTPRouteMaxBodyBytes, ""), "per-route request body limits as route=bytes[,...] (also via "+envHTTPRouteMaxBodyBytes+")")
+	httpDocsUI := flag.Bool("http-docs-ui", envOrDefaultBool(envHTTPDocsUI, false), "serve the API reference page on /docs (also via "+envHTTPDocsUI+")")
+	httpDocsRedocFile := flag.String("http-docs-redoc-file", envOrDefaultStr(envHTTPDocsRedocFile, ""), "Redoc standalone bundle served for the API reference page, instead of loading it from its CDN (also via "+envHTTPDocsRedocFile+")")
+	httpValidateResponses := flag.Bool("http-validate-responses", envOrDefaultBool(envHTTPValidateResponses, false), "validate responses against the OpenAPI document, for debugging (also via "+envHTTPValidateResponses+")")
+	httpRateLimits := flag.String("http-rate-limits", envOrDefaultStr(envHTTPRateLimits, ""), "per-route token buckets as route=rate:burst[,...], e.g. /api/message=10:20 (also via "+envHTTPRateLimits+")")
+	httpRateLimitKey := flag.String("http-rate-limit-key", envOrDefaultStr(envHTTPRateLimitKey, "ip"), "how rate-limited clients are identified: ip, api-key or header:<name> (also via "+envHTTPRateLimitKey+")")
+	httpRateLimitMaxKeys := flag.Int64("http-rate-limit-max-keys", envOrDefaultInt64(envHTTPRateLimitMaxKeys, 10000), "max number of clients tracked per rate-limited route (also via "+envHTTPRateLimitMaxKeys+")")
+	httpConcurrencyLimits := flag.String("http-concurrency-limits", envOrDefaultStr(envHTTPConcurrencyLimits, ""), "per-route adaptive concurrency limits as route=initial:max:target[,...], e.g. /api/message=10:100:250ms (also via "+envHTTPConcurrencyLimits+")")
+	httpIdempotencyTTL := flag.Int64("http-idempotency-ttl", envOrDefaultInt64(envHTTPIdempotencyTTL, 86400000), "how long responses are kept for Idempotency-Key retries, in milliseconds, 0 to disable (also via "+envHTTPIdempotencyTTL+")")
+	httpIdempotencyMaxKeys := flag.Int64("http-idempotency-max-keys", envOrDefaultInt64(envHTTPIdempotencyMaxKeys, 10000), "max number of Idempotency-Key responses kept, the least recently used being dropped first (also via "+envHTTPIdempotencyMaxKeys+")")
+	messageStorePath := flag.String("message-store-path", envOrDefaultStr(envMessageStorePath, ""), "database file storing the messages, kept in memory if empty (also via "+envMessageStorePath+")")
+	messageStoreMaxMessages := flag.Int64("message-store-max-messages", envOrDefaultInt64(envMessageStoreMaxMessages, 10000), "max number of messages kept in memory, the oldest being removed first, when no store path is set (also via "+envMessageStoreMaxMessages+")")
+	scheduleStorePath := flag.String("schedule-store-path", envOrDefaultStr(envScheduleStorePath, ""), "database file storing pending scheduled messages, kept in memory if empty (also via "+envScheduleStorePath+")")
+	scheduleWebhookURL := flag.String("schedule-webhook-url", envOrDefaultStr(envScheduleWebhookURL, ""), "URL due scheduled messages are POSTed to, if set (also via "+envScheduleWebhookURL+")")
+	scheduleMaxPending := flag.Int64("schedule-max-pending", envOrDefaultInt64(envScheduleMaxPending, 10000), "max number of pending scheduled messages, scheduling more answering 429 (also via "+envScheduleMaxPending+")")
+	webhooks := flag.String("webhooks", envOrDefaultStr(envWebhooks, ""), "URLs notified of processed messages as type=url[,...], * matching all types (also via "+envWebhooks+")")
+	webhookSigningKeyFile := flag.String("webhook-signing-key-file", envOrDefaultStr(envWebhookSigningKeyFile, ""), "file holding the '<key id> <hex secret>' webhook deliveries are signed with (also via "+envWebhookSigningKeyFile+")")
+	webhookMaxAttempts := flag.Int64("webhook-max-attempts", envOrDefaultInt64(envWebhookMaxAttempts, 5), "attempts before a webhook delivery is dead-lettered (also via "+envWebhookMaxAttempts+")")
+	webhookBackoff := flag.Int64("webhook-backoff", envOrDefaultInt64(envWebhookBackoff, 1000), "delay before the first webhook retry in milliseconds, doubling with each retry (also via "+envWebhookBackoff+")")
+	webhookMaxDeadLetters := flag.Int64("webhook-max-dead-letters", envOrDefaultInt64(envWebhookMaxDeadLetters, 1000), "failed webhook deliveries kept for replay, the oldest being dropped first (also via "+envWebhookMaxDeadLetters+")")
+	pubSubMaxSubscriptions := flag.Int64("pubsub-max-subscriptions", envOrDefaultInt64(envPubSubMaxSubscriptions, 1000), "open pub/sub subscriptions allowed in total (also via "+envPubSubMaxSubscriptions+")")
+	pubSubMaxPerPrincipal := flag.Int64("pubsub-max-subscriptions-per-principal", envOrDefaultInt64(envPubSubMaxPerPrincipal, 16), "open pub/sub subscriptions allowed per authenticated caller (also via "+envPubSubMaxPerPrincipal+")")
+	helloTemplatesFile := flag.String("hello-templates-file", envOrDefaultStr(envHelloTemplatesFile, ""), "JSON file of /hello greeting templates by locale, overriding the built-in ones (also via "+envHelloTemplatesFile+")")
+
+	corsAllowedOrigins := flag.String("cors-allowed-origins", envOrDefaultStr(envCORSAllowedOrigins, ""), "origins allowed to call the API cross-origin, e.g. https://app.example.com,https://*.example.com; enables CORS (also via "+envCORSAllowedOrigins+")")
+	corsRouteOrigins := flag.String("cors-route-origins", envOrDefaultStr(envCORSRouteOrigins, ""), "per-route CORS origins as route=origin origin...[,...], route= disabling CORS on the route (also via "+envCORSRouteOrigins+")")
+	corsAllowedMethods := flag.String("cors-allowed-methods", envOrDefaultStr(envCORSAllowedMethods, ""), "methods allowed cross-origin, the route's methods if empty (also via "+envCORSAllowedMethods+")")
+	corsAllowedHeaders := flag.String("cors-allowed-headers", envOrDefaultStr(envCORSAllowedHeaders, "Authorization,Content-Type,Idempotency-Key,X-API-Key"), "request headers allowed cross-origin, * for any (also via "+envCORSAllowedHeaders+")")
+	corsExposedHeaders := flag.String("cors-exposed-headers", envOrDefaultStr(envCORSExposedHeaders, "Idempotent-Replayed,RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset,Retry-After"), "response headers readable by cross-origin scripts (also via "+envCORSExposedHeaders+")")
+	corsAllowCredentials := flag.Bool("cors-allow-credentials", envOrDefaultBool(envCORSAllowCredentials, false), "allow cross-origin requests with credentials (also via "+envCORSAllowCredentials+")")
+	corsMaxAge := flag.Int64("cors-max-age", envOrDefaultInt64(envCORSMaxAge, 600000), "how long browsers may cache CORS preflight responses, in milliseconds (also via "+envCORSMaxAge+")")
+
+	tlsCertFile := flag.String("tls-cert-file", envOrDefaultStr(envTLSCertFile, ""), "PEM certificate file; serves the API over TLS along with -tls-key-file (also via "+envTLSCertFile+")")
+	tlsKeyFile := flag.String("tls-key-file", envOrDefaultStr(envTLSKeyFile, ""), "PEM private key file of the TLS certificate (also via "+envTLSKeyFile+")")
+	hstsMaxAge := flag.Int64("hsts-max-age", envOrDefaultInt64(envHSTSMaxAge, 31536000000), "Strict-Transport-Security max-age sent over TLS, in milliseconds, 0 to disable (also via "+envHSTSMaxAge+")")
+	hstsIncludeSubdomains := flag.Bool("hsts-include-subdomains", envOrDefaultBool(envHSTSIncludeSubdomains, false), "extend HSTS to subdomains (also via "+envHSTSIncludeSubdomains+")")
+	frameOptions := flag.String("frame-options", envOrDefaultStr(envFrameOptions, "DENY"), "X-Frame-Options header: DENY, SAMEORIGIN or empty (also via "+envFrameOptions+")")
+	referrerPolicy := flag.String("referrer-policy", envOrDefaultStr(envReferrerPolicy, "no-referrer"), "Referrer-Policy header, empty for none (also via "+envReferrerPolicy+")")
+	permissionsPolicy := flag.String("permissions-policy", envOrDefaultStr(envPermissionsPolicy, "camera=(), geolocation=(), microphone=()"), "Permissions-Policy header, empty for none (also via "+envPermissionsPolicy+")")
+	cspDirectives := flag.String("csp-directives", envOrDefaultStr(envCSPDirectives, "default-src='none',base-uri='none',form-action='none',frame-ancestors='none'"), "Content-Security-Policy as directive=sources[,...], empty to disable (also via "+envCSPDirectives+")")
+	cspReportOnly := flag.Bool("csp-report-only", envOrDefaultBool(envCSPReportOnly, false), "report Content-Security-Policy violations to /csp-report without blocking them (also via "+envCSPReportOnly+")")
+
+	accessLogFormat := flag.String("access-log-format", envOrDefaultStr(envAccessLogFormat, "json"), "access log format: json, combined or logfmt, empty to disable (also via "+envAccessLogFormat+")")
+	accessLogSampleRate := flag.Float64("access-log-sample-rate", envOrDefaultFloat64(envAccessLogSampleRate, 1), "fraction of successful requests logged; errors and slow requests always are (also via "+envAccessLogSampleRate+")")
+	accessLogSlowThreshold := flag.Int64("access-log-slow-threshold", envOrDefaultInt64(envAccessLogSlowThreshold, 1000), "duration from which requests are always logged, in milliseconds, 0 for none (also via "+envAccessLogSlowThreshold+")")
+	captureFile := flag.String("capture-file", envOrDefaultStr(envCaptureFile, ""), "file sampled requests and responses are recorded to as JSON Lines, for myapp replay; empty to not capture (also via "+envCaptureFile+")")
+	captureSampleRate := flag.Float64("capture-sample-rate", envOrDefaultFloat64(envCaptureSampleRate, 0.01), "fraction of requests captured, between 0 and 1 (also via "+envCaptureSampleRate+")")
+	captureRoutes := flag.String("capture-routes", envOrDefaultStr(envCaptureRoutes, "/api/message"), "routes whose requests are captured, all but event streams if empty (also via "+envCaptureRoutes+")")
+	captureMaxBodyBytes := flag.Int64("capture-max-body-bytes", envOrDefaultInt64(envCaptureMaxBodyBytes, 64<<10), "size up to which bodies are captured, in bytes (also via "+envCaptureMaxBodyBytes+")")
+	captureRedactHeaders := flag.String("capture-redact-headers", envOrDefaultStr(envCaptureRedactHeaders, strings.Join(capture.DefaultRedactHeaders, ",")), "headers whose values are redacted in captures (also via "+envCaptureRedactHeaders+")")
+
+	authAPIKeysFile := flag.String("auth-api-keys-file", envOrDefaultStr(envAuthAPIKeysFile, ""), "file of hashed API keys; enables API key authentication (also via "+envAuthAPIKeysFile+")")
+	authJWKS := flag.String("auth-jwks", envOrDefaultStr(envAuthJWKS, ""), "JWKS file path or URL; enables JWT bearer authentication (also via "+envAuthJWKS+")")
+	authIssuer := flag.String("auth-issuer", envOrDefaultStr(envAuthIssuer, ""), "required JWT issuer, if set (also via "+envAuthIssuer+")")
+	authAudience := flag.String("auth-audience", envOrDefaultStr(envAuthAudience, ""), "required JWT audience, if set (also via "+envAuthAudience+")")
+	authClockSkew := flag.Int64("auth-clock-skew", envOrDefaultInt64(envAuthClockSkew, 30000), "clock skew tolerated on JWT validity, in milliseconds (also via "+envAuthClockSkew+")")
+	authHMACKeysFile := flag.String("auth-hmac-keys-file", envOrDefaultStr(envAuthHMACKeysFile, ""), "file of HMAC request signing keys; enables signed requests (also via "+envAuthHMACKeysFile+")")
+	authHMACWindow := flag.Int64("auth-hmac-window", envOrDefaultInt64(envAuthHMACWindow, 300000), "accepted age of signed requests, in milliseconds (also via "+envAuthHMACWindow+")")
+
+	compressionEncodings := flag.String("compression-encodings", envOrDefaultStr(envCompressionEncodings, "zstd,br,gzip"), "response codings in preference order, empty to disable compression (also via "+envCompressionEncodings+")")
+	compressionMinSize := flag.Int64("compression-min-size", envOrDefaultInt64(envCompressionMinSize, 1024), "min response size to compress, in bytes (also via "+envCompressionMinSize+")")
+	compressionTypes := flag.String("compression-types", envOrDefaultStr(envCompressionTypes, "application/json,application/openmetrics-text,text/"), "compressible media types; a trailing / matches a whole type (also via "+envCompressionTypes+")")
 
 	metricsHost := flag.String("metrics-host", envOrDefaultStr(envMetricsHost, "localhost"), "host for the metrics server (also via "+envMetricsHost+")")
 	metricsPort := flag.String("metrics-port", envOrDefaultStr(envMetricsPort, "9090"), "port for the metrics server (also via "+envMetricsPort+")")
@@ -71,39 +254,155 @@ func main() {
 	metricsTimeoutHandler := flag.Int64("metrics-timeout-handler", envOrDefaultInt64(envMetricsTimeoutHandler, defaultTimeoutMs), "max amount of time for a handler to complete (also via "+envHTTPTimeoutHandler+")")
 	metricsIdleTimeout := flag.Int64("metrics-idle-timeout", envOrDefaultInt64(envMetricsIdleTimeout, defaultTimeoutMs), "max amount of time to wait for the next request when keep-alives are enabled (also via "+envMetricsIdleTimeout+")")
 
-	logLevel := flag.String("log-level", envOrDefaultStr(envLogLevel, "info"), "logging level (debug, info, warn, error)")
+	logLevel := flag.String("log-level", envOrDefaultStr(envLogLevel, "info"), "logging level (debug, info, warn, error) (also via "+envLogLevel+")")
+	logEncoding := flag.String("log-encoding", envOrDefaultStr(envLogEncoding, "json"), "log encoding: json or console (also via "+envLogEncoding+")")
+	logOutputs := flag.String("log-outputs", envOrDefaultStr(envLogOutputs, "stdout"), "comma-separated log outputs: stdout, stderr or file paths (also via "+envLogOutputs+")")
+	logFileMaxSize := flag.Int64("log-file-max-size", envOrDefaultInt64(envLogFileMaxSize, 100), "size from which log files are rotated, in megabytes, 0 for no rotation (also via "+envLogFileMaxSize+")")
+	logFileMaxAge := flag.Int64("log-file-max-age", envOrDefaultInt64(envLogFileMaxAge, 604800000), "age from which rotated log files are removed, in milliseconds rounded up to days, 0 to keep them (also via "+envLogFileMaxAge+")")
+	logFileMaxBackups := flag.Int64("log-file-max-backups", envOrDefaultInt64(envLogFileMaxBackups, 10), "number of rotated log files kept, 0 to keep them all (also via "+envLogFileMaxBackups+")")
+	logFileCompress := flag.Bool("log-file-compress", envOrDefaultBool(envLogFileCompress, true), "gzip rotated log files (also via "+envLogFileCompress+")")
+	logSampling := flag.String("log-sampling", envOrDefaultStr(envLogSampling, "100:100"), "log the first initial entries with the same message each second, then every thereafter-th, as initial:thereafter; empty to disable (also via "+envLogSampling+")")
+	logFieldNames := flag.String("log-field-names", envOrDefaultStr(envLogFieldNames, ""), "renamed log fields as field=name[,...], fields being time, level, message, caller, stacktrace and name (also via "+envLogFieldNames+")")
+	logTimeEncoding := flag.String("log-time-encoding", envOrDefaultStr(envLogTimeEncoding, "epoch"), "log time encoding: epoch, millis, nanos, iso8601, rfc3339 or rfc3339nano (also via "+envLogTimeEncoding+")")
+	logCaller := flag.Bool("log-caller", envOrDefaultBool(envLogCaller, true), "add the calling file and line to log entries (also via "+envLogCaller+")")
+	logStacktraceLevel := flag.String("log-stacktrace-level", envOrDefaultStr(envLogStacktraceLevel, "error"), "level from which log entries get a stack trace, empty for none (also via "+envLogStacktraceLevel+")")
+	logRedactKeys := flag.String("log-redact-keys", envOrDefaultStr(envLogRedactKeys, "authorization,cookie,password,secret,token,api_key,apikey"), "fragments of log field keys whose values are redacted, regardless of case and separators (also via "+envLogRedactKeys+")")
+	logRedactValues := flag.String("log-redact-values", envOrDefaultStr(envLogRedactValues, "email,card,bearer"), "built-in rules redacting values in logged strings: email, card, bearer (also via "+envLogRedactValues+")")
+	logRedactRegexp := flag.String("log-redact-regexp", envOrDefaultStr(envLogRedactRegexp, ""), "regular expression whose matches are redacted in logged strings (also via "+envLogRedactRegexp+")")
+	logPayloadKeys := flag.String("log-payload-keys", envOrDefaultStr(envLogPayloadKeys, "msg"), "log field keys holding message payloads, never logged raw (also via "+envLogPayloadKeys+")")
+	logPayloadMode := flag.String("log-payload-mode", envOrDefaultStr(envLogPayloadMode, "hash"), "how payloads are logged: hash or truncate (also via "+envLogPayloadMode+")")
+	logPayloadMaxLength := flag.Int64("log-payload-max-length", envOrDefaultInt64(envLogPayloadMaxLength, 32), "characters of truncated payloads logged (also via "+envLogPayloadMaxLength+")")
+	lokiURL := flag.String("loki-url", envOrDefaultStr(envLokiURL, ""), "Loki push endpoint logs are also shipped to, e.g. http://localhost:3100/loki/api/v1/push; empty to not ship them (also via "+envLokiURL+")")
+	lokiTenantID := flag.String("loki-tenant-id", envOrDefaultStr(envLokiTenantID, ""), "tenant sent as X-Scope-OrgID to multi-tenant Loki (also via "+envLokiTenantID+")")
+	lokiLabels := flag.String("loki-labels", envOrDefaultStr(envLokiLabels, "app=myapp"), "labels of the Loki streams as name=value, besides level (also via "+envLokiLabels+")")
+	lokiBatchSize := flag.Int64("loki-batch-size", envOrDefaultInt64(envLokiBatchSize, 1000), "entries pushed to Loki at most at once (also via "+envLokiBatchSize+")")
+	lokiFlushInterval := flag.Int64("loki-flush-interval", envOrDefaultInt64(envLokiFlushInterval, 1000), "maximum delay in ms before entries are pushed to Loki (also via "+envLokiFlushInterval+")")
+	lokiBufferSize := flag.Int64("loki-buffer-size", envOrDefaultInt64(envLokiBufferSize, 10000), "entries waiting to be pushed to Loki at most (also via "+envLokiBufferSize+")")
+	lokiDropPolicy := flag.String("loki-drop-policy", envOrDefaultStr(envLokiDropPolicy, "drop_oldest"), "entries dropped when the Loki buffer is full: drop_oldest or drop_newest (also via "+envLokiDropPolicy+")")
+	lokiMaxRetries := flag.Int64("loki-max-retries", envOrDefaultInt64(envLokiMaxRetries, 5), "retries of failed pushes to Loki (also via "+envLokiMaxRetries+")")
+	lokiBackoff := flag.Int64("loki-backoff", envOrDefaultInt64(envLokiBackoff, 500), "delay in ms before the first retry of a push to Loki, doubling with each retry (also via "+envLokiBackoff+")")
 
 	flag.Parse()
 
-	// Create logger based on the log level.
-	level, err := zapcore.ParseLevel(*logLevel)
-	if err != nil {
-		log.Fatalf("invalid log level: %v", err)
+	// Create logger; there is none to report its configuration errors yet.
+	// The access log of the app server is redacted like logs.
+	redaction := []cfg.Option{
+		cfg.WithLogRedactKeys(*logRedactKeys),
+		cfg.WithLogRedactValues(*logRedactValues),
+		cfg.WithLogRedactRegexp(*logRedactRegexp),
+		cfg.WithLogPayloadKeys(*logPayloadKeys),
+		cfg.WithLogPayloadMode(*logPayloadMode),
+		cfg.WithLogPayloadMaxLength(*logPayloadMaxLength),
 	}
-	zapConfig := zap.NewProductionConfig()
-	zapConfig.OutputPaths = []string{"stdout"}
-	zapConfig.Level = zap.NewAtomicLevelAt(level)
-	logger, err := zapConfig.Build()
-	logger.Info("Logger initialized", zap.String("level", level.String()))
+	var logOptions cfg.Options
+	for _, opt := range append([]cfg.Option{
+		cfg.WithLogLevel(*logLevel),
+		cfg.WithLogEncoding(*logEncoding),
+		cfg.WithLogOutputs(*logOutputs),
+		cfg.WithLogFileMaxSize(*logFileMaxSize),
+		cfg.WithLogFileMaxAge(*logFileMaxAge),
+		cfg.WithLogFileMaxBackups(*logFileMaxBackups),
+		cfg.WithLogFileCompress(*logFileCompress),
+		cfg.WithLogSampling(*logSampling),
+		cfg.WithLogFieldNames(*logFieldNames),
+		cfg.WithLogTimeEncoding(*logTimeEncoding),
+		cfg.WithLogCaller(*logCaller),
+		cfg.WithLogStacktraceLevel(*logStacktraceLevel),
+		cfg.WithLokiURL(*lokiURL),
+		cfg.WithLokiTenantID(*lokiTenantID),
+		cfg.WithLokiLabels(*lokiLabels),
+		cfg.WithLokiBatchSize(*lokiBatchSize),
+		cfg.WithLokiFlushInterval(*lokiFlushInterval),
+		cfg.WithLokiBufferSize(*lokiBufferSize),
+		cfg.WithLokiDropPolicy(*lokiDropPolicy),
+		cfg.WithLokiMaxRetries(*lokiMaxRetries),
+		cfg.WithLokiBackoff(*lokiBackoff),
+	}, redaction...) {
+		if err := opt(&logOptions); err != nil {
+			log.Fatalf("invalid logger configuration: %v", err)
+		}
+	}
+	logger, closeLogger, err := logging.New(logOptions)
 	if err != nil {
-		log.Fatalf("invalid log level: %v", err)
+		log.Fatalf("invalid logger configuration: %v", err)
 	}
-	defer func() { // flushes buffer, if any
-		err := logger.Sync()
-		if err != nil {
-			log.Fatalf("error flushing logger buffer: %v", err)
+	logger.Info("Logger initialized", zap.String("level", logOptions.LogLevel.String()))
+	flushLogs := func() { // flushes buffer, if any
+		if err := closeLogger(); err != nil {
+			fmt.Fprintf(os.Stderr, "error flushing logs: %v\n", err)
 		}
-	}()
+	}
+	defer flushLogs()
 
 	// Start servers
 	srvShutdown := httpSrv.RunServerWithShutdown(
 		logger,
-		cfg.WithHost(httpHost),
-		cfg.WithPort(httpPort),
-		cfg.WithReadHeaderTimeout(*httpReadHeaderTimeout),
-		cfg.WithReadTimeout(*httpReadTimeout),
-		cfg.WithTimeoutHandler(*httpTimeoutHandler),
-		cfg.WithIdleTimeout(*httpIdleTimeout),
+		append(redaction,
+			cfg.WithHost(httpHost),
+			cfg.WithPort(httpPort),
+			cfg.WithReadHeaderTimeout(*httpReadHeaderTimeout),
+			cfg.WithReadTimeout(*httpReadTimeout),
+			cfg.WithTimeoutHandler(*httpTimeoutHandler),
+			cfg.WithIdleTimeout(*httpIdleTimeout),
+			cfg.WithRouteTimeouts(*httpRouteTimeouts),
+			cfg.WithMaxBodyBytes(*httpMaxBodyBytes),
+			cfg.WithRouteMaxBodyBytes(*httpRouteMaxBodyBytes),
+			cfg.WithDocsUI(*httpDocsUI),
+			cfg.WithDocsRedocFile(*httpDocsRedocFile),
+			cfg.WithValidateResponses(*httpValidateResponses),
+			cfg.WithRateLimits(*httpRateLimits),
+			cfg.WithRateLimitKey(*httpRateLimitKey),
+			cfg.WithRateLimitMaxKeys(*httpRateLimitMaxKeys),
+			cfg.WithConcurrencyLimits(*httpConcurrencyLimits),
+			cfg.WithIdempotencyTTL(*httpIdempotencyTTL),
+			cfg.WithIdempotencyMaxKeys(*httpIdempotencyMaxKeys),
+			cfg.WithMessageStorePath(*messageStorePath),
+			cfg.WithMessageStoreMaxMessages(*messageStoreMaxMessages),
+			cfg.WithScheduleStorePath(*scheduleStorePath),
+			cfg.WithScheduleWebhookURL(*scheduleWebhookURL),
+			cfg.WithScheduleMaxPending(*scheduleMaxPending),
+			cfg.WithWebhooks(*webhooks),
+			cfg.WithWebhookSigningKeyFile(*webhookSigningKeyFile),
+			cfg.WithWebhookMaxAttempts(*webhookMaxAttempts),
+			cfg.WithWebhookBackoff(*webhookBackoff),
+			cfg.WithWebhookMaxDeadLetters(*webhookMaxDeadLetters),
+			cfg.WithPubSubMaxSubscriptions(*pubSubMaxSubscriptions),
+			cfg.WithPubSubMaxSubscriptionsPerPrincipal(*pubSubMaxPerPrincipal),
+			cfg.WithHelloTemplatesFile(*helloTemplatesFile),
+			cfg.WithCORSAllowedOrigins(*corsAllowedOrigins),
+			cfg.WithCORSRouteOrigins(*corsRouteOrigins),
+			cfg.WithCORSAllowedMethods(*corsAllowedMethods),
+			cfg.WithCORSAllowedHeaders(*corsAllowedHeaders),
+			cfg.WithCORSExposedHeaders(*corsExposedHeaders),
+			cfg.WithCORSAllowCredentials(*corsAllowCredentials),
+			cfg.WithCORSMaxAge(*corsMaxAge),
+			cfg.WithTLS(*tlsCertFile, *tlsKeyFile),
+			cfg.WithHSTSMaxAge(*hstsMaxAge),
+			cfg.WithHSTSIncludeSubdomains(*hstsIncludeSubdomains),
+			cfg.WithFrameOptions(*frameOptions),
+			cfg.WithReferrerPolicy(*referrerPolicy),
+			cfg.WithPermissionsPolicy(*permissionsPolicy),
+			cfg.WithCSPDirectives(*cspDirectives),
+			cfg.WithCSPReportOnly(*cspReportOnly),
+			cfg.WithAccessLogFormat(*accessLogFormat),
+			cfg.WithAccessLogSampleRate(*accessLogSampleRate),
+			cfg.WithAccessLogSlowThreshold(*accessLogSlowThreshold),
+			cfg.WithCaptureFile(*captureFile),
+			cfg.WithCaptureSampleRate(*captureSampleRate),
+			cfg.WithCaptureRoutes(*captureRoutes),
+			cfg.WithCaptureMaxBodyBytes(*captureMaxBodyBytes),
+			cfg.WithCaptureRedactHeaders(*captureRedactHeaders),
+			cfg.WithAuthAPIKeysFile(*authAPIKeysFile),
+			cfg.WithAuthJWKS(*authJWKS),
+			cfg.WithAuthIssuer(*authIssuer),
+			cfg.WithAuthAudience(*authAudience),
+			cfg.WithAuthClockSkew(*authClockSkew),
+			cfg.WithAuthHMACKeysFile(*authHMACKeysFile),
+			cfg.WithAuthHMACWindow(*authHMACWindow),
+			cfg.WithCompressionEncodings(*compressionEncodings),
+			cfg.WithCompressionMinSize(*compressionMinSize),
+			cfg.WithCompressionTypes(*compressionTypes),
+		)...,
 	)
 	metricShutdown := metricsSrv.RunServerWithShutdown(
 		logger,
@@ -113,6 +412,9 @@ func main() {
 		cfg.WithReadTimeout(*metricsReadTimeout),
 		cfg.WithTimeoutHandler(*metricsTimeoutHandler),
 		cfg.WithIdleTimeout(*metricsIdleTimeout),
+		cfg.WithCompressionEncodings(*compressionEncodings),
+		cfg.WithCompressionMinSize(*compressionMinSize),
+		cfg.WithCompressionTypes(*compressionTypes),
 	)
 
 	// Channel to listen for termination signals
@@ -127,12 +429,20 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Call Shutdown on the server
+	// Call Shutdown on the server. Failures exit non-zero, but only once the
+	// logs are flushed: os.Exit skips deferred calls.
+	failed := false
 	if err := srvShutdown(ctx); err != nil {
-		logger.Sugar().Fatalf("server forced to shutdown: %v", err)
+		logger.Error("server forced to shutdown", zap.Error(err))
+		failed = true
 	}
 	if err := metricShutdown(ctx); err != nil {
-		logger.Sugar().Fatalf("metrics server shutdown error: %v", err)
+		logger.Error("metrics server shutdown error", zap.Error(err))
+		failed = true
+	}
+	if failed {
+		flushLogs()
+		os.Exit(1)
 	}
 
 	<-ctx.Done()
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/marcosartorato/myapp/internal/capture"
+)
+
+// replay runs "myapp replay [flags] <capture file>", re-sending captured
+// requests to a server and reporting the responses that differ. It returns
+// the exit code: 1 when responses differ or requests fail, 2 on usage errors.
+func replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: myapp replay [flags] <capture file>")
+		fmt.Fprintln(fs.Output(), "Re-sends the requests of a capture file and reports the responses that differ from the recorded ones.")
+		fs.PrintDefaults()
+	}
+	target := fs.String("target", "http://localhost:8080", "base URL of the server to replay requests to")
+	header := http.Header{}
+	fs.Func("header", "'Name: value' header set on every request, e.g. credentials replacing redacted ones; repeatable", func(s string) error {
+		name, value, ok := strings.Cut(s, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("want 'Name: value'")
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		return nil
+	})
+	ignoreFields := fs.String("ignore-fields", "id,time,due_at,uptime_seconds,events", "JSON body fields not compared, at any depth")
+	compareHeaders := fs.String("compare-headers", "Content-Type", "response headers compared")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each request")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// Stop at the current end of the file, which grows if the target
+	// captures to it.
+	report, err := capture.Replay(ctx, io.LimitReader(file, info.Size()), capture.ReplayOptions{
+		Target:         *target,
+		Client:         &http.Client{Timeout: *timeout},
+		Header:         header,
+		IgnoreFields:   strings.Split(*ignoreFields, ","),
+		CompareHeaders: strings.Split(*compareHeaders, ","),
+	})
+	if printErr := report.Print(os.Stdout); printErr != nil {
+		fmt.Fprintln(os.Stderr, printErr)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
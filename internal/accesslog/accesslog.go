@@ -0,0 +1,358 @@
+// Package accesslog logs one line per HTTP request, with its status,
+// duration, sizes, route and request ID, as zap JSON, Apache Combined or
+// logfmt.
+package accesslog
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Formats of access log lines.
+const (
+	// FormatJSON logs entries through the zap logger.
+	FormatJSON = "json"
+	// FormatCombined writes the Apache Combined Log Format, followed by the
+	// request ID, route, request body size and duration in microseconds.
+	FormatCombined = "combined"
+	// FormatLogfmt writes key=value pairs.
+	FormatLogfmt = "logfmt"
+)
+
+// RequestIDHeader carries the ID of a request, taken from the request when
+// valid and set on the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds the length of request IDs accepted from clients.
+const maxRequestIDLen = 128
+
+// Options configures a Logger.
+type Options struct {
+	// Format is FormatJSON (the default), FormatCombined or FormatLogfmt.
+	Format string
+	// Logger receives FormatJSON entries.
+	Logger *zap.Logger
+	// Writer receives FormatCombined and FormatLogfmt lines.
+	Writer io.Writer
+	// SampleRate is the fraction of successful requests logged, between 0
+	// and 1. Errors (4xx and 5xx) and slow requests are always logged.
+	SampleRate float64
+	// SlowThreshold makes requests lasting at least that long slow; zero
+	// means none is.
+	SlowThreshold time.Duration
+	// Redact, if set, removes sensitive data from the URI, referer and user
+	// agent, which may carry credentials in query strings.
+	Redact func(string) string
+}
+
+// Entry is the access log entry of a request.
+type Entry struct {
+	Time       time.Time
+	RequestID  string
+	RemoteAddr string
+	Method     string
+	// URI is the request URI, as sent by the client.
+	URI   string
+	Proto string
+	// Route is the pattern of the matched route, without method; empty
+	// when no route matched.
+	Route     string
+	Status    int
+	BytesIn   int64
+	BytesOut  int64
+	Duration  time.Duration
+	Referer   string
+	UserAgent string
+}
+
+// Logger logs HTTP requests.
+type Logger struct {
+	opts Options
+	mu   sync.Mutex // serializes writes
+}
+
+// New returns a Logger, failing on unknown formats.
+func New(opts Options) (*Logger, error) {
+	switch opts.Format {
+	case "":
+		opts.Format = FormatJSON
+	case FormatJSON, FormatCombined, FormatLogfmt:
+	default:
+		return nil, fmt.Errorf("accesslog: unknown format %q", opts.Format)
+	}
+	if opts.Logger == nil {
+		opts.Logger = zap.NewNop()
+	}
+	if opts.Writer == nil {
+		opts.Writer = io.Discard
+	}
+	return &Logger{opts: opts}, nil
+}
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request served with ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Handler logs the requests served by next. The request ID is added to the
+// request context, and to the response headers.
+func (l *Logger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			_, route, _ := strings.Cut(r.Pattern, " ")
+			if route == "" {
+				route = r.Pattern
+			}
+			l.Log(Entry{
+				Time:       start,
+				RequestID:  id,
+				RemoteAddr: r.RemoteAddr,
+				Method:     r.Method,
+				URI:        r.RequestURI,
+				Proto:      r.Proto,
+				Route:      route,
+				Status:     rw.status,
+				BytesIn:    body.n,
+				BytesOut:   rw.bytes,
+				Duration:   time.Since(start),
+				Referer:    r.Referer(),
+				UserAgent:  r.UserAgent(),
+			})
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// Log logs e, unless sampled out.
+func (l *Logger) Log(e Entry) {
+	if !l.keep(e) {
+		return
+	}
+	if l.opts.Redact != nil {
+		e.URI, e.Referer, e.UserAgent = l.opts.Redact(e.URI), l.opts.Redact(e.Referer), l.opts.Redact(e.UserAgent)
+	}
+	switch l.opts.Format {
+	case FormatJSON:
+		fields := []zap.Field{
+			zap.String("request_id", e.RequestID),
+			zap.String("remote_addr", e.RemoteAddr),
+			zap.String("method", e.Method),
+			zap.String("uri", e.URI),
+			zap.String("proto", e.Proto),
+			zap.String("route", e.Route),
+			zap.Int("status", e.Status),
+			zap.Int64("bytes_in", e.BytesIn),
+			zap.Int64("bytes_out", e.BytesOut),
+			zap.Duration("duration", e.Duration),
+			zap.String("referer", e.Referer),
+			zap.String("user_agent", e.UserAgent),
+		}
+		if e.Status >= http.StatusInternalServerError {
+			l.opts.Logger.Error("request", fields...)
+		} else {
+			l.opts.Logger.Info("request", fields...)
+		}
+	case FormatCombined:
+		l.write(combined(e))
+	case FormatLogfmt:
+		l.write(logfmt(e))
+	}
+}
+
+// keep reports whether e is logged: errors and slow requests always are,
+// other requests with probability SampleRate.
+func (l *Logger) keep(e Entry) bool {
+	switch {
+	case e.Status >= http.StatusBadRequest:
+		return true
+	case l.opts.SlowThreshold > 0 && e.Duration >= l.opts.SlowThreshold:
+		return true
+	case l.opts.SampleRate >= 1:
+		return true
+	case l.opts.SampleRate <= 0:
+		return false
+	}
+	return rand.Float64() < l.opts.SampleRate
+}
+
+func (l *Logger) write(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.opts.Writer, line+"\n")
+}
+
+// combined formats e in the Apache Combined Log Format, followed by the
+// request ID, the route, the request body size and the duration in
+// microseconds.
+func combined(e Entry) string {
+	host, _, err := net.SplitHostPort(e.RemoteAddr)
+	if err != nil {
+		host = e.RemoteAddr
+	}
+	size := "-"
+	if e.BytesOut > 0 {
+		size = strconv.FormatInt(e.BytesOut, 10)
+	}
+	return fmt.Sprintf(`%s - - [%s] %s %d %s %s %s %s %s %d %d`,
+		dash(host),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		quote(e.Method+" "+e.URI+" "+e.Proto),
+		e.Status,
+		size,
+		quote(dash(e.Referer)),
+		quote(dash(e.UserAgent)),
+		dash(e.RequestID),
+		quote(dash(e.Route)),
+		e.BytesIn,
+		e.Duration.Microseconds(),
+	)
+}
+
+// logfmt formats e as logfmt.
+func logfmt(e Entry) string {
+	var b strings.Builder
+	pair := func(key, value string) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		if value == "" || strings.ContainsAny(value, " =\"\\") || strings.ContainsFunc(value, isControl) {
+			value = quote(value)
+		}
+		b.WriteString(value)
+	}
+	pair("time", e.Time.UTC().Format(time.RFC3339Nano))
+	pair("request_id", e.RequestID)
+	pair("remote_addr", e.RemoteAddr)
+	pair("method", e.Method)
+	pair("uri", e.URI)
+	pair("proto", e.Proto)
+	pair("route", e.Route)
+	pair("status", strconv.Itoa(e.Status))
+	pair("bytes_in", strconv.FormatInt(e.BytesIn, 10))
+	pair("bytes_out", strconv.FormatInt(e.BytesOut, 10))
+	pair("duration", e.Duration.String())
+	pair("referer", e.Referer)
+	pair("user_agent", e.UserAgent)
+	return b.String()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// quote double-quotes s, escaping quotes, backslashes and control
+// characters so that a line cannot be forged.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func isControl(r rune) bool { return r < 0x20 || r == 0x7f }
+
+// validRequestID reports whether id, taken from a client, is safe to log
+// and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	var b [16]byte
+	_, _ = crand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// responseWriter captures the status and size of a response.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= http.StatusOK {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,135 @@
+package accesslog_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/marcosartorato/myapp/internal/accesslog"
+)
+
+// serve serves a request through l, routed by a mux so that it has a pattern.
+func serve(l *accesslog.Logger, req *http.Request) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.Handle("POST /items/{id}", l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(append(body, "!"...))
+	})))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func newRequest(userAgent string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/items/42?x=1", strings.NewReader("hello"))
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set(accesslog.RequestIDHeader, "req-1")
+	req.Header.Set("User-Agent", userAgent)
+	return req
+}
+
+func TestFormats(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := accesslog.New(accesslog.Options{Format: accesslog.FormatCombined, Writer: &buf, SampleRate: 1})
+	require.NoError(t, err)
+	serve(l, newRequest("curl/8.0"))
+	assert.Regexp(t, regexp.MustCompile(`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] `+
+		`"POST /items/42\?x=1 HTTP/1\.1" 201 6 "-" "curl/8\.0" req-1 "/items/\{id\}" 5 \d+\n$`), buf.String())
+
+	buf.Reset()
+	l, err = accesslog.New(accesslog.Options{Format: accesslog.FormatLogfmt, Writer: &buf, SampleRate: 1})
+	require.NoError(t, err)
+	serve(l, newRequest("Mozilla/5.0 (X11)"))
+	line := buf.String()
+	for _, pair := range []string{`request_id=req-1`, `remote_addr=192.0.2.1:1234`, `method=POST`, `uri="/items/42?x=1"`,
+		`route=/items/{id}`, `status=201`, `bytes_in=5`, `bytes_out=6`, `referer=""`, `user_agent="Mozilla/5.0 (X11)"`} {
+		assert.Contains(t, line, pair)
+	}
+	assert.Regexp(t, `duration=[0-9.]+[µnm]?s `, line)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	l, err = accesslog.New(accesslog.Options{Format: accesslog.FormatJSON, Logger: zap.New(core), SampleRate: 1})
+	require.NoError(t, err)
+	serve(l, newRequest("curl/8.0"))
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, "req-1", fields["request_id"])
+	assert.Equal(t, "/items/{id}", fields["route"])
+	assert.Equal(t, int64(201), fields["status"])
+	assert.Equal(t, int64(5), fields["bytes_in"])
+	assert.Equal(t, int64(6), fields["bytes_out"])
+	assert.Contains(t, fields, "duration")
+
+	_, err = accesslog.New(accesslog.Options{Format: "xml"})
+	assert.Error(t, err)
+}
+
+func TestLinesCannotBeForged(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := accesslog.New(accesslog.Options{Format: accesslog.FormatCombined, Writer: &buf, SampleRate: 1})
+	require.NoError(t, err)
+	l.Log(accesslog.Entry{Status: 200, UserAgent: "evil\"\n127.0.0.1 - - \\"})
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+	assert.Contains(t, buf.String(), `"evil\"\x0a127.0.0.1 - - \\"`)
+}
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := accesslog.New(accesslog.Options{Format: accesslog.FormatLogfmt, Writer: &buf, SampleRate: 1,
+		Redact: func(s string) string { return strings.ReplaceAll(s, "s3cr3t", "[REDACTED]") }})
+	require.NoError(t, err)
+	l.Log(accesslog.Entry{Status: 200, URI: "/items?token=s3cr3t", Referer: "https://example.com/?k=s3cr3t", UserAgent: "s3cr3t"})
+	assert.NotContains(t, buf.String(), "s3cr3t")
+	assert.Contains(t, buf.String(), `uri="/items?token=[REDACTED]"`)
+}
+
+func TestSampling(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := accesslog.New(accesslog.Options{Format: accesslog.FormatLogfmt, Writer: &buf, SlowThreshold: time.Second})
+	require.NoError(t, err)
+
+	l.Log(accesslog.Entry{Status: http.StatusOK, Duration: time.Millisecond, RequestID: "fast"})
+	l.Log(accesslog.Entry{Status: http.StatusOK, Duration: 2 * time.Second, RequestID: "slow"})
+	l.Log(accesslog.Entry{Status: http.StatusNotFound, RequestID: "client-error"})
+	l.Log(accesslog.Entry{Status: http.StatusServiceUnavailable, RequestID: "server-error"})
+
+	out := buf.String()
+	assert.NotContains(t, out, "request_id=fast", "successful requests are sampled out")
+	assert.Contains(t, out, "request_id=slow")
+	assert.Contains(t, out, "request_id=client-error")
+	assert.Contains(t, out, "request_id=server-error")
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	l, err := accesslog.New(accesslog.Options{})
+	require.NoError(t, err)
+	h := l.Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		seen = accesslog.RequestID(r.Context())
+	}))
+
+	for id, valid := range map[string]bool{"abc-123": true, "": false, "bad id": false, strings.Repeat("x", 129): false} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(accesslog.RequestIDHeader, id)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, seen, rec.Header().Get(accesslog.RequestIDHeader))
+		if valid {
+			assert.Equal(t, id, seen)
+		} else {
+			assert.Regexp(t, `^[0-9a-f]{32}$`, seen)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// APIKeyHeader is the request header carrying a static API key.
+const APIKeyHeader = "X-API-Key"
+
+// APIKeys authenticates requests with static API keys. Only the SHA-256
+// digests of the keys are kept.
+type APIKeys struct {
+	keys map[[sha256.Size]byte]Principal
+}
+
+// LoadAPIKeys reads API keys from the file at path; see ParseAPIKeys.
+func LoadAPIKeys(path string) (*APIKeys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return ParseAPIKeys(f)
+}
+
+// ParseAPIKeys reads one key per line as
+//
+//	<hex sha256 of the key> <subject> [scope,scope,...]
+//
+// Blank lines and lines starting with '#' are ignored.
+func ParseAPIKeys(r io.Reader) (*APIKeys, error) {
+	k := &APIKeys{keys: map[[sha256.Size]byte]Principal{}}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("api keys line %d: want <sha256> <subject> [scopes]", line)
+		}
+		raw, err := hex.DecodeString(fields[0])
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("api keys line %d: invalid sha256 digest", line)
+		}
+		p := Principal{Subject: fields[1], Method: "api-key"}
+		if len(fields) == 3 {
+			p.Scopes = strings.Split(fields[2], ",")
+		}
+		k.keys[[sha256.Size]byte(raw)] = p
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Authenticate implements Authenticator.
+func (k *APIKeys) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	// Looking up the digest rather than the key keeps the comparison
+	// independent from how much of the key matches.
+	p, ok := k.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, newError("invalid_api_key", "invalid API key")
+	}
+	return &p, nil
+}
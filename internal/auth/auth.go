@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// Subject identifies the caller (API key owner or JWT "sub").
+	Subject string
+	// Method is the scheme that authenticated the caller, e.g. "api-key" or "jwt".
+	Method string
+	Scopes []string
+}
+
+// HasScopes reports whether p holds all the given scopes.
+func (p *Principal) HasScopes(scopes ...string) bool {
+	for _, s := range scopes {
+		if !slices.Contains(p.Scopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Authenticator extracts and verifies the caller's credentials.
+//
+// Implementations return ErrNoCredentials when the request carries none of
+// the credentials they understand, so that a Chain can try the next one.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Error is an authentication failure. Reason is a short, stable label suitable
+// for metrics, e.g. "expired" or "invalid_signature".
+type Error struct {
+	Reason string
+	Msg    string
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+func newError(reason, msg string) *Error { return &Error{Reason: reason, Msg: msg} }
+
+// ErrNoCredentials is returned when a request carries no credentials.
+var ErrNoCredentials = newError("missing", "missing credentials")
+
+// Reason returns the metrics label for err.
+func Reason(err error) string {
+	var aerr *Error
+	if errors.As(err, &aerr) {
+		return aerr.Reason
+	}
+	return "error"
+}
+
+// Chain tries each authenticator in turn until one finds credentials.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator.
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return nil, ErrNoCredentials
+}
+
+type ctxKey int
+
+const principalKey ctxKey = iota
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFrom returns the principal stored in ctx, if any.
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*Principal)
+	return p, ok && p != nil
+}
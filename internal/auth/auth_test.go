@@ -0,0 +1,246 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// signJWT builds a compact JWS for claims using alg and key.
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := b64(header) + "." + b64(payload)
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	}
+	require.NoError(t, err)
+	return signed + "." + b64(sig)
+}
+
+// jwksFor returns a JWKS document with the public parts of keys, by kid.
+func jwksFor(t *testing.T, keys map[string]crypto.Signer) []byte {
+	t.Helper()
+	var set []map[string]string
+	for kid, key := range keys {
+		switch k := key.Public().(type) {
+		case *rsa.PublicKey:
+			set = append(set, map[string]string{"kty": "RSA", "kid": kid, "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			point, err := k.Bytes()
+			require.NoError(t, err)
+			set = append(set, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(point[1:33]), "y": b64(point[33:])})
+		case ed25519.PublicKey:
+			set = append(set, map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(k)})
+		}
+	}
+	data, err := json.Marshal(map[string]any{"keys": set})
+	require.NoError(t, err)
+	return data
+}
+
+func testKeys(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey, "ed": edKey}
+}
+
+func TestAPIKeys(t *testing.T) {
+	digest := sha256.Sum256([]byte("s3cret"))
+	keys, err := auth.ParseAPIKeys(strings.NewReader("# comment\n\n" + hex.EncodeToString(digest[:]) + " ci-bot message:write,admin\n"))
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err = keys.Authenticate(req)
+	assert.ErrorIs(t, err, auth.ErrNoCredentials)
+
+	req.Header.Set(auth.APIKeyHeader, "wrong")
+	_, err = keys.Authenticate(req)
+	assert.Equal(t, "invalid_api_key", auth.Reason(err))
+
+	req.Header.Set(auth.APIKeyHeader, "s3cret")
+	p, err := keys.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "ci-bot", p.Subject)
+	assert.Equal(t, "api-key", p.Method)
+	assert.True(t, p.HasScopes("message:write", "admin"))
+	assert.False(t, p.HasScopes("other"))
+
+	_, err = auth.ParseAPIKeys(strings.NewReader("nothex ci-bot\n"))
+	assert.Error(t, err)
+}
+
+func TestJWTVerify(t *testing.T) {
+	keys := testKeys(t)
+	set, err := auth.ParseJWKS(jwksFor(t, keys))
+	require.NoError(t, err)
+	v := auth.NewJWT(set, "https://issuer", "myapp", 30*time.Second)
+	now := time.Unix(1_700_000_000, 0)
+
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{
+			"iss":   "https://issuer",
+			"sub":   "alice",
+			"aud":   []string{"other", "myapp"},
+			"exp":   now.Add(time.Minute).Unix(),
+			"scope": "message:write read",
+		}
+		for k, val := range overrides {
+			if val == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = val
+		}
+		return c
+	}
+
+	for _, tc := range []struct{ alg, kid string }{{"RS256", "rsa"}, {"ES256", "ec"}, {"EdDSA", "ed"}} {
+		t.Run(tc.alg, func(t *testing.T) {
+			p, err := v.Verify(signJWT(t, tc.alg, tc.kid, keys[tc.kid], claims(nil)), now)
+			require.NoError(t, err)
+			assert.Equal(t, "alice", p.Subject)
+			assert.Equal(t, "jwt", p.Method)
+			assert.ElementsMatch(t, []string{"message:write", "read"}, p.Scopes)
+		})
+	}
+
+	tests := map[string]struct {
+		token      string
+		wantReason string
+	}{
+		"expired beyond skew": {
+			token:      signJWT(t, "RS256", "rsa", keys["rsa"], claims(map[string]any{"exp": now.Add(-time.Minute).Unix()})),
+			wantReason: "expired",
+		},
+		"not yet valid": {
+			token:      signJWT(t, "RS256", "rsa", keys["rsa"], claims(map[string]any{"nbf": now.Add(time.Minute).Unix()})),
+			wantReason: "not_yet_valid",
+		},
+		"missing exp": {
+			token:      signJWT(t, "RS256", "rsa", keys["rsa"], claims(map[string]any{"exp": nil})),
+			wantReason: "malformed_token",
+		},
+		"wrong issuer": {
+			token:      signJWT(t, "RS256", "rsa", keys["rsa"], claims(map[string]any{"iss": "evil"})),
+			wantReason: "invalid_issuer",
+		},
+		"wrong audience": {
+			token:      signJWT(t, "RS256", "rsa", keys["rsa"], claims(map[string]any{"aud": "other"})),
+			wantReason: "invalid_audience",
+		},
+		"unknown kid": {
+			token:      signJWT(t, "RS256", "nope", keys["rsa"], claims(nil)),
+			wantReason: "unknown_key",
+		},
+		"key of another type": {
+			token:      signJWT(t, "ES256", "rsa", keys["ec"], claims(nil)),
+			wantReason: "invalid_signature",
+		},
+		"signed by another key": {
+			token:      signJWT(t, "EdDSA", "ed", mustEd25519(t), claims(nil)),
+			wantReason: "invalid_signature",
+		},
+		"alg none": {
+			token:      b64([]byte(`{"alg":"none","kid":"rsa"}`)) + "." + b64([]byte(`{"sub":"alice"}`)) + ".",
+			wantReason: "unsupported_alg",
+		},
+		"garbage": {
+			token:      "not-a-token",
+			wantReason: "malformed_token",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := v.Verify(tc.token, now)
+			require.Error(t, err)
+			assert.Equal(t, tc.wantReason, auth.Reason(err))
+		})
+	}
+
+	// Expiry within the clock skew is tolerated.
+	_, err = v.Verify(signJWT(t, "RS256", "rsa", keys["rsa"], claims(map[string]any{"exp": now.Add(-10 * time.Second).Unix()})), now)
+	assert.NoError(t, err)
+}
+
+func mustEd25519(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, k, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return k
+}
+
+func TestChainAndRemoteJWKS(t *testing.T) {
+	keys := testKeys(t)
+	var fetches atomic.Int32
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write(jwksFor(t, keys))
+	}))
+	defer jwks.Close()
+
+	ks, err := auth.NewKeySet(jwks.URL, jwks.Client())
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte("s3cret"))
+	apiKeys, err := auth.ParseAPIKeys(strings.NewReader(hex.EncodeToString(digest[:]) + " ci-bot\n"))
+	require.NoError(t, err)
+	chain := auth.Chain{apiKeys, auth.NewJWT(ks, "", "", 0)}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	_, err = chain.Authenticate(req)
+	assert.True(t, errors.Is(err, auth.ErrNoCredentials))
+
+	token := signJWT(t, "ES256", "ec", keys["ec"], map[string]any{"sub": "bob", "exp": time.Now().Add(time.Minute).Unix()})
+	req.Header.Set("Authorization", "Bearer "+token)
+	p, err := chain.Authenticate(req)
+	require.NoError(t, err)
+	assert.Equal(t, "bob", p.Subject)
+
+	// The key set is cached, and unknown kids do not trigger a refetch right away.
+	_, err = ks.Key("unknown")
+	assert.Equal(t, "unknown_key", auth.Reason(err))
+	assert.Equal(t, int32(1), fetches.Load())
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcosartorato/myapp/pkg/hmacsign"
+)
+
+// hmacKeysReloadInterval is how often the keys file is checked for changes.
+const hmacKeysReloadInterval = 10 * time.Second
+
+type hmacKey struct {
+	secret    []byte
+	principal Principal
+}
+
+// HMACKeys holds the shared secrets of the request signing scheme, by key id.
+// Keys loaded from a file are reloaded when the file changes, so secrets can
+// be rotated by adding a new key id, moving callers to it, then removing the
+// old one.
+type HMACKeys struct {
+	path string
+
+	mu      sync.Mutex
+	keys    map[string]hmacKey
+	modTime time.Time
+	checked time.Time
+}
+
+// LoadHMACKeys reads signing keys from the file at path; see ParseHMACKeys.
+func LoadHMACKeys(path string) (*HMACKeys, error) {
+	k := &HMACKeys{path: path}
+	if err := k.reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ParseHMACKeys reads one key per line as
+//
+//	<key id> <hex secret> <subject> [scope,scope,...]
+//
+// Blank lines and lines starting with '#' are ignored.
+func ParseHMACKeys(r io.Reader) (*HMACKeys, error) {
+	keys, err := parseHMACKeys(r)
+	if err != nil {
+		return nil, err
+	}
+	return &HMACKeys{keys: keys}, nil
+}
+
+func parseHMACKeys(r io.Reader) (map[string]hmacKey, error) {
+	keys := map[string]hmacKey{}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("hmac keys line %d: want <key id> <hex secret> <subject> [scopes]", line)
+		}
+		secret, err := hex.DecodeString(fields[1])
+		if err != nil || len(secret) < 16 {
+			return nil, fmt.Errorf("hmac keys line %d: secret must be at least 16 hex-encoded bytes", line)
+		}
+		p := Principal{Subject: fields[2], Method: "hmac"}
+		if len(fields) == 4 {
+			p.Scopes = strings.Split(fields[3], ",")
+		}
+		keys[fields[0]] = hmacKey{secret: secret, principal: p}
+	}
+	return keys, sc.Err()
+}
+
+// lookup returns the key with the given id, reloading the file if it changed.
+func (k *HMACKeys) lookup(id string) (hmacKey, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.path != "" && time.Since(k.checked) > hmacKeysReloadInterval {
+		// Keep serving the previous keys if the file is being rewritten.
+		_ = k.reloadLocked()
+	}
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+func (k *HMACKeys) reload() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.reloadLocked()
+}
+
+func (k *HMACKeys) reloadLocked() error {
+	k.checked = time.Now()
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	if k.keys != nil && info.ModTime().Equal(k.modTime) {
+		return nil
+	}
+	f, err := os.Open(k.path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	keys, err := parseHMACKeys(f)
+	if err != nil {
+		return err
+	}
+	k.keys, k.modTime = keys, info.ModTime()
+	return nil
+}
+
+// HMAC authenticates requests signed with the hmacsign scheme.
+type HMAC struct {
+	keys   *HMACKeys
+	window time.Duration
+	seen   *replayCache
+}
+
+// NewHMAC returns an HMAC authenticator accepting signatures whose timestamp
+// is within window of the server clock. Each signature is accepted once.
+func NewHMAC(keys *HMACKeys, window time.Duration) *HMAC {
+	return &HMAC{keys: keys, window: window, seen: &replayCache{seen: map[string]time.Time{}}}
+}
+
+// Authenticate implements Authenticator. The request body is read to check
+// its digest and restored for the next handler.
+func (h *HMAC) Authenticate(r *http.Request) (*Principal, error) {
+	return h.AuthenticateAt(r, time.Now())
+}
+
+// AuthenticateAt is Authenticate with an explicit clock reading. A body over
+// the limit set by http.MaxBytesReader fails with its *http.MaxBytesError.
+func (h *HMAC) AuthenticateAt(r *http.Request, now time.Time) (*Principal, error) {
+	header := r.Header.Get(hmacsign.HeaderSignature)
+	if header == "" {
+		return nil, ErrNoCredentials
+	}
+	keyID, sig, err := hmacsign.ParseSignature(header)
+	if err != nil {
+		return nil, newError("malformed_signature", err.Error())
+	}
+	key, ok := h.keys.lookup(keyID)
+	if !ok {
+		return nil, newError("unknown_key", fmt.Sprintf("unknown key id %q", keyID))
+	}
+
+	ts := r.Header.Get(hmacsign.HeaderTimestamp)
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, newError("malformed_signature", "invalid signature timestamp")
+	}
+	if d := now.Sub(time.Unix(secs, 0)); d > h.window || d < -h.window {
+		return nil, newError("stale_signature", "signature timestamp outside the accepted window")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, newError("malformed_signature", "reading body: "+err.Error())
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	digest := r.Header.Get(hmacsign.HeaderDigest)
+	if !hmac.Equal([]byte(digest), []byte(hmacsign.BodyDigest(body))) {
+		return nil, newError("digest_mismatch", "body digest mismatch")
+	}
+
+	want := hmacsign.Compute(key.secret, hmacsign.StringToSign(r.Method, r.URL.RequestURI(), ts, digest))
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return nil, newError("invalid_signature", "invalid request signature")
+	}
+	// Only valid signatures are remembered, so forged ones cannot fill the cache.
+	if !h.seen.add(sig, now, now.Add(2*h.window)) {
+		return nil, newError("replayed_signature", "signature already used")
+	}
+	p := key.principal
+	return &p, nil
+}
+
+// replayCache remembers signatures until they fall out of the replay window.
+// A signature is valid for window on either side of its timestamp, so it is
+// kept for twice the window after first use.
+type replayCache struct {
+	mu     sync.Mutex
+	seen   map[string]time.Time // signature -> expiry
+	pruned time.Time
+}
+
+// add records sig until expiry, reporting false if it is already recorded.
+// Expired entries are pruned at most once a minute.
+func (c *replayCache) add(sig string, now, expiry time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if exp, ok := c.seen[sig]; ok && exp.After(now) {
+		return false
+	}
+	if now.Sub(c.pruned) > time.Minute {
+		for s, exp := range c.seen {
+			if !exp.After(now) {
+				delete(c.seen, s)
+			}
+		}
+		c.pruned = now
+	}
+	c.seen[sig] = expiry
+	return true
+}
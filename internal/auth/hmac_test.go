@@ -0,0 +1,85 @@
+package auth_test
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	"github.com/marcosartorato/myapp/pkg/hmacsign"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHMAC(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	keys, err := auth.ParseHMACKeys(strings.NewReader("k1 " + hex.EncodeToString(secret) + " billing message:write\n"))
+	require.NoError(t, err)
+	h := auth.NewHMAC(keys, 5*time.Minute)
+	now := time.Unix(1_700_000_000, 0)
+
+	signed := func(keyID string, secret []byte, at time.Time) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(`{"type":"time"}`))
+		require.NoError(t, hmacsign.Sign(req, keyID, secret, at))
+		return req
+	}
+
+	_, err = h.AuthenticateAt(httptest.NewRequest(http.MethodPost, "/api/message", nil), now)
+	assert.ErrorIs(t, err, auth.ErrNoCredentials)
+
+	req := signed("k1", secret, now.Add(-time.Minute))
+	p, err := h.AuthenticateAt(req, now)
+	require.NoError(t, err)
+	assert.Equal(t, "billing", p.Subject)
+	assert.Equal(t, "hmac", p.Method)
+	assert.True(t, p.HasScopes("message:write"))
+
+	// Same signature again.
+	replay := signed("k1", secret, now.Add(-time.Minute))
+	_, err = h.AuthenticateAt(replay, now)
+	assert.Equal(t, "replayed_signature", auth.Reason(err))
+
+	tampered := signed("k1", secret, now)
+	tampered.Body = http.NoBody
+	_, err = h.AuthenticateAt(tampered, now)
+	assert.Equal(t, "digest_mismatch", auth.Reason(err))
+
+	_, err = h.AuthenticateAt(signed("k1", secret, now.Add(-10*time.Minute)), now)
+	assert.Equal(t, "stale_signature", auth.Reason(err))
+	_, err = h.AuthenticateAt(signed("k1", secret, now.Add(10*time.Minute)), now)
+	assert.Equal(t, "stale_signature", auth.Reason(err))
+
+	_, err = h.AuthenticateAt(signed("k1", []byte("fedcba9876543210"), now), now)
+	assert.Equal(t, "invalid_signature", auth.Reason(err))
+
+	_, err = h.AuthenticateAt(signed("k2", secret, now), now)
+	assert.Equal(t, "unknown_key", auth.Reason(err))
+
+	tooLarge := signed("k1", secret, now.Add(2*time.Second))
+	tooLarge.Body = http.MaxBytesReader(httptest.NewRecorder(), tooLarge.Body, 4)
+	_, err = h.AuthenticateAt(tooLarge, now)
+	var maxBytes *http.MaxBytesError
+	assert.ErrorAs(t, err, &maxBytes)
+
+	pathChanged := signed("k1", secret, now.Add(time.Second))
+	pathChanged.URL.Path = "/api/other"
+	_, err = h.AuthenticateAt(pathChanged, now)
+	assert.Equal(t, "invalid_signature", auth.Reason(err))
+}
+
+func TestLoadHMACKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hmac-keys")
+	require.NoError(t, os.WriteFile(path, []byte("k1 "+hex.EncodeToString([]byte("0123456789abcdef"))+" billing\n"), 0o600))
+	_, err := auth.LoadHMACKeys(path)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte("k1 tooshort billing\n"), 0o600))
+	_, err = auth.LoadHMACKeys(path)
+	assert.Error(t, err)
+}
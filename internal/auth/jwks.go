@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySet resolves JWT verification keys by key ID ("kid").
+type KeySet interface {
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// JWKS is a static JSON Web Key Set.
+type JWKS struct {
+	keys map[string]crypto.PublicKey
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS reads a JSON Web Key Set from the file at path.
+func LoadJWKS(path string) (*JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// ParseJWKS parses a JSON Web Key Set. RSA, EC (P-256, P-384, P-521) and
+// Ed25519 keys are supported; keys not meant for signatures are skipped.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	s := &JWKS{keys: map[string]crypto.PublicKey{}}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %d (kid %q): %w", i, k.Kid, err)
+		}
+		s.keys[k.Kid] = key
+	}
+	return s, nil
+}
+
+// Key implements KeySet. An empty kid matches the only key of a single-key set.
+func (s *JWKS) Key(kid string) (crypto.PublicKey, error) {
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, nil
+		}
+	}
+	return nil, newError("unknown_key", fmt.Sprintf("unknown key id %q", kid))
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		// Uncompressed SEC 1 point; parsing also checks it is on the curve.
+		size := (curve.Params().BitSize + 7) / 8
+		if x.BitLen() > 8*size || y.BitLen() > 8*size {
+			return nil, fmt.Errorf("invalid EC point")
+		}
+		point := make([]byte, 1+2*size)
+		point[0] = 4
+		x.FillBytes(point[1 : 1+size])
+		y.FillBytes(point[1+size:])
+		return ecdsa.ParseUncompressedPublicKey(curve, point)
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+const (
+	// remoteJWKSTTL is how long a fetched key set is trusted.
+	remoteJWKSTTL = 5 * time.Minute
+	// remoteJWKSMinRefresh throttles refetches triggered by unknown key ids.
+	remoteJWKSMinRefresh = 30 * time.Second
+)
+
+// RemoteJWKS is a JSON Web Key Set fetched from a URL and cached.
+type RemoteJWKS struct {
+	url    string
+	client *http.Client
+
+	mu                 sync.Mutex
+	set                *JWKS
+	fetched, attempted time.Time
+	err                error
+}
+
+// NewRemoteJWKS returns a key set fetched lazily from url.
+func NewRemoteJWKS(url string, client *http.Client) *RemoteJWKS {
+	return &RemoteJWKS{url: url, client: client}
+}
+
+// Key implements KeySet. The set is refreshed when stale, or when kid is
+// unknown, at most once every remoteJWKSMinRefresh so that rotated keys are
+// picked up without letting bogus tokens hammer the issuer. A previously
+// fetched set keeps being used while the URL is unreachable.
+func (s *RemoteJWKS) Key(kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	canRefresh := time.Since(s.attempted) > remoteJWKSMinRefresh
+	if canRefresh && (s.set == nil || time.Since(s.fetched) > remoteJWKSTTL) {
+		s.refresh()
+		canRefresh = false
+	}
+	if s.set == nil {
+		return nil, s.err
+	}
+	key, err := s.set.Key(kid)
+	if err != nil && canRefresh {
+		s.refresh()
+		key, err = s.set.Key(kid)
+	}
+	return key, err
+}
+
+// refresh fetches the key set, recording the failure in s.err if any.
+// Callers must hold s.mu.
+func (s *RemoteJWKS) refresh() {
+	s.attempted = time.Now()
+	set, err := s.fetch()
+	if err != nil {
+		s.err = newError("jwks_unavailable", "fetching JWKS: "+err.Error())
+		return
+	}
+	s.set, s.fetched, s.err = set, s.attempted, nil
+}
+
+func (s *RemoteJWKS) fetch() (*JWKS, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+// NewKeySet returns a RemoteJWKS for http(s) URLs and loads a local file otherwise.
+func NewKeySet(source string, client *http.Client) (KeySet, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return NewRemoteJWKS(source, client), nil
+	}
+	return LoadJWKS(source)
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256" // register hashes used by crypto.Hash
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JWT authenticates requests carrying an "Authorization: Bearer <token>"
+// header with a JWS-signed JSON Web Token.
+type JWT struct {
+	keys      KeySet
+	issuer    string
+	audience  string
+	clockSkew time.Duration
+}
+
+// NewJWT returns a JWT authenticator verifying signatures with keys. Empty
+// issuer or audience disable the respective check; clockSkew is tolerated on
+// the "exp" and "nbf" claims.
+func NewJWT(keys KeySet, issuer, audience string, clockSkew time.Duration) *JWT {
+	return &JWT{keys: keys, issuer: issuer, audience: audience, clockSkew: clockSkew}
+}
+
+// Authenticate implements Authenticator.
+func (j *JWT) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+	return j.Verify(strings.TrimSpace(token), time.Now())
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Iss   string          `json:"iss"`
+	Sub   string          `json:"sub"`
+	Aud   json.RawMessage `json:"aud"`
+	Exp   *float64        `json:"exp"`
+	Nbf   *float64        `json:"nbf"`
+	Scope string          `json:"scope"`
+	Scp   json.RawMessage `json:"scp"`
+}
+
+// Verify checks token's signature and claims as of now.
+func (j *JWT) Verify(token string, now time.Time) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, newError("malformed_token", "malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, newError("malformed_token", "malformed token signature")
+	}
+	key, err := j.keys.Key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if claims.Exp == nil {
+		return nil, newError("malformed_token", "missing exp claim")
+	}
+	if now.Add(-j.clockSkew).After(unixTime(*claims.Exp)) {
+		return nil, newError("expired", "token expired")
+	}
+	if claims.Nbf != nil && now.Add(j.clockSkew).Before(unixTime(*claims.Nbf)) {
+		return nil, newError("not_yet_valid", "token not yet valid")
+	}
+	if j.issuer != "" && claims.Iss != j.issuer {
+		return nil, newError("invalid_issuer", "unexpected token issuer")
+	}
+	if j.audience != "" && !slices.Contains(stringOrList(claims.Aud), j.audience) {
+		return nil, newError("invalid_audience", "unexpected token audience")
+	}
+
+	scopes := strings.Fields(claims.Scope)
+	for _, s := range stringOrList(claims.Scp) {
+		scopes = append(scopes, strings.Fields(s)...)
+	}
+	return &Principal{Subject: claims.Sub, Method: "jwt", Scopes: scopes}, nil
+}
+
+// verifySignature checks sig for alg, refusing algorithms that do not match
+// the key type (and "none").
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	invalid := newError("invalid_signature", "invalid token signature")
+
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "PS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "EdDSA":
+		k, ok := key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(k, signed, sig) {
+			return invalid
+		}
+		return nil
+	default:
+		return newError("unsupported_alg", "unsupported token algorithm")
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg[:2] {
+		case "RS":
+			err = rsa.VerifyPKCS1v15(k, hash, digest, sig)
+		case "PS":
+			err = rsa.VerifyPSS(k, hash, digest, sig, nil)
+		default:
+			return invalid
+		}
+		if err != nil {
+			return invalid
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != 2*size || k.Curve.Params().BitSize != ecdsaBits(hash) {
+			return invalid
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return invalid
+		}
+		return nil
+	}
+	return invalid
+}
+
+// ecdsaBits returns the curve size mandated for an ES* algorithm using hash.
+func ecdsaBits(hash crypto.Hash) int {
+	switch hash {
+	case crypto.SHA256:
+		return 256
+	case crypto.SHA384:
+		return 384
+	}
+	return 521
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil || json.Unmarshal(b, v) != nil {
+		return newError("malformed_token", "malformed token")
+	}
+	return nil
+}
+
+// stringOrList decodes a claim that is either a string or a list of strings.
+func stringOrList(raw json.RawMessage) []string {
+	var one string
+	if json.Unmarshal(raw, &one) == nil {
+		return []string{one}
+	}
+	var many []string
+	_ = json.Unmarshal(raw, &many)
+	return many
+}
+
+func unixTime(secs float64) time.Time {
+	sec, frac := math.Modf(secs)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second)))
+}
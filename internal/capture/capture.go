@@ -0,0 +1,223 @@
+// Package capture records sampled HTTP exchanges as JSON Lines, and replays
+// them against a server to report how its responses differ, e.g. to
+// regression-test a new version against real traffic.
+package capture
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Redacted replaces the values of redacted headers.
+const Redacted = "[REDACTED]"
+
+// DefaultRedactHeaders are the headers carrying credentials.
+var DefaultRedactHeaders = []string{
+	"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-API-Key", "X-Signature",
+}
+
+// Body is a captured body: Text when valid UTF-8, Base64 otherwise.
+type Body struct {
+	Text   string `json:"text,omitempty"`
+	Base64 string `json:"base64,omitempty"`
+	// Truncated bodies were longer than the capture limit.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func newBody(b []byte, truncated bool) Body {
+	if utf8.Valid(b) {
+		return Body{Text: string(b), Truncated: truncated}
+	}
+	return Body{Base64: base64.StdEncoding.EncodeToString(b), Truncated: truncated}
+}
+
+// Bytes returns the captured content of b.
+func (b Body) Bytes() ([]byte, error) {
+	if b.Base64 != "" {
+		return base64.StdEncoding.DecodeString(b.Base64)
+	}
+	return []byte(b.Text), nil
+}
+
+// Exchange is a captured request and its response; a line of a capture file.
+type Exchange struct {
+	Time time.Time `json:"time"`
+	// Route is the pattern of the route serving the request.
+	Route  string `json:"route"`
+	Method string `json:"method"`
+	// URI is the path and query of the request.
+	URI             string      `json:"uri"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	RequestBody     Body        `json:"request_body"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"response_headers"`
+	ResponseBody    Body        `json:"response_body"`
+	// LatencyMS is the time to serve the request in milliseconds.
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// Options configures a Recorder.
+type Options struct {
+	// Writer receives the captured exchanges, one JSON object per line.
+	Writer io.Writer
+	// SampleRate is the fraction of requests captured, between 0 and 1.
+	SampleRate float64
+	// MaxBodyBytes is the size up to which bodies are captured; 64 KiB when
+	// zero.
+	MaxBodyBytes int64
+	// RedactHeaders are the headers whose values are replaced with Redacted;
+	// DefaultRedactHeaders when nil.
+	RedactHeaders []string
+	// OnError, if set, is called when an exchange cannot be written.
+	OnError func(err error)
+}
+
+// Recorder captures exchanges.
+type Recorder struct {
+	opts   Options
+	redact []string // canonical header names
+
+	mu  sync.Mutex // serializes writes
+	enc *json.Encoder
+}
+
+// New returns a Recorder for opts.
+func New(opts Options) *Recorder {
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = 64 << 10
+	}
+	if opts.RedactHeaders == nil {
+		opts.RedactHeaders = DefaultRedactHeaders
+	}
+	r := &Recorder{opts: opts, enc: json.NewEncoder(opts.Writer)}
+	for _, h := range opts.RedactHeaders {
+		r.redact = append(r.redact, http.CanonicalHeaderKey(h))
+	}
+	return r
+}
+
+// Handler captures a sample of the requests to route served by next.
+func (rec *Recorder) Handler(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rec.sampled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		reqHeaders := rec.headers(r.Header)
+
+		// The body is read ahead so that it is captured even if the handler
+		// does not read it; the handler reads it from the buffer, then from
+		// the rest of the original body.
+		var reqBody []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			reqBody, _ = io.ReadAll(io.LimitReader(r.Body, rec.opts.MaxBodyBytes+1))
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(reqBody), r.Body), r.Body}
+		}
+		truncated := int64(len(reqBody)) > rec.opts.MaxBodyBytes
+		if truncated {
+			reqBody = reqBody[:rec.opts.MaxBodyBytes]
+		}
+
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK, max: rec.opts.MaxBodyBytes}
+		next.ServeHTTP(rw, r)
+
+		if rw.header == nil {
+			rw.header = w.Header().Clone()
+		}
+		rec.write(Exchange{
+			Time:            start.UTC(),
+			Route:           route,
+			Method:          r.Method,
+			URI:             r.URL.RequestURI(),
+			RequestHeaders:  reqHeaders,
+			RequestBody:     newBody(reqBody, truncated),
+			Status:          rw.status,
+			ResponseHeaders: rec.headers(rw.header),
+			ResponseBody:    newBody(rw.body.Bytes(), rw.truncated),
+			LatencyMS:       float64(time.Since(start)) / float64(time.Millisecond),
+		})
+	})
+}
+
+func (rec *Recorder) sampled() bool {
+	switch {
+	case rec.opts.SampleRate >= 1:
+		return true
+	case rec.opts.SampleRate <= 0:
+		return false
+	}
+	return rand.Float64() < rec.opts.SampleRate
+}
+
+// headers returns a copy of h with the redacted headers redacted.
+func (rec *Recorder) headers(h http.Header) http.Header {
+	h = h.Clone()
+	for _, name := range rec.redact {
+		if values, ok := h[name]; ok {
+			for i := range values {
+				values[i] = Redacted
+			}
+		}
+	}
+	return h
+}
+
+func (rec *Recorder) write(e Exchange) {
+	rec.mu.Lock()
+	err := rec.enc.Encode(e)
+	rec.mu.Unlock()
+	if err != nil && rec.opts.OnError != nil {
+		rec.opts.OnError(err)
+	}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// responseWriter captures the status, headers and body of a response.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header // as sent
+	wroteHeader bool
+	body        bytes.Buffer
+	max         int64
+	truncated   bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if !w.wroteHeader && code >= http.StatusOK {
+		w.status, w.wroteHeader = code, true
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.header = w.Header().Clone()
+	}
+	if room := w.max - int64(w.body.Len()); room < int64(len(b)) {
+		w.body.Write(b[:max(room, 0)])
+		w.truncated = true
+	} else {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
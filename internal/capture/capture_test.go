@@ -0,0 +1,112 @@
+package capture_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/capture"
+)
+
+// exchanges decodes the exchanges written to buf.
+func exchanges(t *testing.T, buf *bytes.Buffer) []capture.Exchange {
+	t.Helper()
+	var out []capture.Exchange
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var e capture.Exchange
+		require.NoError(t, dec.Decode(&e))
+		out = append(out, e)
+	}
+	return out
+}
+
+// echo answers with the request body, reversed in case.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Set-Cookie", "session=s3cr3t")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(`{"echo":` + string(body) + `}`))
+})
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	rec := capture.New(capture.Options{Writer: &buf, SampleRate: 1})
+	req := httptest.NewRequest(http.MethodPost, "/api/message?lang=it", strings.NewReader(`"hi"`))
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	req.Header.Set("X-API-Key", "s3cr3t")
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	rec.Handler("/api/message", echo).ServeHTTP(resp, req)
+
+	// The handler is served as usual.
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, `{"echo":"hi"}`, resp.Body.String())
+	assert.Equal(t, "session=s3cr3t", resp.Header().Get("Set-Cookie"))
+
+	got := exchanges(t, &buf)
+	require.Len(t, got, 1)
+	e := got[0]
+	assert.NotContains(t, buf.String(), "s3cr3t")
+	assert.Equal(t, "/api/message", e.Route)
+	assert.Equal(t, http.MethodPost, e.Method)
+	assert.Equal(t, "/api/message?lang=it", e.URI)
+	assert.Equal(t, capture.Redacted, e.RequestHeaders.Get("Authorization"))
+	assert.Equal(t, capture.Redacted, e.RequestHeaders.Get("X-API-Key"))
+	assert.Equal(t, "application/json", e.RequestHeaders.Get("Content-Type"))
+	assert.Equal(t, capture.Body{Text: `"hi"`}, e.RequestBody)
+	assert.Equal(t, http.StatusCreated, e.Status)
+	assert.Equal(t, "application/json", e.ResponseHeaders.Get("Content-Type"))
+	assert.Equal(t, capture.Redacted, e.ResponseHeaders.Get("Set-Cookie"))
+	assert.Equal(t, capture.Body{Text: `{"echo":"hi"}`}, e.ResponseBody)
+	assert.Positive(t, e.LatencyMS)
+	assert.False(t, e.Time.IsZero())
+}
+
+func TestBodies(t *testing.T) {
+	var buf bytes.Buffer
+	rec := capture.New(capture.Options{Writer: &buf, SampleRate: 1, MaxBodyBytes: 4, RedactHeaders: []string{}})
+
+	// Long bodies are truncated in the capture, not for the handler.
+	resp := httptest.NewRecorder()
+	rec.Handler("/", echo).ServeHTTP(resp, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`"abcdef"`)))
+	assert.Equal(t, `{"echo":"abcdef"}`, resp.Body.String())
+
+	// Bodies are captured even if the handler does not read them.
+	ignore := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	rec.Handler("/", ignore).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("ab")))
+
+	// Binary bodies are captured in base64.
+	binary := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write([]byte{0xff, 0xfe}) })
+	rec.Handler("/", binary).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	got := exchanges(t, &buf)
+	require.Len(t, got, 3)
+	assert.Equal(t, capture.Body{Text: `"abc`, Truncated: true}, got[0].RequestBody)
+	assert.Equal(t, capture.Body{Text: `{"ec`, Truncated: true}, got[0].ResponseBody)
+	assert.Equal(t, "session=s3cr3t", got[0].ResponseHeaders.Get("Set-Cookie"), "no header is redacted")
+	assert.Equal(t, capture.Body{Text: "ab"}, got[1].RequestBody)
+	assert.Equal(t, http.StatusNoContent, got[1].Status)
+	assert.Equal(t, capture.Body{Base64: "//4="}, got[2].ResponseBody)
+	b, err := got[2].ResponseBody.Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0xfe}, b)
+	assert.Equal(t, http.StatusOK, got[2].Status)
+}
+
+func TestSampling(t *testing.T) {
+	var buf bytes.Buffer
+	rec := capture.New(capture.Options{Writer: &buf})
+	for range 10 {
+		rec.Handler("/", echo).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("1")))
+	}
+	assert.Zero(t, buf.Len())
+}
@@ -0,0 +1,300 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxReplayedBodyBytes bounds the replayed response bodies read.
+const maxReplayedBodyBytes = 16 << 20
+
+// maxDiffs bounds the differences reported per exchange.
+const maxDiffs = 10
+
+// notReplayedHeaders are recorded request headers not sent again: they
+// describe the recorded connection, or would make the transport keep the
+// response compressed.
+var notReplayedHeaders = []string{"Connection", "Content-Length", "Accept-Encoding", "Keep-Alive", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// ReplayOptions configures Replay.
+type ReplayOptions struct {
+	// Target is the base URL of the server, e.g. http://localhost:8080.
+	Target string
+	// Client sends the requests; a client with a 10s timeout when nil.
+	Client *http.Client
+	// Header is set on every request, replacing the recorded values, e.g.
+	// credentials for the redacted headers, which are not sent.
+	Header http.Header
+	// IgnoreFields are the names of JSON body fields not compared, at any
+	// depth, such as generated IDs and timestamps.
+	IgnoreFields []string
+	// CompareHeaders are the response headers compared; Content-Type when
+	// nil.
+	CompareHeaders []string
+}
+
+// Result is the outcome of replaying an exchange.
+type Result struct {
+	// Line is the line of the exchange in the capture file, from 1.
+	Line     int
+	Exchange Exchange
+	// Status and LatencyMS are those of the replayed response.
+	Status    int
+	LatencyMS float64
+	// Diffs describe how the replayed response differs from the recorded one.
+	Diffs []string
+	// Err is why the exchange could not be replayed.
+	Err error
+}
+
+// Report is the outcome of a replay.
+type Report struct {
+	Results []Result
+}
+
+// Replay re-sends the exchanges captured in r, in order, and compares the
+// responses to the recorded ones. It fails only if r cannot be read.
+func Replay(ctx context.Context, r io.Reader, opts ReplayOptions) (*Report, error) {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.CompareHeaders == nil {
+		opts.CompareHeaders = []string{"Content-Type"}
+	}
+	rep := &Report{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return rep, fmt.Errorf("capture: line %d: %w", line, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return rep, err
+		}
+		res := replay(ctx, e, opts)
+		res.Line = line
+		rep.Results = append(rep.Results, res)
+	}
+	return rep, scanner.Err()
+}
+
+// replay re-sends e and compares the response.
+func replay(ctx context.Context, e Exchange, opts ReplayOptions) Result {
+	res := Result{Exchange: e}
+	if e.RequestBody.Truncated {
+		res.Err = errors.New("the request body was truncated when captured")
+		return res
+	}
+	body, err := e.RequestBody.Bytes()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	req, err := http.NewRequestWithContext(ctx, e.Method, strings.TrimSuffix(opts.Target, "/")+e.URI, bytes.NewReader(body))
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	for name, values := range e.RequestHeaders {
+		if slices.Contains(notReplayedHeaders, name) || !slices.ContainsFunc(values, func(v string) bool { return v != Redacted }) {
+			continue
+		}
+		req.Header[name] = values
+	}
+	for name, values := range opts.Header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+
+	start := time.Now()
+	resp, err := opts.Client.Do(req)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer func() { _ = resp.Body.Close() }()
+	got, err := io.ReadAll(io.LimitReader(resp.Body, maxReplayedBodyBytes))
+	res.LatencyMS = float64(time.Since(start)) / float64(time.Millisecond)
+	res.Status = resp.StatusCode
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	if resp.StatusCode != e.Status {
+		res.Diffs = append(res.Diffs, fmt.Sprintf("status: recorded %d, replayed %d", e.Status, resp.StatusCode))
+	}
+	for _, name := range opts.CompareHeaders {
+		if want, have := e.ResponseHeaders.Get(name), resp.Header.Get(name); want != have {
+			res.Diffs = append(res.Diffs, fmt.Sprintf("header %s: recorded %q, replayed %q", http.CanonicalHeaderKey(name), want, have))
+		}
+	}
+	want, err := e.ResponseBody.Bytes()
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if e.ResponseBody.Truncated && len(got) > len(want) {
+		got = got[:len(want)] // only the recorded part can be compared
+	}
+	res.Diffs = append(res.Diffs, diffBodies(want, got, opts.IgnoreFields)...)
+	return res
+}
+
+// diffBodies describes the differences between the recorded and replayed
+// bodies, field by field if both are JSON.
+func diffBodies(want, got []byte, ignore []string) []string {
+	var a, b any
+	if decodeJSON(want, &a) == nil && decodeJSON(got, &b) == nil {
+		var diffs []string
+		diffJSON("$", a, b, ignore, &diffs)
+		return diffs
+	}
+	if bytes.Equal(want, got) {
+		return nil
+	}
+	return []string{fmt.Sprintf("body: recorded %s, replayed %s", excerpt(string(want)), excerpt(string(got)))}
+}
+
+func decodeJSON(b []byte, v *any) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("trailing data")
+	}
+	return nil
+}
+
+// diffJSON appends the differences between the JSON values a and b at path
+// to diffs.
+func diffJSON(path string, a, b any, ignore []string, diffs *[]string) {
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			keys := maps.Clone(a)
+			maps.Copy(keys, b)
+			for _, k := range slices.Sorted(maps.Keys(keys)) {
+				if slices.Contains(ignore, k) {
+					continue
+				}
+				va, inA := a[k]
+				vb, inB := b[k]
+				switch {
+				case !inA:
+					*diffs = append(*diffs, fmt.Sprintf("body %s.%s: not recorded, replayed %s", path, k, excerpt(vb)))
+				case !inB:
+					*diffs = append(*diffs, fmt.Sprintf("body %s.%s: recorded %s, not replayed", path, k, excerpt(va)))
+				default:
+					diffJSON(path+"."+k, va, vb, ignore, diffs)
+				}
+			}
+			return
+		}
+	case []any:
+		if b, ok := b.([]any); ok {
+			if len(a) != len(b) {
+				*diffs = append(*diffs, fmt.Sprintf("body %s: recorded %d items, replayed %d", path, len(a), len(b)))
+			}
+			for i := range min(len(a), len(b)) {
+				diffJSON(path+"["+strconv.Itoa(i)+"]", a[i], b[i], ignore, diffs)
+			}
+			return
+		}
+	default:
+		if a == b {
+			return
+		}
+	}
+	*diffs = append(*diffs, fmt.Sprintf("body %s: recorded %s, replayed %s", path, excerpt(a), excerpt(b)))
+}
+
+// excerpt returns v, a string or a decoded JSON value, as JSON shortened if
+// long.
+func excerpt(v any) string {
+	b, _ := json.Marshal(v)
+	s := string(b)
+	const maxLen = 80
+	if len(s) > maxLen {
+		s = s[:maxLen] + "…"
+	}
+	return s
+}
+
+// Differed returns the number of replayed exchanges whose response differs.
+func (rep *Report) Differed() int {
+	n := 0
+	for _, res := range rep.Results {
+		if res.Err == nil && len(res.Diffs) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the number of exchanges that could not be replayed.
+func (rep *Report) Failed() int {
+	n := 0
+	for _, res := range rep.Results {
+		if res.Err != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// OK reports whether every exchange was replayed with the recorded response.
+func (rep *Report) OK() bool {
+	return rep.Differed() == 0 && rep.Failed() == 0
+}
+
+// Print writes the exchanges that differed or failed to w, followed by a
+// summary.
+func (rep *Report) Print(w io.Writer) error {
+	var b strings.Builder
+	var recorded, replayed float64
+	for _, res := range rep.Results {
+		e := res.Exchange
+		recorded += e.LatencyMS
+		replayed += res.LatencyMS
+		if res.Err == nil && len(res.Diffs) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "line %d: %s %s (recorded %s)\n", res.Line, e.Method, e.URI, e.Time.Format(time.RFC3339))
+		if res.Err != nil {
+			fmt.Fprintf(&b, "  failed: %v\n", res.Err)
+			continue
+		}
+		for i, d := range res.Diffs {
+			if i == maxDiffs {
+				fmt.Fprintf(&b, "  … and %d more differences\n", len(res.Diffs)-maxDiffs)
+				break
+			}
+			fmt.Fprintf(&b, "  %s\n", d)
+		}
+	}
+	total := len(rep.Results)
+	differed, failed := rep.Differed(), rep.Failed()
+	fmt.Fprintf(&b, "%d exchanges replayed: %d matched, %d differed, %d failed\n", total, total-differed-failed, differed, failed)
+	if total > 0 {
+		fmt.Fprintf(&b, "mean latency: recorded %.1fms, replayed %.1fms\n", recorded/float64(total), replayed/float64(total))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
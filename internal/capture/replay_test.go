@@ -0,0 +1,144 @@
+package capture_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/capture"
+)
+
+// captureFile returns the JSON Lines of exchanges.
+func captureFile(t *testing.T, exchanges ...capture.Exchange) io.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range exchanges {
+		require.NoError(t, enc.Encode(e))
+	}
+	return &buf
+}
+
+func exchange(uri string, status int, body string) capture.Exchange {
+	return capture.Exchange{
+		Time:            time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		Route:           "/api/message",
+		Method:          http.MethodPost,
+		URI:             uri,
+		RequestHeaders:  http.Header{"Content-Type": {"application/json"}, "X-Api-Key": {capture.Redacted}},
+		RequestBody:     capture.Body{Text: `{"type":"repeat","msg":"hi"}`},
+		Status:          status,
+		ResponseHeaders: http.Header{"Content-Type": {"application/json"}},
+		ResponseBody:    capture.Body{Text: body},
+		LatencyMS:       2,
+	}
+}
+
+// target answers /v1 with the repeat response, and /v2 with a changed one.
+func target(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"type":"repeat","msg":"hi"}` || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1":
+			_, _ = io.WriteString(w, `{"type":"repeat","msg":"hi","id":"new"}`)
+		case "/v2":
+			_, _ = io.WriteString(w, `{"type":"repeat","msg":"HI","items":[1,2,3],"new":true}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestReplay(t *testing.T) {
+	srv := target(t)
+	truncated := exchange("/v1", 200, "")
+	truncated.RequestBody.Truncated = true
+	file := captureFile(t,
+		exchange("/v1", 200, `{"type":"repeat","msg":"hi","id":"old"}`),
+		exchange("/v2", 200, `{"type":"repeat","msg":"hi","items":[1,2],"old":true}`),
+		exchange("/v1?x=1", 201, `not json`),
+		truncated,
+	)
+
+	rep, err := capture.Replay(context.Background(), file, capture.ReplayOptions{
+		Target:       srv.URL + "/",
+		Header:       http.Header{"x-api-key": {"key"}},
+		IgnoreFields: []string{"id"},
+	})
+	require.NoError(t, err)
+	require.Len(t, rep.Results, 4)
+
+	assert.Empty(t, rep.Results[0].Diffs, "ignored fields do not differ")
+	assert.NoError(t, rep.Results[0].Err)
+	assert.Equal(t, 1, rep.Results[0].Line)
+	assert.Equal(t, http.StatusOK, rep.Results[0].Status)
+
+	assert.Equal(t, []string{
+		`body $.items: recorded 2 items, replayed 3`,
+		`body $.msg: recorded "hi", replayed "HI"`,
+		`body $.new: not recorded, replayed true`,
+		`body $.old: recorded true, not replayed`,
+	}, rep.Results[1].Diffs)
+
+	assert.Equal(t, []string{
+		`status: recorded 201, replayed 200`,
+		`body: recorded "not json", replayed "{\"type\":\"repeat\",\"msg\":\"hi\",\"id\":\"new\"}"`,
+	}, rep.Results[2].Diffs)
+
+	assert.ErrorContains(t, rep.Results[3].Err, "truncated")
+
+	assert.False(t, rep.OK())
+	assert.Equal(t, 2, rep.Differed())
+	assert.Equal(t, 1, rep.Failed())
+
+	var out strings.Builder
+	require.NoError(t, rep.Print(&out))
+	assert.Contains(t, out.String(), "line 2: POST /v2 (recorded 2026-10-01T12:00:00Z)\n  body $.items: recorded 2 items, replayed 3\n")
+	assert.Contains(t, out.String(), "line 4: POST /v1 (recorded 2026-10-01T12:00:00Z)\n  failed: the request body was truncated when captured\n")
+	assert.Contains(t, out.String(), "4 exchanges replayed: 1 matched, 2 differed, 1 failed\n")
+	assert.Contains(t, out.String(), "mean latency: recorded 2.0ms")
+}
+
+func TestReplayRedactedHeaders(t *testing.T) {
+	srv := target(t)
+
+	// Redacted headers are not sent: without credentials, the server
+	// answers differently.
+	rep, err := capture.Replay(context.Background(), captureFile(t, exchange("/v1", 200, `{}`)), capture.ReplayOptions{Target: srv.URL})
+	require.NoError(t, err)
+	require.Len(t, rep.Results, 1)
+	assert.Contains(t, rep.Results[0].Diffs, "status: recorded 200, replayed 401")
+	assert.Contains(t, rep.Results[0].Diffs, `header Content-Type: recorded "application/json", replayed "text/plain; charset=utf-8"`)
+}
+
+func TestReplayErrors(t *testing.T) {
+	srv := target(t)
+	rep, err := capture.Replay(context.Background(), strings.NewReader("\n{not json}\n"), capture.ReplayOptions{Target: srv.URL})
+	assert.ErrorContains(t, err, "line 2")
+	assert.Empty(t, rep.Results)
+
+	// Unreachable servers fail the exchanges, not the replay.
+	srv.Close()
+	rep, err = capture.Replay(context.Background(), captureFile(t, exchange("/v1", 200, `{}`)), capture.ReplayOptions{Target: srv.URL})
+	require.NoError(t, err)
+	assert.Equal(t, 1, rep.Failed())
+}
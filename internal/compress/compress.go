@@ -0,0 +1,274 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported content codings.
+const (
+	Gzip   = "gzip"
+	Zstd   = "zstd"
+	Brotli = "br"
+)
+
+// Options configures the compression middleware.
+type Options struct {
+	// Encodings lists the enabled codings in server preference order, used to
+	// break ties between equally weighted client preferences.
+	Encodings []string
+	// MinSize is the smallest response body, in bytes, worth compressing.
+	MinSize int
+	// ContentTypes lists the compressible media types; entries ending with
+	// "/" match a whole type (e.g. "text/").
+	ContentTypes []string
+	// Observe, if set, is called after each compressed response with the
+	// handler's (uncompressed) and the written (compressed) byte counts.
+	Observe func(r *http.Request, encoding string, uncompressed, compressed int)
+}
+
+// Handler compresses responses of next according to the request's
+// Accept-Encoding header. Responses that are too small, of a content type not
+// in the allowlist, or already encoded are passed through unchanged.
+func Handler(opts Options, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Vary on every response: whether it gets compressed depends on the header.
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		enc := Negotiate(r.Header.Get("Accept-Encoding"), opts.Encodings)
+		if enc == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &responseWriter{ResponseWriter: w, opts: &opts, encoding: enc, status: http.StatusOK}
+		defer func() {
+			_ = cw.Close()
+			if cw.enc != nil && opts.Observe != nil {
+				opts.Observe(r, enc, cw.in, cw.out.n)
+			}
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Negotiate picks the coding to use for an Accept-Encoding header among the
+// supported ones (in preference order), or "" for no compression.
+func Negotiate(acceptEncoding string, supported []string) string {
+	weights := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		weights[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range supported {
+		q, ok := weights[enc]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// responseWriter buffers the start of the body until it knows whether the
+// response is worth compressing.
+type responseWriter struct {
+	http.ResponseWriter
+	opts     *Options
+	encoding string
+
+	status      int
+	wroteHeader bool // WriteHeader called by the handler
+	decided     bool // headers sent to the client
+	buf         bytes.Buffer
+	enc         io.WriteCloser
+	in          int         // bytes written by the handler
+	out         countWriter // bytes written to the client
+}
+
+type countWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += n
+	return n, err
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader, w.status = true, code
+	// Informational and bodiless responses go through untouched.
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		_ = w.decide(false)
+	}
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.in += len(b)
+	if !w.decided {
+		w.buf.Write(b)
+		if w.buf.Len() < w.opts.MinSize {
+			return len(b), nil
+		}
+		if err := w.decide(w.compressible()); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends buffered data to the client, committing to the current choice.
+func (w *responseWriter) Flush() {
+	if !w.decided {
+		_ = w.decide(w.buf.Len() >= w.opts.MinSize && w.compressible())
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close flushes the buffer and the encoder.
+func (w *responseWriter) Close() error {
+	if !w.decided {
+		if !w.wroteHeader && w.buf.Len() == 0 {
+			// Nothing was written; let net/http send its default response.
+			return nil
+		}
+		if err := w.decide(w.buf.Len() >= w.opts.MinSize && w.compressible()); err != nil {
+			return err
+		}
+	}
+	if w.enc != nil {
+		err := w.enc.Close()
+		putEncoder(w.encoding, w.enc)
+		return err
+	}
+	return nil
+}
+
+// compressible reports whether the response has a compressible type. A
+// missing Content-Type is detected from the buffered body and set, as
+// net/http would only see the compressed bytes.
+func (w *responseWriter) compressible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := h.Get("Content-Type")
+	if _, set := h["Content-Type"]; !set {
+		ct = http.DetectContentType(w.buf.Bytes())
+		h.Set("Content-Type", ct)
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range w.opts.ContentTypes {
+		if mt == allowed || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(mt, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// decide sends the headers and the buffered body, compressed or not.
+func (w *responseWriter) decide(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if compress {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if compress {
+		w.out.w = w.ResponseWriter
+		w.enc = getEncoder(w.encoding, &w.out)
+		_, err := w.enc.Write(w.buf.Bytes())
+		return err
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	return err
+}
+
+var pools = map[string]*sync.Pool{
+	Gzip: {New: func() any { return gzip.NewWriter(io.Discard) }},
+	Zstd: {New: func() any {
+		e, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return e
+	}},
+	Brotli: {New: func() any { return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression) }},
+}
+
+type resetter interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+func getEncoder(encoding string, w io.Writer) io.WriteCloser {
+	e := pools[encoding].Get().(resetter)
+	e.Reset(w)
+	return e
+}
+
+func putEncoder(encoding string, e io.WriteCloser) {
+	pools[encoding].Put(e)
+}
+
+// Supported reports whether encoding is a supported content coding.
+func Supported(encoding string) bool {
+	_, ok := pools[encoding]
+	return ok
+}
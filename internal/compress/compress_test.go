@@ -0,0 +1,181 @@
+package compress_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/compress"
+)
+
+var all = []string{compress.Zstd, compress.Brotli, compress.Gzip}
+
+func TestNegotiate(t *testing.T) {
+	tests := map[string]struct {
+		header string
+		want   string
+	}{
+		"empty":                 {header: "", want: ""},
+		"identity only":         {header: "identity", want: ""},
+		"single":                {header: "gzip", want: "gzip"},
+		"server preference":     {header: "gzip, br, zstd", want: "zstd"},
+		"client weights":        {header: "zstd;q=0.5, gzip;q=0.8", want: "gzip"},
+		"refused":               {header: "zstd;q=0, br;q=0, gzip", want: "gzip"},
+		"wildcard":              {header: "*", want: "zstd"},
+		"wildcard with refusal": {header: "zstd;q=0, *;q=0.1", want: "br"},
+		"case insensitive":      {header: "GZIP", want: "gzip"},
+		"unsupported":           {header: "deflate, compress", want: ""},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, compress.Negotiate(tc.header, all))
+		})
+	}
+}
+
+func decode(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case compress.Gzip:
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		r = zr
+	case compress.Zstd:
+		zr, err := zstd.NewReader(bytes.NewReader(body))
+		require.NoError(t, err)
+		defer zr.Close()
+		r = zr
+	case compress.Brotli:
+		r = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return string(body)
+	}
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestHandler(t *testing.T) {
+	large := strings.Repeat("hello compression ", 200)
+	serve := func(contentType, contentEncoding, body string, status int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			if contentEncoding != "" {
+				w.Header().Set("Content-Encoding", contentEncoding)
+			}
+			w.WriteHeader(status)
+			// Write in chunks to exercise buffering across the threshold.
+			for chunk := range strings.SplitSeq(body, " ") {
+				_, _ = io.WriteString(w, chunk+" ")
+			}
+		})
+	}
+
+	tests := map[string]struct {
+		handler      http.Handler
+		method       string
+		accept       string
+		wantEncoding string
+	}{
+		"gzip":                 {handler: serve("application/json", "", large, 200), accept: "gzip", wantEncoding: "gzip"},
+		"zstd":                 {handler: serve("application/json", "", large, 200), accept: "zstd", wantEncoding: "zstd"},
+		"brotli":               {handler: serve("text/plain; charset=utf-8", "", large, 200), accept: "br", wantEncoding: "br"},
+		"sniffed content type": {handler: serve("", "", large, 200), accept: "gzip", wantEncoding: "gzip"},
+		"error status":         {handler: serve("application/json", "", large, 500), accept: "gzip", wantEncoding: "gzip"},
+		"not accepted":         {handler: serve("application/json", "", large, 200)},
+		"below threshold":      {handler: serve("application/json", "", "tiny", 200), accept: "gzip"},
+		"type not allowed":     {handler: serve("image/png", "", large, 200), accept: "gzip"},
+		"already encoded":      {handler: serve("application/json", "identity", large, 200), accept: "gzip"},
+		"head":                 {handler: serve("application/json", "", large, 200), method: http.MethodHead, accept: "gzip"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var observed []int
+			h := compress.Handler(compress.Options{
+				Encodings:    all,
+				MinSize:      256,
+				ContentTypes: []string{"application/json", "text/"},
+				Observe: func(_ *http.Request, encoding string, in, out int) {
+					assert.Equal(t, tc.wantEncoding, encoding)
+					observed = append(observed, in, out)
+				},
+			}, tc.handler)
+
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/", nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept-Encoding", tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+			if tc.wantEncoding == "" {
+				assert.NotEqual(t, "gzip", rec.Header().Get("Content-Encoding"))
+				assert.Empty(t, observed)
+				return
+			}
+			assert.Equal(t, tc.wantEncoding, rec.Header().Get("Content-Encoding"))
+			assert.Empty(t, rec.Header().Get("Content-Length"))
+			wire := rec.Body.Len()
+			assert.Equal(t, strings.TrimSpace(large), strings.TrimSpace(decode(t, tc.wantEncoding, rec.Body.Bytes())))
+			require.Len(t, observed, 2)
+			assert.Equal(t, wire, observed[1])
+			assert.Less(t, observed[1], observed[0])
+		})
+	}
+}
+
+func TestHandlerNoContent(t *testing.T) {
+	h := compress.Handler(compress.Options{Encodings: all, ContentTypes: []string{"text/"}},
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Zero(t, rec.Body.Len())
+}
+
+func TestHandlerUntyped(t *testing.T) {
+	body := strings.Repeat("<p>hello compression</p>", 100)
+	h := compress.Handler(compress.Options{Encodings: all, ContentTypes: []string{"text/"}},
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, body)
+		}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := (&http.Transport{DisableCompression: true}).RoundTrip(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	raw, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	// The type is detected from the body, not from the compressed bytes.
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, body, decode(t, "gzip", raw))
+}
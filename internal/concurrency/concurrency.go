@@ -0,0 +1,98 @@
+package concurrency
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Priority classifies requests for load shedding.
+type Priority int
+
+const (
+	// PriorityNormal requests are shed once the limit is reached.
+	PriorityNormal Priority = iota
+	// PriorityCritical requests (e.g. health probes) are never shed, but
+	// still count towards the inflight requests.
+	PriorityCritical
+)
+
+const (
+	minLimit = 1
+	// backoffRatio is the multiplicative decrease applied on overload.
+	backoffRatio = 0.9
+)
+
+// Limiter is an AIMD concurrency limiter: the limit grows by one when a
+// request completes within the latency target while the limiter is at least
+// half utilised, and shrinks by backoffRatio when a request is slower than the
+// target or fails.
+type Limiter struct {
+	target   time.Duration
+	maxLimit float64
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+}
+
+// New returns a Limiter starting at initial concurrent requests, growing up to
+// max, and treating requests slower than target as a sign of overload.
+func New(initial, max int, target time.Duration) *Limiter {
+	return &Limiter{
+		target:   target,
+		maxLimit: float64(max),
+		limit:    math.Max(minLimit, math.Min(float64(initial), float64(max))),
+	}
+}
+
+// Token is an admitted request; it must be released exactly once.
+type Token struct {
+	l *Limiter
+	// inflight is the number of requests in flight when the token was acquired.
+	inflight int
+	once     sync.Once
+}
+
+// Acquire admits a request if the limit allows it. Critical requests are
+// always admitted.
+func (l *Limiter) Acquire(p Priority) (*Token, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if p != PriorityCritical && float64(l.inflight) >= math.Floor(l.limit) {
+		return nil, false
+	}
+	l.inflight++
+	return &Token{l: l, inflight: l.inflight}, true
+}
+
+// Release marks the request as done and adjusts the limit from its latency
+// and outcome.
+func (t *Token) Release(latency time.Duration, failed bool) {
+	t.once.Do(func() {
+		l := t.l
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.inflight--
+		switch {
+		case failed || latency > l.target:
+			l.limit = math.Max(minLimit, l.limit*backoffRatio)
+		case float64(t.inflight)*2 >= l.limit:
+			l.limit = math.Min(l.maxLimit, l.limit+1)
+		}
+	})
+}
+
+// Limit returns the current concurrency limit.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Inflight returns the number of admitted requests not yet released.
+func (l *Limiter) Inflight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
@@ -0,0 +1,73 @@
+package concurrency_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcosartorato/myapp/internal/concurrency"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterSheds(t *testing.T) {
+	l := concurrency.New(2, 10, time.Second)
+
+	a, ok := l.Acquire(concurrency.PriorityNormal)
+	require.True(t, ok)
+	_, ok = l.Acquire(concurrency.PriorityNormal)
+	require.True(t, ok)
+
+	_, ok = l.Acquire(concurrency.PriorityNormal)
+	assert.False(t, ok, "normal request over the limit must be shed")
+
+	probe, ok := l.Acquire(concurrency.PriorityCritical)
+	assert.True(t, ok, "critical requests are never shed")
+	assert.Equal(t, 3, l.Inflight())
+
+	probe.Release(time.Millisecond, false)
+	a.Release(time.Millisecond, false)
+	a.Release(time.Millisecond, false) // releasing twice is a no-op
+	assert.Equal(t, 1, l.Inflight())
+
+	_, ok = l.Acquire(concurrency.PriorityNormal)
+	assert.True(t, ok)
+}
+
+func TestLimiterAdapts(t *testing.T) {
+	l := concurrency.New(10, 12, 100*time.Millisecond)
+
+	// Fast requests at full utilisation grow the limit, up to max.
+	for i := 0; i < 5; i++ {
+		var toks []*concurrency.Token
+		for {
+			tok, ok := l.Acquire(concurrency.PriorityNormal)
+			if !ok {
+				break
+			}
+			toks = append(toks, tok)
+		}
+		for _, tok := range toks {
+			tok.Release(10*time.Millisecond, false)
+		}
+	}
+	assert.Equal(t, 12, l.Limit())
+
+	// Slow requests shrink it multiplicatively.
+	tok, _ := l.Acquire(concurrency.PriorityNormal)
+	tok.Release(time.Second, false)
+	assert.Equal(t, 10, l.Limit()) // 12 * 0.9 = 10.8
+
+	// Failures shrink it too, but never below one.
+	for i := 0; i < 100; i++ {
+		tok, _ := l.Acquire(concurrency.PriorityNormal)
+		tok.Release(time.Millisecond, true)
+	}
+	assert.Equal(t, 1, l.Limit())
+
+	// A lightly used limiter does not grow.
+	l = concurrency.New(10, 100, time.Second)
+	tok, _ = l.Acquire(concurrency.PriorityNormal)
+	tok.Release(time.Millisecond, false)
+	assert.Equal(t, 10, l.Limit())
+}
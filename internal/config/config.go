@@ -3,8 +3,20 @@ package config
 import (
 	"fmt"
 	"math/bits"
+	"net/url"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/marcosartorato/myapp/internal/accesslog"
+	"github.com/marcosartorato/myapp/internal/compress"
+	"github.com/marcosartorato/myapp/internal/cors"
+	"github.com/marcosartorato/myapp/internal/csp"
+	"github.com/marcosartorato/myapp/internal/loki"
+	"github.com/marcosartorato/myapp/internal/redact"
+	"go.uber.org/zap/zapcore"
 )
 
 /*
@@ -30,10 +42,224 @@ Options provides configuration for the HTTP server.
     |---------------| <----------------|
     |
     | time
+
+  - RouteTimeouts maps a route pattern to the TimeoutHandler it gets instead
+    of the default.
+
+  - MaxBodyBytes limits the size of request bodies, with RouteMaxBodyBytes
+    mapping a route pattern to its own limit. Zero means no limit.
+
+  - CORSAllowedOrigins enables CORS on all routes, for origins that are
+    exact, with a wildcard subdomain ("https://*.example.com") or "*".
+    CORSRouteOrigins maps a route pattern to its own origins, none disabling
+    CORS on the route. CORSAllowedMethods (the route's methods when empty),
+    CORSAllowedHeaders, CORSExposedHeaders, CORSAllowCredentials and
+    CORSMaxAge complete the policy.
+
+  - TLSCertFile and TLSKeyFile, when set, serve the API over TLS.
+
+  - HSTSMaxAge, when positive, sets Strict-Transport-Security on responses
+    to TLS requests, HSTSIncludeSubdomains extending it to subdomains.
+    FrameOptions, ReferrerPolicy and PermissionsPolicy are the values of the
+    X-Frame-Options, Referrer-Policy and Permissions-Policy headers, empty
+    for none.
+
+  - CSPDirectives maps Content-Security-Policy directives to their sources;
+    none disables the header. CSPReportOnly reports violations without
+    blocking them.
+
+  - AccessLogFormat ("json", "combined" or "logfmt") enables the access log,
+    one line per request. AccessLogSampleRate is the fraction of successful
+    requests logged; errors and requests lasting AccessLogSlowThreshold or
+    more always are.
+
+  - CaptureFile, when set, records a CaptureSampleRate fraction of the
+    requests to CaptureRoutes (all routes but event streams when empty) and
+    their responses to that file as JSON Lines, for "myapp replay". Bodies
+    are captured up to CaptureMaxBodyBytes, and the values of
+    CaptureRedactHeaders are redacted.
+
+  - LogLevel is the minimum level logged and LogEncoding "json" or
+    "console". Logs are written to each of LogOutputs: "stdout", "stderr" or
+    a file path. Files are rotated once larger than LogFileMaxSize megabytes;
+    rotated files older than LogFileMaxAge (rounded up to days) or beyond the
+    LogFileMaxBackups most recent are removed, and compressed when
+    LogFileCompress is set. Zero means no limit.
+
+  - LogSampling, when set, logs the first Initial entries with the same
+    level and message each second, then every Thereafter-th. Access log
+    entries are not sampled, see AccessLogSampleRate instead.
+
+  - LogFieldNames renames the time, level, message, caller, stacktrace and
+    name (of the logger) fields, e.g. to ECS names; LogTimeEncoding is one of
+    "epoch", "millis", "nanos", "iso8601", "rfc3339" or "rfc3339nano".
+    LogCaller adds the calling file and line to entries, and entries at
+    LogStacktraceLevel or above get a stack trace.
+
+  - LogRedactKeys are fragments of sensitive field keys, whose values are
+    redacted; LogRedactValues names the built-in rules ("email", "card",
+    "bearer") redacting values in strings, along with LogRedactRegexp.
+    Fields keyed by LogPayloadKeys are logged hashed or truncated to
+    LogPayloadMaxLength characters, depending on LogPayloadMode. The access
+    log is redacted too.
+
+  - LokiURL, when set, also pushes logs to that Loki push endpoint, in
+    streams labeled with LokiLabels and the level, as LokiTenantID if set.
+    Entries are pushed by batches of up to LokiBatchSize, at least every
+    LokiFlushInterval. Up to LokiBufferSize entries wait to be pushed; when
+    more are logged, LokiDropPolicy drops the oldest or the newest. Failed
+    pushes are retried up to LokiMaxRetries times, the delay between retries
+    starting at LokiBackoff and doubling.
+
+  - DocsUI enables the API reference page rendering the OpenAPI document.
+    The page loads Redoc from its CDN, or from the app when DocsRedocFile is
+    the path of the Redoc standalone bundle, e.g. for air-gapped deployments.
+
+  - ValidateResponses checks handler responses against the OpenAPI document;
+    meant for tests and debugging since responses are buffered.
+
+  - RateLimits maps a route pattern to its token bucket. Clients are told
+    apart by RateLimitKey ("ip", "api-key" for the authenticated principal,
+    or "header:<name>") and at most RateLimitMaxKeys clients are tracked per
+    route.
+
+  - ConcurrencyLimits maps a route pattern to its adaptive concurrency limiter.
+
+  - AuthAPIKeysFile and AuthJWKS (a file path or an http(s) URL) enable API key
+    and JWT bearer authentication respectively. AuthIssuer and AuthAudience,
+    when set, must match the token claims; AuthClockSkew is tolerated on the
+    token validity window.
+
+  - AuthHMACKeysFile enables HMAC request signing; signatures are accepted
+    within AuthHMACWindow of their timestamp, and only once.
+
+  - CompressionEncodings lists the enabled response codings in preference
+    order; responses of at least CompressionMinSize bytes whose media type
+    matches CompressionTypes (a trailing "/" matches a whole type) are
+    compressed. No encodings disables compression.
+
+  - IdempotencyTTL is how long responses to requests carrying an
+    Idempotency-Key are kept for replay. Zero disables idempotency keys.
+    At most IdempotencyMaxKeys keys are kept, the least recently used one
+    being dropped first.
+
+  - MessageStorePath is the database file messages are stored in; they are
+    kept in memory when empty, at most MessageStoreMaxMessages of them, the
+    oldest being removed first.
+
+  - ScheduleStorePath is the database file pending scheduled messages are
+    stored in; they are kept in memory, and lost on restart, when empty.
+    Due messages are logged and, when ScheduleWebhookURL is set, POSTed to
+    it as JSON. Scheduling past ScheduleMaxPending pending messages answers
+    429.
+
+  - Webhooks maps message types ("*" for all) to the URLs notified of the
+    processed messages of that type. Deliveries are signed with the key in
+    WebhookSigningKeyFile and attempted up to WebhookMaxAttempts times, the
+    delay between attempts starting at WebhookBackoff and doubling. At most
+    WebhookMaxDeadLetters failed deliveries are kept, the oldest being
+    dropped first.
+
+  - PubSubMaxSubscriptions bounds the open pub/sub subscriptions, and
+    PubSubMaxSubscriptionsPerPrincipal those of each authenticated caller.
+    Subscribing past either answers 429.
+
+  - HelloTemplatesFile is a JSON file of /hello greeting templates by
+    locale, overriding or adding to the built-in ones.
 */
 type Options struct {
 	Host, Port                                                  *string
 	ReadHeaderTimeout, ReadTimeout, TimeoutHandler, IdleTimeout time.Duration
+	RouteTimeouts                                               map[string]time.Duration
+	MaxBodyBytes                                                int64
+	RouteMaxBodyBytes                                           map[string]int64
+	DocsUI, ValidateResponses                                   bool
+	DocsRedocFile                                               string
+	RateLimits                                                  map[string]RateLimit
+	RateLimitKey                                                string
+	RateLimitMaxKeys                                            int
+	ConcurrencyLimits                                           map[string]ConcurrencyLimit
+	AuthAPIKeysFile, AuthJWKS, AuthIssuer, AuthAudience         string
+	AuthClockSkew                                               time.Duration
+	AuthHMACKeysFile                                            string
+	AuthHMACWindow                                              time.Duration
+	CompressionEncodings, CompressionTypes                      []string
+	CompressionMinSize                                          int
+	IdempotencyTTL                                              time.Duration
+	IdempotencyMaxKeys                                          int
+	MessageStorePath                                            string
+	MessageStoreMaxMessages                                     int
+	ScheduleStorePath, ScheduleWebhookURL                       string
+	ScheduleMaxPending                                          int
+	Webhooks                                                    map[string][]string
+	WebhookSigningKeyFile                                       string
+	WebhookMaxAttempts                                          int
+	WebhookBackoff                                              time.Duration
+	WebhookMaxDeadLetters                                       int
+	PubSubMaxSubscriptions, PubSubMaxSubscriptionsPerPrincipal  int
+	HelloTemplatesFile                                          string
+	CORSAllowedOrigins, CORSAllowedMethods                      []string
+	CORSAllowedHeaders, CORSExposedHeaders                      []string
+	CORSAllowCredentials                                        bool
+	CORSMaxAge                                                  time.Duration
+	CORSRouteOrigins                                            map[string][]string
+	TLSCertFile, TLSKeyFile                                     string
+	HSTSMaxAge                                                  time.Duration
+	HSTSIncludeSubdomains                                       bool
+	FrameOptions, ReferrerPolicy, PermissionsPolicy             string
+	CSPDirectives                                               map[string][]string
+	CSPReportOnly                                               bool
+	AccessLogFormat                                             string
+	AccessLogSampleRate                                         float64
+	AccessLogSlowThreshold                                      time.Duration
+	CaptureFile                                                 string
+	CaptureSampleRate                                           float64
+	CaptureRoutes                                               []string
+	CaptureMaxBodyBytes                                         int64
+	CaptureRedactHeaders                                        []string
+	LogLevel                                                    zapcore.Level
+	LogEncoding                                                 string
+	LogOutputs                                                  []string
+	LogFileMaxSize, LogFileMaxBackups                           int
+	LogFileMaxAge                                               time.Duration
+	LogFileCompress                                             bool
+	LogSampling                                                 LogSampling
+	LogFieldNames                                               map[string]string
+	LogTimeEncoding                                             string
+	LogCaller                                                   bool
+	LogStacktraceLevel                                          *zapcore.Level
+	LogRedactKeys, LogRedactValues                              []string
+	LogRedactRegexp                                             *regexp.Regexp
+	LogPayloadKeys                                              []string
+	LogPayloadMode                                              string
+	LogPayloadMaxLength                                         int
+	LokiURL, LokiTenantID                                       string
+	LokiLabels                                                  map[string]string
+	LokiBatchSize, LokiBufferSize                               int
+	LokiFlushInterval                                           time.Duration
+	LokiDropPolicy                                              loki.Policy
+	LokiMaxRetries                                              int
+	LokiBackoff                                                 time.Duration
+}
+
+// ConcurrencyLimit configures an adaptive concurrency limiter: it starts at
+// Initial concurrent requests and adapts up to Max, backing off whenever a
+// request takes longer than Target.
+type ConcurrencyLimit struct {
+	Initial, Max int
+	Target       time.Duration
+}
+
+// RateLimit is a token bucket refilling Rate tokens per second up to Burst tokens.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// LogSampling logs the first Initial entries with the same level and message
+// each second, then every Thereafter-th. The zero value disables sampling.
+type LogSampling struct {
+	Initial, Thereafter int
 }
 
 type Option func(*Options) error
@@ -41,7 +267,7 @@ type Option func(*Options) error
 // WithIdleTimeout returns an Option that sets the IdleTimeout.
 func WithIdleTimeout(timeout int64) Option {
 	return func(o *Options) error {
-		if timeout > 0 {
+		if timeout <= 0 {
 			return fmt.Errorf("IdleTimeout must be positive")
 		}
 		o.IdleTimeout = time.Duration(timeout) * time.Millisecond
@@ -52,7 +278,7 @@ func WithIdleTimeout(timeout int64) Option {
 // WithTimeoutHandler returns an Option that sets the TimeoutHandler.
 func WithTimeoutHandler(timeout int64) Option {
 	return func(o *Options) error {
-		if timeout > 0 {
+		if timeout <= 0 {
 			return fmt.Errorf("TimeoutHandler must be positive")
 		}
 		o.TimeoutHandler = time.Duration(timeout) * time.Millisecond
@@ -60,10 +286,63 @@ func WithTimeoutHandler(timeout int64) Option {
 	}
 }
 
+// WithRouteTimeouts returns an Option that sets per-route handler timeouts
+// from a comma-separated list of route=duration entries, e.g. "/api/message=5s".
+func WithRouteTimeouts(spec string) Option {
+	return func(o *Options) error {
+		timeouts := map[string]time.Duration{}
+		for _, entry := range splitList(spec) {
+			route, value, ok := strings.Cut(entry, "=")
+			if !ok || route == "" {
+				return fmt.Errorf("invalid route timeout %q: want route=duration", entry)
+			}
+			timeout, err := time.ParseDuration(value)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("invalid route timeout %q: timeout must be a positive duration", entry)
+			}
+			timeouts[route] = timeout
+		}
+		o.RouteTimeouts = timeouts
+		return nil
+	}
+}
+
+// WithMaxBodyBytes returns an Option that sets the MaxBodyBytes, 0 for no limit.
+func WithMaxBodyBytes(n int64) Option {
+	return func(o *Options) error {
+		if n < 0 {
+			return fmt.Errorf("MaxBodyBytes must not be negative")
+		}
+		o.MaxBodyBytes = n
+		return nil
+	}
+}
+
+// WithRouteMaxBodyBytes returns an Option that sets per-route request body
+// limits from a comma-separated list of route=bytes entries, e.g. "/api/message=65536".
+func WithRouteMaxBodyBytes(spec string) Option {
+	return func(o *Options) error {
+		limits := map[string]int64{}
+		for _, entry := range splitList(spec) {
+			route, value, ok := strings.Cut(entry, "=")
+			if !ok || route == "" {
+				return fmt.Errorf("invalid route body limit %q: want route=bytes", entry)
+			}
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid route body limit %q: bytes must be a positive integer", entry)
+			}
+			limits[route] = n
+		}
+		o.RouteMaxBodyBytes = limits
+		return nil
+	}
+}
+
 // WithReadTimeout returns an Option that sets the ReadTimeout.
 func WithReadTimeout(timeout int64) Option {
 	return func(o *Options) error {
-		if timeout > 0 {
+		if timeout <= 0 {
 			return fmt.Errorf("ReadTimeout must be positive")
 		}
 		o.ReadTimeout = time.Duration(timeout) * time.Millisecond
@@ -74,7 +353,7 @@ func WithReadTimeout(timeout int64) Option {
 // WithReadHeaderTimeout returns an Option that sets the ReadHeaderTimeout.
 func WithReadHeaderTimeout(timeout int64) Option {
 	return func(o *Options) error {
-		if timeout > 0 {
+		if timeout <= 0 {
 			return fmt.Errorf("ReadHeaderTimeout must be positive")
 		}
 		o.ReadHeaderTimeout = time.Duration(timeout) * time.Millisecond
@@ -107,3 +386,1025 @@ func WithPort(port *string) Option {
 		return nil
 	}
 }
+
+// WithDocsUI returns an Option that enables or disables the API reference page.
+func WithDocsUI(enabled bool) Option {
+	return func(o *Options) error {
+		o.DocsUI = enabled
+		return nil
+	}
+}
+
+// WithDocsRedocFile returns an Option that sets the Redoc bundle served for
+// the API reference page.
+func WithDocsRedocFile(path string) Option {
+	return func(o *Options) error {
+		o.DocsRedocFile = path
+		return nil
+	}
+}
+
+// WithValidateResponses returns an Option that enables or disables response validation.
+func WithValidateResponses(enabled bool) Option {
+	return func(o *Options) error {
+		o.ValidateResponses = enabled
+		return nil
+	}
+}
+
+// WithRateLimits returns an Option that sets the per-route token buckets from a
+// comma-separated list of route=rate:burst entries, e.g. "/api/message=10:20".
+func WithRateLimits(spec string) Option {
+	return func(o *Options) error {
+				limits := map[string]RateLimit{}
+		for _, entry := range splitList(spec) {
+			route, bucket, ok := strings.Cut(entry, "=")
+			rateStr, burstStr, ok2 := strings.Cut(bucket, ":")
+			if !ok || !ok2 || route == "" {
+				return fmt.Errorf("invalid rate limit %q: want route=rate:burst", entry)
+			}
+			rate, err := strconv.ParseFloat(rateStr, 64)
+			if err != nil || rate <= 0 {
+				return fmt.Errorf("invalid rate limit %q: rate must be a positive number", entry)
+			}
+			burst, err := strconv.Atoi(burstStr)
+			if err != nil || burst <= 0 {
+				return fmt.Errorf("invalid rate limit %q: burst must be a positive integer", entry)
+			}
+			limits[route] = RateLimit{Rate: rate, Burst: burst}
+		}
+		o.RateLimits = limits
+		return nil
+	}
+}
+
+// WithRateLimitKey returns an Option that sets how clients are identified for
+// rate limiting: "ip", "api-key" or "header:<name>".
+func WithRateLimitKey(key string) Option {
+	return func(o *Options) error {
+		switch name, ok := strings.CutPrefix(key, "header:"); {
+		case key == "ip", key == "api-key":
+		case ok && name != "":
+		default:
+			return fmt.Errorf("invalid rate limit key: %s", key)
+		}
+		o.RateLimitKey = key
+		return nil
+	}
+}
+
+// WithRateLimitMaxKeys returns an Option that sets the RateLimitMaxKeys.
+func WithRateLimitMaxKeys(n int64) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return fmt.Errorf("RateLimitMaxKeys must be positive")
+		}
+		o.RateLimitMaxKeys = int(n)
+		return nil
+	}
+}
+
+// WithConcurrencyLimits returns an Option that sets the per-route adaptive
+// concurrency limiters from a comma-separated list of route=initial:max:target
+// entries, e.g. "/api/message=10:100:250ms".
+func WithConcurrencyLimits(spec string) Option {
+	return func(o *Options) error {
+				limits := map[string]ConcurrencyLimit{}
+		for _, entry := range splitList(spec) {
+			route, params, ok := strings.Cut(entry, "=")
+			fields := strings.Split(params, ":")
+			if !ok || route == "" || len(fields) != 3 {
+				return fmt.Errorf("invalid concurrency limit %q: want route=initial:max:target", entry)
+			}
+			initial, err := strconv.Atoi(fields[0])
+			if err != nil || initial <= 0 {
+				return fmt.Errorf("invalid concurrency limit %q: initial must be a positive integer", entry)
+			}
+			maxLimit, err := strconv.Atoi(fields[1])
+			if err != nil || maxLimit < initial {
+				return fmt.Errorf("invalid concurrency limit %q: max must be an integer not lower than initial", entry)
+			}
+			target, err := time.ParseDuration(fields[2])
+			if err != nil || target <= 0 {
+				return fmt.Errorf("invalid concurrency limit %q: target must be a positive duration", entry)
+			}
+			limits[route] = ConcurrencyLimit{Initial: initial, Max: maxLimit, Target: target}
+		}
+		o.ConcurrencyLimits = limits
+		return nil
+	}
+}
+
+// WithAuthAPIKeysFile returns an Option that sets the AuthAPIKeysFile.
+func WithAuthAPIKeysFile(path string) Option {
+	return func(o *Options) error {
+		o.AuthAPIKeysFile = path
+		return nil
+	}
+}
+
+// WithAuthJWKS returns an Option that sets the AuthJWKS.
+func WithAuthJWKS(source string) Option {
+	return func(o *Options) error {
+		o.AuthJWKS = source
+		return nil
+	}
+}
+
+// WithAuthIssuer returns an Option that sets the AuthIssuer.
+func WithAuthIssuer(issuer string) Option {
+	return func(o *Options) error {
+		o.AuthIssuer = issuer
+		return nil
+	}
+}
+
+// WithAuthAudience returns an Option that sets the AuthAudience.
+func WithAuthAudience(audience string) Option {
+	return func(o *Options) error {
+		o.AuthAudience = audience
+		return nil
+	}
+}
+
+// WithAuthClockSkew returns an Option that sets the AuthClockSkew.
+func WithAuthClockSkew(skew int64) Option {
+	return func(o *Options) error {
+		if skew < 0 {
+			return fmt.Errorf("AuthClockSkew cannot be negative")
+		}
+		o.AuthClockSkew = time.Duration(skew) * time.Millisecond
+		return nil
+	}
+}
+
+// WithAuthHMACKeysFile returns an Option that sets the AuthHMACKeysFile.
+func WithAuthHMACKeysFile(path string) Option {
+	return func(o *Options) error {
+		o.AuthHMACKeysFile = path
+		return nil
+	}
+}
+
+// WithAuthHMACWindow returns an Option that sets the AuthHMACWindow.
+func WithAuthHMACWindow(window int64) Option {
+	return func(o *Options) error {
+		if window <= 0 {
+			return fmt.Errorf("AuthHMACWindow must be positive")
+		}
+		o.AuthHMACWindow = time.Duration(window) * time.Millisecond
+		return nil
+	}
+}
+
+// WithCompressionEncodings returns an Option that sets the CompressionEncodings
+// from a comma-separated list in preference order, e.g. "zstd,br,gzip".
+func WithCompressionEncodings(spec string) Option {
+	return func(o *Options) error {
+		encodings := splitList(spec)
+		for _, enc := range encodings {
+			if !compress.Supported(enc) {
+				return fmt.Errorf("unsupported compression encoding: %s", enc)
+			}
+		}
+		o.CompressionEncodings = encodings
+		return nil
+	}
+}
+
+// WithCompressionTypes returns an Option that sets the CompressionTypes from a
+// comma-separated list, e.g. "application/json,text/".
+func WithCompressionTypes(spec string) Option {
+	return func(o *Options) error {
+		o.CompressionTypes = splitList(spec)
+		return nil
+	}
+}
+
+// WithCompressionMinSize returns an Option that sets the CompressionMinSize.
+func WithCompressionMinSize(size int64) Option {
+	return func(o *Options) error {
+		if size < 0 {
+			return fmt.Errorf("CompressionMinSize must not be negative")
+		}
+		o.CompressionMinSize = int(size)
+		return nil
+	}
+}
+
+// WithIdempotencyTTL returns an Option that sets the IdempotencyTTL in
+// milliseconds, 0 disabling idempotency keys.
+func WithIdempotencyTTL(ttl int64) Option {
+	return func(o *Options) error {
+		if ttl < 0 {
+			return fmt.Errorf("IdempotencyTTL must not be negative")
+		}
+		o.IdempotencyTTL = time.Duration(ttl) * time.Millisecond
+		return nil
+	}
+}
+
+// WithIdempotencyMaxKeys returns an Option that sets the IdempotencyMaxKeys.
+func WithIdempotencyMaxKeys(n int64) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return fmt.Errorf("IdempotencyMaxKeys must be positive")
+		}
+		o.IdempotencyMaxKeys = int(n)
+		return nil
+	}
+}
+
+// WithMessageStorePath returns an Option that sets the MessageStorePath,
+// empty keeping messages in memory.
+func WithMessageStorePath(path string) Option {
+	return func(o *Options) error {
+		o.MessageStorePath = path
+		return nil
+	}
+}
+
+// WithMessageStoreMaxMessages returns an Option that sets the maximum number
+// of messages kept in memory when no MessageStorePath is set.
+func WithMessageStoreMaxMessages(n int64) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return fmt.Errorf("MessageStoreMaxMessages must be positive")
+		}
+		o.MessageStoreMaxMessages = int(n)
+		return nil
+	}
+}
+
+// WithScheduleStorePath returns an Option that sets the ScheduleStorePath,
+// empty keeping pending messages in memory.
+func WithScheduleStorePath(path string) Option {
+	return func(o *Options) error {
+		o.ScheduleStorePath = path
+		return nil
+	}
+}
+
+// WithScheduleWebhookURL returns an Option that sets the ScheduleWebhookURL,
+// which must be an absolute http or https URL if set.
+func WithScheduleWebhookURL(rawURL string) Option {
+	return func(o *Options) error {
+		if rawURL == "" {
+			o.ScheduleWebhookURL = ""
+			return nil
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("ScheduleWebhookURL must be an absolute http or https URL")
+		}
+		o.ScheduleWebhookURL = rawURL
+		return nil
+	}
+}
+
+// WithScheduleMaxPending returns an Option that sets the ScheduleMaxPending.
+func WithScheduleMaxPending(n int64) Option {
+	return func(o *Options) error {
+		if n <= 0 {
+			return fmt.Errorf("ScheduleMaxPending must be positive")
+		}
+		o.ScheduleMaxPending = int(n)
+		return nil
+	}
+}
+
+// WithWebhooks returns an Option that sets the webhook subscriptions from a
+// comma-separated list of type=url entries, e.g. "repeat=https://example.com/hook".
+// A type may be subscribed to several URLs by repeating it.
+func WithWebhooks(spec string) Option {
+	return func(o *Options) error {
+		hooks := map[string][]string{}
+		for _, entry := range splitList(spec) {
+			typ, rawURL, ok := strings.Cut(entry, "=")
+			if !ok || typ == "" {
+				return fmt.Errorf("invalid webhook %q: want type=url", entry)
+			}
+			u, err := url.Parse(rawURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid webhook %q: url must be an absolute http or https URL", entry)
+			}
+			hooks[typ] = append(hooks[typ], rawURL)
+		}
+		o.Webhooks = hooks
+		return nil
+	}
+}
+
+// WithWebhookSigningKeyFile returns an Option that sets the
+// WebhookSigningKeyFile.
+func WithWebhookSigningKeyFile(path string) Option {
+	return func(o *Options) error {
+		o.WebhookSigningKeyFile = path
+		return nil
+	}
+}
+
+// WithWebhookMaxAttempts returns an Option that sets the WebhookMaxAttempts.
+func WithWebhookMaxAttempts(n int64) Option {
+	return func(o *Options) error {
+		if n < 1 {
+			return fmt.Errorf("WebhookMaxAttempts must be positive")
+		}
+		o.WebhookMaxAttempts = int(n)
+		return nil
+	}
+}
+
+// WithWebhookBackoff returns an Option that sets the WebhookBackoff in
+// milliseconds.
+func WithWebhookBackoff(backoff int64) Option {
+	return func(o *Options) error {
+		if backoff <= 0 {
+			return fmt.Errorf("WebhookBackoff must be positive")
+		}
+		o.WebhookBackoff = time.Duration(backoff) * time.Millisecond
+		return nil
+	}
+}
+
+// WithWebhookMaxDeadLetters returns an Option that sets the
+// WebhookMaxDeadLetters.
+func WithWebhookMaxDeadLetters(n int64) Option {
+	return func(o *Options) error {
+		if n < 1 {
+			return fmt.Errorf("WebhookMaxDeadLetters must be positive")
+		}
+		o.WebhookMaxDeadLetters = int(n)
+		return nil
+	}
+}
+
+// WithPubSubMaxSubscriptions returns an Option that sets the
+// PubSubMaxSubscriptions.
+func WithPubSubMaxSubscriptions(n int64) Option {
+	return func(o *Options) error {
+		if n < 1 {
+			return fmt.Errorf("PubSubMaxSubscriptions must be positive")
+		}
+		o.PubSubMaxSubscriptions = int(n)
+		return nil
+	}
+}
+
+// WithPubSubMaxSubscriptionsPerPrincipal returns an Option that sets the
+// PubSubMaxSubscriptionsPerPrincipal.
+func WithPubSubMaxSubscriptionsPerPrincipal(n int64) Option {
+	return func(o *Options) error {
+		if n < 1 {
+			return fmt.Errorf("PubSubMaxSubscriptionsPerPrincipal must be positive")
+		}
+		o.PubSubMaxSubscriptionsPerPrincipal = int(n)
+		return nil
+	}
+}
+
+// WithHelloTemplatesFile returns an Option that sets the HelloTemplatesFile.
+func WithHelloTemplatesFile(path string) Option {
+	return func(o *Options) error {
+		o.HelloTemplatesFile = path
+		return nil
+	}
+}
+
+// WithCORSAllowedOrigins returns an Option that sets the CORSAllowedOrigins
+// from a comma-separated list.
+func WithCORSAllowedOrigins(spec string) Option {
+	return func(o *Options) error {
+		origins := splitList(spec)
+		if err := (cors.Policy{AllowedOrigins: origins}).Validate(); err != nil {
+			return err
+		}
+		o.CORSAllowedOrigins = origins
+		return nil
+	}
+}
+
+// WithCORSRouteOrigins returns an Option that sets per-route CORS origins
+// from a comma-separated list of route=origins entries, origins being
+// space-separated, e.g. "/api/message=https://a.example.com https://*.b.example.com".
+// An empty origins list disables CORS on the route.
+func WithCORSRouteOrigins(spec string) Option {
+	return func(o *Options) error {
+		routes := map[string][]string{}
+		for _, entry := range splitList(spec) {
+			route, value, ok := strings.Cut(entry, "=")
+			if !ok || route == "" {
+				return fmt.Errorf("invalid CORS route origins %q: want route=origins", entry)
+			}
+			origins := strings.Fields(value)
+			if err := (cors.Policy{AllowedOrigins: origins}).Validate(); err != nil {
+				return err
+			}
+			routes[route] = origins
+		}
+		o.CORSRouteOrigins = routes
+		return nil
+	}
+}
+
+// WithCORSAllowedMethods returns an Option that sets the CORSAllowedMethods
+// from a comma-separated list.
+func WithCORSAllowedMethods(spec string) Option {
+	return func(o *Options) error {
+		methods := splitList(spec)
+		for i, method := range methods {
+			methods[i] = strings.ToUpper(method)
+		}
+		o.CORSAllowedMethods = methods
+		return nil
+	}
+}
+
+// WithCORSAllowedHeaders returns an Option that sets the CORSAllowedHeaders
+// from a comma-separated list, "*" allowing any header.
+func WithCORSAllowedHeaders(spec string) Option {
+	return func(o *Options) error {
+		o.CORSAllowedHeaders = splitList(spec)
+		return nil
+	}
+}
+
+// WithCORSExposedHeaders returns an Option that sets the CORSExposedHeaders
+// from a comma-separated list.
+func WithCORSExposedHeaders(spec string) Option {
+	return func(o *Options) error {
+		o.CORSExposedHeaders = splitList(spec)
+		return nil
+	}
+}
+
+// WithCORSAllowCredentials returns an Option that sets the
+// CORSAllowCredentials.
+func WithCORSAllowCredentials(allow bool) Option {
+	return func(o *Options) error {
+		o.CORSAllowCredentials = allow
+		return nil
+	}
+}
+
+// WithCORSMaxAge returns an Option that sets the CORSMaxAge in milliseconds,
+// 0 leaving it to browsers.
+func WithCORSMaxAge(maxAge int64) Option {
+	return func(o *Options) error {
+		if maxAge < 0 {
+			return fmt.Errorf("CORSMaxAge must not be negative")
+		}
+		o.CORSMaxAge = time.Duration(maxAge) * time.Millisecond
+		return nil
+	}
+}
+
+// WithTLS returns an Option that serves the API over TLS with the certificate
+// and key in the given PEM files; both or none must be set.
+func WithTLS(certFile, keyFile string) Option {
+	return func(o *Options) error {
+		if (certFile == "") != (keyFile == "") {
+			return fmt.Errorf("TLS needs both a certificate and a key file")
+		}
+		o.TLSCertFile, o.TLSKeyFile = certFile, keyFile
+		return nil
+	}
+}
+
+// WithHSTSMaxAge returns an Option that sets the HSTSMaxAge in milliseconds,
+// 0 disabling HSTS.
+func WithHSTSMaxAge(maxAge int64) Option {
+	return func(o *Options) error {
+		if maxAge < 0 {
+			return fmt.Errorf("HSTSMaxAge must not be negative")
+		}
+		o.HSTSMaxAge = time.Duration(maxAge) * time.Millisecond
+		return nil
+	}
+}
+
+// WithHSTSIncludeSubdomains returns an Option that sets the
+// HSTSIncludeSubdomains.
+func WithHSTSIncludeSubdomains(include bool) Option {
+	return func(o *Options) error {
+		o.HSTSIncludeSubdomains = include
+		return nil
+	}
+}
+
+// WithFrameOptions returns an Option that sets the FrameOptions: DENY,
+// SAMEORIGIN or empty.
+func WithFrameOptions(value string) Option {
+	return func(o *Options) error {
+		value = strings.ToUpper(value)
+		if value != "" && value != "DENY" && value != "SAMEORIGIN" {
+			return fmt.Errorf("invalid frame options %q: want DENY or SAMEORIGIN", value)
+		}
+		o.FrameOptions = value
+		return nil
+	}
+}
+
+// referrerPolicies are the values of the Referrer-Policy header.
+var referrerPolicies = []string{
+	"no-referrer", "no-referrer-when-downgrade", "origin", "origin-when-cross-origin",
+	"same-origin", "strict-origin", "strict-origin-when-cross-origin", "unsafe-url",
+}
+
+// WithReferrerPolicy returns an Option that sets the ReferrerPolicy.
+func WithReferrerPolicy(policy string) Option {
+	return func(o *Options) error {
+		if policy != "" && !slices.Contains(referrerPolicies, policy) {
+			return fmt.Errorf("invalid referrer policy %q", policy)
+		}
+		o.ReferrerPolicy = policy
+		return nil
+	}
+}
+
+// WithPermissionsPolicy returns an Option that sets the PermissionsPolicy,
+// e.g. "camera=(), geolocation=()".
+func WithPermissionsPolicy(policy string) Option {
+	return func(o *Options) error {
+		o.PermissionsPolicy = policy
+		return nil
+	}
+}
+
+// WithCSPDirectives returns an Option that sets the CSPDirectives from a
+// comma-separated list of directive=sources entries, sources being
+// space-separated, e.g. "default-src='self',img-src='self' data:".
+func WithCSPDirectives(spec string) Option {
+	return func(o *Options) error {
+		directives := map[string][]string{}
+		for _, entry := range splitList(spec) {
+			name, value, _ := strings.Cut(entry, "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+				return fmt.Errorf("invalid CSP directive %q: want directive=sources", entry)
+			}
+			directives[name] = strings.Fields(value)
+		}
+		if err := (csp.Policy{Directives: directives}).Validate(); err != nil {
+			return err
+		}
+		o.CSPDirectives = directives
+		return nil
+	}
+}
+
+// WithCSPReportOnly returns an Option that sets the CSPReportOnly.
+func WithCSPReportOnly(reportOnly bool) Option {
+	return func(o *Options) error {
+		o.CSPReportOnly = reportOnly
+		return nil
+	}
+}
+
+// WithAccessLogFormat returns an Option that sets the AccessLogFormat, empty
+// disabling the access log.
+func WithAccessLogFormat(format string) Option {
+	return func(o *Options) error {
+		switch format {
+		case "", accesslog.FormatJSON, accesslog.FormatCombined, accesslog.FormatLogfmt:
+		default:
+			return fmt.Errorf("invalid access log format %q: want json, combined or logfmt", format)
+		}
+		o.AccessLogFormat = format
+		return nil
+	}
+}
+
+// WithAccessLogSampleRate returns an Option that sets the AccessLogSampleRate,
+// between 0 and 1.
+func WithAccessLogSampleRate(rate float64) Option {
+	return func(o *Options) error {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("AccessLogSampleRate must be between 0 and 1")
+		}
+		o.AccessLogSampleRate = rate
+		return nil
+	}
+}
+
+// WithAccessLogSlowThreshold returns an Option that sets the
+// AccessLogSlowThreshold in milliseconds, 0 for none.
+func WithAccessLogSlowThreshold(threshold int64) Option {
+	return func(o *Options) error {
+		if threshold < 0 {
+			return fmt.Errorf("AccessLogSlowThreshold must not be negative")
+		}
+		o.AccessLogSlowThreshold = time.Duration(threshold) * time.Millisecond
+		return nil
+	}
+}
+
+// WithCaptureFile returns an Option that sets the CaptureFile, empty to not
+// capture requests.
+func WithCaptureFile(path string) Option {
+	return func(o *Options) error {
+		o.CaptureFile = path
+		return nil
+	}
+}
+
+// WithCaptureSampleRate returns an Option that sets the CaptureSampleRate,
+// between 0 and 1.
+func WithCaptureSampleRate(rate float64) Option {
+	return func(o *Options) error {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("CaptureSampleRate must be between 0 and 1")
+		}
+		o.CaptureSampleRate = rate
+		return nil
+	}
+}
+
+// WithCaptureRoutes returns an Option that sets the CaptureRoutes from a
+// comma-separated list of route patterns, e.g. "/api/message".
+func WithCaptureRoutes(spec string) Option {
+	return func(o *Options) error {
+		o.CaptureRoutes = splitList(spec)
+		return nil
+	}
+}
+
+// WithCaptureMaxBodyBytes returns an Option that sets the
+// CaptureMaxBodyBytes.
+func WithCaptureMaxBodyBytes(n int64) Option {
+	return func(o *Options) error {
+		if n < 1 {
+			return fmt.Errorf("CaptureMaxBodyBytes must be positive")
+		}
+		o.CaptureMaxBodyBytes = n
+		return nil
+	}
+}
+
+// WithCaptureRedactHeaders returns an Option that sets the
+// CaptureRedactHeaders from a comma-separated list.
+func WithCaptureRedactHeaders(spec string) Option {
+	return func(o *Options) error {
+		o.CaptureRedactHeaders = splitList(spec)
+		return nil
+	}
+}
+
+// WithLogLevel returns an Option that sets the LogLevel: debug, info, warn,
+// error, dpanic, panic or fatal.
+func WithLogLevel(level string) Option {
+	return func(o *Options) error {
+		l, err := zapcore.ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		o.LogLevel = l
+		return nil
+	}
+}
+
+// WithLogEncoding returns an Option that sets the LogEncoding: json or console.
+func WithLogEncoding(encoding string) Option {
+	return func(o *Options) error {
+		if encoding != "json" && encoding != "console" {
+			return fmt.Errorf("invalid log encoding %q: want json or console", encoding)
+		}
+		o.LogEncoding = encoding
+		return nil
+	}
+}
+
+// WithLogOutputs returns an Option that sets the LogOutputs from a
+// comma-separated list of stdout, stderr or file paths.
+func WithLogOutputs(spec string) Option {
+	return func(o *Options) error {
+		outputs := splitList(spec)
+		if len(outputs) == 0 {
+			return fmt.Errorf("LogOutputs must not be empty")
+		}
+		o.LogOutputs = outputs
+		return nil
+	}
+}
+
+// WithLogFileMaxSize returns an Option that sets the LogFileMaxSize in
+// megabytes, 0 for no rotation.
+func WithLogFileMaxSize(megabytes int64) Option {
+	return func(o *Options) error {
+		if megabytes < 0 {
+			return fmt.Errorf("LogFileMaxSize must not be negative")
+		}
+		o.LogFileMaxSize = int(megabytes)
+		return nil
+	}
+}
+
+// WithLogFileMaxAge returns an Option that sets the LogFileMaxAge in
+// milliseconds, 0 for no limit.
+func WithLogFileMaxAge(maxAge int64) Option {
+	return func(o *Options) error {
+		if maxAge < 0 {
+			return fmt.Errorf("LogFileMaxAge must not be negative")
+		}
+		o.LogFileMaxAge = time.Duration(maxAge) * time.Millisecond
+		return nil
+	}
+}
+
+// WithLogFileMaxBackups returns an Option that sets the LogFileMaxBackups, 0
+// for no limit.
+func WithLogFileMaxBackups(n int64) Option {
+	return func(o *Options) error {
+		if n < 0 {
+			return fmt.Errorf("LogFileMaxBackups must not be negative")
+		}
+		o.LogFileMaxBackups = int(n)
+		return nil
+	}
+}
+
+// WithLogFileCompress returns an Option that sets the LogFileCompress.
+func WithLogFileCompress(compress bool) Option {
+	return func(o *Options) error {
+		o.LogFileCompress = compress
+		return nil
+	}
+}
+
+// WithLogSampling returns an Option that sets the LogSampling from an
+// initial:thereafter spec, empty disabling sampling.
+func WithLogSampling(spec string) Option {
+	return func(o *Options) error {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			o.LogSampling = LogSampling{}
+			return nil
+		}
+		initialStr, thereafterStr, ok := strings.Cut(spec, ":")
+		initial, err := strconv.Atoi(initialStr)
+		thereafter, err2 := strconv.Atoi(thereafterStr)
+		if !ok || err != nil || err2 != nil || initial <= 0 || thereafter <= 0 {
+			return fmt.Errorf("invalid log sampling %q: want initial:thereafter, both positive", spec)
+		}
+		o.LogSampling = LogSampling{Initial: initial, Thereafter: thereafter}
+		return nil
+	}
+}
+
+// logFields are the entry fields that can be renamed.
+var logFields = []string{"time", "level", "message", "caller", "stacktrace", "name"}
+
+// WithLogFieldNames returns an Option that sets the LogFieldNames from a
+// comma-separated list of field=name entries, e.g. "time=@timestamp,level=log.level".
+func WithLogFieldNames(spec string) Option {
+	return func(o *Options) error {
+		names := map[string]string{}
+		for _, entry := range splitList(spec) {
+			field, name, ok := strings.Cut(entry, "=")
+			if !ok || !slices.Contains(logFields, field) || name == "" {
+				return fmt.Errorf("invalid log field name %q: want field=name, field being one of %s", entry, strings.Join(logFields, ", "))
+			}
+			names[field] = name
+		}
+		o.LogFieldNames = names
+		return nil
+	}
+}
+
+// WithLogTimeEncoding returns an Option that sets the LogTimeEncoding.
+func WithLogTimeEncoding(encoding string) Option {
+	return func(o *Options) error {
+		switch encoding {
+		case "epoch", "millis", "nanos", "iso8601", "rfc3339", "rfc3339nano":
+		default:
+			return fmt.Errorf("invalid log time encoding %q", encoding)
+		}
+		o.LogTimeEncoding = encoding
+		return nil
+	}
+}
+
+// WithLogCaller returns an Option that sets the LogCaller.
+func WithLogCaller(caller bool) Option {
+	return func(o *Options) error {
+		o.LogCaller = caller
+		return nil
+	}
+}
+
+// WithLogStacktraceLevel returns an Option that sets the LogStacktraceLevel,
+// empty for no stack traces.
+func WithLogStacktraceLevel(level string) Option {
+	return func(o *Options) error {
+		if level == "" {
+			o.LogStacktraceLevel = nil
+			return nil
+		}
+		l, err := zapcore.ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		o.LogStacktraceLevel = &l
+		return nil
+	}
+}
+
+// WithLogRedactKeys returns an Option that sets the LogRedactKeys from a
+// comma-separated list.
+func WithLogRedactKeys(spec string) Option {
+	return func(o *Options) error {
+		o.LogRedactKeys = splitList(spec)
+		return nil
+	}
+}
+
+// WithLogRedactValues returns an Option that sets the LogRedactValues from a
+// comma-separated list of rule names.
+func WithLogRedactValues(spec string) Option {
+	return func(o *Options) error {
+		values := splitList(spec)
+		if _, err := redact.New(redact.Options{Values: values}); err != nil {
+			return err
+		}
+		o.LogRedactValues = values
+		return nil
+	}
+}
+
+// WithLogRedactRegexp returns an Option that sets the LogRedactRegexp, empty
+// for none.
+func WithLogRedactRegexp(expr string) Option {
+	return func(o *Options) error {
+		if expr == "" {
+			o.LogRedactRegexp = nil
+			return nil
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("invalid log redaction regexp: %w", err)
+		}
+		o.LogRedactRegexp = re
+		return nil
+	}
+}
+
+// WithLogPayloadKeys returns an Option that sets the LogPayloadKeys from a
+// comma-separated list.
+func WithLogPayloadKeys(spec string) Option {
+	return func(o *Options) error {
+		o.LogPayloadKeys = splitList(spec)
+		return nil
+	}
+}
+
+// WithLogPayloadMode returns an Option that sets the LogPayloadMode: hash or
+// truncate.
+func WithLogPayloadMode(mode string) Option {
+	return func(o *Options) error {
+		if mode != redact.PayloadHash && mode != redact.PayloadTruncate {
+			return fmt.Errorf("invalid log payload mode %q: want hash or truncate", mode)
+		}
+		o.LogPayloadMode = mode
+		return nil
+	}
+}
+
+// WithLogPayloadMaxLength returns an Option that sets the LogPayloadMaxLength
+// in characters.
+func WithLogPayloadMaxLength(n int64) Option {
+	return func(o *Options) error {
+		if n < 0 {
+			return fmt.Errorf("LogPayloadMaxLength must not be negative")
+		}
+		o.LogPayloadMaxLength = int(n)
+		return nil
+	}
+}
+
+// WithLokiURL returns an Option that sets the LokiURL, empty to not ship
+// logs to Loki.
+func WithLokiURL(rawURL string) Option {
+	return func(o *Options) error {
+		if rawURL == "" {
+			o.LokiURL = ""
+			return nil
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("LokiURL must be an absolute http or https URL")
+		}
+		o.LokiURL = rawURL
+		return nil
+	}
+}
+
+// WithLokiTenantID returns an Option that sets the LokiTenantID.
+func WithLokiTenantID(tenant string) Option {
+	return func(o *Options) error {
+		o.LokiTenantID = tenant
+		return nil
+	}
+}
+
+// lokiLabelName matches valid Loki label names.
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// WithLokiLabels returns an Option that sets the LokiLabels from a
+// comma-separated list of name=value entries, e.g. "app=myapp,env=prod".
+func WithLokiLabels(spec string) Option {
+	return func(o *Options) error {
+		labels := map[string]string{}
+		for _, entry := range splitList(spec) {
+			name, value, ok := strings.Cut(entry, "=")
+			if !ok || !lokiLabelName.MatchString(name) || value == "" {
+				return fmt.Errorf("invalid Loki label %q: want name=value", entry)
+			}
+			if name == loki.LevelLabel {
+				return fmt.Errorf("invalid Loki label %q: %s is set to the level of entries", entry, loki.LevelLabel)
+			}
+			labels[name] = value
+		}
+		o.LokiLabels = labels
+		return nil
+	}
+}
+
+// WithLokiBatchSize returns an Option that sets the LokiBatchSize in entries.
+func WithLokiBatchSize(n int64) Option {
+	return func(o *Options) error {
+		if n < 1 {
+			return fmt.Errorf("LokiBatchSize must be positive")
+		}
+		o.LokiBatchSize = int(n)
+		return nil
+	}
+}
+
+// WithLokiFlushInterval returns an Option that sets the LokiFlushInterval in
+// milliseconds.
+func WithLokiFlushInterval(interval int64) Option {
+	return func(o *Options) error {
+		if interval <= 0 {
+			return fmt.Errorf("LokiFlushInterval must be positive")
+		}
+		o.LokiFlushInterval = time.Duration(interval) * time.Millisecond
+		return nil
+	}
+}
+
+// WithLokiBufferSize returns an Option that sets the LokiBufferSize in
+// entries.
+func WithLokiBufferSize(n int64) Option {
+	return func(o *Options) error {
+		if n < 1 {
+			return fmt.Errorf("LokiBufferSize must be positive")
+		}
+		o.LokiBufferSize = int(n)
+		return nil
+	}
+}
+
+// WithLokiDropPolicy returns an Option that sets the LokiDropPolicy:
+// drop_oldest or drop_newest.
+func WithLokiDropPolicy(policy string) Option {
+	return func(o *Options) error {
+		p, err := loki.ParsePolicy(policy)
+		if err != nil {
+			return err
+		}
+		o.LokiDropPolicy = p
+		return nil
+	}
+}
+
+// WithLokiMaxRetries returns an Option that sets the LokiMaxRetries.
+func WithLokiMaxRetries(n int64) Option {
+	return func(o *Options) error {
+		if n < 0 {
+			return fmt.Errorf("LokiMaxRetries must not be negative")
+		}
+		o.LokiMaxRetries = int(n)
+		return nil
+	}
+}
+
+// WithLokiBackoff returns an Option that sets the LokiBackoff in
+// milliseconds.
+func WithLokiBackoff(backoff int64) Option {
+	return func(o *Options) error {
+		if backoff <= 0 {
+			return fmt.Errorf("LokiBackoff must be positive")
+		}
+		o.LokiBackoff = time.Duration(backoff) * time.Millisecond
+		return nil
+	}
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(spec string) []string {
+	var items []string
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
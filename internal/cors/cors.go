@@ -0,0 +1,185 @@
+// Package cors implements Cross-Origin Resource Sharing policies: checking
+// preflight and actual cross-origin requests and setting the response
+// headers telling browsers what is allowed.
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reasons of preflight rejections.
+const (
+	ReasonOrigin  = "origin"
+	ReasonMethod  = "method"
+	ReasonHeaders = "headers"
+)
+
+// Rejection is the error of a rejected preflight request.
+type Rejection struct {
+	// Reason is ReasonOrigin, ReasonMethod or ReasonHeaders.
+	Reason  string
+	Message string
+}
+
+func (r *Rejection) Error() string { return "cors: " + r.Message }
+
+// Policy is the CORS policy of a resource.
+type Policy struct {
+	// AllowedOrigins are exact origins ("https://app.example.com"), origins
+	// with a wildcard subdomain ("https://*.example.com", not matching
+	// "https://example.com" itself) or "*" for any origin.
+	AllowedOrigins []string
+	// AllowedMethods are the methods allowed cross-origin; empty allows the
+	// methods of the resource.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed cross-origin, "*" for
+	// any. CORS-safelisted headers are always allowed.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read besides the
+	// CORS-safelisted ones.
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and authorization.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight responses; zero leaves
+	// it to the browser.
+	MaxAge time.Duration
+}
+
+// Validate checks the policy: origins must be "*" or scheme://host[:port]
+// with an optional "*." host prefix, and the "*" origin cannot be combined
+// with credentials.
+func (p Policy) Validate() error {
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if p.AllowCredentials {
+				return errors.New("cors: the * origin cannot be combined with credentials")
+			}
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+			return fmt.Errorf("cors: invalid origin %q: want scheme://host[:port]", origin)
+		}
+	}
+	return nil
+}
+
+// AllowsOrigin reports whether origin, the value of an Origin header, is allowed.
+func (p Policy) AllowsOrigin(origin string) bool {
+	if origin == "" || origin == "null" {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		scheme, host, ok := strings.Cut(allowed, "://*.")
+		if ok && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+host) &&
+			len(origin) > len(scheme)+len("://")+len(host)+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPreflight reports whether r is a CORS preflight request.
+func IsPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// Preflight checks the preflight request r for a resource allowing methods,
+// and sets the headers of the answer to allow it. It returns a *Rejection,
+// setting no headers but Vary, if it is not allowed.
+func (p Policy) Preflight(h http.Header, r *http.Request, methods []string) error {
+	h.Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+	origin := r.Header.Get("Origin")
+	if !p.AllowsOrigin(origin) {
+		return &Rejection{Reason: ReasonOrigin, Message: fmt.Sprintf("origin %s is not allowed", origin)}
+	}
+	if len(p.AllowedMethods) > 0 {
+		methods = p.AllowedMethods
+	}
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !slices.Contains(methods, method) {
+		return &Rejection{Reason: ReasonMethod, Message: fmt.Sprintf("method %s is not allowed", method)}
+	}
+	var headers []string
+	for _, field := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if field = strings.ToLower(strings.TrimSpace(field)); field != "" {
+			headers = append(headers, field)
+		}
+	}
+	for _, header := range headers {
+		if !p.allowsHeader(header) {
+			return &Rejection{Reason: ReasonHeaders, Message: fmt.Sprintf("header %s is not allowed", header)}
+		}
+	}
+
+	p.allowOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+	}
+	return nil
+}
+
+// Actual sets the headers of the answer to the actual (non-preflight)
+// request r, allowing scripts to read it if its origin is allowed. It
+// reports whether it is.
+func (p Policy) Actual(h http.Header, r *http.Request) bool {
+	h.Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if !p.AllowsOrigin(origin) {
+		return false
+	}
+	p.allowOrigin(h, origin)
+	if len(p.ExposedHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+	}
+	return true
+}
+
+func (p Policy) allowOrigin(h http.Header, origin string) {
+	if slices.Contains(p.AllowedOrigins, "*") {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if p.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p Policy) allowsHeader(header string) bool {
+	if safelisted[header] {
+		return true
+	}
+	for _, allowed := range p.AllowedHeaders {
+		// Browsers do not honour * for credentialed requests.
+		if (allowed == "*" && !p.AllowCredentials) || strings.EqualFold(allowed, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// safelisted are the CORS-safelisted request headers, always allowed.
+// Content-Type is only safelisted for form and plain text bodies, for which
+// browsers do not list it in preflights.
+var safelisted = map[string]bool{
+	"accept":           true,
+	"accept-language":  true,
+	"content-language": true,
+}
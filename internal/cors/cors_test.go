@@ -0,0 +1,108 @@
+package cors_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/cors"
+)
+
+func TestAllowsOrigin(t *testing.T) {
+	p := cors.Policy{AllowedOrigins: []string{"https://app.example.com", "https://*.example.org:8443"}}
+	require.NoError(t, p.Validate())
+	for origin, want := range map[string]bool{
+		"https://app.example.com":      true,
+		"https://APP.example.com":      true,
+		"http://app.example.com":       false,
+		"https://evil.example.com":     false,
+		"https://a.example.org:8443":   true,
+		"https://a.b.example.org:8443": true,
+		"https://example.org:8443":     false,
+		"https://.example.org:8443":    false,
+		"https://evilexample.org:8443": false,
+		"https://a.example.org":        false,
+		"null":                         false,
+		"":                             false,
+	} {
+		assert.Equal(t, want, p.AllowsOrigin(origin), origin)
+	}
+	assert.True(t, cors.Policy{AllowedOrigins: []string{"*"}}.AllowsOrigin("https://any.example"))
+}
+
+func TestValidate(t *testing.T) {
+	for _, origins := range [][]string{{"example.com"}, {"https://example.com/path"}, {"ftp://example.com"}, {"https://"}} {
+		assert.Error(t, cors.Policy{AllowedOrigins: origins}.Validate(), origins)
+	}
+	assert.Error(t, cors.Policy{AllowedOrigins: []string{"*"}, AllowCredentials: true}.Validate())
+	assert.NoError(t, cors.Policy{AllowedOrigins: []string{"*"}}.Validate())
+}
+
+func preflight(origin, method, headers string) *http.Request {
+	r := httptest.NewRequest(http.MethodOptions, "/api/message", nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		r.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return r
+}
+
+func TestPreflight(t *testing.T) {
+	p := cors.Policy{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedHeaders:   []string{"Content-Type", "X-API-Key"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+	methods := []string{http.MethodOptions, http.MethodPost}
+
+	r := preflight("https://app.example.com", http.MethodPost, "content-type, x-api-key, accept")
+	assert.True(t, cors.IsPreflight(r))
+	h := http.Header{}
+	require.NoError(t, p.Preflight(h, r, methods))
+	assert.Equal(t, "https://app.example.com", h.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", h.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "OPTIONS, POST", h.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type, x-api-key, accept", h.Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", h.Get("Access-Control-Max-Age"))
+
+	for reason, r := range map[string]*http.Request{
+		cors.ReasonOrigin:  preflight("https://evil.example.com", http.MethodPost, ""),
+		cors.ReasonMethod:  preflight("https://app.example.com", http.MethodDelete, ""),
+		cors.ReasonHeaders: preflight("https://app.example.com", http.MethodPost, "x-secret"),
+	} {
+		h := http.Header{}
+		err := p.Preflight(h, r, methods)
+		var rejection *cors.Rejection
+		require.ErrorAs(t, err, &rejection, reason)
+		assert.Equal(t, reason, rejection.Reason)
+		assert.Empty(t, h.Get("Access-Control-Allow-Origin"), reason)
+	}
+
+	restricted := p
+	restricted.AllowedMethods = []string{http.MethodGet}
+	var rejection *cors.Rejection
+	assert.ErrorAs(t, restricted.Preflight(http.Header{}, preflight("https://app.example.com", http.MethodPost, ""), methods), &rejection)
+}
+
+func TestActual(t *testing.T) {
+	p := cors.Policy{AllowedOrigins: []string{"*"}, ExposedHeaders: []string{"RateLimit-Limit"}}
+	r := httptest.NewRequest(http.MethodPost, "/api/message", nil)
+	r.Header.Set("Origin", "https://any.example")
+	h := http.Header{}
+	assert.True(t, p.Actual(h, r))
+	assert.Equal(t, "*", h.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, h.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "RateLimit-Limit", h.Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "Origin", h.Get("Vary"))
+
+	r.Header.Del("Origin")
+	h = http.Header{}
+	assert.False(t, p.Actual(h, r))
+	assert.Empty(t, h.Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,240 @@
+// Package csp builds Content-Security-Policy headers from structured
+// directives and parses the violation reports browsers send back.
+package csp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Header names of enforced and report-only policies.
+const (
+	Header           = "Content-Security-Policy"
+	ReportOnlyHeader = "Content-Security-Policy-Report-Only"
+)
+
+// ReportGroup is the Reporting API endpoint group policies report to, to be
+// declared in a Reporting-Endpoints header.
+const ReportGroup = "csp-endpoint"
+
+// directives are the known directives, true for fetch directives falling
+// back to default-src.
+var directives = map[string]bool{
+	"default-src":               false,
+	"child-src":                 true,
+	"connect-src":               true,
+	"font-src":                  true,
+	"frame-src":                 true,
+	"img-src":                   true,
+	"manifest-src":              true,
+	"media-src":                 true,
+	"object-src":                true,
+	"script-src":                true,
+	"script-src-elem":           true,
+	"script-src-attr":           true,
+	"style-src":                 true,
+	"style-src-elem":            true,
+	"style-src-attr":            true,
+	"worker-src":                true,
+	"base-uri":                  false,
+	"form-action":               false,
+	"frame-ancestors":           false,
+	"sandbox":                   false,
+	"upgrade-insecure-requests": false,
+}
+
+// keywords are the source expressions to be single-quoted.
+var keywords = []string{
+	"'self'", "'none'", "'unsafe-inline'", "'unsafe-eval'", "'unsafe-hashes'",
+	"'strict-dynamic'", "'report-sample'", "'wasm-unsafe-eval'",
+}
+
+// Policy is a Content-Security-Policy.
+type Policy struct {
+	// Directives maps directive names to their source expressions, e.g.
+	// "script-src" to ["'self'", "https://cdn.example.com"].
+	Directives map[string][]string
+	// ReportOnly reports violations without blocking them.
+	ReportOnly bool
+	// ReportURI, if set, is where browsers send violation reports, with
+	// both the report-uri and report-to (ReportGroup) directives.
+	ReportURI string
+}
+
+// Validate checks that directives are known and sources well-formed.
+func (p Policy) Validate() error {
+	for name, sources := range p.Directives {
+		if _, ok := directives[name]; !ok {
+			return fmt.Errorf("csp: unknown directive %q", name)
+		}
+		for _, src := range sources {
+			if err := validateSource(src); err != nil {
+				return fmt.Errorf("csp: %s: %w", name, err)
+			}
+		}
+	}
+	if strings.ContainsAny(p.ReportURI, " ;,") {
+		return fmt.Errorf("csp: invalid report URI %q", p.ReportURI)
+	}
+	return nil
+}
+
+func validateSource(src string) error {
+	switch {
+	case src == "" || strings.ContainsAny(src, " ;,\"\t\n"):
+		return fmt.Errorf("invalid source %q", src)
+	case strings.HasPrefix(src, "'nonce-"), strings.HasPrefix(src, "'sha256-"),
+		strings.HasPrefix(src, "'sha384-"), strings.HasPrefix(src, "'sha512-"):
+		if !strings.HasSuffix(src, "'") {
+			return fmt.Errorf("invalid source %q", src)
+		}
+	case strings.HasPrefix(src, "'"):
+		if !slices.Contains(keywords, src) {
+			return fmt.Errorf("unknown keyword %s", src)
+		}
+	case slices.Contains(keywords, "'"+src+"'"):
+		return fmt.Errorf("keyword %s must be single-quoted", src)
+	}
+	return nil
+}
+
+// HeaderName returns the name of the header carrying p.
+func (p Policy) HeaderName() string {
+	if p.ReportOnly {
+		return ReportOnlyHeader
+	}
+	return Header
+}
+
+// String returns the header value of p, directives sorted by name with
+// default-src first.
+func (p Policy) String() string {
+	names := slices.SortedFunc(maps.Keys(p.Directives), func(a, b string) int {
+		if (a == "default-src") != (b == "default-src") {
+			if a == "default-src" {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, strings.Join(append([]string{name}, p.Directives[name]...), " "))
+	}
+	if p.ReportURI != "" {
+		parts = append(parts, "report-uri "+p.ReportURI, "report-to "+ReportGroup)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Allow returns a copy of p additionally allowing sources for the
+// directive. A fetch directive missing from p starts from the sources of
+// default-src, so that allowing sources never widens anything else; 'none'
+// is dropped.
+func (p Policy) Allow(directive string, sources ...string) Policy {
+	d := make(map[string][]string, len(p.Directives)+1)
+	for name, srcs := range p.Directives {
+		d[name] = slices.Clone(srcs)
+	}
+	current, ok := d[directive]
+	if !ok && directives[directive] {
+		current = slices.Clone(d["default-src"])
+	}
+	current = slices.DeleteFunc(current, func(src string) bool { return src == "'none'" })
+	for _, src := range sources {
+		if !slices.Contains(current, src) {
+			current = append(current, src)
+		}
+	}
+	d[directive] = current
+	p.Directives = d
+	return p
+}
+
+// Violation is a CSP violation, as reported by browsers.
+type Violation struct {
+	DocumentURL        string `json:"documentURL"`
+	BlockedURL         string `json:"blockedURL"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	Disposition        string `json:"disposition"`
+	SourceFile         string `json:"sourceFile,omitempty"`
+	LineNumber         int    `json:"lineNumber,omitempty"`
+	Sample             string `json:"sample,omitempty"`
+}
+
+// legacyReport is the body of reports sent to report-uri endpoints.
+type legacyReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		BlockedURI         string `json:"blocked-uri"`
+		EffectiveDirective string `json:"effective-directive"`
+		ViolatedDirective  string `json:"violated-directive"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// reportingReport is a report of the Reporting API, sent to report-to
+// endpoints in batches.
+type reportingReport struct {
+	Type string    `json:"type"`
+	Body Violation `json:"body"`
+}
+
+// ParseReports parses the violations reported in body, either a report-uri
+// report (application/csp-report) or a batch of Reporting API reports
+// (application/reports+json), of which only csp-violation ones are kept.
+func ParseReports(contentType string, body []byte) ([]Violation, error) {
+	if strings.HasPrefix(contentType, "application/reports+json") {
+		var batch []reportingReport
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, fmt.Errorf("csp: invalid reports: %w", err)
+		}
+		var violations []Violation
+		for _, r := range batch {
+			if r.Type == "csp-violation" {
+				violations = append(violations, r.Body)
+			}
+		}
+		return violations, nil
+	}
+
+	var legacy legacyReport
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, fmt.Errorf("csp: invalid report: %w", err)
+	}
+	r := legacy.Report
+	directive := r.EffectiveDirective
+	if directive == "" {
+		// Older browsers only send the violated directive, with its sources.
+		directive, _, _ = strings.Cut(r.ViolatedDirective, " ")
+	}
+	if r.DocumentURI == "" && directive == "" {
+		return nil, errors.New("csp: report without csp-report object")
+	}
+	return []Violation{{
+		DocumentURL:        r.DocumentURI,
+		BlockedURL:         r.BlockedURI,
+		EffectiveDirective: directive,
+		Disposition:        r.Disposition,
+		SourceFile:         r.SourceFile,
+		LineNumber:         r.LineNumber,
+		Sample:             r.ScriptSample,
+	}}, nil
+}
+
+// Directive returns the name of the reported directive if known, "other"
+// otherwise, bounding the values of metric labels.
+func (v Violation) Directive() string {
+	if _, ok := directives[v.EffectiveDirective]; ok {
+		return v.EffectiveDirective
+	}
+	return "other"
+}
@@ -0,0 +1,76 @@
+package csp_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/csp"
+)
+
+func TestPolicy(t *testing.T) {
+	p := csp.Policy{
+		Directives: map[string][]string{
+			"img-src":         {"'self'", "data:"},
+			"default-src":     {"'none'"},
+			"frame-ancestors": {"'none'"},
+			"script-src":      {"'self'", "'sha256-abc='"},
+		},
+		ReportURI: "/csp-report",
+	}
+	require.NoError(t, p.Validate())
+	assert.Equal(t, csp.Header, p.HeaderName())
+	assert.Equal(t, "default-src 'none'; frame-ancestors 'none'; img-src 'self' data:; script-src 'self' 'sha256-abc='; "+
+		"report-uri /csp-report; report-to csp-endpoint", p.String())
+
+	p.ReportOnly = true
+	assert.Equal(t, csp.ReportOnlyHeader, p.HeaderName())
+
+	for name, directives := range map[string]map[string][]string{
+		"unknown directive": {"script-source": {"'self'"}},
+		"unquoted keyword":  {"script-src": {"self"}},
+		"unknown keyword":   {"script-src": {"'everything'"}},
+		"injection":         {"script-src": {"'self';"}},
+		"unclosed nonce":    {"script-src": {"'nonce-abc"}},
+	} {
+		assert.Error(t, csp.Policy{Directives: directives}.Validate(), name)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	p := csp.Policy{Directives: map[string][]string{"default-src": {"'self'"}, "style-src": {"'none'"}}}
+	relaxed := p.Allow("script-src", "https://cdn.example.com").Allow("style-src", "'unsafe-inline'").Allow("base-uri", "'self'")
+
+	assert.Equal(t, []string{"'self'", "https://cdn.example.com"}, relaxed.Directives["script-src"], "starts from default-src")
+	assert.Equal(t, []string{"'unsafe-inline'"}, relaxed.Directives["style-src"], "'none' is dropped")
+	assert.Equal(t, []string{"'self'"}, relaxed.Directives["base-uri"])
+	assert.NotContains(t, p.Directives, "script-src", "the original policy is unchanged")
+	assert.Equal(t, []string{"'none'"}, p.Directives["style-src"])
+}
+
+func TestParseReports(t *testing.T) {
+	legacy := `{"csp-report":{"document-uri":"https://app.example.com/docs","blocked-uri":"https://evil.example.com/x.js",` +
+		`"violated-directive":"script-src-elem 'self'","disposition":"enforce","line-number":3}}`
+	violations, err := csp.ParseReports("application/csp-report", []byte(legacy))
+	require.NoError(t, err)
+	require.Len(t, violations, 1)
+	assert.Equal(t, "script-src-elem", violations[0].Directive())
+	assert.Equal(t, "https://evil.example.com/x.js", violations[0].BlockedURL)
+	assert.Equal(t, 3, violations[0].LineNumber)
+
+	batch := `[{"type":"csp-violation","body":{"documentURL":"https://app.example.com/","blockedURL":"inline",` +
+		`"effectiveDirective":"style-src-attr","disposition":"report"}},{"type":"deprecation","body":{}},` +
+		`{"type":"csp-violation","body":{"effectiveDirective":"made-up"}}]`
+	violations, err = csp.ParseReports("application/reports+json", []byte(batch))
+	require.NoError(t, err)
+	require.Len(t, violations, 2)
+	assert.Equal(t, "style-src-attr", violations[0].Directive())
+	assert.Equal(t, "report", violations[0].Disposition)
+	assert.Equal(t, "other", violations[1].Directive(), "unknown directives are bounded")
+
+	for _, body := range []string{`not json`, `{}`, `{"something":"else"}`} {
+		_, err := csp.ParseReports("application/csp-report", []byte(body))
+		assert.Error(t, err, body)
+	}
+}
@@ -0,0 +1,84 @@
+package httpserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/httpserver"
+	"github.com/marcosartorato/myapp/internal/logging"
+	"github.com/marcosartorato/myapp/internal/scheduler"
+	"github.com/marcosartorato/myapp/internal/store"
+)
+
+func TestAccessLogKeepsErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	host, port := "localhost", "8080"
+	options := cfg.Options{Host: &host, Port: &port, TimeoutHandler: 5 * time.Second}
+	for _, opt := range []cfg.Option{
+		cfg.WithLogOutputs(path),
+		cfg.WithLogSampling("100:100"),
+		cfg.WithAccessLogFormat("json"),
+		cfg.WithAccessLogSampleRate(0),
+	} {
+		require.NoError(t, opt(&options))
+	}
+	logger, closeLogger, err := logging.New(options)
+	require.NoError(t, err)
+	srv, err := httpserver.CreateServer(logger, options)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+
+	// Well over the 100 entries per second let through by the log sampling.
+	const requests = 500
+	for range requests {
+		req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(`{`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+	require.NoError(t, closeLogger())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	errors := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		if entry["logger"] == logging.AccessLoggerName {
+			assert.EqualValues(t, http.StatusBadRequest, entry["status"])
+			errors++
+		}
+	}
+	assert.Equal(t, requests, errors)
+}
+
+func TestAccessLogInvalid(t *testing.T) {
+	dir := t.TempDir()
+	host, port := "localhost", "8080"
+	options := cfg.Options{Host: &host, Port: &port, AccessLogFormat: "xml"}
+	require.NoError(t, cfg.WithMessageStorePath(filepath.Join(dir, "messages.db"))(&options))
+	require.NoError(t, cfg.WithScheduleStorePath(filepath.Join(dir, "schedule.db"))(&options))
+	_, err := httpserver.CreateServer(zap.NewNop(), options)
+	require.Error(t, err)
+
+	// The stores were not left open.
+	messages, err := store.OpenBolt(options.MessageStorePath)
+	require.NoError(t, err)
+	assert.NoError(t, messages.Close())
+	jobs, err := scheduler.OpenBoltStore(options.ScheduleStorePath)
+	require.NoError(t, err)
+	assert.NoError(t, jobs.Close())
+}
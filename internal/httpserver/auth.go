@@ -0,0 +1,124 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+	"go.uber.org/zap"
+)
+
+const (
+	authRealm = "myapp"
+	// defaultHMACWindow is the signed request replay window when not configured.
+	defaultHMACWindow = 5 * time.Minute
+)
+
+// authFailureMessages are the messages returned to callers by auth.Reason,
+// so that verifier details only reach the logs.
+var authFailureMessages = map[string]string{
+	"missing":             "missing credentials",
+	"invalid_api_key":     "invalid API key",
+	"malformed_token":     "malformed token",
+	"expired":             "token expired",
+	"not_yet_valid":       "token not yet valid",
+	"invalid_issuer":      "unexpected token issuer",
+	"invalid_audience":    "unexpected token audience",
+	"unsupported_alg":     "unsupported token algorithm",
+	"unknown_key":         "unknown key",
+	"jwks_unavailable":    "credentials cannot be verified right now",
+	"malformed_signature": "malformed request signature",
+	"stale_signature":     "signature timestamp outside the accepted window",
+	"digest_mismatch":     "body digest mismatch",
+	"invalid_signature":   "invalid signature",
+	"replayed_signature":  "signature already used",
+}
+
+// authFailureMessage returns the message for an authentication failure with
+// the given auth.Reason.
+func authFailureMessage(reason string) string {
+	if msg, ok := authFailureMessages[reason]; ok {
+		return msg
+	}
+	return "authentication failed"
+}
+
+// newAuthenticator builds the authenticators enabled in opt, or returns nil
+// when authentication is disabled.
+func newAuthenticator(opt cfg.Options) (auth.Authenticator, error) {
+	var chain auth.Chain
+	if opt.AuthAPIKeysFile != "" {
+		keys, err := auth.LoadAPIKeys(opt.AuthAPIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, keys)
+	}
+	if opt.AuthJWKS != "" {
+		keys, err := auth.NewKeySet(opt.AuthJWKS, &http.Client{Timeout: 5 * time.Second})
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, auth.NewJWT(keys, opt.AuthIssuer, opt.AuthAudience, opt.AuthClockSkew))
+	}
+	if opt.AuthHMACKeysFile != "" {
+		keys, err := auth.LoadHMACKeys(opt.AuthHMACKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		window := opt.AuthHMACWindow
+		if window == 0 {
+			window = defaultHMACWindow
+		}
+		chain = append(chain, auth.NewHMAC(keys, window))
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// withAuth requires an authenticated principal holding scopes. The principal
+// is added to the request context and to the request logger.
+func withAuth(authn auth.Authenticator, scopes []string, route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := getLogger(r)
+
+		p, err := authn.Authenticate(r)
+		if bodyTooLarge(err) {
+			writeStatusError(w, r, http.StatusRequestEntityTooLarge)
+			log.Debug("request body too large", zap.Error(err))
+			return
+		}
+		if err != nil {
+			reason := auth.Reason(err)
+			metrics.AuthFailuresTotal.WithLabelValues(route, reason).Inc()
+			challenge := `Bearer realm="` + authRealm + `"`
+			if !errors.Is(err, auth.ErrNoCredentials) {
+				challenge += `, error="invalid_token"`
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			writeError(w, http.StatusUnauthorized, ErrorResponse{Error: "unauthorized", Message: authFailureMessage(reason), Reason: reason})
+			log.Debug("authentication failed", zap.String("reason", reason), zap.Error(err))
+			return
+		}
+
+		log = log.With(zap.String("principal", p.Subject), zap.String("auth_method", p.Method))
+		if !p.HasScopes(scopes...) {
+			metrics.AuthFailuresTotal.WithLabelValues(route, "insufficient_scope").Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+authRealm+`", error="insufficient_scope", scope="`+strings.Join(scopes, " ")+`"`)
+			writeError(w, http.StatusForbidden, ErrorResponse{Error: "forbidden", Message: "missing required scope", Reason: "insufficient_scope"})
+			log.Debug("authorization failed", zap.Strings("required_scopes", scopes))
+			return
+		}
+
+		ctx := auth.WithPrincipal(r.Context(), p)
+		ctx = context.WithValue(ctx, loggerKey, log)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,79 @@
+package httpserver_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+)
+
+// writeAPIKeys writes an API keys file granting each key its subject and scopes.
+func writeAPIKeys(t *testing.T, entries ...[3]string) string {
+	t.Helper()
+	var b strings.Builder
+	for _, e := range entries {
+		digest := sha256.Sum256([]byte(e[0]))
+		b.WriteString(hex.EncodeToString(digest[:]) + " " + e[1] + " " + e[2] + "\n")
+	}
+	path := filepath.Join(t.TempDir(), "api-keys")
+	require.NoError(t, os.WriteFile(path, []byte(b.String()), 0o600))
+	return path
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	keysFile := writeAPIKeys(t,
+		[3]string{"writer-key", "writer", "message:write"},
+		[3]string{"reader-key", "reader", "message:read"},
+	)
+	h := newTestHandler(t, cfg.WithAuthAPIKeysFile(keysFile))
+
+	tests := map[string]struct {
+		apiKey     string
+		wantStatus int
+		wantReason string
+	}{
+		"missing credentials": {wantStatus: http.StatusUnauthorized, wantReason: "missing"},
+		"invalid key":         {apiKey: "nope", wantStatus: http.StatusUnauthorized, wantReason: "invalid_api_key"},
+		"missing scope":       {apiKey: "reader-key", wantStatus: http.StatusForbidden, wantReason: "insufficient_scope"},
+		"authorized":          {apiKey: "writer-key", wantStatus: http.StatusOK},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			failures := metrics.AuthFailuresTotal.WithLabelValues("/api/message", tc.wantReason)
+			before := testutil.ToFloat64(failures)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(`{"type":"repeat","msg":"hi"}`))
+			req.Header.Set("Content-Type", "application/json")
+			if tc.apiKey != "" {
+				req.Header.Set(auth.APIKeyHeader, tc.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.wantStatus, rec.Code, rec.Body.String())
+			if tc.wantStatus == http.StatusOK {
+				return
+			}
+			assert.Contains(t, rec.Header().Get("WWW-Authenticate"), `realm="myapp"`)
+			assert.Contains(t, rec.Body.String(), `"reason":"`+tc.wantReason+`"`)
+			assert.Equal(t, before+1, testutil.ToFloat64(failures))
+		})
+	}
+
+	// Routes without scopes stay anonymous.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
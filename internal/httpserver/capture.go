@@ -0,0 +1,40 @@
+package httpserver
+
+import (
+	"os"
+	"slices"
+
+	"go.uber.org/zap"
+
+	"github.com/marcosartorato/myapp/internal/capture"
+	cfg "github.com/marcosartorato/myapp/internal/config"
+)
+
+// newRecorder returns the recorder of the exchanges configured in opt, and
+// its capture file to close; nil when capture is disabled.
+func newRecorder(logger *zap.Logger, opt cfg.Options) (*capture.Recorder, *os.File, error) {
+	if opt.CaptureFile == "" {
+		return nil, nil, nil
+	}
+	// Captures hold request bodies: keep them private.
+	file, err := os.OpenFile(opt.CaptureFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	rec := capture.New(capture.Options{
+		Writer:        file,
+		SampleRate:    opt.CaptureSampleRate,
+		MaxBodyBytes:  opt.CaptureMaxBodyBytes,
+		RedactHeaders: opt.CaptureRedactHeaders,
+		OnError: func(err error) {
+			logger.Warn("request capture failed", zap.Error(err))
+		},
+	})
+	return rec, file, nil
+}
+
+// captured reports whether the requests to rt are captured, given the
+// CaptureRoutes in opt: event streams never are.
+func captured(opt cfg.Options, rt route) bool {
+	return !rt.stream && (len(opt.CaptureRoutes) == 0 || slices.Contains(opt.CaptureRoutes, rt.pattern))
+}
@@ -0,0 +1,82 @@
+package httpserver_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	"github.com/marcosartorato/myapp/internal/capture"
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/httpserver"
+	"github.com/marcosartorato/myapp/internal/store"
+)
+
+func TestCaptureAndReplay(t *testing.T) {
+	keysFile := writeAPIKeys(t, [3]string{"writer-key", "writer", "message:write"})
+	path := filepath.Join(t.TempDir(), "captures.jsonl")
+	h := newTestHandler(t,
+		cfg.WithAuthAPIKeysFile(keysFile),
+		cfg.WithCaptureFile(path),
+		cfg.WithCaptureSampleRate(1),
+		cfg.WithCaptureRoutes("/api/message"),
+		cfg.WithCompressionEncodings("gzip"),
+		cfg.WithCompressionMinSize(1),
+	)
+	for _, body := range []string{`{"type":"repeat","msg":"hi"}`, `{"type":"reverse","msg":"hello"}`, `{"type":"nope"}`} {
+		req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set(auth.APIKeyHeader, "writer-key")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	// Other routes are not captured.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello", nil))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(b), "\n"))
+	assert.NotContains(t, string(b), "writer-key")
+	assert.NotContains(t, string(b), "/hello")
+	// Responses are captured before compression.
+	assert.Contains(t, string(b), `"response_body":{"text":"{\"type\":\"reverse\",\"msg\":\"olleh\"}`)
+
+	// Replaying against the same version matches, given credentials.
+	srv := httptest.NewServer(newTestHandler(t, cfg.WithAuthAPIKeysFile(keysFile)))
+	defer srv.Close()
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+	rep, err := capture.Replay(context.Background(), file, capture.ReplayOptions{
+		Target: srv.URL,
+		Header: http.Header{auth.APIKeyHeader: {"writer-key"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, rep.Results, 3)
+	var out strings.Builder
+	require.NoError(t, rep.Print(&out))
+	assert.True(t, rep.OK(), out.String())
+}
+
+func TestCaptureFileInvalid(t *testing.T) {
+	dir := t.TempDir()
+	host, port := "localhost", "8080"
+	options := cfg.Options{Host: &host, Port: &port}
+	require.NoError(t, cfg.WithMessageStorePath(filepath.Join(dir, "messages.db"))(&options))
+	require.NoError(t, cfg.WithCaptureFile(filepath.Join(dir, "missing", "captures.jsonl"))(&options))
+	_, err := httpserver.CreateServer(zap.NewNop(), options)
+	require.Error(t, err)
+
+	// The message store was not left open.
+	messages, err := store.OpenBolt(options.MessageStorePath)
+	require.NoError(t, err)
+	assert.NoError(t, messages.Close())
+}
@@ -0,0 +1,19 @@
+package httpserver
+
+import "time"
+
+// Clock tells the time to the message handlers, so that tests can use a
+// fixed one.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts a function to Clock.
+type ClockFunc func() time.Time
+
+// Now implements Clock.
+func (f ClockFunc) Now() time.Time { return f() }
+
+// SystemClock is the wall clock. Its times carry a monotonic reading, so
+// uptimes measured with it are not affected by wall clock changes.
+var SystemClock Clock = ClockFunc(time.Now)
@@ -0,0 +1,78 @@
+package httpserver_test
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/httpserver"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+)
+
+func TestCompression(t *testing.T) {
+	h := newTestHandler(t,
+		cfg.WithCompressionEncodings("zstd,br,gzip"),
+		cfg.WithCompressionMinSize(1024),
+		cfg.WithCompressionTypes("application/json,text/"),
+	)
+	msg := strings.Repeat("a", 10000)
+	send := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(`{"type":"repeat","msg":"`+msg+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	count, sum := histogramSample(t, "/api/message", "gzip")
+
+	rec := send("gzip")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Less(t, rec.Body.Len(), len(msg))
+
+	// The wire size is recorded separately from the uncompressed response size.
+	gotCount, gotSum := histogramSample(t, "/api/message", "gzip")
+	assert.Equal(t, count+1, gotCount)
+	assert.Equal(t, sum+float64(rec.Body.Len()), gotSum)
+	assert.Contains(t, rec.Header().Values("Vary"), "Accept-Encoding")
+	zr, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	var resp httpserver.RepeatResponse
+	require.NoError(t, json.Unmarshal(body, &resp))
+	assert.Equal(t, msg, resp.Msg)
+
+	// Clients that do not ask for compression get the plain response.
+	rec = send("")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	assert.Contains(t, rec.Body.String(), msg)
+
+	// Small responses are not worth compressing.
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+}
+
+// histogramSample returns the sample count and sum of the compressed response size histogram.
+func histogramSample(t *testing.T, route, encoding string) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, metrics.CompressedResponseSize.WithLabelValues(route, encoding).(prometheus.Metric).Write(&m))
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
@@ -0,0 +1,62 @@
+package httpserver
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/marcosartorato/myapp/internal/concurrency"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+	"go.uber.org/zap"
+)
+
+// withConcurrencyLimit sheds requests with a 503 once the adaptive limit is
+// reached, instead of letting them queue until the TimeoutHandler fires.
+// Requests with priority concurrency.PriorityCritical are never shed.
+// Responses with a 5xx status, or whose context was cancelled (e.g. on
+// timeout), count as overload signals.
+func withConcurrencyLimit(l *concurrency.Limiter, route string, priority concurrency.Priority, next http.Handler) http.Handler {
+	metrics.ConcurrencyLimit.WithLabelValues(route).Set(float64(l.Limit()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tok, ok := l.Acquire(priority)
+		if !ok {
+			metrics.ShedRequestsTotal.WithLabelValues(route).Inc()
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, ErrorResponse{Error: "overloaded", Message: "server is overloaded, retry later"})
+			getLogger(r).Debug("request shed", zap.Int("limit", l.Limit()))
+			return
+		}
+
+		start := time.Now()
+		sw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			failed := sw.status >= http.StatusInternalServerError || r.Context().Err() != nil
+			tok.Release(time.Since(start), failed)
+			metrics.ConcurrencyLimit.WithLabelValues(route).Set(float64(l.Limit()))
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// statusRecorder remembers the status code written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,77 @@
+package httpserver_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+)
+
+// blockingBody signals when the handler starts reading it and then blocks
+// until released, keeping the request in flight.
+type blockingBody struct {
+	started, release chan struct{}
+	r                io.Reader
+}
+
+func (b *blockingBody) Read(p []byte) (int, error) {
+	if b.started != nil {
+		close(b.started)
+		b.started = nil
+		<-b.release
+	}
+	return b.r.Read(p)
+}
+
+func TestConcurrencyLimitSheds(t *testing.T) {
+	h := newTestHandler(t, cfg.WithConcurrencyLimits("/api/message=1:1:1s,/healthz=1:1:1s"))
+	shed := metrics.ShedRequestsTotal.WithLabelValues("/api/message")
+	before := testutil.ToFloat64(shed)
+
+	newReq := func(body io.Reader) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/message", body)
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+
+	// Hold the only slot.
+	body := &blockingBody{started: make(chan struct{}), release: make(chan struct{}), r: strings.NewReader(`{"type":"repeat"}`)}
+	started := body.started
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newReq(body))
+		done <- rec.Code
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newReq(strings.NewReader(`{"type":"repeat"}`)))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), `"error":"overloaded"`)
+	assert.Equal(t, before+1, testutil.ToFloat64(shed))
+
+	// Criticality comes from the route, not from client-chosen headers.
+	probe := newReq(strings.NewReader(`{"type":"repeat"}`))
+	probe.Header.Set("User-Agent", "kube-probe/1.30")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, probe)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok\n", rec.Body.String())
+
+	close(body.release)
+	require.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.ConcurrencyLimit.WithLabelValues("/api/message")))
+}
@@ -0,0 +1,77 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/cors"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+	"go.uber.org/zap"
+)
+
+// corsPolicies returns the CORS policies configured in opt for the route
+// patterns; routes without allowed origins have none.
+func corsPolicies(opt cfg.Options, patterns []string) (map[string]cors.Policy, error) {
+	policies := map[string]cors.Policy{}
+	for _, pattern := range patterns {
+		origins, ok := opt.CORSRouteOrigins[pattern]
+		if !ok {
+			origins = opt.CORSAllowedOrigins
+		}
+		if len(origins) == 0 {
+			continue
+		}
+		p := cors.Policy{
+			AllowedOrigins:   origins,
+			AllowedMethods:   opt.CORSAllowedMethods,
+			AllowedHeaders:   opt.CORSAllowedHeaders,
+			ExposedHeaders:   opt.CORSExposedHeaders,
+			AllowCredentials: opt.CORSAllowCredentials,
+			MaxAge:           opt.CORSMaxAge,
+		}
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		policies[pattern] = p
+	}
+	return policies, nil
+}
+
+// withCORS applies the CORS policy of the matched route, if any. Preflight
+// requests are answered here, before authentication; methods returns the
+// methods of a route, allowed unless the policy restricts them.
+func withCORS(policies map[string]cors.Policy, methods func(pattern string) []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routePattern(r)
+		p, ok := policies[route]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !cors.IsPreflight(r) {
+			p.Actual(w.Header(), r)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var rejection *cors.Rejection
+		if err := p.Preflight(w.Header(), r, methods(route)); errors.As(err, &rejection) {
+			metrics.CORSPreflightRequestsTotal.WithLabelValues(route, "rejected_"+rejection.Reason).Inc()
+			writeError(w, http.StatusForbidden, ErrorResponse{Error: "cors_rejected", Message: rejection.Message, Reason: rejection.Reason})
+			getLogger(r).Debug("CORS preflight rejected", zap.String("origin", r.Header.Get("Origin")), zap.String("reason", rejection.Reason))
+			return
+		}
+		metrics.CORSPreflightRequestsTotal.WithLabelValues(route, "allowed").Inc()
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// routePattern returns the pattern of the route matched by r, without method.
+func routePattern(r *http.Request) string {
+	if _, path, ok := strings.Cut(r.Pattern, " "); ok {
+		return path
+	}
+	return r.Pattern
+}
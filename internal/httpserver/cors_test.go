@@ -0,0 +1,76 @@
+package httpserver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+)
+
+func TestCORS(t *testing.T) {
+	keysFile := writeAPIKeys(t, [3]string{"writer-key", "writer", "message:write"})
+	h := newTestHandler(t,
+		cfg.WithAuthAPIKeysFile(keysFile),
+		cfg.WithCORSAllowedOrigins("https://*.example.com"),
+		cfg.WithCORSAllowedHeaders("Content-Type,X-API-Key"),
+		cfg.WithCORSExposedHeaders("RateLimit-Limit"),
+		cfg.WithCORSMaxAge(600_000),
+		cfg.WithCORSRouteOrigins("/hello="),
+	)
+	preflight := func(path, origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", headers)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Preflights are answered before authentication.
+	rec := preflight("/api/message", "https://tools.example.com", http.MethodPost, "content-type, x-api-key")
+	require.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	assert.Equal(t, "https://tools.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "OPTIONS, POST", rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type, x-api-key", rec.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", rec.Header().Get("Access-Control-Max-Age"))
+
+	rejected := metrics.CORSPreflightRequestsTotal.WithLabelValues("/api/message", "rejected_origin")
+	before := testutil.ToFloat64(rejected)
+	rec = preflight("/api/message", "https://example.org", http.MethodPost, "content-type")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"error":"cors_rejected"`)
+	assert.Contains(t, rec.Body.String(), `"reason":"origin"`)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, before+1, testutil.ToFloat64(rejected))
+
+	rec = preflight("/api/message", "https://tools.example.com", http.MethodPost, "x-secret")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"reason":"headers"`)
+
+	// Actual requests get the headers letting scripts read the response.
+	req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(`{"type":"repeat","msg":"hi"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Origin", "https://tools.example.com")
+	req.Header.Set(auth.APIKeyHeader, "writer-key")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "https://tools.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "RateLimit-Limit", rec.Header().Get("Access-Control-Expose-Headers"))
+
+	// Routes without allowed origins have no CORS: OPTIONS is answered by
+	// the router.
+	rec = preflight("/hello", "https://tools.example.com", http.MethodGet, "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, HEAD, OPTIONS", rec.Header().Get("Allow"))
+}
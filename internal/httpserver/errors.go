@@ -0,0 +1,47 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ErrorResponse is the structured body of JSON error responses.
+type ErrorResponse struct {
+	// Error is a stable, machine-readable error code.
+	Error string `json:"error"`
+	// Message is a human-readable description of the error.
+	Message string `json:"message"`
+	// Reason refines Error, e.g. the failed schema keyword for validation errors.
+	Reason string `json:"reason,omitempty"`
+	// Field points at the offending input, if any.
+	Field string `json:"field,omitempty"`
+}
+
+// writeError writes resp as a JSON error response with the given status.
+func writeError(w http.ResponseWriter, status int, resp ErrorResponse) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// writeStatusError writes a JSON error response for status, coded after its
+// status text, e.g. "request_entity_too_large" for a 413.
+func writeStatusError(w http.ResponseWriter, _ *http.Request, status int) {
+	text := http.StatusText(status)
+	writeError(w, status, ErrorResponse{
+		Error:   strings.ReplaceAll(strings.ToLower(text), " ", "_"),
+		Message: text,
+	})
+}
+
+// bodyTooLarge reports whether err comes from reading a request body past
+// the router's limit; such requests get a 413.
+func bodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
@@ -0,0 +1,12 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+)
+
+// healthHandler answers health probes.
+func healthHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, "ok\n")
+}
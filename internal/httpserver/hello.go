@@ -1,19 +1,128 @@
 package httpserver
 
 import (
-	"fmt"
+	"html/template"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/marcosartorato/myapp/internal/i18n"
 	"go.uber.org/zap"
 )
 
-// HelloHandler is the handler for the "Hello, World!" path.
+// helloNameMaxLength bounds the name query parameter of /hello.
+const helloNameMaxLength = 100
+
+// helloMediaTypes are the representations of /hello, the default first.
+var helloMediaTypes = []string{"text/plain", "application/json", "text/html"}
+
+// HelloResponse is the application/json greeting.
+type HelloResponse struct {
+	Greeting string `json:"greeting"`
+	Language string `json:"language" doc:"BCP 47 tag of the greeting's language."`
+}
+
+var helloPage = template.Must(template.New("hello").Parse(`<!DOCTYPE html>
+<html lang="{{.Language}}">
+<head><meta charset="utf-8"><title>{{.Greeting}}</title></head>
+<body><p>{{.Greeting}}</p></body>
+</html>
+`))
+
+var defaultGreetings = sync.OnceValue(i18n.Default)
+
+// HelloHandler is the handler for the "Hello, World!" path, greeting with
+// the built-in catalogs.
 func HelloHandler(w http.ResponseWriter, r *http.Request) {
-	log := getLogger(r)
-	if _, err := fmt.Fprintln(w, "Hello, World!"); err != nil {
-		// Log the error; since client likely went away, not much else to do
-		log.Error("failed to write response", zap.Error(err))
-		return
+	helloHandler(defaultGreetings())(w, r)
+}
+
+// helloHandler greets the name query parameter, or the world, in the
+// language of greetings best matching Accept-Language, as plain text, JSON
+// or HTML according to Accept.
+func helloHandler(greetings *i18n.Catalogs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := getLogger(r)
+		w.Header().Add("Vary", "Accept, Accept-Language")
+
+		mediaType := negotiate(r.Header.Get("Accept"), helloMediaTypes)
+		if mediaType == "" {
+			writeError(w, http.StatusNotAcceptable, ErrorResponse{
+				Error:   "not_acceptable",
+				Message: "acceptable representations are " + strings.Join(helloMediaTypes, ", "),
+			})
+			return
+		}
+		greeting, tag, err := greetings.Greet(r.Header.Get("Accept-Language"), r.URL.Query().Get("name"))
+		if err != nil {
+			log.Error("failed to render greeting", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, ErrorResponse{Error: "internal_error", Message: "failed to render greeting"})
+			return
+		}
+		resp := HelloResponse{Greeting: greeting, Language: tag.String()}
+		w.Header().Set("Content-Language", resp.Language)
+
+		switch mediaType {
+		case "application/json":
+			writeJSON(w, http.StatusOK, resp)
+		case "text/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			// html/template escapes the greeting, which embeds the caller's name.
+			err = helloPage.Execute(w, resp)
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			_, err = w.Write([]byte(greeting + "\n"))
+		}
+		if err != nil {
+			// Log the error; since client likely went away, not much else to do
+			log.Error("failed to write response", zap.Error(err))
+			return
+		}
+		log.Debug("handled hello request", zap.String("content_type", mediaType), zap.String("language", resp.Language))
+	}
+}
+
+// negotiate returns the offer the Accept header value accept prefers, or ""
+// if it accepts none. Offers are exact media types; an empty Accept accepts
+// anything, and ties go to the earlier offer.
+func negotiate(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// The most specific matching range decides the quality of offer.
+		q, specificity := 0.0, -1
+		for _, rng := range strings.Split(accept, ",") {
+			mt, params, err := mime.ParseMediaType(rng)
+			if err != nil {
+				continue
+			}
+			s := -1
+			switch {
+			case mt == offer:
+				s = 2
+			case strings.HasSuffix(mt, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mt, "*")):
+				s = 1
+			case mt == "*/*":
+				s = 0
+			}
+			if s <= specificity {
+				continue
+			}
+			specificity, q = s, 1
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
 	}
-	log.Debug("handled hello request")
+	return best
 }
@@ -5,11 +5,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	cfg "github.com/marcosartorato/myapp/internal/config"
 	"github.com/marcosartorato/myapp/internal/httpserver"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Basic handler test: returns 200 and "Hello, World!\n"
@@ -27,3 +31,48 @@ func TestHelloHandler(t *testing.T) {
 	body, _ := io.ReadAll(rr.Body)
 	assert.Equal(t, "Hello, World!\n", string(body), "unexpected response body")
 }
+
+func TestHelloNegotiation(t *testing.T) {
+	templates := filepath.Join(t.TempDir(), "greetings.json")
+	require.NoError(t, os.WriteFile(templates, []byte(`{"pt":{"greeting":"Olá, {{.Name}}!","world":"Mundo"}}`), 0o600))
+	h := newTestHandler(t, cfg.WithValidateResponses(true), cfg.WithHelloTemplatesFile(templates))
+
+	get := func(path, accept, acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/hello?name=Ada", "text/html;q=0.9, application/json", "it-CH, en;q=0.5")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "it", rec.Header().Get("Content-Language"))
+	assert.Equal(t, "Accept, Accept-Language", rec.Header().Get("Vary"))
+	assert.JSONEq(t, `{"greeting":"Ciao, Ada!","language":"it"}`, rec.Body.String())
+
+	rec = get("/hello?name=%3Cscript%3Ealert(1)%3C/script%3E", "text/*", "de")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"), "text/plain is preferred on ties")
+	assert.Equal(t, "Hallo, <script>alert(1)</script>!\n", rec.Body.String())
+
+	rec = get("/hello?name=%3Cscript%3Ealert(1)%3C/script%3E", "text/html", "de")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), `<html lang="de">`)
+	assert.Contains(t, rec.Body.String(), "<p>Hallo, &lt;script&gt;alert(1)&lt;/script&gt;!</p>")
+	assert.NotContains(t, rec.Body.String(), "<script>")
+
+	rec = get("/hello", "", "pt-PT")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "Olá, Mundo!\n", rec.Body.String(), "custom templates")
+
+	rec = get("/hello", "image/png, text/plain;q=0", "")
+	assert.Equal(t, http.StatusNotAcceptable, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	rec = get("/hello?name="+strings.Repeat("a", 101), "", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,111 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/marcosartorato/myapp/internal/store"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+// MessageList is a page of stored messages, newest first.
+type MessageList struct {
+	Messages   []store.Message `json:"messages"`
+	NextCursor string          `json:"next_cursor,omitempty" doc:"Pass as the cursor parameter to get the next page; absent on the last page."`
+}
+
+// listMessagesHandler lists stored messages, filtered by the type, since and
+// until query parameters and paginated with limit and cursor.
+func listMessagesHandler(messages store.MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := getLogger(r).Named("/api/messages")
+		params := r.URL.Query()
+
+		q := store.Query{Type: params.Get("type"), Cursor: params.Get("cursor"), Limit: defaultHistoryLimit}
+		if v := params.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxHistoryLimit {
+				writeError(w, http.StatusBadRequest, ErrorResponse{
+					Error: "validation_failed", Message: "limit must be an integer between 1 and 100", Reason: "range", Field: "limit",
+				})
+				return
+			}
+			q.Limit = n
+		}
+		for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+			v := params.Get(name)
+			if v == "" {
+				continue
+			}
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, ErrorResponse{
+					Error: "validation_failed", Message: name + " must be an RFC 3339 date-time", Reason: "format", Field: name,
+				})
+				return
+			}
+			*t = parsed
+		}
+
+		page, err := messages.List(r.Context(), q)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrorResponse{Error: "internal_error", Message: "failed to list messages"})
+			log.Error("failed to list messages", zap.Error(err))
+			return
+		}
+		list := MessageList{Messages: page.Messages, NextCursor: page.NextCursor}
+		if list.Messages == nil {
+			list.Messages = []store.Message{}
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// getMessageHandler returns the stored message named by the id path parameter.
+func getMessageHandler(messages store.MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, err := messages.Get(r.Context(), r.PathValue("id"))
+		if err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	}
+}
+
+// deleteMessageHandler deletes the stored message named by the id path parameter.
+func deleteMessageHandler(messages store.MessageStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := messages.Delete(r.Context(), r.PathValue("id")); err != nil {
+			writeStoreError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeStoreError answers a failed lookup by ID.
+func writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusNotFound, ErrorResponse{Error: "not_found", Message: "message not found"})
+		return
+	}
+	writeError(w, http.StatusInternalServerError, ErrorResponse{Error: "internal_error", Message: "message store unavailable"})
+	getLogger(r).Error("message store failed", zap.Error(err))
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,101 @@
+package httpserver_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/httpserver"
+)
+
+func TestMessageHistory(t *testing.T) {
+	keysFile := writeAPIKeys(t,
+		[3]string{"alice-key", "alice", "message:write,message:read"},
+		[3]string{"reader-key", "reader", "message:read"},
+	)
+	h := newTestHandler(t,
+		cfg.WithAuthAPIKeysFile(keysFile),
+		cfg.WithMessageStorePath(filepath.Join(t.TempDir(), "messages.db")),
+		cfg.WithValidateResponses(true),
+	)
+	do := func(method, target, apiKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set(auth.APIKeyHeader, apiKey)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	list := func(query url.Values) httpserver.MessageList {
+		t.Helper()
+		rec := do(http.MethodGet, "/api/messages?"+query.Encode(), "reader-key", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var got httpserver.MessageList
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+		return got
+	}
+
+	var ids []string
+	for _, body := range []string{`{"type":"repeat","msg":"one"}`, `{"type":"time"}`, `{"type":"repeat","msg":"three"}`} {
+		rec := do(http.MethodPost, "/api/message", "alice-key", body)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NotEmpty(t, rec.Header().Get("X-Message-ID"))
+		ids = append(ids, rec.Header().Get("X-Message-ID"))
+	}
+
+	page := list(url.Values{"limit": {"2"}})
+	require.Len(t, page.Messages, 2)
+	assert.Equal(t, ids[2], page.Messages[0].ID)
+	assert.Equal(t, ids[1], page.Messages[1].ID)
+	require.NotEmpty(t, page.NextCursor)
+	page = list(url.Values{"limit": {"2"}, "cursor": {page.NextCursor}})
+	require.Len(t, page.Messages, 1)
+	assert.Equal(t, ids[0], page.Messages[0].ID)
+	assert.Empty(t, page.NextCursor)
+
+	page = list(url.Values{"type": {"repeat"}})
+	require.Len(t, page.Messages, 2)
+	assert.Equal(t, "alice", page.Messages[0].Principal)
+	assert.JSONEq(t, `{"type":"repeat","msg":"three"}`, string(page.Messages[0].Payload))
+
+	page = list(url.Values{"until": {"2000-01-01T00:00:00Z"}})
+	assert.Empty(t, page.Messages)
+
+	// Messages failing their handler are not kept.
+	rec := do(http.MethodPost, "/api/message", "alice-key", `{"type":"schedule","msg":"x","delay":"soon"}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	assert.Empty(t, rec.Header().Get("X-Message-ID"))
+	assert.Empty(t, list(url.Values{"type": {"schedule"}}).Messages)
+
+	rec = do(http.MethodGet, "/api/messages?since=yesterday", "reader-key", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = do(http.MethodGet, "/api/messages?limit=1000", "reader-key", "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(http.MethodGet, "/api/messages/"+ids[1], "reader-key", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Contains(t, rec.Body.String(), `"type":"time"`)
+
+	// Deleting needs message:write.
+	rec = do(http.MethodDelete, "/api/messages/"+ids[1], "reader-key", "")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = do(http.MethodDelete, "/api/messages/"+ids[1], "alice-key", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+
+	rec = do(http.MethodGet, "/api/messages/"+ids[1], "reader-key", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"error":"not_found"`)
+	rec = do(http.MethodDelete, "/api/messages/"+ids[1], "alice-key", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
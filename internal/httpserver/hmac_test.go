@@ -0,0 +1,78 @@
+package httpserver_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/pkg/hmacsign"
+)
+
+func TestSignedRequests(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	keysFile := filepath.Join(t.TempDir(), "hmac-keys")
+	require.NoError(t, os.WriteFile(keysFile, []byte("k1 "+hex.EncodeToString(secret)+" billing message:write\n"), 0o600))
+
+	srv := httptest.NewServer(newTestHandler(t, cfg.WithAuthHMACKeysFile(keysFile)))
+	defer srv.Close()
+
+	// Requests signed by the client helper are accepted.
+	client := &http.Client{Transport: &hmacsign.Transport{KeyID: "k1", Secret: secret}}
+	body := []byte(`{"type":"repeat","msg":"signed"}`)
+	resp, err := client.Post(srv.URL+"/api/message", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	got, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(got))
+	assert.Contains(t, string(got), "signed")
+
+	// Replaying the exact same signed request is rejected.
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/message", bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	for _, h := range []string{hmacsign.HeaderTimestamp, hmacsign.HeaderDigest, hmacsign.HeaderSignature} {
+		req.Header.Set(h, resp.Request.Header.Get(h))
+	}
+	replay, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	got, _ = io.ReadAll(replay.Body)
+	_ = replay.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, replay.StatusCode)
+	assert.Contains(t, string(got), `"reason":"replayed_signature"`)
+
+	// Verifier details, such as the unknown key ID, stay out of responses.
+	unknown := &http.Client{Transport: &hmacsign.Transport{KeyID: "internal-k9", Secret: secret}}
+	resp, err = unknown.Post(srv.URL+"/api/message", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	got, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.JSONEq(t, `{"error":"unauthorized","message":"unknown key","reason":"unknown_key"}`, string(got))
+}
+
+func TestSignedRequestTooLarge(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	keysFile := filepath.Join(t.TempDir(), "hmac-keys")
+	require.NoError(t, os.WriteFile(keysFile, []byte("k1 "+hex.EncodeToString(secret)+" billing message:write\n"), 0o600))
+	h := newTestHandler(t, cfg.WithAuthHMACKeysFile(keysFile), cfg.WithMaxBodyBytes(16))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/message", bytes.NewReader([]byte(`{"type":"repeat","msg":"too large"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	require.NoError(t, hmacsign.Sign(req, "k1", secret, time.Now()))
+	// Without a Content-Length, the limit is hit while verifying the digest.
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code, rec.Body.String())
+	assert.Empty(t, rec.Header().Get("WWW-Authenticate"))
+}
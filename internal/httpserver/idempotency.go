@@ -0,0 +1,138 @@
+package httpserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	"github.com/marcosartorato/myapp/internal/idempotency"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+)
+
+const (
+	// maxIdempotencyKeyLen bounds the client-chosen keys held in the store.
+	maxIdempotencyKeyLen = 255
+	// idempotentReplayedHeader marks responses replayed from the store.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// defaultIdempotencyMaxKeys bounds the keys held in the store when not
+	// configured.
+	defaultIdempotencyMaxKeys = 10_000
+)
+
+// withIdempotency replays the stored response of requests retried with the
+// same Idempotency-Key. Keys are scoped to the authenticated principal, so
+// it must run after withAuth. Requests without the header run as usual, and
+// 5xx responses are not stored so that the retry runs again.
+func withIdempotency(store idempotency.Store, route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotency.Header)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		log := getLogger(r)
+		count := func(result string) {
+			metrics.IdempotencyRequestsTotal.WithLabelValues(route, result).Inc()
+		}
+
+		if len(key) > maxIdempotencyKeyLen {
+			count("invalid")
+			writeError(w, http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid_idempotency_key",
+				Message: "Idempotency-Key must be at most 255 characters",
+			})
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if bodyTooLarge(err) {
+			writeStatusError(w, r, http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_request", Message: "failed to read request body"})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if p, ok := auth.PrincipalFrom(r.Context()); ok {
+			key = p.Subject + "\x00" + key
+		}
+		stored, err := store.Reserve(key, fingerprint(r, body))
+		switch {
+		case errors.Is(err, idempotency.ErrInFlight):
+			count("in_flight")
+			writeError(w, http.StatusConflict, ErrorResponse{
+				Error:   "idempotency_conflict",
+				Message: "a request with this Idempotency-Key is still being processed",
+				Reason:  "in_flight",
+			})
+			return
+		case errors.Is(err, idempotency.ErrMismatch):
+			count("mismatch")
+			writeError(w, http.StatusConflict, ErrorResponse{
+				Error:   "idempotency_conflict",
+				Message: "Idempotency-Key was already used for a different request",
+				Reason:  "mismatch",
+			})
+			return
+		case err != nil:
+			log.Error("idempotency store failed", zap.Error(err))
+			writeError(w, http.StatusInternalServerError, ErrorResponse{Error: "internal_error", Message: "idempotency store unavailable"})
+			return
+		case stored != nil:
+			count("replayed")
+			for k, v := range stored.Header {
+				w.Header()[k] = v
+			}
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(stored.Status)
+			_, _ = w.Write(stored.Body)
+			return
+		}
+		count("stored")
+
+		// Release the key if the handler panics, so that retries are not
+		// answered with 409 until it expires.
+		completed := false
+		defer func() {
+			if !completed {
+				_ = store.Release(key)
+			}
+		}()
+
+		// Only the handler's own headers are stored; the ones set by outer
+		// middlewares (rate limit, Vary, ...) are set again on replay.
+		buf := &bufferedResponseWriter{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+		for k, v := range buf.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(buf.status)
+		_, _ = w.Write(buf.body.Bytes())
+
+		if buf.status >= http.StatusInternalServerError {
+			return
+		}
+		resp := idempotency.Response{Status: buf.status, Header: buf.header, Body: buf.body.Bytes()}
+		if err := store.Complete(key, resp); err != nil {
+			log.Error("idempotency store failed", zap.Error(err))
+			return
+		}
+		completed = true
+	})
+}
+
+// fingerprint identifies the request a key was first used for.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
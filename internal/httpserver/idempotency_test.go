@@ -0,0 +1,74 @@
+package httpserver_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/httpserver"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	keysFile := writeAPIKeys(t,
+		[3]string{"alice-key", "alice", "message:write"},
+		[3]string{"bob-key", "bob", "message:write"},
+	)
+	h := newTestHandler(t, cfg.WithIdempotencyTTL(60_000), cfg.WithAuthAPIKeysFile(keysFile))
+
+	send := func(apiKey, idemKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(auth.APIKeyHeader, apiKey)
+		if idemKey != "" {
+			req.Header.Set("Idempotency-Key", idemKey)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	timeBody := `{"type":"time"}`
+	replayed := metrics.IdempotencyRequestsTotal.WithLabelValues("/api/message", "replayed")
+	before := testutil.ToFloat64(replayed)
+
+	first := send("alice-key", "k1", timeBody)
+	require.Equal(t, http.StatusOK, first.Code, first.Body.String())
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	// The retry gets the very same response, not a new timestamp.
+	retry := send("alice-key", "k1", timeBody)
+	require.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, before+1, testutil.ToFloat64(replayed))
+
+	// Reusing the key for another request is a conflict.
+	rec := send("alice-key", "k1", `{"type":"repeat","msg":"hi"}`)
+	require.Equal(t, http.StatusConflict, rec.Code)
+	var got httpserver.ErrorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, "idempotency_conflict", got.Error)
+	assert.Equal(t, "mismatch", got.Reason)
+
+	// Keys are scoped to the caller.
+	rec = send("bob-key", "k1", `{"type":"repeat","msg":"hi"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+
+	// Requests without a key are never replayed.
+	rec = send("alice-key", "", timeBody)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+
+	rec = send("alice-key", strings.Repeat("k", 256), timeBody)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
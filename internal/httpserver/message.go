@@ -1,17 +1,42 @@
 package httpserver
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	"github.com/marcosartorato/myapp/internal/pubsub"
+	"github.com/marcosartorato/myapp/internal/scheduler"
+	"github.com/marcosartorato/myapp/internal/store"
 )
 
+// messageIDHeader carries the ID of the stored message in /api/message responses.
+const messageIDHeader = "X-Message-ID"
+
 // MessageRequest represents the incoming JSON payload.
 type MessageRequest struct {
-	Type string `json:"type"`
-	Msg  string `json:"msg,omitempty"`
+	Type      string     `json:"type"`
+	Msg       string     `json:"msg,omitempty"`
+	At        *time.Time `json:"at,omitempty" doc:"schedule only: delivery time."`
+	Delay     string     `json:"delay,omitempty" doc:"schedule only: delivery delay as a duration such as 90s or 1h30m."`
+	Topic     string     `json:"topic,omitempty" doc:"publish and subscribe: topic name; the default topic when omitted. schedule: topic the message is published to when due, if any."`
+	Buffer    int        `json:"buffer,omitempty" doc:"subscribe only: messages buffered for a slow subscriber, 64 by default and at most 1024."`
+	Policy    string     `json:"policy,omitempty" doc:"subscribe only: drop_oldest (default) to drop the oldest buffered message when the buffer is full, or disconnect to end the subscription."`
+	Zone      string     `json:"zone,omitempty" doc:"time only: IANA time zone such as Europe/Rome, UTC by default."`
+	Format    string     `json:"format,omitempty" doc:"time only: rfc3339 (default), rfc3339nano, rfc1123, unix or unix_ms."`
+	Layout    string     `json:"layout,omitempty" doc:"time only: custom Go layout such as 2006-01-02 15:04, instead of format."`
+	Uptime    bool       `json:"uptime,omitempty" doc:"time only: also return the server uptime."`
+	Algorithm string     `json:"algorithm,omitempty" doc:"hash only: sha256 (default), sha512, blake2b-256, blake2b-512 or blake2s-256."`
+	Encoding  string     `json:"encoding,omitempty" doc:"encode and decode only: base64 (default), base64url, base32 or hex."`
 }
 
 // MessageResponse for repeat
@@ -22,38 +47,292 @@ type RepeatResponse struct {
 
 // MessageResponse for time
 type TimeResponse struct {
-	Type string `json:"type"`
-	Time string `json:"time"`
+	Type          string   `json:"type"`
+	Time          string   `json:"time" doc:"Current time in the requested format; unix formats are decimal strings."`
+	Zone          string   `json:"zone"`
+	UptimeSeconds *float64 `json:"uptime_seconds,omitempty" doc:"Time since the server started, if requested."`
+}
+
+// MessageResponse for schedule
+type ScheduleResponse struct {
+	Type  string    `json:"type"`
+	ID    string    `json:"id" doc:"Cancel with DELETE /api/schedules/{id}."`
+	DueAt time.Time `json:"due_at"`
+}
+
+// MessageResponse for publish
+type PublishResponse struct {
+	Type        string `json:"type"`
+	Topic       string `json:"topic"`
+	Subscribers int    `json:"subscribers" doc:"Number of subscribers the message was delivered to."`
+}
+
+// MessageResponse for subscribe
+type SubscribeResponse struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Topic  string `json:"topic"`
+	Events string `json:"events" doc:"Server-Sent Events stream of the subscription, to connect to within 30s."`
+}
+
+// messageError is a handler error caused by an invalid request field; it is
+// answered with a structured 400 rather than a 500.
+type messageError struct {
+	field, reason, message string
+}
+
+func (e *messageError) Error() string { return e.message }
+
+// invalidField returns a messageError for field failing the given reason.
+func invalidField(field, reason, format string, args ...any) error {
+	return &messageError{field: field, reason: reason, message: fmt.Sprintf(format, args...)}
+}
+
+// messageType describes one value accepted in MessageRequest.Type.
+type messageType struct {
+	name string
+	// response is a zero value of the response type, used to build the OpenAPI document.
+	response any
+	// example is the documented request for the type; {type, msg: hello} when zero.
+	example MessageRequest
+	handle  func(svc *services, r *http.Request, req MessageRequest) (any, error)
+}
+
+// messageTypes is the dispatch table used by MessageHandler, in documentation order.
+var messageTypes = append([]messageType{
+	{
+		name:     "repeat",
+		response: RepeatResponse{},
+		handle: func(_ *services, _ *http.Request, req MessageRequest) (any, error) {
+			return RepeatResponse{Type: "repeat", Msg: req.Msg}, nil
+		},
+	},
+	{
+		name:     "time",
+		response: TimeResponse{},
+		handle:   timeMessage,
+	},
+	{
+		name:     "schedule",
+		response: ScheduleResponse{},
+		handle:   scheduleMessage,
+	},
+	{
+		name:     "publish",
+		response: PublishResponse{},
+		handle:   publishMessage,
+	},
+	{
+		name:     "subscribe",
+		response: SubscribeResponse{},
+		handle:   subscribeMessage,
+	},
+}, transformTypes...)
+
+// timeFormats are the named formats of the time type.
+var timeFormats = map[string]func(t time.Time) string{
+	"rfc3339":     func(t time.Time) string { return t.Format(time.RFC3339) },
+	"rfc3339nano": func(t time.Time) string { return t.Format(time.RFC3339Nano) },
+	"rfc1123":     func(t time.Time) string { return t.Format(time.RFC1123) },
+	"unix":        func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) },
+	"unix_ms":     func(t time.Time) string { return strconv.FormatInt(t.UnixMilli(), 10) },
 }
 
+// timeMessage returns the current time in req.Zone, formatted with
+// req.Format or req.Layout.
+func timeMessage(svc *services, _ *http.Request, req MessageRequest) (any, error) {
+	now := svc.clock.Now()
+	loc := time.UTC
+	if req.Zone != "" {
+		var err error
+		// LoadLocation also accepts "Local", which is the server's business.
+		if loc, err = time.LoadLocation(req.Zone); err != nil || req.Zone == "Local" {
+			return nil, invalidField("zone", "zone", "unknown time zone %q", req.Zone)
+		}
+	}
+
+	format := timeFormats["rfc3339"]
+	switch {
+	case req.Format != "" && req.Layout != "":
+		return nil, invalidField("layout", "conflict", "format and layout are mutually exclusive")
+	case req.Format != "":
+		var ok bool
+		if format, ok = timeFormats[req.Format]; !ok {
+			return nil, invalidField("format", "enum", "format must be one of rfc3339, rfc3339nano, rfc1123, unix or unix_ms")
+		}
+	case req.Layout != "":
+		format = func(t time.Time) string { return t.Format(req.Layout) }
+	}
+
+	resp := TimeResponse{Type: "time", Time: format(now.In(loc)), Zone: loc.String()}
+	if req.Uptime {
+		uptime := now.Sub(svc.started).Seconds()
+		resp.UptimeSeconds = &uptime
+	}
+	return resp, nil
+}
+
+// scheduleMessage schedules req.Msg for delivery at req.At or after
+// req.Delay, or right away when neither is set, and for publishing to
+// req.Topic if set.
+func scheduleMessage(svc *services, r *http.Request, req MessageRequest) (any, error) {
+	due := svc.clock.Now().UTC()
+	switch {
+	case req.At != nil && req.Delay != "":
+		return nil, invalidField("delay", "conflict", "at and delay are mutually exclusive")
+	case req.At != nil:
+		due = req.At.UTC()
+	case req.Delay != "":
+		d, err := time.ParseDuration(req.Delay)
+		if err != nil || d < 0 {
+			return nil, invalidField("delay", "format", "delay must be a non-negative duration such as 90s")
+		}
+		due = due.Add(d)
+	}
+
+	if len(req.Topic) > maxTopicLength {
+		return nil, invalidField("topic", "maxLength", "topic must be at most %d bytes", maxTopicLength)
+	}
+
+	j := scheduler.Job{Msg: req.Msg, Topic: req.Topic, DueAt: due}
+	if p, ok := auth.PrincipalFrom(r.Context()); ok {
+		j.Principal = p.Subject
+	}
+	j, err := svc.scheduler.Schedule(j)
+	if err != nil {
+		return nil, err
+	}
+	return ScheduleResponse{Type: "schedule", ID: j.ID, DueAt: j.DueAt}, nil
+}
+
+// lookupMessageType returns the dispatch entry for name.
+func lookupMessageType(name string) (messageType, bool) {
+	for _, mt := range messageTypes {
+		if mt.name == name {
+			return mt, true
+		}
+	}
+	return messageType{}, false
+}
+
+// standaloneServices back MessageHandler and NewMessageHandler: messages are
+// not stored, and scheduled messages are accepted but never delivered since
+// the scheduler is not started. CreateServer wires the real services.
+func standaloneServices(clock Clock) *services {
+	sched, err := scheduler.New(scheduler.NewMemoryStore(), nil, scheduler.Options{})
+	if err != nil {
+		panic(err) // the memory store does not fail
+	}
+	return &services{
+		scheduler: sched,
+		topics:    pubsub.NewBroker(pubsub.Options{AttachTimeout: subscribeAttachTimeout}),
+		clock:     clock,
+		started:   clock.Now(),
+	}
+}
+
+var standalone = sync.OnceValue(func() *services { return standaloneServices(SystemClock) })
+
+// MessageHandler processes a message according to its type without storing it.
 func MessageHandler(w http.ResponseWriter, r *http.Request) {
+	handleMessage(w, r, standalone())
+}
+
+// NewMessageHandler returns a MessageHandler telling the time with clock.
+func NewMessageHandler(clock Clock) http.HandlerFunc {
+	return messageHandler(standaloneServices(clock))
+}
+
+// messageHandler is MessageHandler backed by svc.
+func messageHandler(svc *services) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handleMessage(w, r, svc)
+	}
+}
+
+func handleMessage(w http.ResponseWriter, r *http.Request, svc *services) {
 	log := getLogger(r).Named("/api/message")
 
+	body, err := io.ReadAll(r.Body)
+	if bodyTooLarge(err) {
+		writeStatusError(w, r, http.StatusRequestEntityTooLarge)
+		return
+	}
 	var req MessageRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
 		http.Error(w, "invalid JSON", http.StatusBadRequest)
 		log.Error("invalid JSON", zap.Error(err))
 		return
 	}
 
-	switch req.Type {
-	case "repeat":
-		resp := RepeatResponse{Type: "repeat", Msg: req.Msg}
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			http.Error(w, "failed to encode response", http.StatusInternalServerError)
-			log.Error("failed to encode response", zap.Error(err))
+	mt, ok := lookupMessageType(req.Type)
+	if !ok {
+		http.Error(w, "unknown type", http.StatusBadRequest)
+		log.Error("unknown type", zap.String("type", req.Type))
+		return
+	}
+
+	// The message is saved before it is handled, so that a failed save
+	// leaves nothing behind, such as a scheduled job, for the client's retry
+	// to duplicate. It is deleted again if handling fails.
+	var m *store.Message
+	if svc.messages != nil {
+		m = &store.Message{Type: req.Type, Payload: body}
+		if p, ok := auth.PrincipalFrom(r.Context()); ok {
+			m.Principal = p.Subject
+		}
+		if err := svc.messages.Save(r.Context(), m); err != nil {
+			http.Error(w, "failed to store message", http.StatusInternalServerError)
+			log.Error("failed to store message", zap.Error(err))
+			return
+		}
+	}
+
+	resp, err := mt.handle(svc, r, req)
+	if err != nil {
+		if m != nil {
+			if err := svc.messages.Delete(context.WithoutCancel(r.Context()), m.ID); err != nil {
+				log.Error("failed to delete unprocessed message", zap.String("id", m.ID), zap.Error(err))
+			}
+		}
+		var bad *messageError
+		if errors.As(err, &bad) {
+			writeError(w, http.StatusBadRequest, ErrorResponse{Error: "validation_failed", Message: bad.message, Reason: bad.reason, Field: "/" + bad.field})
+			log.Debug("invalid message", zap.Error(err))
 			return
 		}
-	case "time":
-		resp := TimeResponse{Type: "time", Time: time.Now().UTC().Format(time.RFC3339)}
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			http.Error(w, "failed to encode response", http.StatusInternalServerError)
-			log.Error("failed to write response", zap.Error(err))
+		if errors.Is(err, pubsub.ErrTooManySubscriptions) {
+			writeError(w, http.StatusTooManyRequests, ErrorResponse{Error: "too_many_subscriptions", Message: "too many open subscriptions"})
+			log.Debug("subscription refused", zap.Error(err))
 			return
 		}
-	default:
-		http.Error(w, "unknown type", http.StatusBadRequest)
-		log.Error("failed to write response")
+		if errors.Is(err, scheduler.ErrTooManyPending) {
+			writeError(w, http.StatusTooManyRequests, ErrorResponse{Error: "too_many_scheduled", Message: "too many pending scheduled messages"})
+			log.Debug("schedule refused", zap.Error(err))
+			return
+		}
+		http.Error(w, "failed to process message", http.StatusInternalServerError)
+		log.Error("failed to process message", zap.String("type", req.Type), zap.Error(err))
+		return
+	}
+
+	var messageID string
+	if m != nil {
+		messageID = m.ID
+		w.Header().Set(messageIDHeader, messageID)
+	}
+	if svc.webhooks != nil {
+		svc.webhooks.Notify(req.Type, messageID, body)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		log.Error("failed to encode response", zap.Error(err))
+		return
 	}
 	log.Debug("handled message request")
 }
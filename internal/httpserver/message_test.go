@@ -107,32 +107,119 @@ func TestMessageHandlerTimeType(t *testing.T) {
 		t.Skip("skipping test about handlers")
 	}
 
-	req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(`{"type":"time"}`))
-	req.Header.Set("Content-Type", "application/json")
-	rec := httptest.NewRecorder()
-
-	httpserver.MessageHandler(rec, req)
-
-	res := rec.Result()
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
-	// Check status code
-	assert.Equal(t, res.StatusCode, http.StatusOK, "unexpected status code")
+	// A fixed clock that advances by 90s on every reading after the first,
+	// which is taken when the handler is created.
+	start := time.Date(2025, time.March, 30, 0, 30, 15, 123456789, time.UTC)
+	newHandler := func() http.HandlerFunc {
+		now := start.Add(-90 * time.Second)
+		return httpserver.NewMessageHandler(httpserver.ClockFunc(func() time.Time {
+			now = now.Add(90 * time.Second)
+			return now
+		}))
+	}
 
-	// Check body
 	type timeResp struct {
-		Type string `json:"type"`
-		Time string `json:"time"`
+		Type          string   `json:"type"`
+		Time          string   `json:"time"`
+		Zone          string   `json:"zone"`
+		UptimeSeconds *float64 `json:"uptime_seconds"`
 	}
-	got := timeResp{}
-	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
-		t.Fatalf("unmarshal time: %v", err)
+	uptime := 90.0
+
+	// Define test cases
+	tests := map[string]struct {
+		reqBody    string
+		wantStatus int
+		want       timeResp
+		wantField  string
+	}{
+		"default": {
+			reqBody:    `{"type":"time"}`,
+			wantStatus: http.StatusOK,
+			want:       timeResp{Type: "time", Time: "2025-03-30T00:31:45Z", Zone: "UTC"},
+		},
+		"zone before DST change": {
+			reqBody:    `{"type":"time","zone":"Europe/Rome"}`,
+			wantStatus: http.StatusOK,
+			want:       timeResp{Type: "time", Time: "2025-03-30T01:31:45+01:00", Zone: "Europe/Rome"},
+		},
+		"rfc3339nano": {
+			reqBody:    `{"type":"time","format":"rfc3339nano"}`,
+			wantStatus: http.StatusOK,
+			want:       timeResp{Type: "time", Time: "2025-03-30T00:31:45.123456789Z", Zone: "UTC"},
+		},
+		"rfc1123 in zone": {
+			reqBody:    `{"type":"time","format":"rfc1123","zone":"America/New_York"}`,
+			wantStatus: http.StatusOK,
+			want:       timeResp{Type: "time", Time: "Sat, 29 Mar 2025 20:31:45 EDT", Zone: "America/New_York"},
+		},
+		"unix": {
+			reqBody:    `{"type":"time","format":"unix"}`,
+			wantStatus: http.StatusOK,
+			want:       timeResp{Type: "time", Time: "1743294705", Zone: "UTC"},
+		},
+		"unix_ms": {
+			reqBody:    `{"type":"time","format":"unix_ms"}`,
+			wantStatus: http.StatusOK,
+			want:       timeResp{Type: "time", Time: "1743294705123", Zone: "UTC"},
+		},
+		"custom layout": {
+			reqBody:    `{"type":"time","layout":"2006-01-02 15:04","zone":"Asia/Tokyo"}`,
+			wantStatus: http.StatusOK,
+			want:       timeResp{Type: "time", Time: "2025-03-30 09:31", Zone: "Asia/Tokyo"},
+		},
+		"uptime": {
+			reqBody:    `{"type":"time","uptime":true}`,
+			wantStatus: http.StatusOK,
+			want:       timeResp{Type: "time", Time: "2025-03-30T00:31:45Z", Zone: "UTC", UptimeSeconds: &uptime},
+		},
+		"unknown zone": {
+			reqBody:    `{"type":"time","zone":"Mars/Olympus_Mons"}`,
+			wantStatus: http.StatusBadRequest,
+			wantField:  "/zone",
+		},
+		"local zone": {
+			reqBody:    `{"type":"time","zone":"Local"}`,
+			wantStatus: http.StatusBadRequest,
+			wantField:  "/zone",
+		},
+		"unknown format": {
+			reqBody:    `{"type":"time","format":"iso"}`,
+			wantStatus: http.StatusBadRequest,
+			wantField:  "/format",
+		},
+		"format and layout": {
+			reqBody:    `{"type":"time","format":"unix","layout":"15:04"}`,
+			wantStatus: http.StatusBadRequest,
+			wantField:  "/layout",
+		},
 	}
-	assert.Equal(t, "time", got.Type, "unexpected type in the response body")
-	if _, err := time.Parse(time.RFC3339, got.Time); err != nil {
-		t.Fatalf("time not RFC3339: %q (%v)", got.Time, err)
+
+	// Run tests
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(tc.reqBody))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+
+			newHandler()(rec, req)
+
+			assert.Equal(t, tc.wantStatus, rec.Code, "unexpected status code: %s", rec.Body.String())
+			if tc.wantStatus != http.StatusOK {
+				var got httpserver.ErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+					t.Fatalf("unmarshal error: %v", err)
+				}
+				assert.Equal(t, "validation_failed", got.Error)
+				assert.Equal(t, tc.wantField, got.Field)
+				return
+			}
+			var got timeResp
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("unmarshal time: %v", err)
+			}
+			assert.Equal(t, tc.want, got)
+		})
 	}
 }
 
@@ -0,0 +1,396 @@
+package httpserver
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	"github.com/marcosartorato/myapp/internal/idempotency"
+	"github.com/marcosartorato/myapp/internal/openapi"
+	"github.com/marcosartorato/myapp/internal/store"
+	"github.com/marcosartorato/myapp/pkg/hmacsign"
+)
+
+const (
+	apiTitle   = "myapp"
+	apiVersion = "1.0.0"
+)
+
+//go:embed docs.html
+var docsHTML string
+
+var docsPage = template.Must(template.New("docs").Parse(docsHTML))
+
+const (
+	// redocCDN is the Redoc bundle loaded by the API reference page, unless
+	// served by the app from DocsRedocFile.
+	redocCDN = "https://cdn.redoc.ly/redoc/v2.1.5/bundles/redoc.standalone.js"
+	// redocPath is where the app serves the Redoc bundle from DocsRedocFile.
+	redocPath = "/docs/redoc.standalone.js"
+)
+
+// OpenAPI builds the OpenAPI document describing the application routes.
+func OpenAPI() *openapi.Document {
+	d := openapi.New(apiTitle, apiVersion)
+	d.Info.Description = "Minimal Go web server."
+	for _, rt := range routes(&services{}) {
+		if rt.operation == nil {
+			continue
+		}
+		op := rt.operation(d)
+		if rt.idempotent {
+			documentIdempotency(d, op)
+		}
+		if op.RequestBody != nil || len(op.Parameters) > 0 {
+			documentValidationError(d, op)
+		}
+		if op.RequestBody != nil {
+			documentBodyLimit(d, op)
+		}
+		if rt.scopes != nil {
+			documentSecurity(d, op, rt.scopes)
+		}
+		d.AddOperation(rt.pattern, rt.method, op)
+	}
+	return d
+}
+
+// documentSecurity marks op as requiring one of the authentication schemes
+// handled by withAuth. Both are only enforced when enabled in the config.
+func documentSecurity(d *openapi.Document, op *openapi.Operation, scopes []string) {
+	if d.Components.SecuritySchemes == nil {
+		d.Components.SecuritySchemes = map[string]*openapi.SecurityScheme{
+			"apiKey": {Type: "apiKey", Name: auth.APIKeyHeader, In: "header", Description: "Static API key."},
+			"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "JWT carrying the scopes in its scope claim."},
+			"signature": {
+				Type: "apiKey", Name: hmacsign.HeaderSignature, In: "header",
+				Description: "HMAC-SHA256 request signature, see package pkg/hmacsign.",
+			},
+		}
+	}
+	op.Security = []map[string][]string{{"apiKey": scopes}, {"bearer": scopes}, {"signature": scopes}}
+	errResp := map[string]openapi.MediaType{"application/json": {Schema: d.SchemaRef(ErrorResponse{})}}
+	op.Responses["401"] = &openapi.Response{Description: "Missing or invalid credentials.", Content: errResp}
+	op.Responses["403"] = &openapi.Response{Description: "Missing required scope.", Content: errResp}
+}
+
+// documentIdempotency adds the Idempotency-Key header handled by
+// withIdempotency, and its 409 response, to op.
+func documentIdempotency(d *openapi.Document, op *openapi.Operation) {
+	minLen, maxLen := 1, maxIdempotencyKeyLen
+	op.Parameters = append(op.Parameters, &openapi.Parameter{
+		Name: idempotency.Header,
+		In:   "header",
+		Description: "Client-chosen key making retries safe: a retry with the same key and request " +
+			"gets the first response back, marked with the " + idempotentReplayedHeader + " header.",
+		Schema: &openapi.Schema{Type: "string", MinLength: &minLen, MaxLength: &maxLen},
+	})
+	op.Responses["409"] = &openapi.Response{
+		Description: "The Idempotency-Key is in use by a request still in flight, or was used for a different request.",
+		Content:     map[string]openapi.MediaType{"application/json": {Schema: d.SchemaRef(ErrorResponse{})}},
+	}
+}
+
+// documentBodyLimit adds the 413 answered to request bodies over the limit
+// configured for the route.
+func documentBodyLimit(d *openapi.Document, op *openapi.Operation) {
+	op.Responses["413"] = &openapi.Response{
+		Description: "The request body is larger than allowed.",
+		Content:     map[string]openapi.MediaType{"application/json": {Schema: d.SchemaRef(ErrorResponse{})}},
+	}
+}
+
+// documentValidationError adds the structured 400 returned by withValidation to op.
+func documentValidationError(d *openapi.Document, op *openapi.Operation) {
+	resp, ok := op.Responses["400"]
+	if !ok {
+		resp = &openapi.Response{Description: "Invalid request."}
+		op.Responses["400"] = resp
+	}
+	if resp.Content == nil {
+		resp.Content = map[string]openapi.MediaType{}
+	}
+	resp.Content["application/json"] = openapi.MediaType{Schema: d.SchemaRef(ErrorResponse{})}
+}
+
+func healthOperation(*openapi.Document) *openapi.Operation {
+	return &openapi.Operation{
+		OperationID: "health",
+		Summary:     "Report that the server is up, for health probes.",
+		Description: "The route is never shed by its concurrency limit.",
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "The server is up.",
+				Content:     map[string]openapi.MediaType{"text/plain": {Schema: &openapi.Schema{Type: "string"}, Example: "ok\n"}},
+			},
+		},
+	}
+}
+
+func helloOperation(d *openapi.Document) *openapi.Operation {
+	maxLen := helloNameMaxLength
+	var locales []string
+	for _, tag := range defaultGreetings().Locales() {
+		locales = append(locales, tag.String())
+	}
+	return &openapi.Operation{
+		OperationID: "hello",
+		Summary:     "Return a greeting.",
+		Description: "The greeting is in the language best matching Accept-Language, built in for " +
+			strings.Join(locales, ", ") + ", and rendered as plain text, JSON or HTML according to Accept.",
+		Parameters: []*openapi.Parameter{
+			{
+				Name: "name", In: "query", Description: "Who to greet, the world in the greeting's language by default.",
+				Schema: &openapi.Schema{Type: "string", MaxLength: &maxLen},
+			},
+			{Name: "Accept-Language", In: "header", Schema: &openapi.Schema{Type: "string"}},
+		},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "Greeting.",
+				Content: map[string]openapi.MediaType{
+					"text/plain":       {Schema: &openapi.Schema{Type: "string"}, Example: "Hello, World!\n"},
+					"application/json": {Schema: d.SchemaRef(HelloResponse{})},
+					"text/html":        {Schema: &openapi.Schema{Type: "string"}},
+				},
+			},
+			"406": {
+				Description: "None of the representations is acceptable.",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: d.SchemaRef(ErrorResponse{})}},
+			},
+		},
+	}
+}
+
+func messageOperation(d *openapi.Document) *openapi.Operation {
+	reqSchema := d.SchemaRef(MessageRequest{})
+	// Unknown request fields are ignored by MessageHandler.
+	req := d.Resolve(reqSchema)
+	req.AdditionalProperties = nil
+
+	types := make([]any, 0, len(messageTypes))
+	examples := make(map[string]*openapi.Example, len(messageTypes))
+	var responses []*openapi.Schema
+	for _, mt := range messageTypes {
+		types = append(types, mt.name)
+		example := mt.example
+		if example.Type == "" {
+			example = MessageRequest{Type: mt.name, Msg: "hello"}
+		}
+		examples[mt.name] = &openapi.Example{Value: example}
+
+		// Types sharing a response type share its schema, whose type enum
+		// tells them apart.
+		ref := d.SchemaRef(mt.response)
+		if typ := d.Resolve(ref).Properties["type"]; typ != nil {
+			typ.Enum = append(typ.Enum, mt.name)
+		}
+		if !slices.ContainsFunc(responses, func(s *openapi.Schema) bool { return s.Ref == ref.Ref }) {
+			responses = append(responses, ref)
+		}
+	}
+	req.Properties["type"].Enum = types
+
+	return &openapi.Operation{
+		OperationID: "sendMessage",
+		Summary:     "Process a message according to its type.",
+		RequestBody: &openapi.RequestBody{
+			Required: true,
+			Content: map[string]openapi.MediaType{
+				"application/json": {Schema: reqSchema, Examples: examples},
+			},
+		},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "Message processed.",
+				Headers: map[string]*openapi.Header{
+					messageIDHeader: {Description: "ID of the stored message.", Schema: &openapi.Schema{Type: "string"}},
+				},
+				Content: map[string]openapi.MediaType{
+					"application/json": {Schema: &openapi.Schema{OneOf: responses}},
+				},
+			},
+			"400": {
+				Description: "Invalid JSON or unknown message type (text/plain), or a field invalid for the type (application/json).",
+				Content: map[string]openapi.MediaType{
+					"text/plain":       {Schema: &openapi.Schema{Type: "string"}},
+					"application/json": {Schema: d.SchemaRef(ErrorResponse{})},
+				},
+			},
+			"429": {
+				Description: "Too many open subscriptions, in total or for the caller (subscribe), or too many pending scheduled messages (schedule).",
+				Content: map[string]openapi.MediaType{
+					"application/json": {Schema: d.SchemaRef(ErrorResponse{})},
+				},
+			},
+		},
+	}
+}
+
+func listMessagesOperation(d *openapi.Document) *openapi.Operation {
+	types := make([]any, 0, len(messageTypes))
+	for _, mt := range messageTypes {
+		types = append(types, mt.name)
+	}
+	minLimit, maxLimit := float64(1), float64(maxHistoryLimit)
+	dateTime := &openapi.Schema{Type: "string", Format: "date-time"}
+	return &openapi.Operation{
+		OperationID: "listMessages",
+		Summary:     "List stored messages, newest first.",
+		Parameters: []*openapi.Parameter{
+			{Name: "type", In: "query", Description: "Only return messages of this type.", Schema: &openapi.Schema{Type: "string", Enum: types}},
+			{Name: "since", In: "query", Description: "Only return messages created at or after this time.", Schema: dateTime},
+			{Name: "until", In: "query", Description: "Only return messages created before this time.", Schema: dateTime},
+			{Name: "cursor", In: "query", Description: "The next_cursor of the previous page.", Schema: &openapi.Schema{Type: "string"}},
+			{
+				Name: "limit", In: "query", Description: "Page size, 20 by default.",
+				Schema: &openapi.Schema{Type: "integer", Minimum: &minLimit, Maximum: &maxLimit},
+			},
+		},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "A page of messages.",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: d.SchemaRef(MessageList{})}},
+			},
+		},
+	}
+}
+
+// idParameter is the id path parameter of the routes addressing a single
+// resource, e.g. "message".
+func idParameter(resource string) *openapi.Parameter {
+	return &openapi.Parameter{Name: "id", In: "path", Description: "ID of the " + resource + ".", Required: true, Schema: &openapi.Schema{Type: "string"}}
+}
+
+func getMessageOperation(d *openapi.Document) *openapi.Operation {
+	return &openapi.Operation{
+		OperationID: "getMessage",
+		Summary:     "Return a stored message.",
+		Parameters:  []*openapi.Parameter{idParameter("message")},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "The message.",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: d.SchemaRef(store.Message{})}},
+			},
+			"404": notFoundResponse(d, "message"),
+		},
+	}
+}
+
+func deleteMessageOperation(d *openapi.Document) *openapi.Operation {
+	return &openapi.Operation{
+		OperationID: "deleteMessage",
+		Summary:     "Delete a stored message.",
+		Parameters:  []*openapi.Parameter{idParameter("message")},
+		Responses: map[string]*openapi.Response{
+			"204": {Description: "Message deleted."},
+			"404": notFoundResponse(d, "message"),
+		},
+	}
+}
+
+func listSchedulesOperation(d *openapi.Document) *openapi.Operation {
+	return &openapi.Operation{
+		OperationID: "listSchedules",
+		Summary:     "List the pending scheduled messages, soonest first.",
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "The pending scheduled messages.",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: d.SchemaRef(ScheduleList{})}},
+			},
+		},
+	}
+}
+
+func cancelScheduleOperation(d *openapi.Document) *openapi.Operation {
+	return &openapi.Operation{
+		OperationID: "cancelSchedule",
+		Summary:     "Cancel a pending scheduled message.",
+		Parameters:  []*openapi.Parameter{idParameter("scheduled message")},
+		Responses: map[string]*openapi.Response{
+			"204": {Description: "Scheduled message cancelled."},
+			"404": notFoundResponse(d, "scheduled message"),
+		},
+	}
+}
+
+func subscriptionEventsOperation(d *openapi.Document) *openapi.Operation {
+	return &openapi.Operation{
+		OperationID: "streamSubscription",
+		Summary:     "Stream the messages of a subscription as Server-Sent Events.",
+		Description: "Each published message is a message event carrying it as JSON. " +
+			"The stream finishes with an end event whose reason is slow_consumer when a subscription with the disconnect policy fell behind, or closed.",
+		Parameters: []*openapi.Parameter{idParameter("subscription")},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "The event stream.",
+				Content:     map[string]openapi.MediaType{"text/event-stream": {Schema: &openapi.Schema{Type: "string"}}},
+			},
+			"404": notFoundResponse(d, "subscription"),
+			"409": {
+				Description: "The subscription already has an events stream.",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: d.SchemaRef(ErrorResponse{})}},
+			},
+		},
+	}
+}
+
+func listDeadLettersOperation(d *openapi.Document) *openapi.Operation {
+	return &openapi.Operation{
+		OperationID: "listWebhookDeadLetters",
+		Summary:     "List the failed webhook deliveries, oldest first.",
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "The failed deliveries.",
+				Content:     map[string]openapi.MediaType{"application/json": {Schema: d.SchemaRef(DeadLetterList{})}},
+			},
+		},
+	}
+}
+
+func replayDeadLetterOperation(d *openapi.Document) *openapi.Operation {
+	return &openapi.Operation{
+		OperationID: "replayWebhookDeadLetter",
+		Summary:     "Deliver a failed webhook delivery again.",
+		Parameters:  []*openapi.Parameter{idParameter("dead letter")},
+		Responses: map[string]*openapi.Response{
+			"202": {Description: "Delivery restarted; it is dead-lettered again if it keeps failing."},
+			"404": notFoundResponse(d, "dead letter"),
+		},
+	}
+}
+
+// notFoundResponse is the 404 of the routes addressing a single resource,
+// e.g. "message".
+func notFoundResponse(d *openapi.Document, resource string) *openapi.Response {
+	return &openapi.Response{
+		Description: "No " + resource + " with this ID.",
+		Content:     map[string]openapi.MediaType{"application/json": {Schema: d.SchemaRef(ErrorResponse{})}},
+	}
+}
+
+// docsHandler serves the embedded API reference page rendering
+// /openapi.json with the Redoc bundle at script.
+func docsHandler(script string) http.HandlerFunc {
+	var page bytes.Buffer
+	if err := docsPage.Execute(&page, script); err != nil {
+		panic(err) // the template is embedded
+	}
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(page.Bytes())
+	}
+}
+
+// redocHandler serves the Redoc bundle.
+func redocHandler(bundle []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		_, _ = w.Write(bundle)
+	}
+}
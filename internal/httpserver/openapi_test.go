@@ -0,0 +1,109 @@
+package httpserver_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/marcosartorato/myapp/internal/httpserver"
+	"github.com/marcosartorato/myapp/internal/openapi"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOpenAPIMessageContract sends one request per documented message type and
+// checks that the response matches the documented schema, so that a handler
+// drifting from the spec (or vice versa) fails here.
+func TestOpenAPIMessageContract(t *testing.T) {
+	// Skip the test if the env var is set
+	if os.Getenv("SKIP_HANDLER_TEST") == "true" {
+		t.Skip("skipping test about handlers")
+	}
+
+	doc := httpserver.OpenAPI()
+	op, ok := doc.Operation("/api/message", http.MethodPost)
+	require.True(t, ok, "POST /api/message is not documented")
+
+	reqMedia := op.RequestBody.Content["application/json"]
+	reqSchema := doc.Resolve(reqMedia.Schema)
+	respSchema := op.Responses["200"].Content["application/json"].Schema
+
+	// Every type has a documented example, which must be a valid request.
+	types := reqSchema.Properties["type"].Enum
+	require.NotEmpty(t, types, "message types are not documented")
+	assert.Len(t, reqMedia.Examples, len(types), "one example per message type")
+	for _, typ := range types {
+		t.Run(typ.(string), func(t *testing.T) {
+			example, ok := reqMedia.Examples[typ.(string)]
+			require.True(t, ok, "message type has no example")
+			body, err := json.Marshal(example.Value)
+			require.NoError(t, err)
+			require.NoError(t, doc.ValidateJSON(reqMedia.Schema, body), "example does not match request schema")
+			assert.Contains(t, string(body), fmt.Sprintf(`"type":%q`, typ), "example of another type")
+
+			req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(string(body)))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			httpserver.MessageHandler(rec, req)
+
+			require.Equal(t, http.StatusOK, rec.Code, "unexpected status code: %s", rec.Body.String())
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			assert.NoError(t, doc.ValidateJSON(respSchema, rec.Body.Bytes()), "response does not match schema")
+		})
+	}
+}
+
+func TestOpenAPIHelloContract(t *testing.T) {
+	// Skip the test if the env var is set
+	if os.Getenv("SKIP_HANDLER_TEST") == "true" {
+		t.Skip("skipping test about handlers")
+	}
+
+	doc := httpserver.OpenAPI()
+	op, ok := doc.Operation("/hello", http.MethodGet)
+	require.True(t, ok, "GET /hello is not documented")
+
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	rec := httptest.NewRecorder()
+	httpserver.HelloHandler(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	media, ok := op.Responses["200"].Content["text/plain"]
+	require.True(t, ok, "text/plain response is not documented")
+	assert.Equal(t, media.Example, rec.Body.String())
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	openapi.Handler(httpserver.OpenAPI()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var got map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, openapi.Version, got["openapi"])
+	assert.Contains(t, got["paths"], "/api/message")
+	assert.Contains(t, got["paths"], "/hello")
+}
+
+func TestOpenAPINotFoundResources(t *testing.T) {
+	doc := httpserver.OpenAPI()
+	for route, resource := range map[string]string{
+		"DELETE /api/messages/{id}":                   "message",
+		"DELETE /api/schedules/{id}":                  "scheduled message",
+		"GET /api/subscriptions/{id}/events":          "subscription",
+		"POST /api/webhooks/dead-letters/{id}/replay": "dead letter",
+	} {
+		method, path, _ := strings.Cut(route, " ")
+		op, ok := doc.Operation(path, method)
+		require.True(t, ok, "%s is not documented", route)
+		assert.Equal(t, "No "+resource+" with this ID.", op.Responses["404"].Description, route)
+		assert.Equal(t, "ID of the "+resource+".", op.Parameters[0].Description, route)
+	}
+}
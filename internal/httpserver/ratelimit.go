@@ -0,0 +1,81 @@
+package httpserver
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+	"github.com/marcosartorato/myapp/internal/ratelimit"
+	"go.uber.org/zap"
+)
+
+// defaultRateLimitMaxKeys bounds the clients tracked per route when not configured.
+const defaultRateLimitMaxKeys = 10_000
+
+// withRateLimit answers 429 once the client identified by key has exhausted
+// its token bucket. RateLimit-* headers are set on every response.
+func withRateLimit(l *ratelimit.Limiter, key func(*http.Request) string, route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := l.Allow(key(r))
+		metrics.RateLimitKeys.WithLabelValues(route).Set(float64(l.Len()))
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+
+		if !res.Allowed {
+			metrics.RateLimitRequestsTotal.WithLabelValues(route, "limited").Inc()
+			h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+			writeError(w, http.StatusTooManyRequests, ErrorResponse{Error: "rate_limited", Message: "too many requests"})
+			getLogger(r).Debug("request rate limited", zap.Duration("retry_after", res.RetryAfter))
+			return
+		}
+		metrics.RateLimitRequestsTotal.WithLabelValues(route, "allowed").Inc()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitKey returns the function identifying clients for the given
+// config.Options.RateLimitKey. With "api-key", clients are identified by the
+// principal authenticated by withAuth, never by unverified credentials, so
+// the limiter must run after it. Requests lacking the configured header or
+// principal fall back to the remote IP.
+func rateLimitKey(spec string) func(*http.Request) string {
+	header := ""
+	if strings.HasPrefix(spec, "header:") {
+		header = strings.TrimPrefix(spec, "header:")
+	}
+	return func(r *http.Request) string {
+		if spec == "api-key" {
+			if p, ok := auth.PrincipalFrom(r.Context()); ok {
+				return "p:" + p.Method + ":" + p.Subject
+			}
+		}
+		if header != "" {
+			if v := r.Header.Get(header); v != "" {
+				return "h:" + v
+			}
+		}
+		return "ip:" + remoteIP(r)
+	}
+}
+
+// remoteIP returns the host part of r.RemoteAddr.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ceilSeconds formats d as a whole number of seconds, rounding up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,89 @@
+package httpserver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+)
+
+func TestRateLimit(t *testing.T) {
+	h := newTestHandler(t,
+		cfg.WithRateLimits("/api/message=0.5:2"),
+		cfg.WithRateLimitKey("header:X-Client-ID"),
+	)
+	limited := metrics.RateLimitRequestsTotal.WithLabelValues("/api/message", "limited")
+	before := testutil.ToFloat64(limited)
+
+	send := func(client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(`{"type":"repeat","msg":"hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Client-ID", client)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send("a")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+
+	rec = send("a")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+
+	rec = send("a")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "4", rec.Header().Get("RateLimit-Reset"))
+	assert.Contains(t, rec.Body.String(), `"error":"rate_limited"`)
+	assert.Equal(t, before+1, testutil.ToFloat64(limited))
+
+	// Other clients have their own bucket.
+	assert.Equal(t, http.StatusOK, send("b").Code)
+
+	// Routes without a configured limit are not affected.
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("RateLimit-Limit"))
+}
+
+func TestRateLimitByAPIKey(t *testing.T) {
+	keysFile := writeAPIKeys(t,
+		[3]string{"writer-key", "writer", "message:write"},
+		[3]string{"other-key", "other", "message:write"},
+	)
+	h := newTestHandler(t,
+		cfg.WithAuthAPIKeysFile(keysFile),
+		cfg.WithRateLimits("/api/message=0.5:1"),
+		cfg.WithRateLimitKey("api-key"),
+	)
+	send := func(key string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(`{"type":"repeat","msg":"hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(auth.APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("writer-key"))
+	assert.Equal(t, http.StatusTooManyRequests, send("writer-key"))
+	// Unverified keys are rejected before getting a bucket of their own.
+	for i := range 3 {
+		assert.Equal(t, http.StatusUnauthorized, send("random-"+strconv.Itoa(i)))
+	}
+	// Other principals have their own bucket.
+	assert.Equal(t, http.StatusOK, send("other-key"))
+}
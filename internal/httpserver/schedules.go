@@ -0,0 +1,38 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/marcosartorato/myapp/internal/scheduler"
+)
+
+// ScheduleList lists the pending scheduled messages, soonest first.
+type ScheduleList struct {
+	Schedules []scheduler.Job `json:"schedules"`
+}
+
+// listSchedulesHandler lists the pending scheduled messages.
+func listSchedulesHandler(sched *scheduler.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, ScheduleList{Schedules: sched.Pending()})
+	}
+}
+
+// cancelScheduleHandler cancels the scheduled message named by the id path parameter.
+func cancelScheduleHandler(sched *scheduler.Scheduler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := sched.Cancel(r.PathValue("id"))
+		switch {
+		case errors.Is(err, scheduler.ErrNotFound):
+			writeError(w, http.StatusNotFound, ErrorResponse{Error: "not_found", Message: "no pending scheduled message with this ID"})
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, ErrorResponse{Error: "internal_error", Message: "schedule store unavailable"})
+			getLogger(r).Error("failed to cancel scheduled message", zap.Error(err))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package httpserver_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/httpserver"
+	"github.com/marcosartorato/myapp/internal/scheduler"
+)
+
+func TestScheduledMessages(t *testing.T) {
+	delivered := make(chan scheduler.Job, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var j scheduler.Job
+		if err := json.NewDecoder(r.Body).Decode(&j); err == nil {
+			delivered <- j
+		}
+	}))
+	defer webhook.Close()
+
+	h := newTestHandler(t, cfg.WithScheduleWebhookURL(webhook.URL), cfg.WithValidateResponses(true))
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	schedule := func(body string) httpserver.ScheduleResponse {
+		t.Helper()
+		rec := do(http.MethodPost, "/api/message", body)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var got httpserver.ScheduleResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+		return got
+	}
+
+	start := time.Now()
+	soon := schedule(`{"type":"schedule","msg":"soon","delay":"50ms"}`)
+	assert.WithinDuration(t, start.Add(50*time.Millisecond), soon.DueAt, time.Second)
+	later := schedule(`{"type":"schedule","msg":"later","at":"` + start.Add(time.Hour).UTC().Format(time.RFC3339) + `"}`)
+
+	select {
+	case j := <-delivered:
+		assert.Equal(t, soon.ID, j.ID)
+		assert.Equal(t, "soon", j.Msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("scheduled message not delivered")
+	}
+
+	rec := do(http.MethodGet, "/api/schedules", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var list httpserver.ScheduleList
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Schedules, 1)
+	assert.Equal(t, later.ID, list.Schedules[0].ID)
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/api/schedules/"+later.ID, "").Code)
+	rec = do(http.MethodDelete, "/api/schedules/"+later.ID, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"not_found"`)
+
+	for name, body := range map[string]string{
+		"at and delay":   `{"type":"schedule","msg":"x","at":"2030-01-01T00:00:00Z","delay":"1s"}`,
+		"invalid delay":  `{"type":"schedule","msg":"x","delay":"soon"}`,
+		"negative delay": `{"type":"schedule","msg":"x","delay":"-1s"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/message", body).Code)
+		})
+	}
+}
+
+func TestScheduledMessagesMaxPending(t *testing.T) {
+	h := newTestHandler(t, cfg.WithScheduleMaxPending(1), cfg.WithValidateResponses(true))
+	schedule := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(`{"type":"schedule","msg":"x","delay":"1h"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, schedule().Code)
+	rec := schedule()
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	var e httpserver.ErrorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &e))
+	assert.Equal(t, "too_many_scheduled", e.Error)
+}
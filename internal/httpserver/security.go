@@ -0,0 +1,136 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/csp"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+	"go.uber.org/zap"
+)
+
+// cspReportPath is where browsers report Content-Security-Policy violations.
+const cspReportPath = "/csp-report"
+
+// cspReportMaxBodyBytes limits violation reports, unless configured otherwise.
+const cspReportMaxBodyBytes = 64 << 10
+
+// cspPolicy returns the Content-Security-Policy configured in opt, reporting
+// to the collector; ok is false when CSP is disabled.
+func cspPolicy(opt cfg.Options) (p csp.Policy, ok bool) {
+	if len(opt.CSPDirectives) == 0 {
+		return csp.Policy{}, false
+	}
+	return csp.Policy{Directives: opt.CSPDirectives, ReportOnly: opt.CSPReportOnly, ReportURI: cspReportPath}, true
+}
+
+// docsPolicy relaxes p for the API reference page, which loads Redoc from
+// the app when selfHosted or from its CDN, runs it in a worker and injects
+// styles.
+func docsPolicy(p csp.Policy, selfHosted bool) csp.Policy {
+	origin := "https://cdn.redoc.ly"
+	if selfHosted {
+		origin = "'self'"
+	}
+	return p.
+		Allow("script-src", origin).
+		Allow("style-src", "'unsafe-inline'").
+		Allow("img-src", "data:", origin).
+		Allow("worker-src", "blob:").
+		Allow("connect-src", "'self'")
+}
+
+// securityHeaders returns the headers set on every response.
+func securityHeaders(opt cfg.Options) http.Header {
+	h := http.Header{}
+	h.Set("X-Content-Type-Options", "nosniff")
+	if opt.FrameOptions != "" {
+		h.Set("X-Frame-Options", opt.FrameOptions)
+	}
+	if opt.ReferrerPolicy != "" {
+		h.Set("Referrer-Policy", opt.ReferrerPolicy)
+	}
+	if opt.PermissionsPolicy != "" {
+		h.Set("Permissions-Policy", opt.PermissionsPolicy)
+	}
+	if p, ok := cspPolicy(opt); ok {
+		h.Set(p.HeaderName(), p.String())
+		h.Set("Reporting-Endpoints", csp.ReportGroup+`="`+cspReportPath+`"`)
+	}
+	return h
+}
+
+// hstsHeader returns the Strict-Transport-Security value configured in opt,
+// empty when disabled.
+func hstsHeader(opt cfg.Options) string {
+	if opt.HSTSMaxAge <= 0 {
+		return ""
+	}
+	v := "max-age=" + strconv.FormatInt(int64(opt.HSTSMaxAge.Seconds()), 10)
+	if opt.HSTSIncludeSubdomains {
+		v += "; includeSubDomains"
+	}
+	return v
+}
+
+// withSecurityHeaders sets headers on every response, and hsts on those to
+// TLS requests: browsers ignore it over plain HTTP.
+func withSecurityHeaders(headers http.Header, hsts string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		for k, v := range headers {
+			h[k] = v
+		}
+		if hsts != "" && r.TLS != nil {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withCSP replaces the Content-Security-Policy of the route with p.
+func withCSP(p csp.Policy, next http.Handler) http.Handler {
+	value := p.String()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(p.HeaderName(), value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSPReportHandler collects the Content-Security-Policy violations reported
+// by browsers, logging and counting them.
+func CSPReportHandler(w http.ResponseWriter, r *http.Request) {
+	logger := getLogger(r)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		if bodyTooLarge(err) {
+			writeStatusError(w, r, http.StatusRequestEntityTooLarge)
+			return
+		}
+		writeError(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_report", Message: "failed to read the report"})
+		return
+	}
+	violations, err := csp.ParseReports(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_report", Message: err.Error()})
+		return
+	}
+	for _, v := range violations {
+		disposition := "enforce"
+		if v.Disposition == "report" {
+			disposition = "report"
+		}
+		metrics.CSPViolationsTotal.WithLabelValues(v.Directive(), disposition).Inc()
+		logger.Warn("CSP violation",
+			zap.String("document_url", v.DocumentURL),
+			zap.String("blocked_url", v.BlockedURL),
+			zap.String("directive", v.EffectiveDirective),
+			zap.String("disposition", disposition),
+			zap.String("source_file", v.SourceFile),
+			zap.Int("line", v.LineNumber),
+		)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
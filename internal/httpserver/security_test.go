@@ -0,0 +1,129 @@
+package httpserver_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	h := newTestHandler(t,
+		cfg.WithDocsUI(true),
+		cfg.WithHSTSMaxAge(86400000),
+		cfg.WithFrameOptions("deny"),
+		cfg.WithReferrerPolicy("no-referrer"),
+		cfg.WithPermissionsPolicy("camera=()"),
+		cfg.WithCSPDirectives("default-src='none',frame-ancestors='none'"),
+	)
+	get := func(path string, secure bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if secure {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Every response carries the headers, errors included.
+	for _, path := range []string{"/hello", "/nope"} {
+		rec := get(path, false)
+		assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"), path)
+		assert.Equal(t, "DENY", rec.Header().Get("X-Frame-Options"), path)
+		assert.Equal(t, "no-referrer", rec.Header().Get("Referrer-Policy"), path)
+		assert.Equal(t, "camera=()", rec.Header().Get("Permissions-Policy"), path)
+		assert.Equal(t, "default-src 'none'; frame-ancestors 'none'; report-uri /csp-report; report-to csp-endpoint",
+			rec.Header().Get("Content-Security-Policy"), path)
+		assert.Equal(t, `csp-endpoint="/csp-report"`, rec.Header().Get("Reporting-Endpoints"), path)
+		assert.Empty(t, rec.Header().Get("Strict-Transport-Security"), "no HSTS over plain HTTP")
+	}
+	assert.Equal(t, "max-age=86400", get("/hello", true).Header().Get("Strict-Transport-Security"))
+
+	// The API reference page loads Redoc.
+	docs := get("/docs", false).Header().Get("Content-Security-Policy")
+	assert.Contains(t, docs, "script-src https://cdn.redoc.ly")
+	assert.Contains(t, docs, "worker-src blob:")
+	assert.Contains(t, docs, "frame-ancestors 'none'")
+}
+
+func TestDocsSelfHostedRedoc(t *testing.T) {
+	bundle := filepath.Join(t.TempDir(), "redoc.standalone.js")
+	require.NoError(t, os.WriteFile(bundle, []byte("/* redoc */"), 0o600))
+	h := newTestHandler(t,
+		cfg.WithDocsUI(true),
+		cfg.WithDocsRedocFile(bundle),
+		cfg.WithCSPDirectives("default-src='none'"),
+	)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, rec.Code, path)
+		return rec
+	}
+
+	docs := get("/docs")
+	assert.Contains(t, docs.Body.String(), `<script src="/docs/redoc.standalone.js">`)
+	policy := docs.Header().Get("Content-Security-Policy")
+	assert.Contains(t, policy, "script-src 'self'")
+	assert.NotContains(t, policy, "cdn.redoc.ly")
+
+	js := get("/docs/redoc.standalone.js")
+	assert.Equal(t, "/* redoc */", js.Body.String())
+	assert.Equal(t, "text/javascript; charset=utf-8", js.Header().Get("Content-Type"))
+}
+
+func TestCSPReportOnly(t *testing.T) {
+	h := newTestHandler(t, cfg.WithCSPDirectives("default-src='self'"), cfg.WithCSPReportOnly(true))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Empty(t, rec.Header().Get("Content-Security-Policy"))
+	assert.Contains(t, rec.Header().Get("Content-Security-Policy-Report-Only"), "default-src 'self'")
+
+	// Without CSP, there is no collector.
+	h = newTestHandler(t)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestCSPReport(t *testing.T) {
+	h := newTestHandler(t, cfg.WithCSPDirectives("default-src='none'"))
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	enforced := metrics.CSPViolationsTotal.WithLabelValues("script-src-elem", "enforce")
+	reported := metrics.CSPViolationsTotal.WithLabelValues("img-src", "report")
+	before, beforeReported := testutil.ToFloat64(enforced), testutil.ToFloat64(reported)
+
+	rec := post("application/csp-report", `{"csp-report":{"document-uri":"http://localhost:8080/docs",`+
+		`"blocked-uri":"https://evil.example.com/x.js","effective-directive":"script-src-elem","disposition":"enforce"}}`)
+	assert.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	assert.Equal(t, before+1, testutil.ToFloat64(enforced))
+
+	rec = post("application/reports+json", `[{"type":"csp-violation","body":{"documentURL":"http://localhost:8080/docs",`+
+		`"blockedURL":"data","effectiveDirective":"img-src","disposition":"report"}}]`)
+	assert.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+	assert.Equal(t, beforeReported+1, testutil.ToFloat64(reported))
+
+	rec = post("application/csp-report", `not json`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"error":"invalid_report"`)
+
+	rec = post("application/csp-report", `{"csp-report":{"document-uri":"`+strings.Repeat("x", 128<<10)+`"}}`)
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
@@ -2,12 +2,27 @@ package httpserver
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/http"
+	"os"
+	"runtime/debug"
 	"time"
 
+	"github.com/marcosartorato/myapp/internal/accesslog"
+	"github.com/marcosartorato/myapp/internal/concurrency"
 	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/i18n"
+	"github.com/marcosartorato/myapp/internal/idempotency"
+	"github.com/marcosartorato/myapp/internal/logging"
 	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+	"github.com/marcosartorato/myapp/internal/openapi"
+	"github.com/marcosartorato/myapp/internal/pubsub"
+	"github.com/marcosartorato/myapp/internal/ratelimit"
+	"github.com/marcosartorato/myapp/internal/router"
+	"github.com/marcosartorato/myapp/internal/scheduler"
+	"github.com/marcosartorato/myapp/internal/store"
+	"github.com/marcosartorato/myapp/internal/webhook"
 	"go.uber.org/zap"
 )
 
@@ -18,13 +33,17 @@ const loggerKey ctxKey = iota
 // withRequestLogger attaches a request-scoped logger to the context
 func withRequestLogger(base *zap.Logger, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		reqLog := base.With(
+		fields := []zap.Field{
 			zap.String("method", r.Method),
 			zap.String("path", r.URL.Path),
 			zap.String("remote_addr", r.RemoteAddr),
 			zap.String("user_agent", r.UserAgent()),
 			zap.Time("ts", time.Now()),
-		)
+		}
+		if id := accesslog.RequestID(r.Context()); id != "" {
+			fields = append(fields, zap.String("request_id", id))
+		}
+		reqLog := base.With(fields...)
 		ctx := context.WithValue(r.Context(), loggerKey, reqLog)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -39,36 +58,481 @@ func getLogger(r *http.Request) *zap.Logger {
 	return l
 }
 
+// onTimeout records a request whose handler timed out.
+func onTimeout(r *http.Request) {
+	metrics.RecordTimeout(r)
+	getLogger(r).Warn("handler timed out")
+}
+
+// recoverPanic records a handler panic, logging its stack trace.
+func recoverPanic(_ http.ResponseWriter, r *http.Request, v any) {
+	metrics.RecordPanic(r)
+	getLogger(r).Error("handler panicked", zap.Any("panic", v), zap.ByteString("stack", debug.Stack()))
+}
+
+// route is a single endpoint served by the app server.
+// The same table drives both the mux and the OpenAPI document.
+type route struct {
+	pattern string
+	method  string
+	handler http.HandlerFunc
+	// operation documents the route; nil keeps it out of the OpenAPI document.
+	operation func(d *openapi.Document) *openapi.Operation
+	// scopes, when not nil, makes the route require an authenticated caller
+	// holding all of them (if authentication is enabled).
+	scopes []string
+	// idempotent makes the route honour the Idempotency-Key header (if
+	// enabled), replaying the first response to retries.
+	idempotent bool
+	// stream marks long-lived responses: they are not subject to the
+	// timeout handler and not buffered for response validation.
+	stream bool
+	// critical marks routes, such as health checks, that are never shed by
+	// their concurrency limit.
+	critical bool
+}
+
+// services are the stateful dependencies of the route handlers.
+type services struct {
+	messages  store.MessageStore
+	scheduler *scheduler.Scheduler
+	webhooks  *webhook.Dispatcher
+	topics    *pubsub.Broker
+	greetings *i18n.Catalogs
+	clock     Clock
+	// started is when the services were created, for uptimes.
+	started time.Time
+}
+
+// routes returns the application routes in registration order, with handlers
+// backed by svc.
+func routes(svc *services) []route {
+	return []route{
+		{
+			pattern:   "/hello",
+			method:    http.MethodGet,
+			handler:   helloHandler(svc.greetings),
+			operation: helloOperation,
+		},
+		{
+			pattern:   "/healthz",
+			method:    http.MethodGet,
+			handler:   healthHandler,
+			operation: healthOperation,
+			critical:  true,
+		},
+		{
+			pattern:    "/api/message",
+			method:     http.MethodPost,
+			handler:    messageHandler(svc),
+			operation:  messageOperation,
+			scopes:     []string{"message:write"},
+			idempotent: true,
+		},
+		{
+			pattern:   "/api/messages",
+			method:    http.MethodGet,
+			handler:   listMessagesHandler(svc.messages),
+			operation: listMessagesOperation,
+			scopes:    []string{"message:read"},
+		},
+		{
+			pattern:   "/api/messages/{id}",
+			method:    http.MethodGet,
+			handler:   getMessageHandler(svc.messages),
+			operation: getMessageOperation,
+			scopes:    []string{"message:read"},
+		},
+		{
+			pattern:   "/api/messages/{id}",
+			method:    http.MethodDelete,
+			handler:   deleteMessageHandler(svc.messages),
+			operation: deleteMessageOperation,
+			scopes:    []string{"message:write"},
+		},
+		{
+			pattern:   "/api/schedules",
+			method:    http.MethodGet,
+			handler:   listSchedulesHandler(svc.scheduler),
+			operation: listSchedulesOperation,
+			scopes:    []string{"message:read"},
+		},
+		{
+			pattern:   "/api/schedules/{id}",
+			method:    http.MethodDelete,
+			handler:   cancelScheduleHandler(svc.scheduler),
+			operation: cancelScheduleOperation,
+			scopes:    []string{"message:write"},
+		},
+		{
+			pattern:   "/api/subscriptions/{id}/events",
+			method:    http.MethodGet,
+			handler:   subscriptionEventsHandler(svc.topics),
+			operation: subscriptionEventsOperation,
+			scopes:    []string{"message:read"},
+			stream:    true,
+		},
+		{
+			pattern:   "/api/webhooks/dead-letters",
+			method:    http.MethodGet,
+			handler:   listDeadLettersHandler(svc.webhooks),
+			operation: listDeadLettersOperation,
+			scopes:    []string{"webhook:admin"},
+		},
+		{
+			pattern:   "/api/webhooks/dead-letters/{id}/replay",
+			method:    http.MethodPost,
+			handler:   replayDeadLetterHandler(svc.webhooks),
+			operation: replayDeadLetterOperation,
+			scopes:    []string{"webhook:admin"},
+		},
+	}
+}
+
+// defaultMessageStoreMaxMessages bounds the messages kept in memory when not
+// configured.
+const defaultMessageStoreMaxMessages = 10_000
+
+// defaultPubSubMaxSubscriptions and defaultPubSubMaxPerPrincipal bound the
+// open subscriptions when not configured.
+const (
+	defaultPubSubMaxSubscriptions = 1000
+	defaultPubSubMaxPerPrincipal  = 16
+)
+
+// scheduleLateAfter is how far past their due time scheduled messages count
+// as late in the metrics.
+const scheduleLateAfter = time.Second
+
+// defaultScheduleMaxPending bounds the pending scheduled messages when not
+// configured.
+const defaultScheduleMaxPending = 10_000
+
+// Server is the app HTTP server along with the services backing it.
+type Server struct {
+	*http.Server
+	svc *services
+	// captures is the capture file, if any.
+	captures *os.File
+}
+
+// Shutdown gracefully shuts down the HTTP server, then stops the scheduler
+// (waiting for ongoing deliveries while ctx allows) and closes the stores and
+// the capture file.
+func (s *Server) Shutdown(ctx context.Context) error {
+	// Event streams never go idle: end them so that the HTTP server can
+	// shut down.
+	s.svc.topics.Close()
+	err := errors.Join(s.Server.Shutdown(ctx), s.svc.close(ctx))
+	if s.captures != nil {
+		err = errors.Join(err, s.captures.Close())
+	}
+	return err
+}
+
+// newServices opens the stores and starts the scheduler.
+func newServices(logger *zap.Logger, opt cfg.Options) (*services, error) {
+	greetings := i18n.Default()
+	if opt.HelloTemplatesFile != "" {
+		var err error
+		if greetings, err = i18n.Load(opt.HelloTemplatesFile); err != nil {
+			return nil, err
+		}
+	}
+	hooks, err := newWebhooks(logger, opt)
+	if err != nil {
+		return nil, err
+	}
+	maxMessages := opt.MessageStoreMaxMessages
+	if maxMessages == 0 {
+		maxMessages = defaultMessageStoreMaxMessages
+	}
+	messages, err := store.Open(opt.MessageStorePath, maxMessages)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := scheduler.OpenStore(opt.ScheduleStorePath)
+	if err != nil {
+		_ = messages.Close()
+		return nil, err
+	}
+	maxSubscriptions, maxPerPrincipal := opt.PubSubMaxSubscriptions, opt.PubSubMaxSubscriptionsPerPrincipal
+	if maxSubscriptions == 0 {
+		maxSubscriptions = defaultPubSubMaxSubscriptions
+	}
+	if maxPerPrincipal == 0 {
+		maxPerPrincipal = defaultPubSubMaxPerPrincipal
+	}
+	topics := pubsub.NewBroker(pubsub.Options{
+		AttachTimeout:    subscribeAttachTimeout,
+		MaxSubscriptions: maxSubscriptions,
+		MaxPerOwner:      maxPerPrincipal,
+		OnCount: func(topics, subscribers int) {
+			metrics.PubSubTopics.Set(float64(topics))
+			metrics.PubSubSubscribers.Set(float64(subscribers))
+		},
+		OnDrop: func(p pubsub.Policy) { metrics.PubSubDroppedMessagesTotal.WithLabelValues(p.String()).Inc() },
+	})
+	sinks := []scheduler.Sink{
+		scheduler.LogSink{Logger: logger.Named("scheduler")},
+		scheduler.TopicSink{Broker: topics, OnPublish: func(int) { metrics.PubSubPublishedMessagesTotal.Inc() }},
+	}
+	if opt.ScheduleWebhookURL != "" {
+		sinks = append(sinks, scheduler.WebhookSink{URL: opt.ScheduleWebhookURL, Client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	maxPending := opt.ScheduleMaxPending
+	if maxPending == 0 {
+		maxPending = defaultScheduleMaxPending
+	}
+	sched, err := scheduler.New(jobs, sinks, scheduler.Options{
+		LateAfter:  scheduleLateAfter,
+		MaxPending: maxPending,
+		OnPending:  func(n int) { metrics.ScheduledPending.Set(float64(n)) },
+		OnFired: func(_ scheduler.Job, lateness time.Duration, late bool) {
+			metrics.ScheduledFiredTotal.Inc()
+			metrics.ScheduledLateness.Observe(lateness.Seconds())
+			if late {
+				metrics.ScheduledLateTotal.Inc()
+			}
+		},
+		OnDeliveryError: func(sink string, j scheduler.Job, err error) {
+			metrics.ScheduledDeliveryFailuresTotal.WithLabelValues(sink).Inc()
+			logger.Warn("scheduled message delivery failed", zap.String("sink", sink), zap.String("id", j.ID), zap.Error(err))
+		},
+	})
+	if err != nil {
+		_ = messages.Close()
+		_ = jobs.Close()
+		return nil, err
+	}
+	sched.Start()
+	return &services{messages: messages, scheduler: sched, webhooks: hooks, topics: topics, greetings: greetings, clock: SystemClock, started: SystemClock.Now()}, nil
+}
+
+// newWebhooks returns the dispatcher notifying the webhook subscriptions.
+func newWebhooks(logger *zap.Logger, opt cfg.Options) (*webhook.Dispatcher, error) {
+	wopt := webhook.Options{
+		Subscriptions:  opt.Webhooks,
+		MaxAttempts:    opt.WebhookMaxAttempts,
+		Backoff:        opt.WebhookBackoff,
+		MaxDeadLetters: opt.WebhookMaxDeadLetters,
+		OnAttempt: func(d webhook.Delivery, elapsed time.Duration, err error) {
+			result := "success"
+			if err != nil {
+				result = "failure"
+				logger.Debug("webhook attempt failed", zap.String("delivery", d.ID), zap.Int("attempt", d.Attempts), zap.Error(err))
+			}
+			metrics.WebhookAttemptDuration.WithLabelValues(result).Observe(elapsed.Seconds())
+		},
+		OnDone: func(d webhook.Delivery, delivered bool) {
+			if delivered {
+				metrics.WebhookDeliveriesTotal.WithLabelValues("delivered").Inc()
+				return
+			}
+			metrics.WebhookDeliveriesTotal.WithLabelValues("dead_letter").Inc()
+			logger.Warn("webhook delivery dead-lettered", zap.String("delivery", d.ID), zap.String("url", d.URL), zap.String("error", d.LastError))
+		},
+		OnDeadLetters: func(n int) { metrics.WebhookDeadLetters.Set(float64(n)) },
+	}
+	if opt.WebhookSigningKeyFile != "" {
+		var err error
+		if wopt.KeyID, wopt.Secret, err = webhook.LoadSigningKey(opt.WebhookSigningKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	return webhook.New(wopt)
+}
+
+func (svc *services) close(ctx context.Context) error {
+	return errors.Join(svc.webhooks.Close(ctx), svc.scheduler.Stop(ctx), svc.messages.Close())
+}
+
+// newAccessLog returns the access log configured in opt, redacted like the
+// app logs.
+func newAccessLog(logger *zap.Logger, opt cfg.Options) (*accesslog.Logger, error) {
+	redactor, err := logging.NewRedactor(opt)
+	if err != nil {
+		return nil, err
+	}
+	return accesslog.New(accesslog.Options{
+		Format:        opt.AccessLogFormat,
+		Logger:        logger.Named(logging.AccessLoggerName),
+		Writer:        os.Stdout,
+		SampleRate:    opt.AccessLogSampleRate,
+		SlowThreshold: opt.AccessLogSlowThreshold,
+		Redact:        redactor.String,
+	})
+}
+
 // Start run the HTTP on dedicated goroutine.
-func CreateServer(logger *zap.Logger, opt cfg.Options) *http.Server {
-	mux := http.NewServeMux()
-
-	mux.Handle(
-		"/hello",
-		http.TimeoutHandler(
-			metrics.Instrument(withRequestLogger(logger, http.HandlerFunc(HelloHandler))),
-			opt.TimeoutHandler,
-			"Service Timeout",
-		),
-	)
-	mux.Handle(
-		"/api/message",
-		http.TimeoutHandler(
-			metrics.Instrument(withRequestLogger(logger, http.HandlerFunc(MessageHandler))),
-			opt.TimeoutHandler,
-			"Service Timeout",
+func CreateServer(logger *zap.Logger, opt cfg.Options) (*Server, error) {
+	doc := OpenAPI()
+	authn, err := newAuthenticator(opt)
+	if err != nil {
+		return nil, err
+	}
+	maxKeys := opt.RateLimitMaxKeys
+	if maxKeys == 0 {
+		maxKeys = defaultRateLimitMaxKeys
+	}
+
+	var idem idempotency.Store
+	if opt.IdempotencyTTL > 0 {
+		maxIdem := opt.IdempotencyMaxKeys
+		if maxIdem == 0 {
+			maxIdem = defaultIdempotencyMaxKeys
+		}
+		idem = idempotency.NewMemoryStore(opt.IdempotencyTTL, maxIdem)
+	}
+	patterns := []string{"/openapi.json", "/docs", redocPath}
+	for _, rt := range routes(&services{}) {
+		patterns = append(patterns, rt.pattern)
+	}
+	policies, err := corsPolicies(opt, patterns)
+	if err != nil {
+		return nil, err
+	}
+	var redoc []byte
+	if opt.DocsUI && opt.DocsRedocFile != "" {
+		if redoc, err = os.ReadFile(opt.DocsRedocFile); err != nil {
+			return nil, err
+		}
+	}
+	var access *accesslog.Logger
+	if opt.AccessLogFormat != "" {
+		if access, err = newAccessLog(logger, opt); err != nil {
+			return nil, err
+		}
+	}
+	recorder, captures, err := newRecorder(logger, opt)
+	if err != nil {
+		return nil, err
+	}
+	// Everything that may fail is built before the services, which hold
+	// the stores and the scheduler goroutine.
+	svc, err := newServices(logger, opt)
+	if err != nil {
+		if captures != nil {
+			_ = captures.Close()
+		}
+		return nil, err
+	}
+
+	headers, hsts := securityHeaders(opt), hstsHeader(opt)
+	middleware := []router.Middleware{
+		metrics.Instrument,
+		func(next http.Handler) http.Handler { return withSecurityHeaders(headers, hsts, next) },
+	}
+	if access != nil {
+		middleware = append(middleware, access.Handler)
+	}
+
+	var mux *router.Router
+	mux = router.New(router.Options{
+		// Instrumentation and the access log are outermost so that they
+		// record the responses of the timeout and body limit too; the request
+		// logger picks up the request ID of the access log. CORS preflights
+		// are answered before any route middleware, authentication included.
+		Middleware: append(middleware,
+			func(next http.Handler) http.Handler { return withRequestLogger(logger, next) },
+			func(next http.Handler) http.Handler { return withCORS(policies, mux.Allowed, next) },
 		),
-	)
+		Timeout:      opt.TimeoutHandler,
+		MaxBodyBytes: opt.MaxBodyBytes,
+		Error:        writeStatusError,
+		OnTimeout:    onTimeout,
+		Recover:      recoverPanic,
+	})
+	for _, rt := range routes(svc) {
+		// Route middleware, outermost first.
+		var mw []router.Middleware
+		if recorder != nil && captured(opt, rt) {
+			mw = append(mw, func(next http.Handler) http.Handler { return recorder.Handler(rt.pattern, next) })
+		}
+		if cl, ok := opt.ConcurrencyLimits[rt.pattern]; ok {
+			limiter := concurrency.New(cl.Initial, cl.Max, cl.Target)
+			priority := concurrency.PriorityNormal
+			if rt.critical {
+				priority = concurrency.PriorityCritical
+			}
+			mw = append(mw, func(next http.Handler) http.Handler {
+				return withConcurrencyLimit(limiter, rt.pattern, priority, next)
+			})
+		}
+		// Clients keyed by API key are limited once authenticated; others
+		// before, so that failed authentications count too.
+		var rateLimit router.Middleware
+		if rl, ok := opt.RateLimits[rt.pattern]; ok {
+			limiter := ratelimit.New(rl.Rate, rl.Burst, maxKeys)
+			rateLimit = func(next http.Handler) http.Handler {
+				return withRateLimit(limiter, rateLimitKey(opt.RateLimitKey), rt.pattern, next)
+			}
+		}
+		byPrincipal := opt.RateLimitKey == "api-key"
+		if rateLimit != nil && !byPrincipal {
+			mw = append(mw, rateLimit)
+		}
+		if authn != nil && rt.scopes != nil {
+			mw = append(mw, func(next http.Handler) http.Handler { return withAuth(authn, rt.scopes, rt.pattern, next) })
+		}
+		if rateLimit != nil && byPrincipal {
+			mw = append(mw, rateLimit)
+		}
+		if rt.idempotent && idem != nil {
+			mw = append(mw, func(next http.Handler) http.Handler { return withIdempotency(idem, rt.pattern, next) })
+		}
+		if op, ok := doc.Operation(rt.pattern, rt.method); ok {
+			validateResponses := opt.ValidateResponses && !rt.stream
+			mw = append(mw, func(next http.Handler) http.Handler {
+				return withValidation(doc, op, rt.pattern, validateResponses, next)
+			})
+		}
+
+		route := router.Route{
+			Method:       rt.method,
+			Pattern:      rt.pattern,
+			Handler:      rt.handler,
+			Timeout:      opt.RouteTimeouts[rt.pattern],
+			MaxBodyBytes: opt.RouteMaxBodyBytes[rt.pattern],
+			Middleware:   mw,
+		}
+		if rt.stream {
+			route.Timeout = -1
+		}
+		mux.Handle(route)
+	}
+
+	// API description
+	mux.Handle(router.Route{Method: http.MethodGet, Pattern: "/openapi.json", Handler: openapi.Handler(doc)})
+	if opt.DocsUI {
+		script := redocCDN
+		if redoc != nil {
+			script = redocPath
+			mux.Handle(router.Route{Method: http.MethodGet, Pattern: redocPath, Handler: redocHandler(redoc)})
+		}
+		docs := router.Route{Method: http.MethodGet, Pattern: "/docs", Handler: docsHandler(script)}
+		if p, ok := cspPolicy(opt); ok {
+			docs.Middleware = []router.Middleware{func(next http.Handler) http.Handler { return withCSP(docsPolicy(p, redoc != nil), next) }}
+		}
+		mux.Handle(docs)
+	}
+	if _, ok := cspPolicy(opt); ok {
+		limit, ok := opt.RouteMaxBodyBytes[cspReportPath]
+		if !ok {
+			limit = cspReportMaxBodyBytes
+		}
+		mux.Handle(router.Route{Method: http.MethodPost, Pattern: cspReportPath, Handler: http.HandlerFunc(CSPReportHandler), MaxBodyBytes: limit})
+	}
 
 	addr := net.JoinHostPort(*opt.Host, *opt.Port)
 	server := &http.Server{
 		Addr:              addr,
-		Handler:           mux,
+		Handler:           metrics.Compress(opt, mux),
 		ReadTimeout:       opt.ReadTimeout,
 		ReadHeaderTimeout: opt.ReadHeaderTimeout,
 		IdleTimeout:       opt.IdleTimeout,
 	}
-	return server
+	return &Server{Server: server, svc: svc, captures: captures}, nil
 }
 
 // Start run the HTTP server on dedicated goroutine and return the shutdown function.
@@ -79,12 +543,21 @@ func RunServerWithShutdown(logger *zap.Logger, opts ...cfg.Option) func(context.
 			panic(err)
 		}
 	}
-	srv := CreateServer(logger, options)
+	srv, err := CreateServer(logger, options)
+	if err != nil {
+		panic(err)
+	}
 
 	go func() {
 		addr := srv.Addr
 		logger.Info("App server listening on " + addr)
-		if err := http.ListenAndServe(addr, srv.Handler); err != nil {
+		var err error
+		if options.TLSCertFile != "" {
+			err = srv.ListenAndServeTLS(options.TLSCertFile, options.TLSKeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error("app server failed: %v", zap.Error(err))
 		}
 	}()
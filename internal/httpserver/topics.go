@@ -0,0 +1,143 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+	"github.com/marcosartorato/myapp/internal/pubsub"
+)
+
+const (
+	defaultTopic           = "default"
+	maxTopicLength         = 128
+	defaultSubscribeBuffer = 64
+	maxSubscribeBuffer     = 1024
+	// subscribeAttachTimeout is how long a subscription waits for its events
+	// stream to be opened.
+	subscribeAttachTimeout = 30 * time.Second
+	// sseKeepAlive is how often idle event streams get a comment, so that
+	// proxies do not time them out.
+	sseKeepAlive = 15 * time.Second
+)
+
+// topicOf returns the topic of a publish or subscribe request.
+func topicOf(req MessageRequest) (string, error) {
+	switch {
+	case req.Topic == "":
+		return defaultTopic, nil
+	case len(req.Topic) > maxTopicLength:
+		return "", invalidField("topic", "maxLength", "topic must be at most %d bytes", maxTopicLength)
+	}
+	return req.Topic, nil
+}
+
+// principalOf returns the subject of the authenticated caller, if any.
+func principalOf(r *http.Request) string {
+	if p, ok := auth.PrincipalFrom(r.Context()); ok {
+		return p.Subject
+	}
+	return ""
+}
+
+// publishMessage delivers req.Msg to the subscribers of req.Topic.
+func publishMessage(svc *services, r *http.Request, req MessageRequest) (any, error) {
+	topic, err := topicOf(req)
+	if err != nil {
+		return nil, err
+	}
+	metrics.PubSubPublishedMessagesTotal.Inc()
+	n := svc.topics.Publish(pubsub.Message{Topic: topic, Msg: req.Msg, Principal: principalOf(r), PublishedAt: svc.clock.Now().UTC()})
+	return PublishResponse{Type: "publish", Topic: topic, Subscribers: n}, nil
+}
+
+// subscribeMessage subscribes to req.Topic; messages are buffered until the
+// caller opens the events stream.
+func subscribeMessage(svc *services, r *http.Request, req MessageRequest) (any, error) {
+	topic, err := topicOf(req)
+	if err != nil {
+		return nil, err
+	}
+	size := req.Buffer
+	switch {
+	case size == 0:
+		size = defaultSubscribeBuffer
+	case size < 0 || size > maxSubscribeBuffer:
+		return nil, invalidField("buffer", "range", "buffer must be between 1 and %d", maxSubscribeBuffer)
+	}
+	policy := pubsub.DropOldest
+	if req.Policy != "" {
+		if policy, err = pubsub.ParsePolicy(req.Policy); err != nil {
+			return nil, invalidField("policy", "enum", "policy must be drop_oldest or disconnect")
+		}
+	}
+	s, err := svc.topics.Subscribe(topic, principalOf(r), size, policy)
+	if err != nil {
+		return nil, err
+	}
+	return SubscribeResponse{Type: "subscribe", ID: s.ID, Topic: topic, Events: "/api/subscriptions/" + s.ID + "/events"}, nil
+}
+
+// subscriptionEventsHandler streams the messages of the subscription named by
+// the id path parameter as Server-Sent Events, until the client goes away or
+// the subscription ends.
+func subscriptionEventsHandler(topics *pubsub.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := topics.Attach(r.PathValue("id"), principalOf(r))
+		switch {
+		case errors.Is(err, pubsub.ErrNotFound):
+			writeError(w, http.StatusNotFound, ErrorResponse{Error: "not_found", Message: "no subscription with this ID"})
+			return
+		case errors.Is(err, pubsub.ErrAttached):
+			writeError(w, http.StatusConflict, ErrorResponse{Error: "already_attached", Message: "the subscription already has an events stream"})
+			return
+		}
+		defer s.Close()
+
+		rc := http.NewResponseController(w)
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			getLogger(r).Error("event stream not supported", zap.Error(err))
+			return
+		}
+
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+		for seq := 1; ; {
+			select {
+			case m := <-s.C():
+				data, _ := json.Marshal(m)
+				_, err = fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", seq, data)
+				seq++
+			case <-keepAlive.C:
+				_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			case <-s.Done():
+				reason := "closed"
+				if errors.Is(s.Err(), pubsub.ErrSlowConsumer) {
+					reason = "slow_consumer"
+				}
+				_, _ = fmt.Fprintf(w, "event: end\ndata: {\"reason\":%q}\n\n", reason)
+				_ = rc.Flush()
+				return
+			case <-r.Context().Done():
+				return
+			}
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package httpserver_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/auth"
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/httpserver"
+	"github.com/marcosartorato/myapp/internal/pubsub"
+)
+
+// readEvent reads the next Server-Sent Event, skipping comments.
+func readEvent(t *testing.T, r *bufio.Reader) (event, data string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	srv := httptest.NewServer(newTestHandler(t))
+	defer srv.Close()
+	post := func(body string, v any) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/api/message", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+
+	var sub httpserver.SubscribeResponse
+	post(`{"type":"subscribe","topic":"news"}`, &sub)
+	assert.Equal(t, "news", sub.Topic)
+
+	// Messages published before the stream is opened are buffered.
+	var pub httpserver.PublishResponse
+	post(`{"type":"publish","topic":"news","msg":"early"}`, &pub)
+	assert.Equal(t, 1, pub.Subscribers)
+	post(`{"type":"publish","topic":"sports","msg":"other"}`, &pub)
+	assert.Equal(t, 0, pub.Subscribers)
+
+	resp, err := http.Get(srv.URL + sub.Events)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	events := bufio.NewReader(resp.Body)
+
+	post(`{"type":"publish","topic":"news","msg":"late"}`, &pub)
+	for _, want := range []string{"early", "late"} {
+		event, data := readEvent(t, events)
+		assert.Equal(t, "message", event)
+		var m pubsub.Message
+		require.NoError(t, json.Unmarshal([]byte(data), &m))
+		assert.Equal(t, "news", m.Topic)
+		assert.Equal(t, want, m.Msg)
+	}
+
+	// Scheduled messages with a topic are published when due.
+	var sched httpserver.ScheduleResponse
+	post(`{"type":"schedule","topic":"news","msg":"reminder"}`, &sched)
+	event, data := readEvent(t, events)
+	assert.Equal(t, "message", event)
+	var m pubsub.Message
+	require.NoError(t, json.Unmarshal([]byte(data), &m))
+	assert.Equal(t, "reminder", m.Msg)
+
+	second, err := http.Get(srv.URL + sub.Events)
+	require.NoError(t, err)
+	_ = second.Body.Close()
+	assert.Equal(t, http.StatusConflict, second.StatusCode)
+}
+
+func TestPubSubSlowConsumer(t *testing.T) {
+	h := newTestHandler(t)
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/message", `{"type":"subscribe","buffer":1,"policy":"disconnect"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var sub httpserver.SubscribeResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &sub))
+	assert.Equal(t, "default", sub.Topic)
+
+	for range 2 {
+		require.Equal(t, http.StatusOK, do(http.MethodPost, "/api/message", `{"type":"publish","msg":"hi"}`).Code)
+	}
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, sub.Events, "").Code, "disconnected")
+
+	for name, body := range map[string]string{
+		"buffer": `{"type":"subscribe","buffer":5000}`,
+		"policy": `{"type":"subscribe","policy":"block"}`,
+		"topic":  `{"type":"publish","topic":"` + strings.Repeat("t", 200) + `"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/message", body).Code)
+		})
+	}
+}
+
+func TestPubSubSubscriptionLimits(t *testing.T) {
+	keysFile := writeAPIKeys(t,
+		[3]string{"alice-key", "alice", "message:write"},
+		[3]string{"bob-key", "bob", "message:write"},
+	)
+	h := newTestHandler(t,
+		cfg.WithAuthAPIKeysFile(keysFile),
+		cfg.WithPubSubMaxSubscriptions(3),
+		cfg.WithPubSubMaxSubscriptionsPerPrincipal(2),
+	)
+	subscribe := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(`{"type":"subscribe"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(auth.APIKeyHeader, key)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for range 2 {
+		require.Equal(t, http.StatusOK, subscribe("alice-key").Code)
+	}
+	rec := subscribe("alice-key")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	var e httpserver.ErrorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &e))
+	assert.Equal(t, "too_many_subscriptions", e.Error)
+
+	assert.Equal(t, http.StatusOK, subscribe("bob-key").Code, "other principals have their own cap")
+	assert.Equal(t, http.StatusTooManyRequests, subscribe("bob-key").Code, "total cap")
+}
@@ -0,0 +1,244 @@
+package httpserver
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MessageResponse for the text transformations: upper, lower, title,
+// reverse, slug, encode and decode.
+type TransformResponse struct {
+	Type string `json:"type"`
+	Msg  string `json:"msg" doc:"The transformed msg."`
+}
+
+// MessageResponse for hash
+type HashResponse struct {
+	Type      string `json:"type"`
+	Algorithm string `json:"algorithm"`
+	Digest    string `json:"digest" doc:"Hex-encoded digest of the UTF-8 msg."`
+}
+
+// MessageResponse for count
+type CountResponse struct {
+	Type  string `json:"type"`
+	Bytes int    `json:"bytes" doc:"Length of the UTF-8 msg in bytes."`
+	Runes int    `json:"runes" doc:"Number of Unicode code points."`
+	Words int    `json:"words" doc:"Number of whitespace-separated words."`
+}
+
+// transformTypes are the message types transforming msg, in documentation order.
+var transformTypes = []messageType{
+	// Casers are stateful: each call needs its own.
+	textTransform("upper", func(s string) string { return cases.Upper(language.Und).String(s) }),
+	textTransform("lower", func(s string) string { return cases.Lower(language.Und).String(s) }),
+	textTransform("title", func(s string) string { return cases.Title(language.Und).String(s) }),
+	textTransform("reverse", reverseGraphemes),
+	{
+		name:     "slug",
+		response: TransformResponse{},
+		example:  MessageRequest{Type: "slug", Msg: "Crème Brûlée: 10 Recipes!"},
+		handle:   slugMessage,
+	},
+	{
+		name:     "hash",
+		response: HashResponse{},
+		example:  MessageRequest{Type: "hash", Msg: "hello", Algorithm: "blake2b-256"},
+		handle:   hashMessage,
+	},
+	{
+		name:     "encode",
+		response: TransformResponse{},
+		example:  MessageRequest{Type: "encode", Msg: "hello", Encoding: "base32"},
+		handle:   encodeMessage,
+	},
+	{
+		name:     "decode",
+		response: TransformResponse{},
+		example:  MessageRequest{Type: "decode", Msg: "aGVsbG8=", Encoding: "base64"},
+		handle:   decodeMessage,
+	},
+	{
+		name:     "count",
+		response: CountResponse{},
+		handle: func(_ *services, _ *http.Request, req MessageRequest) (any, error) {
+			return CountResponse{
+				Type:  "count",
+				Bytes: len(req.Msg),
+				Runes: utf8.RuneCountInString(req.Msg),
+				Words: len(strings.Fields(req.Msg)),
+			}, nil
+		},
+	},
+}
+
+// textTransform returns a message type answering with transform(msg).
+func textTransform(name string, transform func(string) string) messageType {
+	return messageType{
+		name:     name,
+		response: TransformResponse{},
+		handle: func(_ *services, _ *http.Request, req MessageRequest) (any, error) {
+			return TransformResponse{Type: name, Msg: transform(req.Msg)}, nil
+		},
+	}
+}
+
+// reverseGraphemes reverses s by user-perceived characters rather than code
+// points, so that combining marks, emoji modifiers, ZWJ sequences and flags
+// stay attached. It approximates Unicode extended grapheme clusters.
+func reverseGraphemes(s string) string {
+	var clusters []string
+	for i := 0; i < len(s); {
+		start := i
+		r, n := utf8.DecodeRuneInString(s[i:])
+		i += n
+		pairable := isRegionalIndicator(r)
+	cluster:
+		for i < len(s) {
+			next, m := utf8.DecodeRuneInString(s[i:])
+			switch {
+			case isGraphemeExtend(next):
+				i += m
+			case next == '\u200d': // zero width joiner: glue the next rune
+				i += m
+				if i < len(s) {
+					_, k := utf8.DecodeRuneInString(s[i:])
+					i += k
+				}
+			case pairable && isRegionalIndicator(next): // flags are pairs
+				i += m
+				pairable = false
+			case r == '\r' && next == '\n' && i == start+1:
+				i += m
+			default:
+				break cluster
+			}
+		}
+		clusters = append(clusters, s[start:i])
+	}
+	slices.Reverse(clusters)
+	return strings.Join(clusters, "")
+}
+
+func isGraphemeExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) || (r >= 0x1f3fb && r <= 0x1f3ff) // emoji skin tones
+}
+
+func isRegionalIndicator(r rune) bool { return r >= 0x1f1e6 && r <= 0x1f1ff }
+
+// slugMessage turns msg into a lowercase ASCII slug: accents are dropped and
+// runs of other characters become single dashes.
+func slugMessage(_ *services, _ *http.Request, req MessageRequest) (any, error) {
+	var b strings.Builder
+	dash := false
+	for _, r := range norm.NFKD.String(req.Msg) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			dash = false
+		default:
+			dash = true
+		}
+	}
+	if b.Len() == 0 {
+		return nil, invalidField("msg", "slug", "msg has no ASCII letters or digits to build a slug from")
+	}
+	return TransformResponse{Type: "slug", Msg: b.String()}, nil
+}
+
+// hashAlgorithms are the digests of the hash type.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256":      sha256.New,
+	"sha512":      sha512.New,
+	"blake2b-256": func() hash.Hash { h, _ := blake2b.New256(nil); return h },
+	"blake2b-512": func() hash.Hash { h, _ := blake2b.New512(nil); return h },
+	"blake2s-256": func() hash.Hash { h, _ := blake2s.New256(nil); return h },
+}
+
+func hashMessage(_ *services, _ *http.Request, req MessageRequest) (any, error) {
+	algorithm := req.Algorithm
+	if algorithm == "" {
+		algorithm = "sha256"
+	}
+	newHash, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return nil, invalidField("algorithm", "enum", "algorithm must be one of sha256, sha512, blake2b-256, blake2b-512 or blake2s-256")
+	}
+	h := newHash()
+	h.Write([]byte(req.Msg))
+	return HashResponse{Type: "hash", Algorithm: algorithm, Digest: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// binaryEncoding is an encoding of the encode and decode types.
+type binaryEncoding interface {
+	EncodeToString(src []byte) string
+	DecodeString(s string) ([]byte, error)
+}
+
+type hexEncoding struct{}
+
+func (hexEncoding) EncodeToString(src []byte) string      { return hex.EncodeToString(src) }
+func (hexEncoding) DecodeString(s string) ([]byte, error) { return hex.DecodeString(s) }
+
+var binaryEncodings = map[string]binaryEncoding{
+	"base64":    base64.StdEncoding,
+	"base64url": base64.URLEncoding,
+	"base32":    base32.StdEncoding,
+	"hex":       hexEncoding{},
+}
+
+// encodingOf returns the encoding named by req.Encoding, base64 by default.
+func encodingOf(req MessageRequest) (binaryEncoding, error) {
+	if req.Encoding == "" {
+		return base64.StdEncoding, nil
+	}
+	enc, ok := binaryEncodings[req.Encoding]
+	if !ok {
+		return nil, invalidField("encoding", "enum", "encoding must be one of base64, base64url, base32 or hex")
+	}
+	return enc, nil
+}
+
+func encodeMessage(_ *services, _ *http.Request, req MessageRequest) (any, error) {
+	enc, err := encodingOf(req)
+	if err != nil {
+		return nil, err
+	}
+	return TransformResponse{Type: "encode", Msg: enc.EncodeToString([]byte(req.Msg))}, nil
+}
+
+func decodeMessage(_ *services, _ *http.Request, req MessageRequest) (any, error) {
+	enc, err := encodingOf(req)
+	if err != nil {
+		return nil, err
+	}
+	b, err := enc.DecodeString(req.Msg)
+	if err != nil {
+		return nil, invalidField("msg", "encoding", "msg is not valid %s: %v", cmp.Or(req.Encoding, "base64"), err)
+	}
+	if !utf8.Valid(b) {
+		return nil, invalidField("msg", "utf8", "decoded msg is not valid UTF-8 text")
+	}
+	return TransformResponse{Type: "decode", Msg: string(b)}, nil
+}
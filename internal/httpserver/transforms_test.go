@@ -0,0 +1,67 @@
+package httpserver_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/httpserver"
+)
+
+func TestTransformTypes(t *testing.T) {
+	tests := map[string]struct {
+		reqBody    string
+		wantStatus int
+		want       string // JSON response, or the error field
+	}{
+		"upper":               {`{"type":"upper","msg":"straße"}`, http.StatusOK, `{"type":"upper","msg":"STRASSE"}`},
+		"lower":               {`{"type":"lower","msg":"ÀÉÎ Go"}`, http.StatusOK, `{"type":"lower","msg":"àéî go"}`},
+		"title":               {`{"type":"title","msg":"hello wORLD"}`, http.StatusOK, `{"type":"title","msg":"Hello World"}`},
+		"reverse":             {`{"type":"reverse","msg":"abc"}`, http.StatusOK, `{"type":"reverse","msg":"cba"}`},
+		"reverse combining":   {`{"type":"reverse","msg":"no\u0308el"}`, http.StatusOK, `{"type":"reverse","msg":"leo\u0308n"}`},
+		"reverse emoji":       {`{"type":"reverse","msg":"a👍🏽b👨‍👩‍👧🇮🇹"}`, http.StatusOK, `{"type":"reverse","msg":"🇮🇹👨‍👩‍👧b👍🏽a"}`},
+		"slug":                {`{"type":"slug","msg":"  Crème Brûlée: 10 Recipes!"}`, http.StatusOK, `{"type":"slug","msg":"creme-brulee-10-recipes"}`},
+		"slug empty":          {`{"type":"slug","msg":"!!!"}`, http.StatusBadRequest, "/msg"},
+		"sha256":              {`{"type":"hash","msg":"hello"}`, http.StatusOK, `{"type":"hash","algorithm":"sha256","digest":"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}`},
+		"sha512":              {`{"type":"hash","msg":"","algorithm":"sha512"}`, http.StatusOK, `{"type":"hash","algorithm":"sha512","digest":"cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e"}`},
+		"blake2b-256":         {`{"type":"hash","msg":"","algorithm":"blake2b-256"}`, http.StatusOK, `{"type":"hash","algorithm":"blake2b-256","digest":"0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"}`},
+		"blake2s-256":         {`{"type":"hash","msg":"","algorithm":"blake2s-256"}`, http.StatusOK, `{"type":"hash","algorithm":"blake2s-256","digest":"69217a3079908094e11121d042354a7c1f55b6482ca1a51e1b250dfd1ed0eef9"}`},
+		"unknown algorithm":   {`{"type":"hash","msg":"x","algorithm":"md5"}`, http.StatusBadRequest, "/algorithm"},
+		"encode base64":       {`{"type":"encode","msg":"hi?>"}`, http.StatusOK, `{"type":"encode","msg":"aGk/Pg=="}`},
+		"encode base64url":    {`{"type":"encode","msg":"hi?>","encoding":"base64url"}`, http.StatusOK, `{"type":"encode","msg":"aGk_Pg=="}`},
+		"encode base32":       {`{"type":"encode","msg":"hello","encoding":"base32"}`, http.StatusOK, `{"type":"encode","msg":"NBSWY3DP"}`},
+		"encode hex":          {`{"type":"encode","msg":"hi","encoding":"hex"}`, http.StatusOK, `{"type":"encode","msg":"6869"}`},
+		"decode hex":          {`{"type":"decode","msg":"6869","encoding":"hex"}`, http.StatusOK, `{"type":"decode","msg":"hi"}`},
+		"decode base32":       {`{"type":"decode","msg":"NBSWY3DP","encoding":"base32"}`, http.StatusOK, `{"type":"decode","msg":"hello"}`},
+		"decode invalid":      {`{"type":"decode","msg":"not base64!"}`, http.StatusBadRequest, "/msg"},
+		"decode binary":       {`{"type":"decode","msg":"/w==","encoding":"base64"}`, http.StatusBadRequest, "/msg"},
+		"unknown encoding":    {`{"type":"encode","msg":"x","encoding":"rot13"}`, http.StatusBadRequest, "/encoding"},
+		"count":               {`{"type":"count","msg":" héllo  wörld "}`, http.StatusOK, `{"type":"count","bytes":16,"runes":14,"words":2}`},
+		"count empty":         {`{"type":"count"}`, http.StatusOK, `{"type":"count","bytes":0,"runes":0,"words":0}`},
+		"transform empty msg": {`{"type":"upper"}`, http.StatusOK, `{"type":"upper","msg":""}`},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(tc.reqBody))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			httpserver.MessageHandler(rec, req)
+
+			require.Equal(t, tc.wantStatus, rec.Code, rec.Body.String())
+			if tc.wantStatus == http.StatusOK {
+				assert.JSONEq(t, tc.want, rec.Body.String())
+				return
+			}
+			var got httpserver.ErrorResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+			assert.Equal(t, "validation_failed", got.Error)
+			assert.Equal(t, tc.want, got.Field)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package httpserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+	"github.com/marcosartorato/myapp/internal/openapi"
+	"go.uber.org/zap"
+)
+
+// withValidation rejects requests that do not match the OpenAPI operation
+// with a structured 400. When validateResponses is set, the handler response
+// is buffered and checked as well; a mismatch is replaced with a 500 so that
+// contract regressions surface in tests and debug deployments.
+func withValidation(doc *openapi.Document, op *openapi.Operation, route string, validateResponses bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := getLogger(r)
+
+		// reject writes a structured error for err and counts it under reasonPrefix+keyword.
+		reject := func(status int, code, reasonPrefix string, err error) {
+			resp := ErrorResponse{Error: code, Message: err.Error(), Reason: "body"}
+			var verr *openapi.ValidationError
+			if errors.As(err, &verr) {
+				resp.Message, resp.Reason, resp.Field = verr.Message, verr.Keyword, verr.Path
+			}
+			metrics.ValidationFailuresTotal.WithLabelValues(route, reasonPrefix+resp.Reason).Inc()
+			writeError(w, status, resp)
+		}
+
+		if err := doc.ValidateParameters(op, r); err != nil {
+			reject(http.StatusBadRequest, "validation_failed", "", err)
+			log.Debug("request parameters failed validation", zap.Error(err))
+			return
+		}
+
+		if op.RequestBody != nil {
+			body, err := io.ReadAll(r.Body)
+			if err == nil {
+				err = doc.ValidateBody(op, r.Header.Get("Content-Type"), body)
+			}
+			if bodyTooLarge(err) {
+				writeStatusError(w, r, http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				reject(http.StatusBadRequest, "validation_failed", "", err)
+				log.Debug("request body failed validation", zap.Error(err))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		if !validateResponses {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf := &bufferedResponseWriter{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+		if err := doc.ValidateResponse(op, buf.status, buf.header.Get("Content-Type"), buf.body.Bytes()); err != nil {
+			reject(http.StatusInternalServerError, "response_validation_failed", "response_", err)
+			log.Error("response failed validation", zap.Int("status", buf.status), zap.Error(err))
+			return
+		}
+		w.WriteHeader(buf.status)
+		_, _ = w.Write(buf.body.Bytes())
+	})
+}
+
+// bufferedResponseWriter holds a response in memory until it is validated.
+type bufferedResponseWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header { return w.header }
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.status, w.wroteHeader = code, true
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
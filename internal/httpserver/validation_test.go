@@ -0,0 +1,173 @@
+package httpserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/httpserver"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+)
+
+// newTestHandler returns the full app server handler with test-friendly options.
+func newTestHandler(t *testing.T, opts ...cfg.Option) http.Handler {
+	t.Helper()
+	host, port := "localhost", "8080"
+	options := cfg.Options{Host: &host, Port: &port, TimeoutHandler: 5 * time.Second}
+	for _, opt := range opts {
+		require.NoError(t, opt(&options))
+	}
+	srv, err := httpserver.CreateServer(zap.NewNop(), options)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+	return srv.Handler
+}
+
+func TestValidationMiddleware(t *testing.T) {
+	h := newTestHandler(t, cfg.WithValidateResponses(true))
+
+	tests := map[string]struct {
+		contentType string
+		body        string
+		wantStatus  int
+		wantReason  string
+		wantField   string
+	}{
+		"valid repeat": {
+			contentType: "application/json",
+			body:        `{"type":"repeat","msg":"hi"}`,
+			wantStatus:  http.StatusOK,
+		},
+		"extra field ignored": {
+			contentType: "application/json",
+			body:        `{"type":"repeat","msg":"hi","extra":1}`,
+			wantStatus:  http.StatusOK,
+		},
+		"unknown type": {
+			contentType: "application/json",
+			body:        `{"type":"nope"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantReason:  "enum",
+			wantField:   "/type",
+		},
+		"missing type": {
+			contentType: "application/json",
+			body:        `{"msg":"hi"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantReason:  "required",
+		},
+		"wrong msg type": {
+			contentType: "application/json",
+			body:        `{"type":"repeat","msg":42}`,
+			wantStatus:  http.StatusBadRequest,
+			wantReason:  "type",
+			wantField:   "/msg",
+		},
+		"invalid JSON": {
+			contentType: "application/json",
+			body:        `{`,
+			wantStatus:  http.StatusBadRequest,
+			wantReason:  "json",
+		},
+		"empty body": {
+			contentType: "application/json",
+			wantStatus:  http.StatusBadRequest,
+			wantReason:  "required",
+		},
+		"unsupported content type": {
+			contentType: "text/plain",
+			body:        `{"type":"repeat"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantReason:  "contentType",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			before := testutil.ToFloat64(metrics.ValidationFailuresTotal.WithLabelValues("/api/message", tc.wantReason))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			require.Equal(t, tc.wantStatus, rec.Code, "unexpected status code: %s", rec.Body.String())
+			if tc.wantStatus == http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			var got httpserver.ErrorResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+			assert.Equal(t, "validation_failed", got.Error)
+			assert.Equal(t, tc.wantReason, got.Reason)
+			assert.Equal(t, tc.wantField, got.Field)
+
+			after := testutil.ToFloat64(metrics.ValidationFailuresTotal.WithLabelValues("/api/message", tc.wantReason))
+			assert.Equal(t, before+1, after, "validation failure not counted")
+		})
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	h := newTestHandler(t, cfg.WithMaxBodyBytes(64), cfg.WithRouteMaxBodyBytes("/api/message=128"))
+	before := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues(http.MethodPost, "/api/message", "413", metrics.OutcomeCompleted))
+
+	post := func(msg string, chunked bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(`{"type":"repeat","msg":"`+msg+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, post(strings.Repeat("a", 80), false).Code, "route limit overrides the default")
+	for _, chunked := range []bool{false, true} {
+		rec := post(strings.Repeat("a", 200), chunked)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		var resp httpserver.ErrorResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, "request_entity_too_large", resp.Error)
+	}
+	assert.Equal(t, before+2, testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues(http.MethodPost, "/api/message", "413", metrics.OutcomeCompleted)))
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/message", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "OPTIONS, POST", rec.Header().Get("Allow"))
+	var resp httpserver.ErrorResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "method_not_allowed", resp.Error)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/api/messages/1", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", rec.Header().Get("Allow"))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/hello", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nope", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "not_found", resp.Error)
+}
@@ -0,0 +1,36 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/marcosartorato/myapp/internal/webhook"
+)
+
+// DeadLetterList lists the failed webhook deliveries, oldest first.
+type DeadLetterList struct {
+	DeadLetters []webhook.Delivery `json:"dead_letters"`
+}
+
+// listDeadLettersHandler lists the failed webhook deliveries.
+func listDeadLettersHandler(hooks *webhook.Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		list := DeadLetterList{DeadLetters: hooks.DeadLetters()}
+		if list.DeadLetters == nil {
+			list.DeadLetters = []webhook.Delivery{}
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// replayDeadLetterHandler delivers the failed webhook delivery named by the id
+// path parameter again.
+func replayDeadLetterHandler(hooks *webhook.Dispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := hooks.Replay(r.PathValue("id")); errors.Is(err, webhook.ErrNotFound) {
+			writeError(w, http.StatusNotFound, ErrorResponse{Error: "not_found", Message: "no dead letter with this ID"})
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
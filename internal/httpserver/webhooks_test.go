@@ -0,0 +1,101 @@
+package httpserver_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/httpserver"
+	"github.com/marcosartorato/myapp/internal/webhook"
+	"github.com/marcosartorato/myapp/pkg/hmacsign"
+)
+
+func TestWebhookDeadLetters(t *testing.T) {
+	var healthy atomic.Bool
+	received := make(chan webhook.Event, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		assert.NotEmpty(t, r.Header.Get(hmacsign.HeaderSignature))
+		body, _ := io.ReadAll(r.Body)
+		var ev webhook.Event
+		assert.NoError(t, json.Unmarshal(body, &ev))
+		received <- ev
+	}))
+	defer receiver.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "webhook.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("k1 000102030405060708090a0b0c0d0e0f\n"), 0o600))
+	h := newTestHandler(t,
+		cfg.WithWebhooks("repeat="+receiver.URL),
+		cfg.WithWebhookSigningKeyFile(keyFile),
+		cfg.WithWebhookMaxAttempts(2),
+		cfg.WithWebhookBackoff(1),
+		cfg.WithValidateResponses(true),
+	)
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	deadLetters := func() []webhook.Delivery {
+		rec := do(http.MethodGet, "/api/webhooks/dead-letters", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var list httpserver.DeadLetterList
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+		return list.DeadLetters
+	}
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/api/message", `{"type":"repeat","msg":"hi"}`).Code)
+	require.Eventually(t, func() bool { return len(deadLetters()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	dl := deadLetters()[0]
+	assert.Equal(t, 2, dl.Attempts)
+	assert.Equal(t, http.StatusBadGateway, dl.LastStatus)
+	assert.Equal(t, "repeat", dl.Event.Type)
+
+	healthy.Store(true)
+	assert.Equal(t, http.StatusAccepted, do(http.MethodPost, "/api/webhooks/dead-letters/"+dl.ID+"/replay", "").Code)
+	select {
+	case ev := <-received:
+		assert.Equal(t, dl.Event.ID, ev.ID)
+		assert.JSONEq(t, `{"type":"repeat","msg":"hi"}`, string(ev.Payload))
+	case <-time.After(2 * time.Second):
+		t.Fatal("replay not delivered")
+	}
+	assert.Empty(t, deadLetters())
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPost, "/api/webhooks/dead-letters/"+dl.ID+"/replay", "").Code)
+
+	// Types without subscription are not delivered.
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/api/message", `{"type":"time"}`).Code)
+	select {
+	case ev := <-received:
+		t.Fatalf("unexpected delivery of %s", ev.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhooksRequireSigningKey(t *testing.T) {
+	host, port := "localhost", "8080"
+	options := cfg.Options{Host: &host, Port: &port}
+	require.NoError(t, cfg.WithWebhooks("*=http://example.com/hook")(&options))
+	_, err := httpserver.CreateServer(zap.NewNop(), options)
+	assert.Error(t, err)
+}
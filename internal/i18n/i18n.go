@@ -0,0 +1,156 @@
+// Package i18n holds the localized greetings of the /hello endpoint.
+//
+// Each locale has a catalog with a greeting template, executed with the
+// name to greet as {{.Name}}, and the default name used when none is given.
+// The built-in catalogs are embedded; a JSON file can override them or add
+// locales.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+
+	"golang.org/x/text/language"
+)
+
+// Fallback is the locale used when no other matches.
+var Fallback = language.English
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// Entry is the catalog of a locale, as found in the locale files.
+type Entry struct {
+	// Greeting is a text/template executed with {{.Name}}.
+	Greeting string `json:"greeting"`
+	// World is the name greeted when none is given.
+	World string `json:"world"`
+}
+
+type locale struct {
+	greeting *template.Template
+	world    string
+}
+
+// Catalogs are the greetings of all locales.
+type Catalogs struct {
+	tags    []language.Tag // Fallback first
+	locales []locale       // by tag index
+	matcher language.Matcher
+}
+
+// Default returns the built-in catalogs.
+func Default() *Catalogs {
+	c, err := build(nil)
+	if err != nil {
+		panic(err) // embedded catalogs are valid
+	}
+	return c
+}
+
+// Load returns the built-in catalogs with the entries of the JSON file at
+// path, an object keyed by BCP 47 locale, added over them. Fields left empty
+// keep their built-in value.
+func Load(path string) (*Catalogs, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("i18n: %w", err)
+	}
+	var overrides map[string]Entry
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("i18n: %s: %w", path, err)
+	}
+	return build(overrides)
+}
+
+func build(overrides map[string]Entry) (*Catalogs, error) {
+	entries := map[language.Tag]Entry{}
+	files, _ := locales.ReadDir("locales")
+	for _, f := range files {
+		data, err := locales.ReadFile("locales/" + f.Name())
+		if err != nil {
+			return nil, err
+		}
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("i18n: %s: %w", f.Name(), err)
+		}
+		entries[language.MustParse(strings.TrimSuffix(f.Name(), path.Ext(f.Name())))] = e
+	}
+	for name, o := range overrides {
+		tag, err := language.Parse(name)
+		if err != nil {
+			return nil, fmt.Errorf("i18n: locale %q: %w", name, err)
+		}
+		e := entries[tag]
+		if o.Greeting != "" {
+			e.Greeting = o.Greeting
+		}
+		if o.World != "" {
+			e.World = o.World
+		}
+		entries[tag] = e
+	}
+
+	c := &Catalogs{tags: []language.Tag{Fallback}}
+	for tag := range entries {
+		if tag != Fallback {
+			c.tags = append(c.tags, tag)
+		}
+	}
+	slices.SortFunc(c.tags[1:], func(a, b language.Tag) int { return strings.Compare(a.String(), b.String()) })
+	for _, tag := range c.tags {
+		e := entries[tag]
+		if e.Greeting == "" {
+			return nil, fmt.Errorf("i18n: locale %s: no greeting", tag)
+		}
+		if e.World == "" {
+			e.World = entries[Fallback].World
+		}
+		tmpl, err := template.New(tag.String()).Parse(e.Greeting)
+		if err != nil {
+			return nil, fmt.Errorf("i18n: locale %s: %w", tag, err)
+		}
+		// Templates only see Name: catch references to anything else now
+		// rather than on the first request.
+		if err := tmpl.Execute(&strings.Builder{}, greetingData{Name: e.World}); err != nil {
+			return nil, fmt.Errorf("i18n: locale %s: %w", tag, err)
+		}
+		c.locales = append(c.locales, locale{greeting: tmpl, world: e.World})
+	}
+	c.matcher = language.NewMatcher(c.tags)
+	return c, nil
+}
+
+type greetingData struct {
+	Name string
+}
+
+// Locales returns the supported locales, Fallback first.
+func (c *Catalogs) Locales() []language.Tag {
+	return slices.Clone(c.tags)
+}
+
+// Greet greets name, or the locale's World if empty, in the locale best
+// matching the Accept-Language header value acceptLanguage. It returns the
+// greeting and its locale.
+func (c *Catalogs) Greet(acceptLanguage, name string) (string, language.Tag, error) {
+	// An unparsable header matches nothing, like an absent one.
+	desired, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, i, _ := c.matcher.Match(desired...)
+	l := c.locales[i]
+	if name == "" {
+		name = l.world
+	}
+	var b strings.Builder
+	if err := l.greeting.Execute(&b, greetingData{Name: name}); err != nil {
+		return "", c.tags[i], fmt.Errorf("i18n: locale %s: %w", c.tags[i], err)
+	}
+	return b.String(), c.tags[i], nil
+}
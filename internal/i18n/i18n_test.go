@@ -0,0 +1,67 @@
+package i18n_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/language"
+
+	"github.com/marcosartorato/myapp/internal/i18n"
+)
+
+func TestGreet(t *testing.T) {
+	c := i18n.Default()
+	for _, tc := range []struct {
+		acceptLanguage, name string
+		want                 string
+		tag                  language.Tag
+	}{
+		{"", "", "Hello, World!", language.English},
+		{"it-CH, en;q=0.5", "Ada", "Ciao, Ada!", language.Italian},
+		{"de;q=0.5, fr", "", "Bonjour, le monde !", language.French},
+		{"es-419", "", "¡Hola, Mundo!", language.Spanish},
+		{"ja", "Ada", "Hello, Ada!", language.English},
+		{"not a language", "", "Hello, World!", language.English},
+	} {
+		got, tag, err := c.Greet(tc.acceptLanguage, tc.name)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, got, tc.acceptLanguage)
+		assert.Equal(t, tc.tag, tag, tc.acceptLanguage)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "greetings.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"en": {"greeting": "Hi there, {{.Name}}."},
+		"pt-BR": {"greeting": "Olá, {{.Name}}!", "world": "Mundo"}
+	}`), 0o600))
+	c, err := i18n.Load(path)
+	require.NoError(t, err)
+
+	got, _, err := c.Greet("", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Hi there, World.", got, "built-in world kept")
+	got, tag, err := c.Greet("pt-BR", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Olá, Mundo!", got)
+	assert.Equal(t, "pt-BR", tag.String())
+	got, _, err = c.Greet("it", "")
+	require.NoError(t, err)
+	assert.Equal(t, "Ciao, Mondo!", got, "other built-ins kept")
+
+	for _, bad := range []string{
+		`{"en": {"greeting": "Hi {{.Name"}}`,
+		`{"en": {"greeting": "Hi {{.Surname}}"}}`,
+		`{"pt": {"world": "Mundo"}}`,
+		`{"???": {"greeting": "Hi"}}`,
+		`[]`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(bad), 0o600))
+		_, err := i18n.Load(path)
+		assert.Error(t, err, bad)
+	}
+}
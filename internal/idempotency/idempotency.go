@@ -0,0 +1,151 @@
+// Package idempotency remembers the responses of requests carrying an
+// Idempotency-Key so that retries get the original response back instead of
+// running the request again.
+package idempotency
+
+import (
+	"container/list"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Header is the request header carrying the client-chosen key.
+const Header = "Idempotency-Key"
+
+var (
+	// ErrInFlight is returned by Reserve while the first request with the
+	// key has not completed yet.
+	ErrInFlight = errors.New("idempotency: request with this key is in flight")
+	// ErrMismatch is returned by Reserve when the key was first used for a
+	// different request.
+	ErrMismatch = errors.New("idempotency: key reused with a different request")
+)
+
+// Response is a stored response.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Store keeps track of idempotency keys.
+//
+// A request first reserves its key along with a fingerprint of the request.
+// Reserve returns the stored response if the key has completed already, or
+// one of ErrInFlight and ErrMismatch; otherwise the caller owns the key and
+// must either Complete it with the response to replay or Release it so that
+// a retry can run again.
+type Store interface {
+	Reserve(key, fingerprint string) (*Response, error)
+	Complete(key string, resp Response) error
+	Release(key string) error
+}
+
+type entry struct {
+	key         string
+	fingerprint string
+	resp        *Response // nil while in flight
+	expires     time.Time
+}
+
+// MemoryStore is an in-process Store. Completed keys are kept for the TTL;
+// in-flight keys are kept for the TTL as well, in case Complete or Release
+// never comes. Past the maximum number of keys, the least recently used one
+// is dropped.
+type MemoryStore struct {
+	ttl time.Duration
+	max int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *entry
+	lru     *list.List               // least recently used first
+	pruned  time.Time
+}
+
+// NewMemoryStore returns an empty store keeping keys for ttl, at most max of
+// them when max is positive.
+func NewMemoryStore(ttl time.Duration, max int) *MemoryStore {
+	return &MemoryStore{ttl: ttl, max: max, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+// Reserve implements Store.
+func (s *MemoryStore) Reserve(key, fingerprint string) (*Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.prune(now)
+
+	if el, ok := s.entries[key]; ok {
+		if e := el.Value.(*entry); e.expires.After(now) {
+			s.lru.MoveToBack(el)
+			switch {
+			case e.fingerprint != fingerprint:
+				return nil, ErrMismatch
+			case e.resp == nil:
+				return nil, ErrInFlight
+			default:
+				return e.resp, nil
+			}
+		}
+		s.remove(el)
+	}
+	if s.max > 0 && len(s.entries) >= s.max {
+		s.remove(s.lru.Front())
+	}
+	s.entries[key] = s.lru.PushBack(&entry{key: key, fingerprint: fingerprint, expires: now.Add(s.ttl)})
+	return nil, nil
+}
+
+// Complete implements Store.
+func (s *MemoryStore) Complete(key string, resp Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return errors.New("idempotency: completing an unknown key")
+	}
+	e := el.Value.(*entry)
+	e.resp = &resp
+	e.expires = time.Now().Add(s.ttl)
+	return nil
+}
+
+// Release implements Store.
+func (s *MemoryStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+	return nil
+}
+
+// Len returns the number of keys currently held, expired ones included until
+// they are pruned.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// prune drops expired keys, at most once a minute. Callers hold s.mu.
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.pruned) < time.Minute {
+		return
+	}
+	for el := s.lru.Front(); el != nil; {
+		next := el.Next()
+		if !el.Value.(*entry).expires.After(now) {
+			s.remove(el)
+		}
+		el = next
+	}
+	s.pruned = now
+}
+
+// remove drops the key held in el. Callers hold s.mu.
+func (s *MemoryStore) remove(el *list.Element) {
+	delete(s.entries, s.lru.Remove(el).(*entry).key)
+}
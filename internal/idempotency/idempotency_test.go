@@ -0,0 +1,73 @@
+package idempotency_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/idempotency"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := idempotency.NewMemoryStore(50*time.Millisecond, 0)
+
+	resp, err := s.Reserve("k", "fp")
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+
+	_, err = s.Reserve("k", "fp")
+	assert.ErrorIs(t, err, idempotency.ErrInFlight)
+	_, err = s.Reserve("k", "other")
+	assert.ErrorIs(t, err, idempotency.ErrMismatch)
+
+	want := idempotency.Response{Status: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{}`)}
+	require.NoError(t, s.Complete("k", want))
+	resp, err = s.Reserve("k", "fp")
+	require.NoError(t, err)
+	assert.Equal(t, &want, resp)
+	_, err = s.Reserve("k", "other")
+	assert.ErrorIs(t, err, idempotency.ErrMismatch)
+
+	// Released keys can be reserved again.
+	_, err = s.Reserve("released", "fp")
+	require.NoError(t, err)
+	require.NoError(t, s.Release("released"))
+	resp, err = s.Reserve("released", "other")
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+
+	// Expired keys are forgotten.
+	time.Sleep(60 * time.Millisecond)
+	resp, err = s.Reserve("k", "other")
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+
+	assert.Error(t, s.Complete("unknown", want))
+}
+
+func TestMemoryStoreMax(t *testing.T) {
+	s := idempotency.NewMemoryStore(time.Hour, 2)
+	want := idempotency.Response{Status: http.StatusOK}
+	for _, k := range []string{"a", "b"} {
+		_, err := s.Reserve(k, "fp")
+		require.NoError(t, err)
+		require.NoError(t, s.Complete(k, want))
+	}
+	// Replaying a makes b the least recently used key.
+	resp, err := s.Reserve("a", "fp")
+	require.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	_, err = s.Reserve("c", "fp")
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.Len())
+	resp, err = s.Reserve("a", "fp")
+	require.NoError(t, err)
+	assert.NotNil(t, resp, "recently used keys are kept")
+	resp, err = s.Reserve("b", "other")
+	require.NoError(t, err)
+	assert.Nil(t, resp, "the least recently used key is dropped")
+}
@@ -0,0 +1,230 @@
+// Package logging builds the application logger from its configuration:
+// encoding, outputs with file rotation, shipping to Loki, sampling, field
+// names and redaction.
+package logging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/loki"
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+	"github.com/marcosartorato/myapp/internal/redact"
+)
+
+// New returns the logger configured in opt, along with a function flushing
+// it and closing its files, to be called before exiting.
+func New(opt cfg.Options) (*zap.Logger, func() error, error) {
+	encoder, err := newEncoder(opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	outputs := opt.LogOutputs
+	if len(outputs) == 0 {
+		outputs = []string{"stdout"}
+	}
+	var syncers []zapcore.WriteSyncer
+	var closers []io.Closer
+	for _, output := range outputs {
+		switch output {
+		case "stdout":
+			syncers = append(syncers, zapcore.Lock(stdSyncer{os.Stdout}))
+		case "stderr":
+			syncers = append(syncers, zapcore.Lock(stdSyncer{os.Stderr}))
+		default:
+			file := &lumberjack.Logger{
+				Filename:   output,
+				MaxSize:    opt.LogFileMaxSize,
+				MaxAge:     days(opt.LogFileMaxAge),
+				MaxBackups: opt.LogFileMaxBackups,
+				Compress:   opt.LogFileCompress,
+			}
+			if opt.LogFileMaxSize == 0 {
+				// lumberjack would rotate at 100 MB: make it unreachable.
+				file.MaxSize = math.MaxInt32
+			}
+			syncers = append(syncers, zapcore.AddSync(file))
+			closers = append(closers, file)
+		}
+	}
+
+	redactor, err := NewRedactor(opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	level := zap.NewAtomicLevelAt(opt.LogLevel)
+	core := zapcore.NewCore(encoder, zapcore.NewMultiWriteSyncer(syncers...), level)
+	var shipper *loki.Shipper
+	if opt.LokiURL != "" {
+		if shipper, err = newShipper(opt); err != nil {
+			return nil, nil, err
+		}
+		core = zapcore.NewTee(core, shipper.Core(encoder.Clone(), level))
+	}
+	// Redaction is inside sampling, which only lets through entries to log.
+	core = redactor.Core(core)
+	if s := opt.LogSampling; s.Initial > 0 {
+		core = unsampledAccess{
+			Core:  zapcore.NewSamplerWithOptions(core, time.Second, s.Initial, s.Thereafter),
+			inner: core,
+		}
+	}
+	options := []zap.Option{zap.ErrorOutput(zapcore.Lock(stdSyncer{os.Stderr}))}
+	if opt.LogCaller {
+		options = append(options, zap.AddCaller())
+	}
+	if opt.LogStacktraceLevel != nil {
+		options = append(options, zap.AddStacktrace(*opt.LogStacktraceLevel))
+	}
+	logger := zap.New(core, options...)
+
+	closeLogger := func() error {
+		errs := []error{logger.Sync()}
+		if shipper != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), shipperCloseTimeout)
+			defer cancel()
+			errs = append(errs, shipper.Close(ctx))
+		}
+		for _, c := range closers {
+			errs = append(errs, c.Close())
+		}
+		return errors.Join(errs...)
+	}
+	return logger, closeLogger, nil
+}
+
+// AccessLoggerName is the name of the access logger. Its entries are not
+// sampled: the access log samples requests itself, always keeping errors and
+// slow requests, all logged with the same level and message.
+const AccessLoggerName = "access"
+
+// unsampledAccess is a sampling core letting through the entries of the
+// access logger unsampled.
+type unsampledAccess struct {
+	zapcore.Core // sampling inner
+	inner        zapcore.Core
+}
+
+func (c unsampledAccess) With(fields []zapcore.Field) zapcore.Core {
+	return unsampledAccess{Core: c.Core.With(fields), inner: c.inner.With(fields)}
+}
+
+func (c unsampledAccess) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if ent.LoggerName == AccessLoggerName {
+		return c.inner.Check(ent, ce)
+	}
+	return c.Core.Check(ent, ce)
+}
+
+// shipperCloseTimeout bounds the time spent pushing the last entries to Loki
+// when closing the logger.
+const shipperCloseTimeout = 5 * time.Second
+
+// newShipper returns the Loki shipper configured in opt, reporting its health
+// as metrics.
+func newShipper(opt cfg.Options) (*loki.Shipper, error) {
+	maxRetries := opt.LokiMaxRetries
+	if maxRetries == 0 {
+		maxRetries = -1 // no retries, rather than the default
+	}
+	return loki.New(loki.Options{
+		URL:           opt.LokiURL,
+		TenantID:      opt.LokiTenantID,
+		Labels:        opt.LokiLabels,
+		BatchSize:     opt.LokiBatchSize,
+		FlushInterval: opt.LokiFlushInterval,
+		BufferSize:    opt.LokiBufferSize,
+		Policy:        opt.LokiDropPolicy,
+		MaxRetries:    maxRetries,
+		Backoff:       opt.LokiBackoff,
+		OnPush: func(entries int, elapsed time.Duration, err error) {
+			result := "success"
+			if err != nil {
+				result = "failure"
+			} else {
+				metrics.LokiSentEntriesTotal.Add(float64(entries))
+				metrics.LokiLastSuccessTimestamp.SetToCurrentTime()
+			}
+			metrics.LokiPushDuration.WithLabelValues(result).Observe(elapsed.Seconds())
+		},
+		OnDrop: func(reason string, entries int) {
+			metrics.LokiDroppedEntriesTotal.WithLabelValues(reason).Add(float64(entries))
+		},
+		OnBuffered: func(n int) { metrics.LokiBufferedEntries.Set(float64(n)) },
+	})
+}
+
+// NewRedactor returns the redactor configured in opt, nil if none is.
+func NewRedactor(opt cfg.Options) (*redact.Redactor, error) {
+	return redact.New(redact.Options{
+		Keys:             opt.LogRedactKeys,
+		Values:           opt.LogRedactValues,
+		Regexp:           opt.LogRedactRegexp,
+		PayloadKeys:      opt.LogPayloadKeys,
+		PayloadMode:      opt.LogPayloadMode,
+		PayloadMaxLength: opt.LogPayloadMaxLength,
+	})
+}
+
+// newEncoder returns the encoder configured in opt, zap's production one by
+// default.
+func newEncoder(opt cfg.Options) (zapcore.Encoder, error) {
+	ec := zap.NewProductionEncoderConfig()
+	for field, name := range opt.LogFieldNames {
+		switch field {
+		case "time":
+			ec.TimeKey = name
+		case "level":
+			ec.LevelKey = name
+		case "message":
+			ec.MessageKey = name
+		case "caller":
+			ec.CallerKey = name
+		case "stacktrace":
+			ec.StacktraceKey = name
+		case "name":
+			ec.NameKey = name
+		}
+	}
+	if opt.LogTimeEncoding != "" {
+		if err := ec.EncodeTime.UnmarshalText([]byte(opt.LogTimeEncoding)); err != nil {
+			return nil, err
+		}
+	}
+	if opt.LogEncoding == "console" {
+		ec.EncodeLevel = zapcore.CapitalLevelEncoder
+		return zapcore.NewConsoleEncoder(ec), nil
+	}
+	return zapcore.NewJSONEncoder(ec), nil
+}
+
+// days rounds d up to whole days, as lumberjack keeps files by day.
+func days(d time.Duration) int {
+	const day = 24 * time.Hour
+	return int((d + day - 1) / day)
+}
+
+// stdSyncer is the standard output or error. They cannot be synced when
+// attached to a terminal or a pipe, which is no reason to fail flushing logs.
+type stdSyncer struct {
+	*os.File
+}
+
+func (s stdSyncer) Sync() error {
+	err := s.File.Sync()
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTTY) || errors.Is(err, syscall.EBADF) {
+		return nil
+	}
+	return err
+}
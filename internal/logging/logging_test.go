@@ -0,0 +1,217 @@
+package logging_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	cfg "github.com/marcosartorato/myapp/internal/config"
+	"github.com/marcosartorato/myapp/internal/logging"
+)
+
+func newLogger(t *testing.T, opts ...cfg.Option) (*zap.Logger, func() error) {
+	t.Helper()
+	var options cfg.Options
+	for _, opt := range opts {
+		require.NoError(t, opt(&options))
+	}
+	logger, closeLogger, err := logging.New(options)
+	require.NoError(t, err)
+	return logger, closeLogger
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func TestFieldNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	logger, closeLogger := newLogger(t,
+		cfg.WithLogOutputs(path),
+		cfg.WithLogFieldNames("time=@timestamp,level=log.level,message=message,stacktrace=error.stack_trace,name=log.logger"),
+		cfg.WithLogTimeEncoding("iso8601"),
+		cfg.WithLogStacktraceLevel("error"),
+	)
+	logger.Named("access").Info("hello", zap.String("k", "v"))
+	logger.Error("failed")
+	require.NoError(t, closeLogger())
+
+	lines := readLines(t, path)
+	require.Len(t, lines, 2)
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "info", entry["log.level"])
+	assert.Equal(t, "hello", entry["message"])
+	assert.Equal(t, "access", entry["log.logger"])
+	assert.Equal(t, "v", entry["k"])
+	assert.Regexp(t, `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}`, entry["@timestamp"])
+	assert.NotContains(t, entry, "caller")
+	assert.NotContains(t, entry, "error.stack_trace")
+
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Contains(t, entry["error.stack_trace"], "logging_test.TestFieldNames")
+
+	assert.Error(t, cfg.WithLogFieldNames("msg=message")(&cfg.Options{}))
+}
+
+func TestConsoleAndLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	logger, closeLogger := newLogger(t,
+		cfg.WithLogOutputs(path),
+		cfg.WithLogEncoding("console"),
+		cfg.WithLogLevel("warn"),
+		cfg.WithLogCaller(true),
+	)
+	logger.Info("dropped")
+	logger.Warn("kept", zap.Int("n", 1))
+	require.NoError(t, closeLogger())
+
+	lines := readLines(t, path)
+	require.Len(t, lines, 1)
+	assert.Regexp(t, `\tWARN\tlogging/logging_test\.go:\d+\tkept\t\{"n": 1\}$`, lines[0])
+}
+
+func TestSampling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	logger, closeLogger := newLogger(t, cfg.WithLogOutputs(path), cfg.WithLogSampling("2:5"))
+	for range 12 {
+		logger.Info("repeated")
+	}
+	require.NoError(t, closeLogger())
+	// The first 2, then the 5th and 10th of the following ones.
+	assert.Len(t, readLines(t, path), 4)
+}
+
+func TestAccessLogNotSampled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	logger, closeLogger := newLogger(t, cfg.WithLogOutputs(path), cfg.WithLogSampling("2:5"))
+	access := logger.Named(logging.AccessLoggerName).With(zap.String("k", "v"))
+	for range 12 {
+		access.Info("request")
+	}
+	require.NoError(t, closeLogger())
+	assert.Len(t, readLines(t, path), 12)
+}
+
+func TestRedaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	logger, closeLogger := newLogger(t,
+		cfg.WithLogOutputs(path),
+		cfg.WithLogRedactKeys("authorization,password"),
+		cfg.WithLogRedactValues("email"),
+		cfg.WithLogPayloadKeys("msg"),
+		cfg.WithLogPayloadMode("truncate"),
+		cfg.WithLogPayloadMaxLength(4),
+	)
+	logger.With(zap.String("Authorization", "Basic czNjcjN0")).Info("welcome alice@example.com",
+		zap.String("query", "password=hunter2"),
+		zap.String("msg", "confidential"),
+	)
+	require.NoError(t, closeLogger())
+
+	lines := readLines(t, path)
+	require.Len(t, lines, 1)
+	for _, secret := range []string{"czNjcjN0", "alice@example.com", "hunter2", "confidential"} {
+		assert.NotContains(t, lines[0], secret)
+	}
+	assert.Contains(t, lines[0], `"msg":"conf… (12 bytes)"`)
+
+	var options cfg.Options
+	assert.Error(t, cfg.WithLogRedactValues("phone")(&options))
+	assert.Error(t, cfg.WithLogRedactRegexp("(")(&options))
+	assert.Error(t, cfg.WithLogPayloadMode("drop")(&options))
+}
+
+func TestLoki(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	logger, closeLogger := newLogger(t,
+		cfg.WithLogOutputs(path),
+		cfg.WithLogRedactKeys("password"),
+		cfg.WithLokiURL(srv.URL+"/loki/api/v1/push"),
+		cfg.WithLokiLabels("app=myapp,env=test"),
+		cfg.WithLokiFlushInterval(60_000),
+	)
+	logger.Debug("filtered")
+	logger.Info("shipped", zap.String("password", "hunter2"))
+	require.NoError(t, closeLogger())
+
+	// Entries are shipped on close, filtered and redacted like in files.
+	assert.Len(t, readLines(t, path), 1)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, bodies, 1)
+	assert.Contains(t, bodies[0], `"stream":{"app":"myapp","env":"test","level":"info"}`)
+	assert.Contains(t, bodies[0], `shipped`)
+	assert.Contains(t, bodies[0], `[REDACTED]`)
+	assert.NotContains(t, bodies[0], "hunter2")
+	assert.NotContains(t, bodies[0], "filtered")
+
+	var options cfg.Options
+	assert.Error(t, cfg.WithLokiURL("localhost:3100")(&options))
+	assert.Error(t, cfg.WithLokiLabels("level=info")(&options))
+	assert.Error(t, cfg.WithLokiLabels("app")(&options))
+	assert.Error(t, cfg.WithLokiDropPolicy("block")(&options))
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	logger, closeLogger := newLogger(t, cfg.WithLogOutputs(path), cfg.WithLogFileMaxSize(1), cfg.WithLogFileMaxBackups(1))
+	line := strings.Repeat("x", 64<<10)
+	for range 40 {
+		logger.Info(line)
+	}
+	require.NoError(t, closeLogger())
+
+	// Backups are removed in the background.
+	assert.Eventually(t, func() bool {
+		entries, err := os.ReadDir(dir)
+		return err == nil && len(entries) == 2
+	}, time.Second, 10*time.Millisecond, "the current file and a single backup")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(1<<20))
+}
+
+func TestStandardOutputs(t *testing.T) {
+	// Syncing a pipe or a terminal fails, which must not fail closing.
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	stdout := os.Stdout
+	os.Stdout = w
+	t.Cleanup(func() { os.Stdout = stdout })
+
+	logger, closeLogger := newLogger(t, cfg.WithLogOutputs("stdout"))
+	logger.Info("hello")
+	assert.NoError(t, closeLogger())
+	require.NoError(t, w.Close())
+	buf := make([]byte, 512)
+	n, _ := r.Read(buf)
+	assert.Contains(t, string(buf[:n]), `"msg":"hello"`)
+}
@@ -0,0 +1,410 @@
+// Package loki ships log entries to a Loki push API, for deployments without
+// an agent scraping the standard output.
+//
+// Entries are buffered and pushed in batches, when a batch is full or at
+// every flush interval. The buffer is bounded: when Loki is slow or down,
+// entries are dropped according to a Policy rather than blocking the
+// application. Failed pushes are retried with exponential backoff and
+// jitter.
+package loki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/marcosartorato/myapp/internal/retry"
+)
+
+// Reasons for dropping entries, passed to OnDrop.
+const (
+	// DropBufferFull entries did not fit in the buffer.
+	DropBufferFull = "buffer_full"
+	// DropPushFailed entries were in a batch whose push failed for good.
+	DropPushFailed = "push_failed"
+)
+
+// LevelLabel is the label added to streams with the level of their entries.
+const LevelLabel = "level"
+
+// Policy is what happens when an entry is logged while the buffer is full.
+type Policy int
+
+const (
+	// DropOldest drops the oldest buffered entry to make room.
+	DropOldest Policy = iota
+	// DropNewest drops the entry being logged.
+	DropNewest
+)
+
+// String returns the name parsed by ParsePolicy.
+func (p Policy) String() string {
+	if p == DropNewest {
+		return "drop_newest"
+	}
+	return "drop_oldest"
+}
+
+// ParsePolicy parses "drop_oldest" or "drop_newest".
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "drop_oldest":
+		return DropOldest, nil
+	case "drop_newest":
+		return DropNewest, nil
+	}
+	return 0, fmt.Errorf("loki: unknown drop policy %q", s)
+}
+
+// Options configures a Shipper. The callbacks, if set, are called from the
+// logging and shipping goroutines, and must neither block nor log.
+type Options struct {
+	// URL is the push endpoint, e.g. http://localhost:3100/loki/api/v1/push.
+	URL string
+	// TenantID, if set, is sent as X-Scope-OrgID to multi-tenant Loki.
+	TenantID string
+	// Labels identify the streams, along with LevelLabel. Loki requires at
+	// least one label, {"app": "myapp"} when empty.
+	Labels map[string]string
+	// BatchSize is the number of entries pushed at most at once; 1000 when
+	// zero. A full batch is pushed without waiting for FlushInterval.
+	BatchSize int
+	// FlushInterval is the maximum delay before an entry is pushed; 1s when
+	// zero.
+	FlushInterval time.Duration
+	// BufferSize is the number of entries buffered at most; 10 batches
+	// when zero.
+	BufferSize int
+	// Policy applies when the buffer is full.
+	Policy Policy
+	// MaxRetries is the number of retries of a failed push before its
+	// batch is dropped; 5 when zero, none when negative.
+	MaxRetries int
+	// Backoff and MaxBackoff are the initial and maximum retry delays of a
+	// retry.Backoff; 500ms and 30s when zero.
+	Backoff, MaxBackoff time.Duration
+	// Client sends the pushes; a client with a 10s timeout when nil.
+	Client *http.Client
+
+	// OnPush is called after each push attempt of a batch of the given
+	// number of entries, with a nil error on success.
+	OnPush func(entries int, elapsed time.Duration, err error)
+	// OnDrop is called when entries are dropped, with the reason:
+	// DropBufferFull or DropPushFailed.
+	OnDrop func(reason string, entries int)
+	// OnBuffered is called with the number of buffered entries whenever it
+	// changes.
+	OnBuffered func(n int)
+}
+
+// entry is a log line and its labels.
+type entry struct {
+	time  time.Time
+	level zapcore.Level
+	line  string
+}
+
+// Shipper pushes log entries to Loki in a background goroutine.
+type Shipper struct {
+	opts Options
+
+	// ctx aborts the pushes once Close gives up on them.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{} // a batch is full
+	stop   chan struct{} // closed by Close
+	done   chan struct{} // closed once the last batch is pushed
+
+	mu     sync.Mutex
+	buf    []entry // oldest first
+	closed bool
+}
+
+// New returns a running Shipper for opts.
+func New(opts Options) (*Shipper, error) {
+	if opts.URL == "" {
+		return nil, errors.New("loki: a push URL is required")
+	}
+	if len(opts.Labels) == 0 {
+		opts.Labels = map[string]string{"app": "myapp"}
+	}
+	if _, ok := opts.Labels[LevelLabel]; ok {
+		return nil, fmt.Errorf("loki: the %q label is reserved", LevelLabel)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 10 * opts.BatchSize
+	}
+	opts.BufferSize = max(opts.BufferSize, opts.BatchSize)
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 5
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 500 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	opts.MaxBackoff = max(opts.MaxBackoff, opts.Backoff)
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Shipper{
+		opts:   opts,
+		ctx:    ctx,
+		cancel: cancel,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Core returns a core encoding entries enabled by enab with enc, and
+// shipping them.
+func (s *Shipper) Core(enc zapcore.Encoder, enab zapcore.LevelEnabler) zapcore.Core {
+	return &core{LevelEnabler: enab, enc: enc, s: s}
+}
+
+// Close pushes the buffered entries and stops the shipper; entries logged
+// afterwards are discarded. If ctx ends first, pending pushes are aborted and
+// their entries dropped.
+func (s *Shipper) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.stop)
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-s.done
+		return ctx.Err()
+	}
+}
+
+// add buffers e, dropping an entry if the buffer is full.
+func (s *Shipper) add(e entry) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	dropped := false
+	if len(s.buf) >= s.opts.BufferSize {
+		dropped = true
+		if s.opts.Policy == DropOldest {
+			s.buf = s.buf[1:]
+		}
+	}
+	if !dropped || s.opts.Policy == DropOldest {
+		s.buf = append(s.buf, e)
+	}
+	n := len(s.buf)
+	s.mu.Unlock()
+
+	if dropped {
+		s.dropped(DropBufferFull, 1)
+	}
+	if n >= s.opts.BatchSize {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+	s.buffered(n)
+}
+
+// run pushes full batches as soon as they are, and the others at every
+// flush interval, until Close.
+func (s *Shipper) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.wake:
+			for s.flush(true) {
+			}
+		case <-ticker.C:
+			for s.flush(false) {
+			}
+		case <-s.stop:
+			for s.flush(false) {
+			}
+			return
+		}
+	}
+}
+
+// flush pushes the oldest buffered entries, only if they make a full batch
+// when full is set. It reports whether it pushed any.
+func (s *Shipper) flush(full bool) bool {
+	s.mu.Lock()
+	n := min(len(s.buf), s.opts.BatchSize)
+	if n == 0 || (full && n < s.opts.BatchSize) {
+		s.mu.Unlock()
+		return false
+	}
+	batch := make([]entry, n)
+	copy(batch, s.buf)
+	s.buf = s.buf[n:]
+	left := len(s.buf)
+	s.mu.Unlock()
+
+	s.buffered(left)
+	s.push(batch)
+	return true
+}
+
+// push sends batch, retrying failures that may succeed later.
+func (s *Shipper) push(batch []entry) {
+	body, err := s.encode(batch)
+	if err != nil {
+		s.dropped(DropPushFailed, len(batch))
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		status, err := s.send(body)
+		if s.opts.OnPush != nil {
+			s.opts.OnPush(len(batch), time.Since(start), err)
+		}
+		if err == nil {
+			return
+		}
+		if !retry.Retryable(status) || attempt > s.opts.MaxRetries || s.ctx.Err() != nil {
+			s.dropped(DropPushFailed, len(batch))
+			return
+		}
+		timer := time.NewTimer(retry.Backoff{Initial: s.opts.Backoff, Max: s.opts.MaxBackoff}.Delay(attempt))
+		select {
+		case <-timer.C:
+		case <-s.ctx.Done():
+			timer.Stop()
+			s.dropped(DropPushFailed, len(batch))
+			return
+		}
+	}
+}
+
+// pushRequest is the JSON body of a push.
+type pushRequest struct {
+	Streams []stream `json:"streams"`
+}
+
+type stream struct {
+	Stream map[string]string `json:"stream"`
+	// Values are [timestamp in Unix nanoseconds, line] pairs.
+	Values [][2]string `json:"values"`
+}
+
+// encode returns the push body of batch, with one stream per level.
+func (s *Shipper) encode(batch []entry) ([]byte, error) {
+	var req pushRequest
+	streams := map[zapcore.Level]int{}
+	for _, e := range batch {
+		i, ok := streams[e.level]
+		if !ok {
+			labels := maps.Clone(s.opts.Labels)
+			labels[LevelLabel] = e.level.String()
+			i = len(req.Streams)
+			streams[e.level] = i
+			req.Streams = append(req.Streams, stream{Stream: labels})
+		}
+		req.Streams[i].Values = append(req.Streams[i].Values, [2]string{strconv.FormatInt(e.time.UnixNano(), 10), e.line})
+	}
+	return json.Marshal(req)
+}
+
+// send pushes body once, returning the response status if there was one.
+func (s *Shipper) send(body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.opts.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.opts.TenantID)
+	}
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("loki answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.StatusCode, nil
+}
+
+func (s *Shipper) dropped(reason string, n int) {
+	if s.opts.OnDrop != nil {
+		s.opts.OnDrop(reason, n)
+	}
+}
+
+func (s *Shipper) buffered(n int) {
+	if s.opts.OnBuffered != nil {
+		s.opts.OnBuffered(n)
+	}
+}
+
+// core encodes entries as lines for a Shipper.
+type core struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	s   *Shipper
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return &core{LevelEnabler: c.LevelEnabler, enc: enc, s: c.s}
+}
+
+func (c *core) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *core) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(e, fields)
+	if err != nil {
+		return err
+	}
+	line := strings.TrimSuffix(buf.String(), "\n")
+	buf.Free()
+	c.s.add(entry{time: e.Time, level: e.Level, line: line})
+	return nil
+}
+
+// Sync does nothing: entries are pushed in the background, and Close
+// flushes them.
+func (c *core) Sync() error { return nil }
@@ -0,0 +1,304 @@
+package loki_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/marcosartorato/myapp/internal/loki"
+)
+
+type stream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type push struct {
+	Streams  []stream `json:"streams"`
+	TenantID string   `json:"-"`
+}
+
+// standIn is a Loki stand-in recording pushes, answering with the statuses
+// of respond in turn, then 204.
+type standIn struct {
+	*httptest.Server
+	mu      sync.Mutex
+	pushes  []push
+	respond []int
+	// hold, if set, delays answers until closed.
+	hold chan struct{}
+}
+
+func newStandIn(t *testing.T, respond ...int) *standIn {
+	t.Helper()
+	s := &standIn{respond: respond}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/loki/api/v1/push" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if s.hold != nil {
+			<-s.hold
+		}
+		var p push
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		p.TenantID = r.Header.Get("X-Scope-OrgID")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.respond) > 0 {
+			status := s.respond[0]
+			s.respond = s.respond[1:]
+			if status != http.StatusNoContent {
+				http.Error(w, "try again", status)
+				return
+			}
+		}
+		s.pushes = append(s.pushes, p)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *standIn) Pushes() []push {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]push(nil), s.pushes...)
+}
+
+// Lines returns the pushed lines, by level.
+func (s *standIn) Lines() map[string][]string {
+	lines := map[string][]string{}
+	for _, p := range s.Pushes() {
+		for _, st := range p.Streams {
+			for _, v := range st.Values {
+				lines[st.Stream[loki.LevelLabel]] = append(lines[st.Stream[loki.LevelLabel]], v[1])
+			}
+		}
+	}
+	return lines
+}
+
+// messages returns the messages of JSON lines.
+func messages(t *testing.T, lines []string) []string {
+	t.Helper()
+	var msgs []string
+	for _, line := range lines {
+		var e map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &e))
+		msgs = append(msgs, e["msg"].(string))
+	}
+	return msgs
+}
+
+func newShipper(t *testing.T, opts loki.Options) (*loki.Shipper, *zap.Logger) {
+	t.Helper()
+	s, err := loki.New(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close(context.Background()) })
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	return s, zap.New(s.Core(enc, zapcore.DebugLevel))
+}
+
+func TestPush(t *testing.T) {
+	srv := newStandIn(t)
+	var sent atomic.Int64
+	_, logger := newShipper(t, loki.Options{
+		URL:           srv.URL + "/loki/api/v1/push",
+		TenantID:      "team-a",
+		Labels:        map[string]string{"app": "myapp", "env": "test"},
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+		OnPush: func(entries int, _ time.Duration, err error) {
+			if err == nil {
+				sent.Add(int64(entries))
+			}
+		},
+	})
+
+	before := time.Now()
+	logger.With(zap.String("component", "test")).Info("one")
+	logger.Warn("two")
+	logger.Info("three")
+	logger.Info("four")
+
+	// A full batch is pushed without waiting for the flush interval.
+	require.Eventually(t, func() bool { return sent.Load() == 3 }, 2*time.Second, 10*time.Millisecond)
+	pushes := srv.Pushes()
+	require.Len(t, pushes, 1)
+	assert.Equal(t, "team-a", pushes[0].TenantID)
+	require.Len(t, pushes[0].Streams, 2)
+	info, warn := pushes[0].Streams[0], pushes[0].Streams[1]
+	assert.Equal(t, map[string]string{"app": "myapp", "env": "test", "level": "info"}, info.Stream)
+	assert.Equal(t, map[string]string{"app": "myapp", "env": "test", "level": "warn"}, warn.Stream)
+	require.Len(t, info.Values, 2)
+	ts, err := strconv.ParseInt(info.Values[0][0], 10, 64)
+	require.NoError(t, err)
+	assert.WithinDuration(t, before, time.Unix(0, ts), time.Second)
+	assert.JSONEq(t, `{"level":"info","msg":"one","component":"test"}`, dropTime(t, info.Values[0][1]))
+	assert.Equal(t, []string{"one", "three"}, messages(t, srv.Lines()["info"]))
+	assert.Equal(t, []string{"two"}, messages(t, srv.Lines()["warn"]))
+}
+
+// dropTime removes the time of a JSON line.
+func dropTime(t *testing.T, line string) string {
+	t.Helper()
+	var e map[string]any
+	require.NoError(t, json.Unmarshal([]byte(line), &e))
+	delete(e, "ts")
+	b, err := json.Marshal(e)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestFlushInterval(t *testing.T) {
+	srv := newStandIn(t)
+	_, logger := newShipper(t, loki.Options{URL: srv.URL + "/loki/api/v1/push", FlushInterval: 20 * time.Millisecond})
+	logger.Info("alone")
+	require.Eventually(t, func() bool { return len(srv.Pushes()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]string{"app": "myapp", "level": "info"}, srv.Pushes()[0].Streams[0].Stream)
+}
+
+func TestRetries(t *testing.T) {
+	srv := newStandIn(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	var attempts, failures atomic.Int64
+	_, logger := newShipper(t, loki.Options{
+		URL:           srv.URL + "/loki/api/v1/push",
+		FlushInterval: 10 * time.Millisecond,
+		Backoff:       time.Millisecond,
+		OnPush: func(_ int, _ time.Duration, err error) {
+			attempts.Add(1)
+			if err != nil {
+				failures.Add(1)
+			}
+		},
+	})
+	logger.Info("eventually")
+	require.Eventually(t, func() bool { return len(srv.Pushes()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.EqualValues(t, 3, attempts.Load())
+	assert.EqualValues(t, 2, failures.Load())
+	assert.Equal(t, []string{"eventually"}, messages(t, srv.Lines()["info"]))
+}
+
+func TestPushFailed(t *testing.T) {
+	for name, tc := range map[string]struct {
+		respond    []int
+		maxRetries int
+		attempts   int64
+	}{
+		"not retryable":     {respond: []int{http.StatusBadRequest}, attempts: 1},
+		"retries exhausted": {respond: []int{500, 500, 500}, maxRetries: 2, attempts: 3},
+		"no retries":        {respond: []int{500}, maxRetries: -1, attempts: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := newStandIn(t, tc.respond...)
+			var attempts, dropped atomic.Int64
+			_, logger := newShipper(t, loki.Options{
+				URL:           srv.URL + "/loki/api/v1/push",
+				FlushInterval: 10 * time.Millisecond,
+				MaxRetries:    tc.maxRetries,
+				Backoff:       time.Millisecond,
+				OnPush:        func(int, time.Duration, error) { attempts.Add(1) },
+				OnDrop: func(reason string, entries int) {
+					assert.Equal(t, loki.DropPushFailed, reason)
+					dropped.Add(int64(entries))
+				},
+			})
+			logger.Info("lost")
+			require.Eventually(t, func() bool { return dropped.Load() == 1 }, 2*time.Second, 10*time.Millisecond)
+			assert.Equal(t, tc.attempts, attempts.Load())
+			assert.Empty(t, srv.Pushes())
+		})
+	}
+}
+
+func TestDropPolicy(t *testing.T) {
+	for policy, want := range map[loki.Policy][]string{
+		loki.DropOldest: {"first", "4", "5"},
+		loki.DropNewest: {"first", "2", "3"},
+	} {
+		t.Run(policy.String(), func(t *testing.T) {
+			srv := newStandIn(t)
+			srv.hold = make(chan struct{})
+			var dropped, buffered atomic.Int64
+			s, logger := newShipper(t, loki.Options{
+				URL:        srv.URL + "/loki/api/v1/push",
+				BatchSize:  1,
+				BufferSize: 2,
+				Policy:     policy,
+				OnDrop: func(reason string, entries int) {
+					assert.Equal(t, loki.DropBufferFull, reason)
+					dropped.Add(int64(entries))
+				},
+				OnBuffered: func(n int) { buffered.Store(int64(n)) },
+			})
+			// The first entry is being pushed while the others fill the buffer.
+			logger.Info("first")
+			require.Eventually(t, func() bool { return buffered.Load() == 0 }, 2*time.Second, 10*time.Millisecond)
+			for i := 2; i <= 5; i++ {
+				logger.Info(strconv.Itoa(i))
+			}
+			assert.EqualValues(t, 2, dropped.Load())
+			assert.EqualValues(t, 2, buffered.Load())
+
+			close(srv.hold)
+			require.NoError(t, s.Close(context.Background()))
+			assert.Equal(t, want, messages(t, srv.Lines()["info"]))
+		})
+	}
+}
+
+func TestClose(t *testing.T) {
+	srv := newStandIn(t)
+	s, logger := newShipper(t, loki.Options{URL: srv.URL + "/loki/api/v1/push", FlushInterval: time.Hour})
+	logger.Info("buffered")
+	require.NoError(t, s.Close(context.Background()))
+	assert.Equal(t, []string{"buffered"}, messages(t, srv.Lines()["info"]))
+	logger.Info("discarded")
+	require.NoError(t, s.Close(context.Background()))
+	assert.Len(t, srv.Pushes(), 1)
+
+	// Close gives up on a Loki that is down.
+	down := newStandIn(t, 503, 503, 503, 503, 503, 503)
+	var dropped atomic.Int64
+	s, logger = newShipper(t, loki.Options{
+		URL:           down.URL + "/loki/api/v1/push",
+		FlushInterval: time.Hour,
+		Backoff:       time.Hour,
+		OnDrop:        func(_ string, entries int) { dropped.Add(int64(entries)) },
+	})
+	logger.Info("lost")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Close(ctx), context.DeadlineExceeded)
+	assert.EqualValues(t, 1, dropped.Load())
+}
+
+func TestNew(t *testing.T) {
+	_, err := loki.New(loki.Options{})
+	assert.Error(t, err)
+	_, err = loki.New(loki.Options{URL: "http://localhost:3100/loki/api/v1/push", Labels: map[string]string{"level": "x"}})
+	assert.ErrorContains(t, err, "reserved")
+
+	for _, p := range []loki.Policy{loki.DropOldest, loki.DropNewest} {
+		parsed, err := loki.ParsePolicy(p.String())
+		require.NoError(t, err)
+		assert.Equal(t, p, parsed)
+	}
+	_, err = loki.ParsePolicy("block")
+	assert.Error(t, err)
+}
@@ -16,7 +16,7 @@ var (
 			Name:      "requests_total",
 			Help:      "Total number of HTTP requests",
 		},
-		[]string{"method", "route", "status"},
+		[]string{"method", "route", "status", "outcome"},
 	)
 
 	RequestDuration = prometheus.NewHistogramVec(
@@ -68,12 +68,261 @@ var (
 		},
 		[]string{"route"},
 	)
+
+	ValidationFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "validation_failures_total",
+			Help: "Number of requests (or responses, when enabled) rejected by OpenAPI validation.",
+		},
+		[]string{"route", "reason"},
+	)
+
+	RateLimitRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "http",
+			Name:      "rate_limit_requests_total",
+			Help:      "Number of requests checked by the rate limiter, by result (allowed or limited).",
+		},
+		[]string{"route", "result"},
+	)
+
+	RateLimitKeys = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: "http",
+			Name:      "rate_limit_keys",
+			Help:      "Number of clients currently tracked by the rate limiter.",
+		},
+		[]string{"route"},
+	)
+
+	ConcurrencyLimit = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Subsystem: "http",
+			Name:      "concurrency_limit",
+			Help:      "Current adaptive concurrency limit.",
+		},
+		[]string{"route"},
+	)
+
+	ShedRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "http",
+			Name:      "shed_requests_total",
+			Help:      "Number of requests rejected because the concurrency limit was reached.",
+		},
+		[]string{"route"},
+	)
+
+	AuthFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "http",
+			Name:      "auth_failures_total",
+			Help:      "Number of requests rejected by authentication or authorization, by reason.",
+		},
+		[]string{"route", "reason"},
+	)
+
+	CORSPreflightRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "http",
+			Name:      "cors_preflight_requests_total",
+			Help:      "Number of CORS preflight requests, by result (allowed, rejected_origin, rejected_method or rejected_headers).",
+		},
+		[]string{"route", "result"},
+	)
+
+	CSPViolationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "http",
+			Name:      "csp_violations_total",
+			Help:      "Number of Content-Security-Policy violations reported by browsers, by directive and disposition (enforce or report).",
+		},
+		[]string{"directive", "disposition"},
+	)
+
+	CompressedResponseSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "http",
+			Name:      "response_compressed_size_bytes",
+			Help:      "Size of compressed HTTP responses on the wire in bytes; http_response_size_bytes keeps the uncompressed size.",
+			Buckets:   prometheus.ExponentialBuckets(200, 2, 12),
+		},
+		[]string{"route", "encoding"},
+	)
+
+	IdempotencyRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "http",
+			Name:      "idempotency_requests_total",
+			Help:      "Number of requests carrying an Idempotency-Key, by result (stored, replayed, in_flight, mismatch or invalid).",
+		},
+		[]string{"route", "result"},
+	)
+
+	ScheduledPending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "scheduler",
+			Name:      "pending_messages",
+			Help:      "Number of scheduled messages waiting for their due time.",
+		},
+	)
+
+	ScheduledFiredTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: "scheduler",
+			Name:      "fired_total",
+			Help:      "Number of scheduled messages delivered.",
+		},
+	)
+
+	ScheduledLateTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: "scheduler",
+			Name:      "late_total",
+			Help:      "Number of scheduled messages delivered well past their due time, e.g. because the server was down.",
+		},
+	)
+
+	ScheduledLateness = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Subsystem: "scheduler",
+			Name:      "lateness_seconds",
+			Help:      "Delay between the due time of scheduled messages and their delivery in seconds.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 12), // 1ms .. ~70min
+		},
+	)
+
+	ScheduledDeliveryFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "scheduler",
+			Name:      "delivery_failures_total",
+			Help:      "Number of failed deliveries of scheduled messages, by sink.",
+		},
+		[]string{"sink"},
+	)
+
+	WebhookAttemptDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "webhook",
+			Name:      "attempt_duration_seconds",
+			Help:      "Duration of webhook delivery attempts in seconds, by result (success or failure).",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12), // 5ms .. ~10s, the client timeout
+		},
+		[]string{"result"},
+	)
+
+	WebhookDeliveriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "webhook",
+			Name:      "deliveries_total",
+			Help:      "Number of finished webhook deliveries, by outcome (delivered or dead_letter).",
+		},
+		[]string{"outcome"},
+	)
+
+	WebhookDeadLetters = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "webhook",
+			Name:      "dead_letters",
+			Help:      "Number of failed webhook deliveries waiting for a replay.",
+		},
+	)
+
+	PubSubTopics = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "pubsub",
+			Name:      "topics",
+			Help:      "Number of topics with subscribers.",
+		},
+	)
+
+	PubSubSubscribers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "pubsub",
+			Name:      "subscribers",
+			Help:      "Number of subscribers, attached to an events stream or not yet.",
+		},
+	)
+
+	PubSubPublishedMessagesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: "pubsub",
+			Name:      "published_messages_total",
+			Help:      "Number of messages published.",
+		},
+	)
+
+	PubSubDroppedMessagesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "pubsub",
+			Name:      "dropped_messages_total",
+			Help:      "Number of messages not delivered to a subscriber whose buffer was full, by the subscriber's policy (drop_oldest or disconnect).",
+		},
+		[]string{"policy"},
+	)
+
+	LokiPushDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: "loki",
+			Name:      "push_duration_seconds",
+			Help:      "Duration of pushes of log entries to Loki in seconds, by result (success or failure).",
+			Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12), // 5ms .. ~10s, the client timeout
+		},
+		[]string{"result"},
+	)
+
+	LokiSentEntriesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Subsystem: "loki",
+			Name:      "sent_entries_total",
+			Help:      "Number of log entries pushed to Loki.",
+		},
+	)
+
+	LokiDroppedEntriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "loki",
+			Name:      "dropped_entries_total",
+			Help:      "Number of log entries not pushed to Loki, by reason (buffer_full or push_failed).",
+		},
+		[]string{"reason"},
+	)
+
+	LokiBufferedEntries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "loki",
+			Name:      "buffered_entries",
+			Help:      "Number of log entries waiting to be pushed to Loki.",
+		},
+	)
+
+	LokiLastSuccessTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Subsystem: "loki",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful push to Loki.",
+		},
+	)
 )
 
 // init registers all metrics
 func init() {
 	// App metrics, i.e. custom metrics defined above
-	reg.MustRegister(RequestsTotal, RequestDuration, InflightRequests, RequestSize, ResponseSize, PanicsTotal)
+	reg.MustRegister(
+		RequestsTotal, RequestDuration, InflightRequests, RequestSize, ResponseSize, PanicsTotal,
+		ValidationFailuresTotal,
+		RateLimitRequestsTotal, RateLimitKeys,
+		ConcurrencyLimit, ShedRequestsTotal,
+		AuthFailuresTotal,
+		CORSPreflightRequestsTotal,
+		CSPViolationsTotal,
+		CompressedResponseSize,
+		IdempotencyRequestsTotal,
+		ScheduledPending, ScheduledFiredTotal, ScheduledLateTotal, ScheduledLateness, ScheduledDeliveryFailuresTotal,
+		WebhookAttemptDuration, WebhookDeliveriesTotal, WebhookDeadLetters,
+		PubSubTopics, PubSubSubscribers, PubSubPublishedMessagesTotal, PubSubDroppedMessagesTotal,
+		LokiPushDuration, LokiSentEntriesTotal, LokiDroppedEntriesTotal, LokiBufferedEntries, LokiLastSuccessTimestamp,
+	)
 	// Go/process runtime metrics (SRE staple)
 	reg.MustRegister(
 		collectors.NewGoCollector(),
@@ -1,9 +1,15 @@
 package metricsserver
 
 import (
+	"context"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/marcosartorato/myapp/internal/compress"
+	cfg "github.com/marcosartorato/myapp/internal/config"
 )
 
 type statusCapturingResponseWriter struct {
@@ -23,10 +29,84 @@ func (w *statusCapturingResponseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusCapturingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// routeLabel returns the route label of r: the path of the pattern matched by
+// the mux (without its method), which unlike the request path keeps label
+// cardinality bounded. Requests not routed by a mux are "unmatched".
+func routeLabel(r *http.Request) string {
+	if r.Pattern == "" {
+		return "unmatched"
+	}
+	if _, path, ok := strings.Cut(r.Pattern, " "); ok {
+		return path
+	}
+	return r.Pattern
+}
+
+// Request outcomes, the outcome label of RequestsTotal.
+const (
+	// OutcomeCompleted is a request whose handler returned normally.
+	OutcomeCompleted = "completed"
+	// OutcomeTimeout is a request whose handler did not complete in time.
+	OutcomeTimeout = "timeout"
+	// OutcomePanic is a request whose handler panicked.
+	OutcomePanic = "panic"
+	// OutcomeClientDisconnect is a request whose client went away before
+	// the handler completed.
+	OutcomeClientDisconnect = "client_disconnect"
+)
+
+type outcomeKey struct{}
+
+// outcome is the outcome of an instrumented request, set at most once.
+type outcome struct {
+	mu    sync.Mutex
+	value string
+}
+
+func (o *outcome) set(value string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.value == "" {
+		o.value = value
+	}
+}
+
+func (o *outcome) get() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.value
+}
+
+// setOutcome records the outcome of the request instrumented with ctx. The
+// first outcome set wins; requests without one are completed, or
+// disconnected if their context is canceled.
+func setOutcome(ctx context.Context, value string) {
+	if o, ok := ctx.Value(outcomeKey{}).(*outcome); ok {
+		o.set(value)
+	}
+}
+
+// RecordTimeout records that the handler of the instrumented request r timed out.
+func RecordTimeout(r *http.Request) {
+	setOutcome(r.Context(), OutcomeTimeout)
+}
+
+// RecordPanic records that the handler of the instrumented request r
+// panicked, for panics recovered before reaching Instrument.
+func RecordPanic(r *http.Request) {
+	setOutcome(r.Context(), OutcomePanic)
+	PanicsTotal.WithLabelValues(routeLabel(r)).Inc()
+}
+
 // Instrument wraps an http.Handler and records RED + extras.
 func Instrument(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		route := r.URL.Path
+		route := routeLabel(r)
 		method := r.Method
 
 		// Inflight
@@ -38,24 +118,48 @@ func Instrument(next http.Handler) http.Handler {
 			RequestSize.WithLabelValues(method, route).Observe(float64(r.ContentLength))
 		}
 
-		// Recoverer to count panics and avoid crashing the server
-		defer func(start time.Time) {
+		o := &outcome{}
+		r = r.WithContext(context.WithValue(r.Context(), outcomeKey{}, o))
+		start := time.Now()
+		srw := &statusCapturingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			// Last resort for panics not recovered closer to the handler:
+			// count them and avoid crashing the server.
 			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				o.set(OutcomePanic)
 				PanicsTotal.WithLabelValues(route).Inc()
 				// Return 500 if nothing was written
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				http.Error(srw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			}
-		}(time.Now())
-
-		start := time.Now()
-		srw := &statusCapturingResponseWriter{ResponseWriter: w, status: http.StatusOK}
-		next.ServeHTTP(srw, r)
+			if r.Context().Err() != nil {
+				o.set(OutcomeClientDisconnect)
+			}
+			o.set(OutcomeCompleted)
 
-		status := strconv.Itoa(srw.status)
-		elapsed := time.Since(start).Seconds()
+			status := strconv.Itoa(srw.status)
+			elapsed := time.Since(start).Seconds()
 
-		RequestsTotal.WithLabelValues(method, route, status).Inc()
-		RequestDuration.WithLabelValues(method, route, status).Observe(elapsed)
-		ResponseSize.WithLabelValues(method, route, status).Observe(float64(srw.bytes))
+			RequestsTotal.WithLabelValues(method, route, status, o.get()).Inc()
+			RequestDuration.WithLabelValues(method, route, status).Observe(elapsed)
+			ResponseSize.WithLabelValues(method, route, status).Observe(float64(srw.bytes))
+		}()
+		next.ServeHTTP(srw, r)
 	})
 }
+
+// Compress wraps next with response compression as configured in opt.
+// The compressed size is recorded per route and encoding; handlers wrapped
+// by Instrument keep reporting the uncompressed size.
+func Compress(opt cfg.Options, next http.Handler) http.Handler {
+	return compress.Handler(compress.Options{
+		Encodings:    opt.CompressionEncodings,
+		MinSize:      opt.CompressionMinSize,
+		ContentTypes: opt.CompressionTypes,
+		Observe: func(r *http.Request, encoding string, _, compressed int) {
+			CompressedResponseSize.WithLabelValues(routeLabel(r), encoding).Observe(float64(compressed))
+		},
+	}, next)
+}
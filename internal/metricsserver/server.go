@@ -2,6 +2,7 @@ package metricsserver
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/http"
 
@@ -14,6 +15,8 @@ import (
 func Handler() http.Handler {
 	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{
 		EnableOpenMetrics: true,
+		// Compression is negotiated by the server-wide middleware.
+		DisableCompression: true,
 	})
 }
 
@@ -22,7 +25,7 @@ func CreateServer(opt cfg.Options) *http.Server {
 	mux := http.NewServeMux()
 
 	mux.Handle(
-		"/metrics",
+		"GET /metrics",
 		http.TimeoutHandler(
 			Handler(),
 			opt.TimeoutHandler,
@@ -33,7 +36,7 @@ func CreateServer(opt cfg.Options) *http.Server {
 	addr := net.JoinHostPort(*opt.Host, *opt.Port)
 	server := &http.Server{
 		Addr:              addr,
-		Handler:           mux,
+		Handler:           Compress(opt, mux),
 		ReadTimeout:       opt.ReadTimeout,
 		ReadHeaderTimeout: opt.ReadHeaderTimeout,
 		IdleTimeout:       opt.IdleTimeout,
@@ -56,7 +59,7 @@ func RunServerWithShutdown(logger *zap.Logger, opts ...cfg.Option) func(context.
 	go func() {
 		addr := srv.Addr
 		logger.Info("Metrics server listening on  " + addr)
-		if err := http.ListenAndServe(addr, srv.Handler); err != nil {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error("metrics server failed: %v", zap.Error(err))
 		}
 	}()
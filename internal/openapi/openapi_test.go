@@ -0,0 +1,196 @@
+package openapi_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marcosartorato/myapp/internal/openapi"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type inner struct {
+	Count int `json:"count"`
+}
+
+type sample struct {
+	Name     string            `json:"name"`
+	Note     string            `json:"note,omitempty"`
+	At       time.Time         `json:"at"`
+	Tags     []string          `json:"tags,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Inner    inner             `json:"inner"`
+	Ignored  string            `json:"-"`
+	internal string
+}
+
+func TestSchemaRef(t *testing.T) {
+	doc := openapi.New("test", "0.0.0")
+	ref := doc.SchemaRef(sample{})
+	assert.Equal(t, "#/components/schemas/sample", ref.Ref)
+
+	s := doc.Component("sample")
+	require.NotNil(t, s)
+	assert.Equal(t, "object", s.Type)
+	assert.Equal(t, false, s.AdditionalProperties)
+	assert.ElementsMatch(t, []string{"name", "at", "inner"}, s.Required)
+	assert.Equal(t, "date-time", s.Properties["at"].Format)
+	assert.Equal(t, "array", s.Properties["tags"].Type)
+	assert.Equal(t, "string", s.Properties["tags"].Items.Type)
+	assert.Equal(t, "#/components/schemas/inner", s.Properties["inner"].Ref)
+	assert.NotContains(t, s.Properties, "Ignored")
+	assert.NotContains(t, s.Properties, "internal")
+	assert.NotNil(t, doc.Component("inner"))
+}
+
+func TestValidate(t *testing.T) {
+	doc := openapi.New("test", "0.0.0")
+	ref := doc.SchemaRef(sample{})
+	doc.Component("sample").Properties["name"].Enum = []any{"a", "b"}
+
+	tests := map[string]struct {
+		body        string
+		wantKeyword string
+		wantPath    string
+	}{
+		"valid": {
+			body: `{"name":"a","at":"2025-01-01T00:00:00Z","inner":{"count":1}}`,
+		},
+		"invalid JSON": {
+			body:        `{`,
+			wantKeyword: "json",
+		},
+		"missing required": {
+			body:        `{"name":"a","inner":{"count":1}}`,
+			wantKeyword: "required",
+		},
+		"wrong type": {
+			body:        `{"name":"a","at":"x","inner":{"count":"1"}}`,
+			wantKeyword: "type",
+			wantPath:    "/inner/count",
+		},
+		"not an integer": {
+			body:        `{"name":"a","at":"x","inner":{"count":1.5}}`,
+			wantKeyword: "type",
+			wantPath:    "/inner/count",
+		},
+		"enum": {
+			body:        `{"name":"c","at":"x","inner":{"count":1}}`,
+			wantKeyword: "enum",
+			wantPath:    "/name",
+		},
+		"unknown property": {
+			body:        `{"name":"a","at":"x","inner":{"count":1},"extra":true}`,
+			wantKeyword: "additionalProperties",
+			wantPath:    "/extra",
+		},
+		"array items": {
+			body:        `{"name":"a","at":"x","inner":{"count":1},"tags":["ok",1]}`,
+			wantKeyword: "type",
+			wantPath:    "/tags/1",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := doc.ValidateJSON(ref, []byte(tc.body))
+			if tc.wantKeyword == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *openapi.ValidationError
+			require.True(t, errors.As(err, &verr), "want ValidationError, got %v", err)
+			assert.Equal(t, tc.wantKeyword, verr.Keyword)
+			assert.Equal(t, tc.wantPath, verr.Path)
+		})
+	}
+}
+
+func TestValidateOneOf(t *testing.T) {
+	doc := openapi.New("test", "0.0.0")
+	s := &openapi.Schema{OneOf: []*openapi.Schema{
+		{Type: "string"},
+		{Type: "integer"},
+		{Type: "number"},
+	}}
+
+	assert.NoError(t, doc.Validate(s, "x"))
+	// 1 is both an integer and a number.
+	assert.Error(t, doc.Validate(s, float64(1)))
+	assert.NoError(t, doc.Validate(s, 1.5))
+	assert.Error(t, doc.Validate(s, true))
+}
+
+func TestValidatePattern(t *testing.T) {
+	doc := openapi.New("test", "0.0.0")
+	s := &openapi.Schema{Type: "string", Pattern: "^[a-z]+(-[a-z]+)*$"}
+
+	assert.NoError(t, doc.Validate(s, "kebab-case"))
+	var verr *openapi.ValidationError
+	require.ErrorAs(t, doc.Validate(s, "Not Kebab"), &verr)
+	assert.Equal(t, "pattern", verr.Keyword)
+
+	// Patterns are compiled once, not on every validation.
+	allocs := testing.AllocsPerRun(100, func() { _ = doc.Validate(s, "kebab-case") })
+	assert.LessOrEqual(t, allocs, float64(2))
+
+	invalid := &openapi.Schema{Type: "string", Pattern: "("}
+	for range 2 {
+		require.ErrorAs(t, doc.Validate(invalid, "x"), &verr)
+		assert.Equal(t, "pattern", verr.Keyword)
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	doc := openapi.New("test", "0.0.0")
+	op := &openapi.Operation{Parameters: []*openapi.Parameter{
+		{Name: "limit", In: "query", Schema: &openapi.Schema{Type: "integer"}},
+		{Name: "X-Tenant", In: "header", Required: true, Schema: &openapi.Schema{Type: "string"}},
+	}}
+
+	tests := map[string]struct {
+		target      string
+		tenant      string
+		wantKeyword string
+	}{
+		"valid":           {target: "/?limit=10", tenant: "a"},
+		"optional absent": {target: "/", tenant: "a"},
+		"missing header":  {target: "/", wantKeyword: "required"},
+		"not an integer":  {target: "/?limit=ten", tenant: "a", wantKeyword: "type"},
+		"not whole":       {target: "/?limit=1.5", tenant: "a", wantKeyword: "type"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			if tc.tenant != "" {
+				r.Header.Set("X-Tenant", tc.tenant)
+			}
+			err := doc.ValidateParameters(op, r)
+			if tc.wantKeyword == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var verr *openapi.ValidationError
+			require.True(t, errors.As(err, &verr), "want ValidationError, got %v", err)
+			assert.Equal(t, tc.wantKeyword, verr.Keyword)
+		})
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	doc := openapi.New("test", "0.0.0")
+	op := &openapi.Operation{Responses: map[string]*openapi.Response{
+		"200": {Content: map[string]openapi.MediaType{
+			"application/json": {Schema: doc.SchemaRef(inner{})},
+		}},
+	}}
+
+	assert.NoError(t, doc.ValidateResponse(op, http.StatusOK, "application/json; charset=utf-8", []byte(`{"count":1}`)))
+	assert.Error(t, doc.ValidateResponse(op, http.StatusOK, "application/json", []byte(`{"count":1,"x":2}`)))
+	assert.Error(t, doc.ValidateResponse(op, http.StatusOK, "text/html", []byte(`{"count":1}`)))
+	assert.Error(t, doc.ValidateResponse(op, http.StatusTeapot, "application/json", []byte(`{"count":1}`)))
+}
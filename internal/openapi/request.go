@@ -0,0 +1,152 @@
+package openapi
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ValidateParameters checks the query, header and path parameters of r
+// against the parameters declared by op. Undeclared parameters are ignored.
+func (d *Document) ValidateParameters(op *Operation, r *http.Request) error {
+	for _, p := range op.Parameters {
+		path := p.In + "." + p.Name
+		raw, present := parameterValue(p, r)
+		if !present {
+			if p.Required {
+				return &ValidationError{Path: path, Keyword: "required", Message: "missing required parameter"}
+			}
+			continue
+		}
+		v, err := coerce(d.Resolve(p.Schema), raw)
+		if err != nil {
+			return &ValidationError{Path: path, Keyword: "type", Message: err.Error()}
+		}
+		if err := d.validate(p.Schema, v, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateBody checks a request body against the request body declared by op.
+// Only JSON media types are validated against their schema.
+func (d *Document) ValidateBody(op *Operation, contentType string, body []byte) error {
+	rb := op.RequestBody
+	if rb == nil {
+		return nil
+	}
+	if len(body) == 0 {
+		if rb.Required {
+			return &ValidationError{Keyword: "required", Message: "missing request body"}
+		}
+		return nil
+	}
+	media, err := mediaFor(rb.Content, contentType)
+	if err != nil {
+		return err
+	}
+	if !isJSON(media.name) {
+		return nil
+	}
+	return d.ValidateJSON(media.Schema, body)
+}
+
+// ValidateResponse checks a response against the responses declared by op.
+func (d *Document) ValidateResponse(op *Operation, status int, contentType string, body []byte) error {
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		resp, ok = op.Responses["default"]
+	}
+	if !ok {
+		return &ValidationError{Keyword: "status", Message: "undocumented status " + strconv.Itoa(status)}
+	}
+	if len(resp.Content) == 0 {
+		return nil
+	}
+	media, err := mediaFor(resp.Content, contentType)
+	if err != nil {
+		return err
+	}
+	if !isJSON(media.name) {
+		return nil
+	}
+	return d.ValidateJSON(media.Schema, body)
+}
+
+type namedMedia struct {
+	MediaType
+	name string
+}
+
+// mediaFor picks the declared media type matching contentType. An empty
+// contentType matches when exactly one media type is declared.
+func mediaFor(content map[string]MediaType, contentType string) (namedMedia, error) {
+	if contentType == "" && len(content) == 1 {
+		for name, m := range content {
+			return namedMedia{MediaType: m, name: name}, nil
+		}
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		if m, ok := content[mt]; ok {
+			return namedMedia{MediaType: m, name: mt}, nil
+		}
+	}
+	return namedMedia{}, &ValidationError{Keyword: "contentType", Message: "unsupported content type " + strconv.Quote(contentType)}
+}
+
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func parameterValue(p *Parameter, r *http.Request) (string, bool) {
+	switch p.In {
+	case "query":
+		q := r.URL.Query()
+		if !q.Has(p.Name) {
+			return "", false
+		}
+		return q.Get(p.Name), true
+	case "header":
+		v := r.Header.Values(p.Name)
+		if len(v) == 0 {
+			return "", false
+		}
+		return v[0], true
+	case "path":
+		v := r.PathValue(p.Name)
+		return v, v != ""
+	case "cookie":
+		c, err := r.Cookie(p.Name)
+		if err != nil {
+			return "", false
+		}
+		return c.Value, true
+	}
+	return "", false
+}
+
+// coerce converts a raw parameter string to the JSON type declared by s.
+func coerce(s *Schema, raw string) (any, error) {
+	if s == nil {
+		return raw, nil
+	}
+	switch s.Type {
+	case "integer", "number":
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, errors.New("expected " + s.Type)
+		}
+		return f, nil
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, errors.New("expected boolean")
+		}
+		return b, nil
+	}
+	return raw, nil
+}
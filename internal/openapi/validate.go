@@ -0,0 +1,225 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// ValidationError reports why a value does not match a schema.
+type ValidationError struct {
+	// Path is a JSON pointer to the offending value ("" for the root).
+	Path string
+	// Keyword is the schema keyword that failed, e.g. "type" or "required".
+	Keyword string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Validate checks a decoded JSON value (as produced by json.Unmarshal into an
+// any) against s, resolving references through d.
+func (d *Document) Validate(s *Schema, v any) error {
+	return d.validate(s, v, "")
+}
+
+// patterns caches the compiled pattern keywords, which come from documents
+// rather than from the validated values, so that each is compiled once.
+var patterns sync.Map // string -> compiledPattern
+
+type compiledPattern struct {
+	re  *regexp.Regexp
+	err error
+}
+
+// compilePattern returns the compiled pattern, from the cache if possible.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if c, ok := patterns.Load(pattern); ok {
+		return c.(compiledPattern).re, c.(compiledPattern).err
+	}
+	re, err := regexp.Compile(pattern)
+	patterns.Store(pattern, compiledPattern{re: re, err: err})
+	return re, err
+}
+
+// ValidateJSON decodes data and validates it against s.
+func (d *Document) ValidateJSON(s *Schema, data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return &ValidationError{Keyword: "json", Message: "invalid JSON: " + err.Error()}
+	}
+	return d.Validate(s, v)
+}
+
+func (d *Document) validate(s *Schema, v any, path string) error {
+	s = d.Resolve(s)
+	if s == nil {
+		return nil
+	}
+	fail := func(keyword, format string, args ...any) error {
+		return &ValidationError{Path: path, Keyword: keyword, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if s.Type != "" && !hasType(v, s.Type) {
+		return fail("type", "expected %s, got %s", s.Type, typeOf(v))
+	}
+	if s.Const != nil && !reflect.DeepEqual(normalize(s.Const), v) {
+		return fail("const", "must be %v", s.Const)
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		return fail("enum", "must be one of %v", s.Enum)
+	}
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, sub := range s.OneOf {
+			if d.validate(sub, v, path) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fail("oneOf", "must match exactly one schema, matched %d", matched)
+		}
+	}
+
+	switch val := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.MinLength != nil && n < *s.MinLength {
+			return fail("minLength", "must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fail("maxLength", "must be at most %d characters", *s.MaxLength)
+		}
+		if s.Pattern != "" {
+			re, err := compilePattern(s.Pattern)
+			if err != nil {
+				return fail("pattern", "invalid pattern %q: %v", s.Pattern, err)
+			}
+			if !re.MatchString(val) {
+				return fail("pattern", "must match %q", s.Pattern)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			return fail("minimum", "must be >= %v", *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			return fail("maximum", "must be <= %v", *s.Maximum)
+		}
+	case []any:
+		for i, item := range val {
+			if err := d.validate(s.Items, item, path+"/"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				return fail("required", "missing required property %q", name)
+			}
+		}
+		// Iterate in a stable order so the reported error is deterministic.
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := path + "/" + k
+			if prop, ok := s.Properties[k]; ok {
+				if err := d.validate(prop, val[k], child); err != nil {
+					return err
+				}
+				continue
+			}
+			switch ap := s.AdditionalProperties.(type) {
+			case bool:
+				if !ap {
+					return &ValidationError{Path: child, Keyword: "additionalProperties", Message: "unknown property"}
+				}
+			case *Schema:
+				if err := d.validate(ap, val[k], child); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func hasType(v any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	case "null":
+		return v == nil
+	}
+	return true
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func inEnum(enum []any, v any) bool {
+	for _, e := range enum {
+		if reflect.DeepEqual(normalize(e), v) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize converts Go literals used in schemas (e.g. int) to the types
+// produced by encoding/json so they can be compared with decoded values.
+func normalize(v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return v
+	}
+	return out
+}
@@ -0,0 +1,296 @@
+// Package pubsub fans messages published on named topics out to in-process
+// subscribers.
+//
+// Every subscriber has a bounded buffer. A publisher never waits for a slow
+// subscriber: when its buffer is full, the subscriber's Policy either drops
+// its oldest buffered message or disconnects it.
+package pubsub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when attaching to an unknown subscription.
+	ErrNotFound = errors.New("pubsub: subscription not found")
+	// ErrAttached is returned when attaching to a subscription that already
+	// has a consumer.
+	ErrAttached = errors.New("pubsub: subscription already attached")
+	// ErrSlowConsumer is the Err of a subscription disconnected because its
+	// buffer was full.
+	ErrSlowConsumer = errors.New("pubsub: disconnected slow consumer")
+	// ErrClosed is the Err of a subscription closed by its consumer, by the
+	// broker or because nobody attached to it in time.
+	ErrClosed = errors.New("pubsub: subscription closed")
+	// ErrTooManySubscriptions is returned when subscribing past
+	// MaxSubscriptions or MaxPerOwner.
+	ErrTooManySubscriptions = errors.New("pubsub: too many subscriptions")
+)
+
+// Policy is what happens when a message is published to a subscriber whose
+// buffer is full.
+type Policy int
+
+const (
+	// DropOldest drops the oldest buffered message to make room.
+	DropOldest Policy = iota
+	// Disconnect closes the subscription with ErrSlowConsumer.
+	Disconnect
+)
+
+// String returns the name parsed by ParsePolicy.
+func (p Policy) String() string {
+	if p == Disconnect {
+		return "disconnect"
+	}
+	return "drop_oldest"
+}
+
+// ParsePolicy parses "drop_oldest" or "disconnect".
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "drop_oldest":
+		return DropOldest, nil
+	case "disconnect":
+		return Disconnect, nil
+	}
+	return 0, fmt.Errorf("pubsub: unknown policy %q", s)
+}
+
+// Message is a published message.
+type Message struct {
+	Topic       string    `json:"topic"`
+	Msg         string    `json:"msg"`
+	Principal   string    `json:"principal,omitempty" doc:"Authenticated publisher, if any."`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// Options configures a Broker. The callbacks, if set, are called with the
+// broker lock held and must not block nor call the broker.
+type Options struct {
+	// AttachTimeout, if positive, closes subscriptions nobody attached to
+	// within that time.
+	AttachTimeout time.Duration
+	// MaxSubscriptions and MaxPerOwner, if positive, bound the subscriptions
+	// in total and per owner. Subscriptions without owner only count toward
+	// MaxSubscriptions.
+	MaxSubscriptions, MaxPerOwner int
+	// OnCount is called with the number of topics with subscribers and the
+	// number of subscribers whenever they change.
+	OnCount func(topics, subscribers int)
+	// OnDrop is called when a message is not delivered to a subscriber
+	// because its buffer is full, with the subscriber's policy.
+	OnDrop func(p Policy)
+}
+
+// Broker routes published messages to the subscribers of their topic.
+type Broker struct {
+	opts Options
+
+	mu      sync.Mutex
+	topics  map[string]map[*Subscription]struct{}
+	byID    map[string]*Subscription
+	byOwner map[string]int
+	closed  bool
+}
+
+// NewBroker returns a broker without topics.
+func NewBroker(opts Options) *Broker {
+	return &Broker{
+		opts:    opts,
+		topics:  map[string]map[*Subscription]struct{}{},
+		byID:    map[string]*Subscription{},
+		byOwner: map[string]int{},
+	}
+}
+
+// Subscription is a subscriber's view of a topic. Messages are received from
+// C until Done is closed.
+type Subscription struct {
+	ID     string
+	Topic  string
+	Policy Policy
+
+	b     *Broker
+	owner string
+	ch    chan Message
+	done  chan struct{}
+	err   error // set before done is closed
+
+	attached bool // guarded by b.mu
+}
+
+// C returns the channel the subscription's messages are received from.
+func (s *Subscription) C() <-chan Message { return s.ch }
+
+// Done is closed when the subscription ends; Err tells why.
+func (s *Subscription) Done() <-chan struct{} { return s.done }
+
+// Err returns ErrSlowConsumer or ErrClosed once Done is closed, nil before.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.b.mu.Lock()
+	defer s.b.mu.Unlock()
+	s.b.remove(s, ErrClosed)
+}
+
+// Subscribe subscribes to topic with a buffer of size messages. The
+// subscription is owned by owner, who alone can Attach to it. It fails with
+// ErrTooManySubscriptions past the limits.
+func (b *Broker) Subscribe(topic, owner string, size int, policy Policy) (*Subscription, error) {
+	s := &Subscription{
+		ID:     newID(),
+		Topic:  topic,
+		Policy: policy,
+		b:      b,
+		owner:  owner,
+		ch:     make(chan Message, max(size, 1)),
+		done:   make(chan struct{}),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		s.err = ErrClosed
+		close(s.done)
+		return s, nil
+	}
+	if (b.opts.MaxSubscriptions > 0 && len(b.byID) >= b.opts.MaxSubscriptions) ||
+		(b.opts.MaxPerOwner > 0 && owner != "" && b.byOwner[owner] >= b.opts.MaxPerOwner) {
+		return nil, ErrTooManySubscriptions
+	}
+	subs := b.topics[topic]
+	if subs == nil {
+		subs = map[*Subscription]struct{}{}
+		b.topics[topic] = subs
+	}
+	subs[s] = struct{}{}
+	b.byID[s.ID] = s
+	if owner != "" {
+		b.byOwner[owner]++
+	}
+	b.countChanged()
+	if b.opts.AttachTimeout > 0 {
+		time.AfterFunc(b.opts.AttachTimeout, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if !s.attached {
+				b.remove(s, ErrClosed)
+			}
+		})
+	}
+	return s, nil
+}
+
+// Attach returns the subscription with the given ID for its consumer. Each
+// subscription can be attached once, by its owner; others get ErrNotFound.
+func (b *Broker) Attach(id, owner string) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.byID[id]
+	if !ok || s.owner != owner {
+		return nil, ErrNotFound
+	}
+	if s.attached {
+		return nil, ErrAttached
+	}
+	s.attached = true
+	return s, nil
+}
+
+// Publish delivers m to the current subscribers of m.Topic and returns how
+// many there were.
+func (b *Broker) Publish(m Message) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subs := b.topics[m.Topic]
+	for s := range subs {
+		b.offer(s, m)
+	}
+	return len(subs)
+}
+
+// Close ends all subscriptions; later ones end right away.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, s := range b.byID {
+		b.remove(s, ErrClosed)
+	}
+}
+
+// offer sends m to s according to its policy. Callers hold b.mu, which makes
+// it the only sender on s.ch.
+func (b *Broker) offer(s *Subscription, m Message) {
+	for {
+		select {
+		case s.ch <- m:
+			return
+		default:
+		}
+		if s.Policy == Disconnect {
+			b.dropped(Disconnect)
+			b.remove(s, ErrSlowConsumer)
+			return
+		}
+		// Make room, unless the consumer just did.
+		select {
+		case <-s.ch:
+			b.dropped(DropOldest)
+		default:
+		}
+	}
+}
+
+// remove ends s with err, unless it ended already. Callers hold b.mu.
+func (b *Broker) remove(s *Subscription, err error) {
+	if _, ok := b.byID[s.ID]; !ok {
+		return
+	}
+	delete(b.byID, s.ID)
+	if s.owner != "" {
+		if b.byOwner[s.owner]--; b.byOwner[s.owner] == 0 {
+			delete(b.byOwner, s.owner)
+		}
+	}
+	subs := b.topics[s.Topic]
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(b.topics, s.Topic)
+	}
+	s.err = err
+	close(s.done)
+	b.countChanged()
+}
+
+func (b *Broker) countChanged() {
+	if b.opts.OnCount != nil {
+		b.opts.OnCount(len(b.topics), len(b.byID))
+	}
+}
+
+func (b *Broker) dropped(p Policy) {
+	if b.opts.OnDrop != nil {
+		b.opts.OnDrop(p)
+	}
+}
+
+func newID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
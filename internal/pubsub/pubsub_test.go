@@ -0,0 +1,121 @@
+package pubsub_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/pubsub"
+)
+
+func subscribe(t *testing.T, b *pubsub.Broker, topic, owner string, size int, policy pubsub.Policy) *pubsub.Subscription {
+	t.Helper()
+	s, err := b.Subscribe(topic, owner, size, policy)
+	require.NoError(t, err)
+	return s
+}
+
+func drain(s *pubsub.Subscription) []string {
+	var msgs []string
+	for {
+		select {
+		case m := <-s.C():
+			msgs = append(msgs, m.Msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func TestBroker(t *testing.T) {
+	var topics, subscribers int
+	drops := map[pubsub.Policy]int{}
+	b := pubsub.NewBroker(pubsub.Options{
+		OnCount: func(t, s int) { topics, subscribers = t, s },
+		OnDrop:  func(p pubsub.Policy) { drops[p]++ },
+	})
+
+	oldest := subscribe(t, b, "news", "", 2, pubsub.DropOldest)
+	slow := subscribe(t, b, "news", "", 2, pubsub.Disconnect)
+	other := subscribe(t, b, "sports", "", 2, pubsub.DropOldest)
+	assert.Equal(t, 2, topics)
+	assert.Equal(t, 3, subscribers)
+
+	for _, msg := range []string{"one", "two", "three"} {
+		b.Publish(pubsub.Message{Topic: "news", Msg: msg})
+	}
+	assert.Equal(t, []string{"two", "three"}, drain(oldest))
+	assert.Nil(t, oldest.Err())
+
+	<-slow.Done()
+	assert.ErrorIs(t, slow.Err(), pubsub.ErrSlowConsumer)
+	assert.Equal(t, 1, drops[pubsub.DropOldest])
+	assert.Equal(t, 1, drops[pubsub.Disconnect])
+	assert.Equal(t, 2, subscribers)
+
+	assert.Empty(t, drain(other))
+	assert.Equal(t, 1, b.Publish(pubsub.Message{Topic: "news", Msg: "four"}))
+	assert.Equal(t, 0, b.Publish(pubsub.Message{Topic: "weather", Msg: "sun"}))
+
+	other.Close()
+	assert.ErrorIs(t, other.Err(), pubsub.ErrClosed)
+	assert.Equal(t, 1, topics)
+
+	b.Close()
+	<-oldest.Done()
+	assert.Equal(t, 0, topics)
+	assert.Equal(t, 0, subscribers)
+	late := subscribe(t, b, "news", "", 1, pubsub.DropOldest)
+	assert.ErrorIs(t, late.Err(), pubsub.ErrClosed)
+}
+
+func TestAttach(t *testing.T) {
+	b := pubsub.NewBroker(pubsub.Options{AttachTimeout: 50 * time.Millisecond})
+	s := subscribe(t, b, "news", "alice", 1, pubsub.DropOldest)
+
+	_, err := b.Attach(s.ID, "bob")
+	assert.ErrorIs(t, err, pubsub.ErrNotFound)
+	got, err := b.Attach(s.ID, "alice")
+	require.NoError(t, err)
+	assert.Same(t, s, got)
+	_, err = b.Attach(s.ID, "alice")
+	assert.ErrorIs(t, err, pubsub.ErrAttached)
+
+	unattached := subscribe(t, b, "news", "alice", 1, pubsub.DropOldest)
+	select {
+	case <-unattached.Done():
+	case <-time.After(time.Second):
+		t.Fatal("unattached subscription not closed")
+	}
+	assert.ErrorIs(t, unattached.Err(), pubsub.ErrClosed)
+	_, err = b.Attach(unattached.ID, "alice")
+	assert.ErrorIs(t, err, pubsub.ErrNotFound)
+	assert.Nil(t, s.Err(), "attached subscriptions do not expire")
+}
+
+func TestSubscriptionLimits(t *testing.T) {
+	b := pubsub.NewBroker(pubsub.Options{MaxSubscriptions: 3, MaxPerOwner: 2})
+	first := subscribe(t, b, "news", "alice", 1, pubsub.DropOldest)
+	subscribe(t, b, "sports", "alice", 1, pubsub.DropOldest)
+	_, err := b.Subscribe("news", "alice", 1, pubsub.DropOldest)
+	assert.ErrorIs(t, err, pubsub.ErrTooManySubscriptions)
+
+	subscribe(t, b, "news", "bob", 1, pubsub.DropOldest)
+	_, err = b.Subscribe("news", "carol", 1, pubsub.DropOldest)
+	assert.ErrorIs(t, err, pubsub.ErrTooManySubscriptions, "total limit")
+
+	first.Close()
+	subscribe(t, b, "news", "alice", 1, pubsub.DropOldest)
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []pubsub.Policy{pubsub.DropOldest, pubsub.Disconnect} {
+		got, err := pubsub.ParsePolicy(p.String())
+		require.NoError(t, err)
+		assert.Equal(t, p, got)
+	}
+	_, err := pubsub.ParsePolicy("block")
+	assert.Error(t, err)
+}
@@ -0,0 +1,112 @@
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of a single Allow call.
+type Result struct {
+	Allowed bool
+	// Limit is the bucket capacity (burst).
+	Limit int
+	// Remaining is the number of whole tokens left after this call.
+	Remaining int
+	// RetryAfter is the time until the next token is available; zero when allowed.
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+}
+
+// Limiter hands out tokens from per-key token buckets.
+// Buckets are kept in memory; once maxKeys buckets exist the least recently
+// used one is evicted, which is equivalent to forgetting that client.
+type Limiter struct {
+	rate    float64 // tokens added per second
+	burst   int
+	maxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List // front is most recently used
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// New returns a Limiter refilling rate tokens per second up to burst tokens,
+// tracking at most maxKeys keys.
+func New(rate float64, burst, maxKeys int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   burst,
+		maxKeys: maxKeys,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Allow takes one token from key's bucket, if available.
+func (l *Limiter) Allow(key string) Result {
+	return l.AllowAt(key, time.Now())
+}
+
+// AllowAt is Allow with an explicit clock reading.
+func (l *Limiter) AllowAt(key string, now time.Time) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.get(key, now)
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(l.burst), b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+
+	res := Result{Limit: l.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = l.duration(1 - b.tokens)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = l.duration(float64(l.burst) - b.tokens)
+	return res
+}
+
+// Len returns the number of tracked keys.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lru.Len()
+}
+
+// get returns key's bucket, creating a full one and evicting the least
+// recently used bucket when needed. Callers must hold l.mu.
+func (l *Limiter) get(key string, now time.Time) *bucket {
+	if el, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(el)
+		return el.Value.(*bucket)
+	}
+	if l.maxKeys > 0 && l.lru.Len() >= l.maxKeys {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*bucket).key)
+	}
+	b := &bucket{key: key, tokens: float64(l.burst), last: now}
+	l.buckets[key] = l.lru.PushFront(b)
+	return b
+}
+
+// duration returns how long it takes to refill n tokens.
+func (l *Limiter) duration(n float64) time.Duration {
+	if n <= 0 || l.rate <= 0 {
+		return 0
+	}
+	return time.Duration(n / l.rate * float64(time.Second))
+}
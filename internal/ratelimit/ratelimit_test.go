@@ -0,0 +1,58 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcosartorato/myapp/internal/ratelimit"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	l := ratelimit.New(2, 3, 10) // 2 tokens/s, burst 3
+	now := time.Unix(0, 0)
+
+	for i := 2; i >= 0; i-- {
+		res := l.AllowAt("a", now)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 3, res.Limit)
+		assert.Equal(t, i, res.Remaining)
+	}
+
+	res := l.AllowAt("a", now)
+	assert.False(t, res.Allowed, "burst exhausted")
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, 500*time.Millisecond, res.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, res.Reset)
+
+	// Other keys have their own bucket.
+	assert.True(t, l.AllowAt("b", now).Allowed)
+
+	// Half a second later one token is back.
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, l.AllowAt("a", now).Allowed)
+	assert.False(t, l.AllowAt("a", now).Allowed)
+
+	// Refill is capped at burst.
+	now = now.Add(time.Hour)
+	res = l.AllowAt("a", now)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 2, res.Remaining)
+}
+
+func TestLimiterEvictsLeastRecentlyUsed(t *testing.T) {
+	l := ratelimit.New(1, 1, 2)
+	now := time.Unix(0, 0)
+
+	assert.True(t, l.AllowAt("a", now).Allowed)
+	assert.True(t, l.AllowAt("b", now).Allowed)
+	// Touch "a" so that "b" is the least recently used.
+	assert.False(t, l.AllowAt("a", now).Allowed)
+	assert.True(t, l.AllowAt("c", now).Allowed)
+	assert.Equal(t, 2, l.Len())
+
+	// "a" is still tracked (and empty), "b" was evicted and starts full again.
+	assert.False(t, l.AllowAt("a", now).Allowed)
+	assert.True(t, l.AllowAt("b", now).Allowed)
+}
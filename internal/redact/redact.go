@@ -0,0 +1,312 @@
+// Package redact removes sensitive data from logs: secrets, personal data
+// and message payloads.
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Redacted replaces sensitive values.
+const Redacted = "[REDACTED]"
+
+// Payload modes, how payload fields are logged.
+const (
+	PayloadHash     = "hash"
+	PayloadTruncate = "truncate"
+)
+
+// valueRule redacts the matches of re in string values, those failing valid
+// excepted.
+type valueRule struct {
+	re    *regexp.Regexp
+	valid func(match string) bool
+	// replacement of the matches, Redacted when empty.
+	replacement string
+}
+
+// valueRules are the built-in rules redacting sensitive values, by name.
+var valueRules = map[string]valueRule{
+	"email": {re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	// Card numbers pass the Luhn check, which spares other long numbers.
+	"card":   {re: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), valid: luhn},
+	"bearer": {re: regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/-]+=*`), replacement: "$1 " + Redacted},
+}
+
+// Options configures a Redactor.
+type Options struct {
+	// Keys are the fragments of sensitive field keys, matched regardless of
+	// case and of "-", "_" or "." separators: "api_key" matches "X-API-Key".
+	// String values are also redacted in key=value (or key: value) pairs
+	// with such keys.
+	Keys []string
+	// Values are the names of the built-in rules redacting sensitive
+	// values in strings: "email", "card" (numbers passing the Luhn check)
+	// and "bearer" (Authorization credentials).
+	Values []string
+	// Regexp, if set, redacts its matches in strings.
+	Regexp *regexp.Regexp
+	// PayloadKeys are the keys of fields holding payloads, logged according
+	// to PayloadMode: PayloadHash (the default) or PayloadTruncate, keeping
+	// PayloadMaxLength characters.
+	PayloadKeys      []string
+	PayloadMode      string
+	PayloadMaxLength int
+}
+
+// Redactor removes sensitive data from logs: values of fields whose key
+// looks sensitive, key=value pairs with such keys and values matching rules
+// in strings, and payloads, hashed or truncated.
+type Redactor struct {
+	keys        []string
+	pairs       *regexp.Regexp
+	values      []valueRule
+	payloadKeys []string
+	payloadMode string
+	payloadMax  int
+}
+
+// New returns the Redactor configured in opts, nil when it would redact
+// nothing.
+func New(opts Options) (*Redactor, error) {
+	switch opts.PayloadMode {
+	case "", PayloadHash, PayloadTruncate:
+	default:
+		return nil, fmt.Errorf("redact: unknown payload mode %q", opts.PayloadMode)
+	}
+	r := &Redactor{payloadMode: opts.PayloadMode, payloadMax: opts.PayloadMaxLength}
+	var alternatives []string
+	for _, key := range opts.Keys {
+		key = normalizeKey(key)
+		r.keys = append(r.keys, key)
+		alternatives = append(alternatives, strings.ReplaceAll(regexp.QuoteMeta(key), "_", "[_-]?"))
+	}
+	if len(alternatives) > 0 {
+		r.pairs = regexp.MustCompile(`(?i)([\w.-]*(?:` + strings.Join(alternatives, "|") + `)[\w.-]*"?\s*[=:]\s*)("[^"]*"|[^\s&"',;]+)`)
+	}
+	for _, name := range opts.Values {
+		rule, ok := valueRules[name]
+		if !ok {
+			return nil, fmt.Errorf("redact: unknown value rule %q: want one of %s", name, strings.Join(slices.Sorted(maps.Keys(valueRules)), ", "))
+		}
+		r.values = append(r.values, rule)
+	}
+	if opts.Regexp != nil {
+		r.values = append(r.values, valueRule{re: opts.Regexp})
+	}
+	for _, key := range opts.PayloadKeys {
+		r.payloadKeys = append(r.payloadKeys, normalizeKey(key))
+	}
+	if r.pairs == nil && len(r.values) == 0 && len(r.payloadKeys) == 0 {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// String redacts the sensitive values and key=value pairs in s.
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, rule := range r.values {
+		replacement := rule.replacement
+		if replacement == "" {
+			replacement = Redacted
+		}
+		if rule.valid == nil {
+			s = rule.re.ReplaceAllString(s, replacement)
+			continue
+		}
+		s = rule.re.ReplaceAllStringFunc(s, func(match string) string {
+			if !rule.valid(match) {
+				return match
+			}
+			return rule.re.ReplaceAllString(match, replacement)
+		})
+	}
+	if r.pairs != nil {
+		s = r.pairs.ReplaceAllString(s, "${1}"+Redacted)
+	}
+	return s
+}
+
+// Core wraps core, redacting entry messages and fields before they reach
+// its encoder.
+func (r *Redactor) Core(core zapcore.Core) zapcore.Core {
+	if r == nil {
+		return core
+	}
+	return &redactingCore{Core: core, r: r}
+}
+
+type redactingCore struct {
+	zapcore.Core
+	r *Redactor
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(c.r.fields(fields)), r: c.r}
+}
+
+func (c *redactingCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *redactingCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	e.Message = c.r.String(e.Message)
+	return c.Core.Write(e, c.r.fields(fields))
+}
+
+func (r *Redactor) fields(fields []zapcore.Field) []zapcore.Field {
+	out := make([]zapcore.Field, 0, len(fields))
+	for _, f := range fields {
+		out = append(out, r.field(f)...)
+	}
+	return out
+}
+
+// field redacts f; complex fields are flattened to plain values first so
+// that nothing they hold escapes redaction.
+func (r *Redactor) field(f zapcore.Field) []zapcore.Field {
+	switch f.Type {
+	case zapcore.NamespaceType, zapcore.SkipType:
+		return []zapcore.Field{f}
+	}
+	if r.sensitive(f.Key) {
+		return []zapcore.Field{zap.String(f.Key, Redacted)}
+	}
+	switch f.Type {
+	case zapcore.StringType:
+		return []zapcore.Field{zap.String(f.Key, r.scalar(f.Key, f.String))}
+	case zapcore.ByteStringType:
+		return []zapcore.Field{zap.String(f.Key, r.scalar(f.Key, string(f.Interface.([]byte))))}
+	case zapcore.BinaryType, zapcore.BoolType, zapcore.DurationType, zapcore.TimeType, zapcore.TimeFullType,
+		zapcore.Int64Type, zapcore.Int32Type, zapcore.Int16Type, zapcore.Int8Type,
+		zapcore.Uint64Type, zapcore.Uint32Type, zapcore.Uint16Type, zapcore.Uint8Type, zapcore.UintptrType,
+		zapcore.Float64Type, zapcore.Float32Type, zapcore.Complex128Type, zapcore.Complex64Type:
+		return []zapcore.Field{f}
+	}
+
+	enc := zapcore.NewMapObjectEncoder()
+	f.AddTo(enc)
+	var out []zapcore.Field
+	for _, key := range slices.Sorted(maps.Keys(enc.Fields)) {
+		out = append(out, zap.Any(key, r.value(key, enc.Fields[key])))
+	}
+	return out
+}
+
+// value redacts v, the value of key as encoded by a MapObjectEncoder.
+func (r *Redactor) value(key string, v any) any {
+	if r.sensitive(key) {
+		return Redacted
+	}
+	switch v := v.(type) {
+	case string:
+		return r.scalar(key, v)
+	case []byte:
+		return r.scalar(key, string(v))
+	case map[string]any:
+		redacted := make(map[string]any, len(v))
+		for k, e := range v {
+			redacted[k] = r.value(k, e)
+		}
+		return redacted
+	case []any:
+		redacted := make([]any, len(v))
+		for i, e := range v {
+			redacted[i] = r.value(key, e)
+		}
+		return redacted
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr,
+		float32, float64, complex64, complex128, time.Time, time.Duration:
+		return v
+	}
+	// Reflected values are kept as is by the encoder: look into their JSON.
+	b, err := json.Marshal(v)
+	if err != nil {
+		return r.scalar(key, fmt.Sprint(v))
+	}
+	var generic any
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return r.scalar(key, string(b))
+	}
+	return r.value(key, generic)
+}
+
+// scalar redacts s, the string value of key, logging it as a payload if key
+// is one.
+func (r *Redactor) scalar(key, s string) string {
+	s = r.String(s)
+	if !slices.Contains(r.payloadKeys, normalizeKey(key)) {
+		return s
+	}
+	if r.payloadMode == PayloadTruncate {
+		return truncate(s, r.payloadMax)
+	}
+	digest := sha256.Sum256([]byte(s))
+	return fmt.Sprintf("sha256:%s (%d bytes)", hex.EncodeToString(digest[:8]), len(s))
+}
+
+// sensitive reports whether the values of key are to be redacted.
+func (r *Redactor) sensitive(key string) bool {
+	key = normalizeKey(key)
+	for _, k := range r.keys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeKey lowercases key, with underscores for separators.
+func normalizeKey(key string) string {
+	return strings.NewReplacer("-", "_", ".", "_", " ", "_").Replace(strings.ToLower(key))
+}
+
+// truncate keeps the first max runes of s, noting its length if cut.
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	i := 0
+	for n := 0; n < max; n++ {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return fmt.Sprintf("%s… (%d bytes)", s[:i], len(s))
+}
+
+// luhn reports whether the digits of number pass the Luhn check.
+func luhn(number string) bool {
+	sum, double := 0, false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
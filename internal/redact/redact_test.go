@@ -0,0 +1,162 @@
+package redact_test
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/marcosartorato/myapp/internal/redact"
+)
+
+var defaults = redact.Options{
+	Keys:             []string{"authorization", "cookie", "password", "secret", "token", "api_key", "apikey"},
+	Values:           []string{"email", "card", "bearer"},
+	PayloadKeys:      []string{"msg"},
+	PayloadMode:      redact.PayloadHash,
+	PayloadMaxLength: 8,
+}
+
+// secrets must never reach the encoder.
+var secrets = []string{"hunter2", "s3cr3t", "alice@example.com", "4111111111111111", "4111 1111 1111 1111", "eyJhbGciOi", "top secret payload"}
+
+// newLogger returns a logger redacting with opts, and the entries reaching
+// its encoder.
+func newLogger(t *testing.T, opts redact.Options) (*zap.Logger, *observer.ObservedLogs) {
+	t.Helper()
+	r, err := redact.New(opts)
+	require.NoError(t, err)
+	core, logs := observer.New(zapcore.DebugLevel)
+	return zap.New(r.Core(core)), logs
+}
+
+// encoded returns the entries of logs as the JSON encoder writes them.
+func encoded(t *testing.T, logs *observer.ObservedLogs) string {
+	t.Helper()
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	var b strings.Builder
+	for _, e := range logs.All() {
+		buf, err := enc.EncodeEntry(e.Entry, e.Context)
+		require.NoError(t, err)
+		b.WriteString(buf.String())
+		buf.Free()
+	}
+	return b.String()
+}
+
+func assertNoSecrets(t *testing.T, out string) {
+	t.Helper()
+	for _, s := range secrets {
+		assert.NotContains(t, out, s)
+	}
+}
+
+type credentials struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+func TestKeys(t *testing.T) {
+	logger, logs := newLogger(t, defaults)
+	logger.With(zap.String("api_key", "s3cr3t")).Info("request",
+		zap.String("Authorization", "Bearer eyJhbGciOi"),
+		zap.String("X-API-Key", "s3cr3t"),
+		zap.ByteString("session.token", []byte("s3cr3t")),
+		zap.Any("headers", map[string][]string{"Cookie": {"id=s3cr3t"}, "Accept": {"*/*"}}),
+		zap.Any("login", credentials{User: "alice", Password: "hunter2"}),
+		zap.Int("status", 200),
+	)
+
+	out := encoded(t, logs)
+	assertNoSecrets(t, out)
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, redact.Redacted, fields["api_key"])
+	assert.Equal(t, redact.Redacted, fields["Authorization"])
+	assert.Equal(t, redact.Redacted, fields["X-API-Key"])
+	assert.Equal(t, redact.Redacted, fields["session.token"])
+	assert.Equal(t, map[string]any{"Cookie": redact.Redacted, "Accept": []any{"*/*"}}, fields["headers"])
+	assert.Equal(t, map[string]any{"user": "alice", "password": redact.Redacted}, fields["login"])
+	assert.EqualValues(t, 200, fields["status"])
+}
+
+func TestValues(t *testing.T) {
+	logger, logs := newLogger(t, defaults)
+	logger.Info("signup of alice@example.com",
+		zap.String("card", "4111 1111 1111 1111"),
+		zap.String("note", "paid with 4111111111111111, order 1234567890123"),
+		zap.Strings("contacts", []string{"alice@example.com", "bob"}),
+		zap.String("header", "Bearer eyJhbGciOi.payload.sig"),
+	)
+
+	out := encoded(t, logs)
+	assertNoSecrets(t, out)
+	entry := logs.All()[0]
+	assert.Equal(t, "signup of "+redact.Redacted, entry.Message)
+	fields := entry.ContextMap()
+	assert.Equal(t, redact.Redacted, fields["card"])
+	// Numbers failing the Luhn check are not card numbers.
+	assert.Equal(t, "paid with "+redact.Redacted+", order 1234567890123", fields["note"])
+	assert.Equal(t, []any{redact.Redacted, "bob"}, fields["contacts"])
+	assert.Equal(t, "Bearer "+redact.Redacted, fields["header"])
+}
+
+func TestPairs(t *testing.T) {
+	logger, logs := newLogger(t, defaults)
+	logger.Error("call failed",
+		zap.String("url", "/login?user=alice&password=hunter2&next=/"),
+		zap.Error(errors.New(`upstream rejected {"access_token": "s3cr3t"}`)),
+	)
+
+	out := encoded(t, logs)
+	assertNoSecrets(t, out)
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, "/login?user=alice&password="+redact.Redacted+"&next=/", fields["url"])
+	assert.Equal(t, `upstream rejected {"access_token": `+redact.Redacted+`}`, fields["error"])
+}
+
+func TestRegexp(t *testing.T) {
+	opts := defaults
+	opts.Regexp = regexp.MustCompile(`\bIBAN [A-Z0-9]+`)
+	r, err := redact.New(opts)
+	require.NoError(t, err)
+	assert.Equal(t, "refund to "+redact.Redacted, r.String("refund to IBAN DE89370400440532013000"))
+}
+
+func TestPayloads(t *testing.T) {
+	payload := "top secret payload"
+
+	logger, logs := newLogger(t, defaults)
+	logger.Info("job ran", zap.String("msg", payload))
+	out := encoded(t, logs)
+	assertNoSecrets(t, out)
+	assert.Regexp(t, `^sha256:[0-9a-f]{16} \(18 bytes\)$`, logs.All()[0].ContextMap()["msg"])
+
+	// Equal payloads hash alike, so that they can be correlated.
+	logger.Info("job ran", zap.String("msg", payload))
+	assert.Equal(t, logs.All()[0].ContextMap()["msg"], logs.All()[1].ContextMap()["msg"])
+
+	opts := defaults
+	opts.PayloadMode = redact.PayloadTruncate
+	logger, logs = newLogger(t, opts)
+	logger.Info("job ran", zap.String("msg", payload))
+	assertNoSecrets(t, encoded(t, logs))
+	assert.Equal(t, "top secr… (18 bytes)", logs.All()[0].ContextMap()["msg"])
+}
+
+func TestNew(t *testing.T) {
+	r, err := redact.New(redact.Options{})
+	require.NoError(t, err)
+	assert.Nil(t, r)
+	assert.Equal(t, "alice@example.com", r.String("alice@example.com"))
+
+	_, err = redact.New(redact.Options{Values: []string{"phone"}})
+	assert.ErrorContains(t, err, `unknown value rule "phone"`)
+	_, err = redact.New(redact.Options{PayloadKeys: []string{"msg"}, PayloadMode: "drop"})
+	assert.ErrorContains(t, err, `unknown payload mode "drop"`)
+}
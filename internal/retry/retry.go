@@ -0,0 +1,33 @@
+// Package retry holds the retry policy of the app's outgoing HTTP requests,
+// such as webhook deliveries and Loki pushes.
+package retry
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// Retryable reports whether a failed request with the given status (0 when
+// there was no response) may succeed later.
+func Retryable(status int) bool {
+	return status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// Backoff is an exponential backoff: the delay before the first retry is
+// Initial, doubling with each retry up to Max. Delays are jittered down to
+// half their value ("equal jitter"), so that clients failing together do not
+// retry together.
+type Backoff struct {
+	Initial, Max time.Duration
+}
+
+// Delay returns the delay after the given number of attempts.
+func (b Backoff) Delay(attempts int) time.Duration {
+	delay := b.Initial
+	for i := 1; i < attempts && delay < b.Max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, b.Max)
+	return delay/2 + rand.N(delay/2+1)
+}
@@ -0,0 +1,37 @@
+package retry_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/marcosartorato/myapp/internal/retry"
+)
+
+func TestRetryable(t *testing.T) {
+	for _, status := range []int{0, http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		assert.True(t, retry.Retryable(status), status)
+	}
+	for _, status := range []int{http.StatusOK, http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound} {
+		assert.False(t, retry.Retryable(status), status)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := retry.Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	for attempts, want := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		50: time.Second,
+	} {
+		for range 100 {
+			d := b.Delay(attempts)
+			assert.GreaterOrEqual(t, d, want/2, attempts)
+			assert.LessOrEqual(t, d, want, attempts)
+		}
+	}
+}
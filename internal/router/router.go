@@ -0,0 +1,251 @@
+// Package router serves HTTP routes through a fixed chain of middleware.
+//
+// Every route is served through, from the outside in:
+//
+//  1. the global middleware of the router, in the order given to New;
+//  2. the handler timeout;
+//  3. the panic recovery;
+//  4. the request body limit;
+//  5. the middleware of the route, in order;
+//  6. the route handler.
+//
+// Global middleware, such as instrumentation, thus see the responses written
+// by the timeout, the panic recovery and the body limit. Panics are recovered
+// inside the timeout, which would otherwise lose their stack.
+package router
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// timeoutMessage is the body of responses to requests whose handler timed out.
+const timeoutMessage = "Service Timeout"
+
+// Middleware wraps a handler.
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes mw into a single middleware, the first being the outermost.
+func Chain(mw ...Middleware) Middleware {
+	return func(h http.Handler) http.Handler {
+		for i := len(mw) - 1; i >= 0; i-- {
+			h = mw[i](h)
+		}
+		return h
+	}
+}
+
+// Options configures a Router.
+type Options struct {
+	// Middleware wrap every route, the first being the outermost.
+	Middleware []Middleware
+	// Timeout is the default time handlers have to complete; zero means no
+	// limit. Requests timing out get a 503.
+	Timeout time.Duration
+	// MaxBodyBytes is the default limit on request bodies; zero means no
+	// limit. Larger bodies get a 413 when announced by Content-Length, and
+	// fail to read with an *http.MaxBytesError otherwise.
+	MaxBodyBytes int64
+	// Error writes the error responses of the router, http.Error with the
+	// status text by default.
+	Error func(w http.ResponseWriter, r *http.Request, status int)
+	// OnTimeout, if set, is called after a request timed out, with the
+	// request as seen by the global middleware.
+	OnTimeout func(r *http.Request)
+	// Recover, if set, is called with the value of handler panics, from the
+	// deferred call so that the panicking stack is still at hand. A 500 is
+	// written afterwards, unless Recover wrote a response.
+	// http.ErrAbortHandler is never recovered.
+	Recover func(w http.ResponseWriter, r *http.Request, v any)
+}
+
+// Route is an endpoint served by a Router.
+type Route struct {
+	// Method is the HTTP method of the route; empty matches any. GET routes
+	// also serve HEAD.
+	Method string
+	// Pattern is the http.ServeMux pattern of the route, without method.
+	Pattern string
+	Handler http.Handler
+	// Timeout overrides the router's Timeout when positive; negative means
+	// no limit, which long-lived responses need.
+	Timeout time.Duration
+	// MaxBodyBytes overrides the router's MaxBodyBytes when positive;
+	// negative means no limit.
+	MaxBodyBytes int64
+	// Middleware wrap the handler inside the router's, the first being the
+	// outermost.
+	Middleware []Middleware
+}
+
+// Router is an http.Handler dispatching requests to its routes.
+//
+// Requests for a registered pattern with another method get a 405 with an
+// Allow header, except OPTIONS requests which get a 204 with it. GET routes
+// also serve HEAD. Requests matching no pattern get a 404. These responses
+// go through the global middleware only.
+type Router struct {
+	opts     Options
+	mux      *http.ServeMux
+	methods  map[string][]string // by pattern
+	notFound http.Handler
+}
+
+// New returns a router without routes.
+func New(opts Options) *Router {
+	if opts.Error == nil {
+		opts.Error = func(w http.ResponseWriter, _ *http.Request, status int) {
+			http.Error(w, http.StatusText(status), status)
+		}
+	}
+	rt := &Router{opts: opts, mux: http.NewServeMux(), methods: map[string][]string{}}
+	rt.notFound = Chain(opts.Middleware...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts.Error(w, r, http.StatusNotFound)
+	}))
+	return rt
+}
+
+// Handle registers rt. It panics if rt conflicts with a registered route,
+// like http.ServeMux.Handle.
+func (rt *Router) Handle(r Route) {
+	mw := append([]Middleware(nil), rt.opts.Middleware...)
+	if timeout := override(r.Timeout, rt.opts.Timeout); timeout > 0 {
+		mw = append(mw, rt.timeout(timeout))
+	}
+	if rt.opts.Recover != nil {
+		mw = append(mw, rt.recoverer)
+	}
+	if limit := override(r.MaxBodyBytes, rt.opts.MaxBodyBytes); limit > 0 {
+		mw = append(mw, rt.bodyLimit(limit))
+	}
+	mw = append(mw, r.Middleware...)
+	h := Chain(mw...)(r.Handler)
+
+	if r.Method == "" {
+		rt.mux.Handle(r.Pattern, h)
+		return
+	}
+	rt.mux.Handle(r.Method+" "+r.Pattern, h)
+	// Other methods fall back to the pattern without method.
+	if _, ok := rt.methods[r.Pattern]; !ok {
+		rt.mux.Handle(r.Pattern, Chain(rt.opts.Middleware...)(rt.methodNotAllowed(r.Pattern)))
+	}
+	rt.methods[r.Pattern] = append(rt.methods[r.Pattern], r.Method)
+}
+
+// ServeHTTP dispatches req to the route matching it.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if _, pattern := rt.mux.Handler(req); pattern == "" {
+		rt.notFound.ServeHTTP(w, req)
+		return
+	}
+	rt.mux.ServeHTTP(w, req)
+}
+
+// methodNotAllowed answers requests for pattern whose method has no route.
+func (rt *Router) methodNotAllowed(pattern string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", strings.Join(rt.Allowed(pattern), ", "))
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		rt.opts.Error(w, r, http.StatusMethodNotAllowed)
+	})
+}
+
+// Allowed returns the sorted methods allowed on the route pattern, as listed
+// in Allow headers.
+func (rt *Router) Allowed(pattern string) []string {
+	methods := append([]string{http.MethodOptions}, rt.methods[pattern]...)
+	if slices.Contains(methods, http.MethodGet) {
+		methods = append(methods, http.MethodHead)
+	}
+	slices.Sort(methods)
+	return slices.Compact(methods)
+}
+
+// timeout gives handlers timeout to complete, answering 503 otherwise.
+func (rt *Router) timeout(timeout time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var completed atomic.Bool
+			inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r)
+				completed.Store(true)
+			})
+			http.TimeoutHandler(inner, timeout, timeoutMessage).ServeHTTP(w, r)
+			// TimeoutHandler returns early only when timing out.
+			if !completed.Load() && rt.opts.OnTimeout != nil {
+				rt.opts.OnTimeout(r)
+			}
+		})
+	}
+}
+
+// recoverer recovers handler panics with rt.opts.Recover.
+func (rt *Router) recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &writeTracker{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			rt.opts.Recover(tw, r, v)
+			if !tw.wrote {
+				rt.opts.Error(w, r, http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(tw, r)
+	})
+}
+
+// writeTracker tracks whether a response was started.
+type writeTracker struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *writeTracker) WriteHeader(code int) {
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *writeTracker) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *writeTracker) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// bodyLimit limits request bodies to limit bytes.
+func (rt *Router) bodyLimit(limit int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				rt.opts.Error(w, r, http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// override returns v if set, def otherwise.
+func override[T int64 | time.Duration](v, def T) T {
+	if v != 0 {
+		return v
+	}
+	return def
+}
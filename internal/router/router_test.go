@@ -0,0 +1,191 @@
+package router_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	metrics "github.com/marcosartorato/myapp/internal/metricsserver"
+	"github.com/marcosartorato/myapp/internal/router"
+)
+
+// recordStatus is a global middleware recording the status seen outside the
+// timeout and body limit.
+func recordStatus(statuses *[]int) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := httptest.NewRecorder()
+			next.ServeHTTP(rec, r)
+			*statuses = append(*statuses, rec.Code)
+			for k, v := range rec.Header() {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.Code)
+			_, _ = w.Write(rec.Body.Bytes())
+		})
+	}
+}
+
+func tag(name string, trace *[]string) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*trace = append(*trace, name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func serve(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestChainOrder(t *testing.T) {
+	var trace []string
+	rt := router.New(router.Options{Middleware: []router.Middleware{tag("global1", &trace), tag("global2", &trace)}})
+	rt.Handle(router.Route{
+		Method:     http.MethodGet,
+		Pattern:    "/a",
+		Middleware: []router.Middleware{tag("route1", &trace), tag("route2", &trace)},
+		Handler:    http.HandlerFunc(func(http.ResponseWriter, *http.Request) { trace = append(trace, "handler") }),
+	})
+
+	assert.Equal(t, http.StatusOK, serve(rt, http.MethodGet, "/a", "").Code)
+	assert.Equal(t, []string{"global1", "global2", "route1", "route2", "handler"}, trace)
+	assert.Equal(t, http.StatusNotFound, serve(rt, http.MethodGet, "/b", "").Code)
+}
+
+func TestTimeout(t *testing.T) {
+	var statuses []int
+	rt := router.New(router.Options{Middleware: []router.Middleware{recordStatus(&statuses)}, Timeout: 10 * time.Millisecond})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	rt.Handle(router.Route{Pattern: "/default", Handler: slow})
+	rt.Handle(router.Route{Pattern: "/longer", Handler: slow, Timeout: time.Second})
+	rt.Handle(router.Route{Pattern: "/stream", Handler: slow, Timeout: -1})
+
+	assert.Equal(t, http.StatusServiceUnavailable, serve(rt, http.MethodGet, "/default", "").Code)
+	assert.Equal(t, http.StatusOK, serve(rt, http.MethodGet, "/longer", "").Code)
+	assert.Equal(t, http.StatusOK, serve(rt, http.MethodGet, "/stream", "").Code)
+	assert.Equal(t, []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK}, statuses, "timeouts are seen by global middleware")
+}
+
+func TestBodyLimit(t *testing.T) {
+	var statuses []int
+	rt := router.New(router.Options{Middleware: []router.Middleware{recordStatus(&statuses)}, MaxBodyBytes: 4})
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write(body)
+	})
+	rt.Handle(router.Route{Pattern: "/default", Handler: echo})
+	rt.Handle(router.Route{Pattern: "/larger", Handler: echo, MaxBodyBytes: 8})
+	rt.Handle(router.Route{Pattern: "/unlimited", Handler: echo, MaxBodyBytes: -1})
+
+	assert.Equal(t, "1234", serve(rt, http.MethodPost, "/default", "1234").Body.String())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(rt, http.MethodPost, "/default", "12345").Code)
+	assert.Equal(t, "12345678", serve(rt, http.MethodPost, "/larger", "12345678").Body.String())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(rt, http.MethodPost, "/larger", "123456789").Code)
+	assert.Equal(t, strings.Repeat("x", 64), serve(rt, http.MethodPost, "/unlimited", strings.Repeat("x", 64)).Body.String())
+
+	// Without Content-Length, the handler fails to read past the limit.
+	req := httptest.NewRequest(http.MethodPost, "/default", io.NopCloser(strings.NewReader("12345")))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "too large")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, statuses[1])
+}
+
+func TestOutcomes(t *testing.T) {
+	var recovered any
+	var stack string
+	rt := router.New(router.Options{
+		Middleware: []router.Middleware{metrics.Instrument},
+		Timeout:    20 * time.Millisecond,
+		OnTimeout:  metrics.RecordTimeout,
+		Recover: func(_ http.ResponseWriter, r *http.Request, v any) {
+			recovered, stack = v, string(debug.Stack())
+			metrics.RecordPanic(r)
+		},
+	})
+	rt.Handle(router.Route{Pattern: "/outcomes/ok", Handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})})
+	rt.Handle(router.Route{Pattern: "/outcomes/slow", Handler: http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})})
+	rt.Handle(router.Route{Pattern: "/outcomes/panic", Handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})})
+	rt.Handle(router.Route{Pattern: "/outcomes/gone", Timeout: -1, Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})})
+	count := func(route, status, outcome string) float64 {
+		return testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues(http.MethodGet, route, status, outcome))
+	}
+
+	assert.Equal(t, http.StatusOK, serve(rt, http.MethodGet, "/outcomes/ok", "").Code)
+	assert.Equal(t, 1.0, count("/outcomes/ok", "200", metrics.OutcomeCompleted))
+
+	assert.Equal(t, http.StatusServiceUnavailable, serve(rt, http.MethodGet, "/outcomes/slow", "").Code)
+	assert.Equal(t, 1.0, count("/outcomes/slow", "503", metrics.OutcomeTimeout))
+
+	assert.Equal(t, http.StatusInternalServerError, serve(rt, http.MethodGet, "/outcomes/panic", "").Code)
+	assert.Equal(t, 1.0, count("/outcomes/panic", "500", metrics.OutcomePanic))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.PanicsTotal.WithLabelValues("/outcomes/panic")))
+	assert.Equal(t, "boom", recovered)
+	assert.Contains(t, stack, "panic(", "stack of the panicking handler")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, httptest.NewRequestWithContext(ctx, http.MethodGet, "/outcomes/gone", nil))
+	assert.Equal(t, 1.0, count("/outcomes/gone", "202", metrics.OutcomeClientDisconnect))
+}
+
+func TestMethods(t *testing.T) {
+	rt := router.New(router.Options{Middleware: []router.Middleware{metrics.Instrument}})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte(r.Method)) })
+	rt.Handle(router.Route{Method: http.MethodPost, Pattern: "/methods/items", Handler: ok})
+	rt.Handle(router.Route{Method: http.MethodGet, Pattern: "/methods/items/{id}", Handler: ok})
+	rt.Handle(router.Route{Method: http.MethodDelete, Pattern: "/methods/items/{id}", Handler: ok})
+	count := func(method, route, status string) float64 {
+		return testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues(method, route, status, metrics.OutcomeCompleted))
+	}
+
+	rec := serve(rt, http.MethodGet, "/methods/items", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "OPTIONS, POST", rec.Header().Get("Allow"))
+	assert.Equal(t, 1.0, count(http.MethodGet, "/methods/items", "405"))
+
+	rec = serve(rt, http.MethodPut, "/methods/items/1", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", rec.Header().Get("Allow"))
+
+	rec = serve(rt, http.MethodOptions, "/methods/items/1", "")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", rec.Header().Get("Allow"))
+
+	assert.Equal(t, "HEAD", serve(rt, http.MethodHead, "/methods/items/1", "").Body.String())
+	assert.Equal(t, "DELETE", serve(rt, http.MethodDelete, "/methods/items/1", "").Body.String())
+
+	assert.Equal(t, http.StatusNotFound, serve(rt, http.MethodGet, "/methods/nope", "").Code)
+	assert.Equal(t, 1.0, count(http.MethodGet, "unmatched", "404"))
+}
@@ -0,0 +1,334 @@
+// Package scheduler delivers messages at a future time. Pending jobs are kept
+// in a Store so that they survive restarts; due jobs are handed to every Sink.
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when cancelling a job that is not pending.
+	ErrNotFound = errors.New("scheduler: job not found")
+	// ErrTooManyPending is returned when scheduling past MaxPending.
+	ErrTooManyPending = errors.New("scheduler: too many pending jobs")
+)
+
+// Job is a message scheduled for delivery.
+type Job struct {
+	ID        string `json:"id"`
+	Msg       string `json:"msg"`
+	Principal string `json:"principal,omitempty"`
+	// Topic, if set, is the pub/sub topic the message is published to.
+	Topic     string    `json:"topic,omitempty"`
+	DueAt     time.Time `json:"due_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Sink receives due jobs.
+type Sink interface {
+	// Name identifies the sink in logs and metrics.
+	Name() string
+	Deliver(ctx context.Context, j Job) error
+}
+
+// Options configures a Scheduler. The callbacks, if set, are called from the
+// scheduler goroutines and must not block.
+type Options struct {
+	// LateAfter is how far past its due time a job may fire before it counts
+	// as late, e.g. when it was due while the server was down.
+	LateAfter time.Duration
+	// MaxPending, if positive, bounds the pending jobs. Jobs already in the
+	// store when the scheduler is created are kept even past it.
+	MaxPending int
+	// OnPending is called with the number of pending jobs whenever it changes.
+	OnPending func(n int)
+	// OnFired is called after a job was handed to the sinks.
+	OnFired func(j Job, lateness time.Duration, late bool)
+	// OnDeliveryError is called when a sink fails to deliver a job.
+	OnDeliveryError func(sink string, j Job, err error)
+}
+
+// Scheduler fires jobs at their due time. Jobs are removed from the store
+// once delivered; jobs in delivery during Stop stay in the store and fire
+// again after a restart, so deliveries are at-least-once.
+type Scheduler struct {
+	store Store
+	sinks []Sink
+	opts  Options
+
+	mu    sync.Mutex
+	queue jobQueue
+	index map[string]*queued
+	wake  chan struct{}
+	// adding counts the jobs being stored by Schedule, not queued yet.
+	adding int
+
+	// ctx is cancelled by Stop to abort deliveries that outlive it.
+	ctx      context.Context
+	cancel   context.CancelFunc
+	started  bool
+	stop     chan struct{}
+	loopDone chan struct{}
+	inflight sync.WaitGroup
+}
+
+// New returns a scheduler for the jobs in store, which it owns from then on.
+// Jobs fire once Start is called.
+func New(store Store, sinks []Sink, opts Options) (*Scheduler, error) {
+	jobs, err := store.List()
+	if err != nil {
+		return nil, fmt.Errorf("load scheduled jobs: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		store:    store,
+		sinks:    sinks,
+		opts:     opts,
+		index:    map[string]*queued{},
+		wake:     make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+		stop:     make(chan struct{}),
+		loopDone: make(chan struct{}),
+	}
+	for _, j := range jobs {
+		s.push(j)
+	}
+	s.pendingChanged()
+	return s, nil
+}
+
+// Start starts firing jobs in a background goroutine.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	go s.loop()
+}
+
+// Schedule stores j and queues it, assigning its ID and creation time. It
+// fails with ErrTooManyPending past MaxPending.
+func (s *Scheduler) Schedule(j Job) (Job, error) {
+	if j.ID == "" {
+		j.ID = newID()
+	}
+	if j.CreatedAt.IsZero() {
+		j.CreatedAt = time.Now().UTC()
+	}
+	s.mu.Lock()
+	if s.opts.MaxPending > 0 && len(s.queue)+s.adding >= s.opts.MaxPending {
+		s.mu.Unlock()
+		return Job{}, ErrTooManyPending
+	}
+	s.adding++
+	s.mu.Unlock()
+
+	err := s.store.Put(j)
+	s.mu.Lock()
+	s.adding--
+	if err == nil {
+		s.push(j)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return Job{}, err
+	}
+	s.pendingChanged()
+	s.notify()
+	return j, nil
+}
+
+// Cancel removes a pending job, or returns ErrNotFound.
+func (s *Scheduler) Cancel(id string) error {
+	s.mu.Lock()
+	q, ok := s.index[id]
+	if ok {
+		heap.Remove(&s.queue, q.index)
+		delete(s.index, id)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return ErrNotFound
+	}
+	s.pendingChanged()
+	return s.store.Delete(id)
+}
+
+// Pending returns the pending jobs, soonest first.
+func (s *Scheduler) Pending() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, len(s.queue))
+	for i, q := range s.queue {
+		jobs[i] = q.job
+	}
+	sortJobs(jobs)
+	return jobs
+}
+
+// Stop stops firing jobs and waits for ongoing deliveries until ctx is done,
+// then aborts them. It closes the store.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+	if started {
+		close(s.stop)
+		<-s.loopDone
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	s.cancel()
+	<-done
+	return errors.Join(err, s.store.Close())
+}
+
+func (s *Scheduler) loop() {
+	defer close(s.loopDone)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		wait := time.Hour
+		if len(s.queue) > 0 {
+			wait = max(time.Until(s.queue[0].job.DueAt), 0)
+		}
+		s.mu.Unlock()
+		timer.Reset(wait)
+
+		select {
+		case <-s.stop:
+			return
+		case <-s.wake:
+		case now := <-timer.C:
+			s.fireDue(now)
+		}
+	}
+}
+
+// fireDue hands the jobs due at now to the sinks.
+func (s *Scheduler) fireDue(now time.Time) {
+	s.mu.Lock()
+	var due []Job
+	for len(s.queue) > 0 && !s.queue[0].job.DueAt.After(now) {
+		q := heap.Pop(&s.queue).(*queued)
+		delete(s.index, q.job.ID)
+		due = append(due, q.job)
+	}
+	s.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+	s.pendingChanged()
+	for _, j := range due {
+		s.inflight.Add(1)
+		go func() {
+			defer s.inflight.Done()
+			s.deliver(j, now)
+		}()
+	}
+}
+
+func (s *Scheduler) deliver(j Job, now time.Time) {
+	for _, sink := range s.sinks {
+		if err := sink.Deliver(s.ctx, j); err != nil && s.opts.OnDeliveryError != nil {
+			s.opts.OnDeliveryError(sink.Name(), j, err)
+		}
+	}
+	if s.ctx.Err() != nil {
+		// Aborted by Stop: keep the job so that it fires after a restart.
+		return
+	}
+	if err := s.store.Delete(j.ID); err != nil && !errors.Is(err, ErrNotFound) && s.opts.OnDeliveryError != nil {
+		s.opts.OnDeliveryError("store", j, err)
+	}
+	if s.opts.OnFired != nil {
+		lateness := max(now.Sub(j.DueAt), 0)
+		s.opts.OnFired(j, lateness, lateness > s.opts.LateAfter)
+	}
+}
+
+// push queues j. Callers hold s.mu, except in New.
+func (s *Scheduler) push(j Job) {
+	if q, ok := s.index[j.ID]; ok {
+		q.job = j
+		heap.Fix(&s.queue, q.index)
+		return
+	}
+	q := &queued{job: j}
+	heap.Push(&s.queue, q)
+	s.index[j.ID] = q
+}
+
+func (s *Scheduler) pendingChanged() {
+	if s.opts.OnPending == nil {
+		return
+	}
+	s.mu.Lock()
+	n := len(s.queue)
+	s.mu.Unlock()
+	s.opts.OnPending(n)
+}
+
+// notify wakes the loop up to recompute its timer.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func newID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// queued is a job in the queue, a min-heap on the due time.
+type queued struct {
+	job   Job
+	index int
+}
+
+type jobQueue []*queued
+
+func (q jobQueue) Len() int           { return len(q) }
+func (q jobQueue) Less(i, j int) bool { return q[i].job.DueAt.Before(q[j].job.DueAt) }
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+
+func (q *jobQueue) Push(x any) {
+	it := x.(*queued)
+	it.index = len(*q)
+	*q = append(*q, it)
+}
+
+func (q *jobQueue) Pop() any {
+	old := *q
+	it := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return it
+}
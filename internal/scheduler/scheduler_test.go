@@ -0,0 +1,177 @@
+package scheduler_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/pubsub"
+	"github.com/marcosartorato/myapp/internal/scheduler"
+)
+
+// firedLog records the OnFired calls.
+type firedLog struct {
+	mu   sync.Mutex
+	late map[string]bool
+}
+
+func (f *firedLog) onFired(j scheduler.Job, _ time.Duration, late bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.late[j.ID] = late
+}
+
+func (f *firedLog) get(id string) (late, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	late, ok = f.late[id]
+	return late, ok
+}
+
+// chanSink hands due jobs to a channel.
+type chanSink chan scheduler.Job
+
+func (chanSink) Name() string { return "chan" }
+
+func (s chanSink) Deliver(_ context.Context, j scheduler.Job) error {
+	s <- j
+	return nil
+}
+
+func receive(t *testing.T, ch <-chan scheduler.Job) scheduler.Job {
+	t.Helper()
+	select {
+	case j := <-ch:
+		return j
+	case <-time.After(2 * time.Second):
+		t.Fatal("no job delivered")
+		return scheduler.Job{}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	ch := make(chanSink, 10)
+	fired := &firedLog{late: map[string]bool{}}
+	var pending int
+	var mu sync.Mutex
+	s, err := scheduler.New(scheduler.NewMemoryStore(), []scheduler.Sink{ch}, scheduler.Options{
+		LateAfter: time.Second,
+		OnFired:   fired.onFired,
+		OnPending: func(n int) { mu.Lock(); pending = n; mu.Unlock() },
+	})
+	require.NoError(t, err)
+	s.Start()
+	defer func() { assert.NoError(t, s.Stop(context.Background())) }()
+
+	now := time.Now()
+	later, err := s.Schedule(scheduler.Job{Msg: "later", DueAt: now.Add(time.Hour)})
+	require.NoError(t, err)
+	soon, err := s.Schedule(scheduler.Job{Msg: "soon", DueAt: now.Add(50 * time.Millisecond)})
+	require.NoError(t, err)
+	cancelled, err := s.Schedule(scheduler.Job{Msg: "cancelled", DueAt: now.Add(20 * time.Millisecond)})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{cancelled.ID, soon.ID, later.ID}, ids(s.Pending()))
+	require.NoError(t, s.Cancel(cancelled.ID))
+	assert.ErrorIs(t, s.Cancel(cancelled.ID), scheduler.ErrNotFound)
+
+	j := receive(t, ch)
+	assert.Equal(t, soon.ID, j.ID)
+	assert.Equal(t, "soon", j.Msg)
+	assert.Eventually(t, func() bool { _, ok := fired.get(soon.ID); return ok }, time.Second, 10*time.Millisecond)
+	late, _ := fired.get(soon.ID)
+	assert.False(t, late)
+
+	assert.Equal(t, []string{later.ID}, ids(s.Pending()))
+	mu.Lock()
+	assert.Equal(t, 1, pending)
+	mu.Unlock()
+}
+
+func TestSchedulerSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedule.db")
+
+	// Schedule without running: the server stops before the job is due.
+	store, err := scheduler.OpenBoltStore(path)
+	require.NoError(t, err)
+	s, err := scheduler.New(store, nil, scheduler.Options{})
+	require.NoError(t, err)
+	job, err := s.Schedule(scheduler.Job{Msg: "while down", DueAt: time.Now().Add(-2 * time.Second)})
+	require.NoError(t, err)
+	require.NoError(t, s.Stop(context.Background()))
+
+	store, err = scheduler.OpenBoltStore(path)
+	require.NoError(t, err)
+	ch := make(chanSink, 1)
+	fired := &firedLog{late: map[string]bool{}}
+	s, err = scheduler.New(store, []scheduler.Sink{ch}, scheduler.Options{LateAfter: time.Second, OnFired: fired.onFired})
+	require.NoError(t, err)
+	assert.Equal(t, []string{job.ID}, ids(s.Pending()))
+	s.Start()
+
+	assert.Equal(t, job.ID, receive(t, ch).ID)
+	assert.Eventually(t, func() bool { _, ok := fired.get(job.ID); return ok }, time.Second, 10*time.Millisecond)
+	late, _ := fired.get(job.ID)
+	assert.True(t, late, "a job due while the server was down is late")
+	require.NoError(t, s.Stop(context.Background()))
+
+	// Delivered jobs are gone from the store.
+	store, err = scheduler.OpenBoltStore(path)
+	require.NoError(t, err)
+	defer func() { _ = store.Close() }()
+	jobs, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, jobs)
+}
+
+func ids(jobs []scheduler.Job) []string {
+	out := make([]string, len(jobs))
+	for i, j := range jobs {
+		out[i] = j.ID
+	}
+	return out
+}
+
+func TestSchedulerMaxPending(t *testing.T) {
+	s, err := scheduler.New(scheduler.NewMemoryStore(), nil, scheduler.Options{MaxPending: 2})
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, s.Stop(context.Background())) }()
+
+	due := time.Now().Add(time.Hour)
+	first, err := s.Schedule(scheduler.Job{Msg: "one", DueAt: due})
+	require.NoError(t, err)
+	_, err = s.Schedule(scheduler.Job{Msg: "two", DueAt: due})
+	require.NoError(t, err)
+	_, err = s.Schedule(scheduler.Job{Msg: "three", DueAt: due})
+	assert.ErrorIs(t, err, scheduler.ErrTooManyPending)
+	assert.Len(t, s.Pending(), 2)
+
+	require.NoError(t, s.Cancel(first.ID))
+	_, err = s.Schedule(scheduler.Job{Msg: "three", DueAt: due})
+	assert.NoError(t, err)
+}
+
+func TestTopicSink(t *testing.T) {
+	broker := pubsub.NewBroker(pubsub.Options{})
+	defer broker.Close()
+	sub, err := broker.Subscribe("reminders", "", 10, pubsub.DropOldest)
+	require.NoError(t, err)
+	var published []int
+	sink := scheduler.TopicSink{Broker: broker, OnPublish: func(n int) { published = append(published, n) }}
+
+	require.NoError(t, sink.Deliver(context.Background(), scheduler.Job{Msg: "no topic"}))
+	require.NoError(t, sink.Deliver(context.Background(), scheduler.Job{Msg: "due", Principal: "alice", Topic: "reminders"}))
+	require.NoError(t, sink.Deliver(context.Background(), scheduler.Job{Msg: "nobody", Topic: "other"}))
+
+	m := <-sub.C()
+	assert.Equal(t, "reminders", m.Topic)
+	assert.Equal(t, "due", m.Msg)
+	assert.Equal(t, "alice", m.Principal)
+	assert.Len(t, sub.C(), 0)
+	assert.Equal(t, []int{1, 0}, published)
+}
@@ -0,0 +1,92 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/marcosartorato/myapp/internal/pubsub"
+)
+
+// LogSink logs due jobs.
+type LogSink struct {
+	Logger *zap.Logger
+}
+
+// Name implements Sink.
+func (LogSink) Name() string { return "log" }
+
+// Deliver implements Sink.
+func (s LogSink) Deliver(_ context.Context, j Job) error {
+	s.Logger.Info("scheduled message due",
+		zap.String("id", j.ID),
+		zap.String("msg", j.Msg),
+		zap.String("principal", j.Principal),
+		zap.String("topic", j.Topic),
+		zap.Time("due_at", j.DueAt),
+	)
+	return nil
+}
+
+// WebhookSink POSTs due jobs as JSON to URL; any non-2xx status is an error.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// Name implements Sink.
+func (WebhookSink) Name() string { return "webhook" }
+
+// Deliver implements Sink.
+func (s WebhookSink) Deliver(ctx context.Context, j Job) error {
+	body, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// TopicSink publishes due jobs with a Topic to the subscribers of that topic;
+// jobs without one are skipped.
+type TopicSink struct {
+	Broker *pubsub.Broker
+	// OnPublish, if set, is called with the number of subscribers a job was
+	// published to.
+	OnPublish func(subscribers int)
+}
+
+// Name implements Sink.
+func (TopicSink) Name() string { return "topic" }
+
+// Deliver implements Sink. It never blocks: the broker applies the slow
+// consumer policy of each subscriber.
+func (s TopicSink) Deliver(_ context.Context, j Job) error {
+	if j.Topic == "" {
+		return nil
+	}
+	n := s.Broker.Publish(pubsub.Message{Topic: j.Topic, Msg: j.Msg, Principal: j.Principal, PublishedAt: time.Now().UTC()})
+	if s.OnPublish != nil {
+		s.OnPublish(n)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store persists pending jobs.
+type Store interface {
+	Put(j Job) error
+	// Delete removes a job, or returns ErrNotFound.
+	Delete(id string) error
+	// List returns all the jobs, in no particular order.
+	List() ([]Job, error)
+	Close() error
+}
+
+// OpenStore returns a bbolt-backed store at path, or an in-memory store when
+// path is empty.
+func OpenStore(path string) (Store, error) {
+	if path == "" {
+		return NewMemoryStore(), nil
+	}
+	return OpenBoltStore(path)
+}
+
+// MemoryStore is an in-memory Store; jobs are lost on restart.
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: map[string]Job{}}
+}
+
+// Put implements Store.
+func (s *MemoryStore) Put(j Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[j.ID] = j
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.jobs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.jobs, id)
+	return nil
+}
+
+// List implements Store.
+func (s *MemoryStore) List() ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// Close implements Store.
+func (s *MemoryStore) Close() error { return nil }
+
+var jobsBucket = []byte("jobs")
+
+// BoltStore is a Store kept in a bbolt database file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens (creating it if needed) the database file at path.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open schedule store: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("open schedule store: %w", err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Put implements Store.
+func (s *BoltStore) Put(j Job) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(j.ID), data)
+	})
+}
+
+// Delete implements Store.
+func (s *BoltStore) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(jobsBucket)
+		if b.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// List implements Store.
+func (s *BoltStore) List() ([]Job, error) {
+	var jobs []Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var j Job
+			if err := json.Unmarshal(v, &j); err != nil {
+				return fmt.Errorf("decode job %s: %w", k, err)
+			}
+			jobs = append(jobs, j)
+			return nil
+		})
+	})
+	return jobs, err
+}
+
+// Close implements Store.
+func (s *BoltStore) Close() error { return s.db.Close() }
+
+// sortJobs sorts jobs soonest first.
+func sortJobs(jobs []Job) {
+	slices.SortFunc(jobs, func(a, b Job) int { return a.DueAt.Compare(b.DueAt) })
+}
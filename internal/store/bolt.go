@@ -0,0 +1,118 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var messagesBucket = []byte("messages")
+
+// Bolt is a MessageStore kept in a bbolt database file. Messages are keyed by
+// ID, so listing walks the keys backwards from the cursor.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens (creating it if needed) the database file at path.
+// The file is locked; a second process opening it waits up to a second.
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open message store: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(messagesBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("open message store: %w", err)
+	}
+	return &Bolt{db: db}, nil
+}
+
+// Save implements MessageStore.
+func (s *Bolt) Save(_ context.Context, m *Message) error {
+	prepare(m)
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(messagesBucket).Put([]byte(m.ID), data)
+	})
+}
+
+// Get implements MessageStore.
+func (s *Bolt) Get(_ context.Context, id string) (Message, error) {
+	var m Message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(messagesBucket).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, &m)
+	})
+	return m, err
+}
+
+// List implements MessageStore.
+func (s *Bolt) List(ctx context.Context, q Query) (Page, error) {
+	var page Page
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(messagesBucket).Cursor()
+		var k, v []byte
+		if q.Cursor == "" {
+			k, v = c.Last()
+		} else {
+			// Seek lands on the cursor or the first key after it (if any);
+			// the page starts right before.
+			if k, _ = c.Seek([]byte(q.Cursor)); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+		for ; k != nil; k, v = c.Prev() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var m Message
+			if err := json.Unmarshal(v, &m); err != nil {
+				return fmt.Errorf("decode message %s: %w", k, err)
+			}
+			ok, more := q.match(&m)
+			if !more {
+				break
+			}
+			if !ok {
+				continue
+			}
+			if len(page.Messages) == q.Limit {
+				page.NextCursor = page.Messages[len(page.Messages)-1].ID
+				break
+			}
+			page.Messages = append(page.Messages, m)
+		}
+		return nil
+	})
+	return page, err
+}
+
+// Delete implements MessageStore.
+func (s *Bolt) Delete(_ context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(messagesBucket)
+		if b.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// Close implements MessageStore.
+func (s *Bolt) Close() error { return s.db.Close() }
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Memory is an in-memory MessageStore; messages are lost on restart.
+type Memory struct {
+	max      int
+	mu       sync.RWMutex
+	ids      []string // sorted
+	messages map[string]Message
+}
+
+// NewMemory returns an empty in-memory store keeping at most max messages,
+// the oldest being removed to make room; zero means no limit.
+func NewMemory(max int) *Memory {
+	return &Memory{max: max, messages: map[string]Message{}}
+}
+
+// Save implements MessageStore.
+func (s *Memory) Save(_ context.Context, m *Message) error {
+	prepare(m)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.messages[m.ID]; !ok {
+		i, _ := slices.BinarySearch(s.ids, m.ID)
+		s.ids = slices.Insert(s.ids, i, m.ID)
+	}
+	s.messages[m.ID] = *m
+	if s.max > 0 && len(s.ids) > s.max {
+		// IDs sort by creation time.
+		for _, id := range s.ids[:len(s.ids)-s.max] {
+			delete(s.messages, id)
+		}
+		s.ids = slices.Delete(s.ids, 0, len(s.ids)-s.max)
+	}
+	return nil
+}
+
+// Get implements MessageStore.
+func (s *Memory) Get(_ context.Context, id string) (Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.messages[id]
+	if !ok {
+		return Message{}, ErrNotFound
+	}
+	return m, nil
+}
+
+// List implements MessageStore.
+func (s *Memory) List(_ context.Context, q Query) (Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := len(s.ids)
+	if q.Cursor != "" {
+		i, _ = slices.BinarySearch(s.ids, q.Cursor)
+	}
+	var page Page
+	for i--; i >= 0; i-- {
+		m := s.messages[s.ids[i]]
+		ok, more := q.match(&m)
+		if !more {
+			break
+		}
+		if !ok {
+			continue
+		}
+		if len(page.Messages) == q.Limit {
+			page.NextCursor = page.Messages[len(page.Messages)-1].ID
+			break
+		}
+		page.Messages = append(page.Messages, m)
+	}
+	return page, nil
+}
+
+// Delete implements MessageStore.
+func (s *Memory) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.messages[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.messages, id)
+	i, _ := slices.BinarySearch(s.ids, id)
+	s.ids = slices.Delete(s.ids, i, i+1)
+	return nil
+}
+
+// Close implements MessageStore.
+func (s *Memory) Close() error { return nil }
@@ -0,0 +1,107 @@
+// Package store persists the messages handled by the app server.
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when a message does not exist.
+var ErrNotFound = errors.New("store: message not found")
+
+// Message is a stored message.
+type Message struct {
+	ID        string          `json:"id" doc:"Opaque identifier; IDs sort by creation time."`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload" doc:"The request as sent."`
+	Principal string          `json:"principal,omitempty" doc:"Authenticated sender, if any."`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Query selects messages, newest first.
+type Query struct {
+	// Type, if set, only matches messages of that type.
+	Type string
+	// Since (inclusive) and Until (exclusive) bound CreatedAt when set.
+	Since, Until time.Time
+	// Cursor continues a previous listing from its NextCursor.
+	Cursor string
+	// Limit is the maximum page size; it must be positive.
+	Limit int
+}
+
+// Page is a page of messages. NextCursor is empty on the last page.
+type Page struct {
+	Messages   []Message
+	NextCursor string
+}
+
+// MessageStore stores messages. Implementations are safe for concurrent use.
+type MessageStore interface {
+	// Save stores m, assigning its ID when empty.
+	Save(ctx context.Context, m *Message) error
+	// Get returns the message with the given ID, or ErrNotFound.
+	Get(ctx context.Context, id string) (Message, error)
+	// List returns the messages matching q, newest first.
+	List(ctx context.Context, q Query) (Page, error)
+	// Delete removes the message with the given ID, or returns ErrNotFound.
+	Delete(ctx context.Context, id string) error
+	Close() error
+}
+
+// Open returns a bbolt-backed store at path, or an in-memory store keeping
+// at most maxMemory messages when path is empty.
+func Open(path string, maxMemory int) (MessageStore, error) {
+	if path == "" {
+		return NewMemory(maxMemory), nil
+	}
+	return OpenBolt(path)
+}
+
+var ids struct {
+	mu   sync.Mutex
+	last int64
+}
+
+// newID returns an ID for a message created at t. IDs are 32 hex digits: a
+// strictly increasing nanosecond timestamp followed by random bits, so that
+// their byte order is their creation order.
+func newID(t time.Time) string {
+	ids.mu.Lock()
+	ns := max(t.UnixNano(), ids.last+1)
+	ids.last = ns
+	ids.mu.Unlock()
+
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(ns))
+	_, _ = rand.Read(b[8:])
+	return hex.EncodeToString(b[:])
+}
+
+// prepare fills in the ID and creation time of a message being saved.
+func prepare(m *Message) {
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now().UTC()
+	}
+	if m.ID == "" {
+		m.ID = newID(m.CreatedAt)
+	}
+}
+
+// match reports whether m is selected by q, and whether messages older than
+// m can still be; the scan stops once they cannot.
+func (q Query) match(m *Message) (ok, more bool) {
+	if !q.Since.IsZero() && m.CreatedAt.Before(q.Since) {
+		return false, false
+	}
+	if !q.Until.IsZero() && !m.CreatedAt.Before(q.Until) {
+		return false, true
+	}
+	return q.Type == "" || m.Type == q.Type, true
+}
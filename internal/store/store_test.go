@@ -0,0 +1,128 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/store"
+)
+
+func TestMessageStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) store.MessageStore{
+		"memory": func(*testing.T) store.MessageStore { return store.NewMemory(0) },
+		"bolt": func(t *testing.T) store.MessageStore {
+			s, err := store.OpenBolt(filepath.Join(t.TempDir(), "messages.db"))
+			require.NoError(t, err)
+			return s
+		},
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			defer func() { assert.NoError(t, s.Close()) }()
+			testMessageStore(t, s)
+		})
+	}
+}
+
+func testMessageStore(t *testing.T, s store.MessageStore) {
+	ctx := context.Background()
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Five messages a minute apart, alternating types.
+	var ids []string
+	for i := range 5 {
+		typ := "repeat"
+		if i%2 == 1 {
+			typ = "time"
+		}
+		m := &store.Message{Type: typ, Payload: json.RawMessage(`{"type":"` + typ + `"}`), Principal: "alice", CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		require.NoError(t, s.Save(ctx, m))
+		require.NotEmpty(t, m.ID)
+		ids = append(ids, m.ID)
+	}
+
+	got, err := s.Get(ctx, ids[2])
+	require.NoError(t, err)
+	assert.Equal(t, "repeat", got.Type)
+	assert.Equal(t, "alice", got.Principal)
+	assert.JSONEq(t, `{"type":"repeat"}`, string(got.Payload))
+	assert.True(t, base.Add(2*time.Minute).Equal(got.CreatedAt))
+
+	listIDs := func(q store.Query) ([]string, string) {
+		t.Helper()
+		page, err := s.List(ctx, q)
+		require.NoError(t, err)
+		var ids []string
+		for _, m := range page.Messages {
+			ids = append(ids, m.ID)
+		}
+		return ids, page.NextCursor
+	}
+
+	// Pages of two, newest first.
+	page, cursor := listIDs(store.Query{Limit: 2})
+	assert.Equal(t, []string{ids[4], ids[3]}, page)
+	page, cursor = listIDs(store.Query{Limit: 2, Cursor: cursor})
+	assert.Equal(t, []string{ids[2], ids[1]}, page)
+	page, cursor = listIDs(store.Query{Limit: 2, Cursor: cursor})
+	assert.Equal(t, []string{ids[0]}, page)
+	assert.Empty(t, cursor)
+
+	page, _ = listIDs(store.Query{Limit: 10, Type: "time"})
+	assert.Equal(t, []string{ids[3], ids[1]}, page)
+
+	page, _ = listIDs(store.Query{Limit: 10, Since: base.Add(time.Minute), Until: base.Add(3 * time.Minute)})
+	assert.Equal(t, []string{ids[2], ids[1]}, page)
+
+	require.NoError(t, s.Delete(ctx, ids[2]))
+	_, err = s.Get(ctx, ids[2])
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	assert.ErrorIs(t, s.Delete(ctx, ids[2]), store.ErrNotFound)
+
+	// A deleted cursor still works.
+	page, _ = listIDs(store.Query{Limit: 10, Cursor: ids[2]})
+	assert.Equal(t, []string{ids[1], ids[0]}, page)
+}
+
+func TestMemoryMax(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory(2)
+	var ids []string
+	for range 3 {
+		m := &store.Message{Type: "repeat", Payload: json.RawMessage(`{}`)}
+		require.NoError(t, s.Save(ctx, m))
+		ids = append(ids, m.ID)
+	}
+
+	// The oldest message made room for the newest.
+	_, err := s.Get(ctx, ids[0])
+	assert.ErrorIs(t, err, store.ErrNotFound)
+	page, err := s.List(ctx, store.Query{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, page.Messages, 2)
+	assert.Equal(t, ids[2], page.Messages[0].ID)
+	assert.Equal(t, ids[1], page.Messages[1].ID)
+}
+
+func TestBoltPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	s, err := store.OpenBolt(path)
+	require.NoError(t, err)
+	m := &store.Message{Type: "repeat", Payload: json.RawMessage(`{}`)}
+	require.NoError(t, s.Save(context.Background(), m))
+	require.NoError(t, s.Close())
+
+	s, err = store.OpenBolt(path)
+	require.NoError(t, err)
+	defer func() { _ = s.Close() }()
+	got, err := s.Get(context.Background(), m.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "repeat", got.Type)
+}
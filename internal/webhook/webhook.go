@@ -0,0 +1,338 @@
+// Package webhook notifies downstream systems of processed messages.
+//
+// Each message is POSTed as a JSON Event to the URLs subscribed to its type,
+// signed with the pkg/hmacsign scheme. Failed attempts are retried with
+// exponential backoff and jitter; deliveries that keep failing end up in a
+// dead-letter list from which they can be replayed.
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcosartorato/myapp/internal/retry"
+	"github.com/marcosartorato/myapp/pkg/hmacsign"
+)
+
+// Headers set on deliveries, besides the hmacsign ones.
+const (
+	// HeaderDelivery identifies the delivery; it is the same for all attempts
+	// and replays, so receivers can use it to drop duplicates.
+	HeaderDelivery = "X-Webhook-Delivery"
+	// HeaderAttempt is the attempt number, starting at 1.
+	HeaderAttempt = "X-Webhook-Attempt"
+)
+
+// AllTypes subscribes a URL to every message type.
+const AllTypes = "*"
+
+// ErrNotFound is returned when replaying a delivery that is not dead-lettered.
+var ErrNotFound = errors.New("webhook: dead letter not found")
+
+// Event is the body of a delivery.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	MessageID string          `json:"message_id,omitempty" doc:"ID of the stored message, if stored."`
+	Payload   json.RawMessage `json:"payload" doc:"The message as sent."`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Delivery is an Event on its way to one URL.
+type Delivery struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Event      Event     `json:"event"`
+	Attempts   int       `json:"attempts"`
+	LastStatus int       `json:"last_status,omitempty" doc:"Status of the last attempt; absent when no response was received."`
+	LastError  string    `json:"last_error"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+// Options configures a Dispatcher. The callbacks, if set, are called from
+// the delivery goroutines and must not block.
+type Options struct {
+	// Subscriptions maps message types, or AllTypes, to URLs.
+	Subscriptions map[string][]string
+	// KeyID and Secret sign the deliveries; they are required when there are
+	// subscriptions.
+	KeyID  string
+	Secret []byte
+	// MaxAttempts is the number of attempts before a delivery is
+	// dead-lettered; 5 when zero.
+	MaxAttempts int
+	// Backoff and MaxBackoff are the initial and maximum retry delays of a
+	// retry.Backoff; 1s and 1m when zero.
+	Backoff, MaxBackoff time.Duration
+	// Client sends the deliveries; a client with a 10s timeout when nil.
+	Client *http.Client
+	// MaxDeadLetters bounds the dead-letter list, the oldest dead letters
+	// being dropped to make room; 1000 when zero.
+	MaxDeadLetters int
+
+	// OnAttempt is called after each attempt, with a nil error on success.
+	OnAttempt func(d Delivery, elapsed time.Duration, err error)
+	// OnDone is called once a delivery succeeded or was dead-lettered.
+	OnDone func(d Delivery, delivered bool)
+	// OnDeadLetters is called with the size of the dead-letter list whenever
+	// it changes.
+	OnDeadLetters func(n int)
+}
+
+// Dispatcher delivers events in background goroutines. Dead letters are kept
+// in memory, up to MaxDeadLetters, and deliveries still being retried at
+// Close are dropped.
+type Dispatcher struct {
+	opts Options
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	inflight sync.WaitGroup
+
+	mu   sync.Mutex
+	dead []Delivery // oldest first
+}
+
+// New returns a dispatcher for opts.
+func New(opts Options) (*Dispatcher, error) {
+	if len(opts.Subscriptions) > 0 && (opts.KeyID == "" || len(opts.Secret) == 0) {
+		return nil, errors.New("webhook: a signing key is required")
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Minute
+	}
+	opts.MaxBackoff = max(opts.MaxBackoff, opts.Backoff)
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.MaxDeadLetters <= 0 {
+		opts.MaxDeadLetters = 1000
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{opts: opts, ctx: ctx, cancel: cancel}, nil
+}
+
+// Notify delivers an event for a processed message to the URLs subscribed
+// to its type. It does not wait for the deliveries.
+func (d *Dispatcher) Notify(typ, messageID string, payload []byte) {
+	urls := slices.Concat(d.opts.Subscriptions[typ], d.opts.Subscriptions[AllTypes])
+	if len(urls) == 0 {
+		return
+	}
+	ev := Event{ID: newID(), Type: typ, MessageID: messageID, Payload: payload, CreatedAt: time.Now().UTC()}
+	for _, url := range urls {
+		d.start(Delivery{ID: newID(), URL: url, Event: ev})
+	}
+}
+
+// DeadLetters returns the failed deliveries, oldest first.
+func (d *Dispatcher) DeadLetters() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]Delivery(nil), d.dead...)
+}
+
+// Replay removes the dead letter with the given delivery ID from the list and
+// delivers it again, with a fresh set of attempts.
+func (d *Dispatcher) Replay(id string) error {
+	d.mu.Lock()
+	i := d.indexOf(id)
+	if i < 0 {
+		d.mu.Unlock()
+		return ErrNotFound
+	}
+	dl := d.dead[i]
+	d.dead = append(d.dead[:i], d.dead[i+1:]...)
+	n := len(d.dead)
+	d.mu.Unlock()
+	d.deadLettersChanged(n)
+
+	dl.Attempts, dl.LastStatus, dl.LastError, dl.FailedAt = 0, 0, "", time.Time{}
+	d.start(dl)
+	return nil
+}
+
+// Close stops retrying and waits for ongoing attempts until ctx is done.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	d.cancel()
+	d.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// start delivers dl in a new goroutine, unless the dispatcher is closed.
+func (d *Dispatcher) start(dl Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ctx.Err() != nil {
+		return
+	}
+	d.inflight.Add(1)
+	go func() {
+		defer d.inflight.Done()
+		d.run(dl)
+	}()
+}
+
+// run attempts dl until it succeeds, fails permanently or runs out of attempts.
+func (d *Dispatcher) run(dl Delivery) {
+	for {
+		dl.Attempts++
+		start := time.Now()
+		status, err := d.attempt(dl)
+		if d.opts.OnAttempt != nil {
+			d.opts.OnAttempt(dl, time.Since(start), err)
+		}
+		if err == nil {
+			if d.opts.OnDone != nil {
+				d.opts.OnDone(dl, true)
+			}
+			return
+		}
+		dl.LastStatus, dl.LastError = status, err.Error()
+		if d.ctx.Err() != nil {
+			return
+		}
+		if !retry.Retryable(status) || dl.Attempts >= d.opts.MaxAttempts {
+			d.deadLetter(dl)
+			return
+		}
+		timer := time.NewTimer(retry.Backoff{Initial: d.opts.Backoff, Max: d.opts.MaxBackoff}.Delay(dl.Attempts))
+		select {
+		case <-timer.C:
+		case <-d.ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// attempt sends dl once, returning the response status if there was one.
+func (d *Dispatcher) attempt(dl Delivery) (int, error) {
+	body, err := json.Marshal(dl.Event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, dl.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderDelivery, dl.ID)
+	req.Header.Set(HeaderAttempt, strconv.Itoa(dl.Attempts))
+	if err := hmacsign.Sign(req, d.opts.KeyID, d.opts.Secret, time.Now()); err != nil {
+		return 0, err
+	}
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) deadLetter(dl Delivery) {
+	dl.FailedAt = time.Now().UTC()
+	d.mu.Lock()
+	d.dead = append(d.dead, dl)
+	if over := len(d.dead) - d.opts.MaxDeadLetters; over > 0 {
+		d.dead = slices.Delete(d.dead, 0, over)
+	}
+	n := len(d.dead)
+	d.mu.Unlock()
+	d.deadLettersChanged(n)
+	if d.opts.OnDone != nil {
+		d.opts.OnDone(dl, false)
+	}
+}
+
+func (d *Dispatcher) deadLettersChanged(n int) {
+	if d.opts.OnDeadLetters != nil {
+		d.opts.OnDeadLetters(n)
+	}
+}
+
+// indexOf returns the index of the dead letter with the given ID, or -1.
+// Callers hold d.mu.
+func (d *Dispatcher) indexOf(id string) int {
+	for i, dl := range d.dead {
+		if dl.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// LoadSigningKey reads the key signing the deliveries from the file at path,
+// holding a single line
+//
+//	<key id> <hex secret>
+//
+// Blank lines and lines starting with '#' are ignored.
+func LoadSigningKey(path string) (keyID string, secret []byte, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = f.Close() }()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return "", nil, errors.New("webhook signing key: want <key id> <hex secret>")
+		}
+		secret, err := hex.DecodeString(fields[1])
+		if err != nil || len(secret) < 16 {
+			return "", nil, errors.New("webhook signing key: secret must be at least 16 hex-encoded bytes")
+		}
+		return fields[0], secret, nil
+	}
+	if err := sc.Err(); err != nil {
+		return "", nil, err
+	}
+	return "", nil, errors.New("webhook signing key: no key in file")
+}
+
+func newID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
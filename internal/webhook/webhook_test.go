@@ -0,0 +1,205 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/marcosartorato/myapp/internal/webhook"
+	"github.com/marcosartorato/myapp/pkg/hmacsign"
+)
+
+var secret = []byte("0123456789abcdef")
+
+// receiver is an httptest webhook receiver answering with the statuses in
+// order, then 204.
+type receiver struct {
+	*httptest.Server
+	t *testing.T
+
+	mu       sync.Mutex
+	statuses []int
+	events   []webhook.Event
+	attempts []string
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	rv := &receiver{t: t, statuses: statuses}
+	rv.Server = httptest.NewServer(http.HandlerFunc(rv.serve))
+	t.Cleanup(rv.Close)
+	return rv
+}
+
+func (rv *receiver) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	keyID, sig, err := hmacsign.ParseSignature(r.Header.Get(hmacsign.HeaderSignature))
+	assert.NoError(rv.t, err)
+	assert.Equal(rv.t, "k1", keyID)
+	digest := hmacsign.BodyDigest(body)
+	assert.Equal(rv.t, digest, r.Header.Get(hmacsign.HeaderDigest))
+	want := hmacsign.Compute(secret, hmacsign.StringToSign(r.Method, r.URL.RequestURI(), r.Header.Get(hmacsign.HeaderTimestamp), digest))
+	assert.Equal(rv.t, want, sig, "signature")
+
+	var ev webhook.Event
+	assert.NoError(rv.t, json.Unmarshal(body, &ev))
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	rv.events = append(rv.events, ev)
+	rv.attempts = append(rv.attempts, r.Header.Get(webhook.HeaderAttempt))
+	status := http.StatusNoContent
+	if len(rv.statuses) > 0 {
+		status, rv.statuses = rv.statuses[0], rv.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (rv *receiver) received() ([]webhook.Event, []string) {
+	rv.mu.Lock()
+	defer rv.mu.Unlock()
+	return append([]webhook.Event(nil), rv.events...), append([]string(nil), rv.attempts...)
+}
+
+func newDispatcher(t *testing.T, subs map[string][]string, done chan<- bool) *webhook.Dispatcher {
+	t.Helper()
+	d, err := webhook.New(webhook.Options{
+		Subscriptions: subs,
+		KeyID:         "k1",
+		Secret:        secret,
+		MaxAttempts:   3,
+		Backoff:       time.Millisecond,
+		MaxBackoff:    5 * time.Millisecond,
+		OnDone:        func(_ webhook.Delivery, delivered bool) { done <- delivered },
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, d.Close(context.Background())) })
+	return d
+}
+
+func wait(t *testing.T, done <-chan bool) bool {
+	t.Helper()
+	select {
+	case delivered := <-done:
+		return delivered
+	case <-time.After(2 * time.Second):
+		t.Fatal("delivery not done")
+		return false
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	rv := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	other := newReceiver(t)
+	done := make(chan bool, 2)
+	d := newDispatcher(t, map[string][]string{"repeat": {rv.URL}, webhook.AllTypes: {other.URL}}, done)
+
+	d.Notify("repeat", "m1", []byte(`{"type":"repeat","msg":"hi"}`))
+	assert.True(t, wait(t, done))
+	assert.True(t, wait(t, done))
+
+	events, attempts := rv.received()
+	assert.Equal(t, []string{"1", "2", "3"}, attempts)
+	require.Len(t, events, 3)
+	assert.Equal(t, "repeat", events[0].Type)
+	assert.Equal(t, "m1", events[0].MessageID)
+	assert.JSONEq(t, `{"type":"repeat","msg":"hi"}`, string(events[0].Payload))
+	otherEvents, _ := other.received()
+	require.Len(t, otherEvents, 1)
+	assert.Equal(t, events[0].ID, otherEvents[0].ID, "one event for all subscribers")
+
+	d.Notify("time", "", []byte(`{"type":"time"}`))
+	assert.True(t, wait(t, done))
+	events, _ = rv.received()
+	assert.Len(t, events, 3, "not subscribed to time")
+	assert.Empty(t, d.DeadLetters())
+}
+
+func TestDeadLetters(t *testing.T) {
+	rv := newReceiver(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusBadRequest)
+	done := make(chan bool, 1)
+	d := newDispatcher(t, map[string][]string{"repeat": {rv.URL}}, done)
+
+	// Out of attempts.
+	d.Notify("repeat", "m1", []byte(`{}`))
+	assert.False(t, wait(t, done))
+	// Permanent failure: not retried.
+	d.Notify("repeat", "m2", []byte(`{}`))
+	assert.False(t, wait(t, done))
+
+	dead := d.DeadLetters()
+	require.Len(t, dead, 2)
+	assert.Equal(t, "m1", dead[0].Event.MessageID)
+	assert.Equal(t, 3, dead[0].Attempts)
+	assert.Equal(t, http.StatusInternalServerError, dead[0].LastStatus)
+	assert.Equal(t, "m2", dead[1].Event.MessageID)
+	assert.Equal(t, 1, dead[1].Attempts)
+	assert.Equal(t, http.StatusBadRequest, dead[1].LastStatus)
+	assert.NotEmpty(t, dead[1].LastError)
+
+	require.NoError(t, d.Replay(dead[0].ID))
+	assert.True(t, wait(t, done))
+	assert.ErrorIs(t, d.Replay(dead[0].ID), webhook.ErrNotFound)
+	remaining := d.DeadLetters()
+	require.Len(t, remaining, 1)
+	assert.Equal(t, dead[1].ID, remaining[0].ID)
+
+	events, _ := rv.received()
+	assert.Len(t, events, 5)
+}
+
+func TestMaxDeadLetters(t *testing.T) {
+	rv := newReceiver(t, http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest)
+	done := make(chan bool, 1)
+	var sizes []int
+	d, err := webhook.New(webhook.Options{
+		Subscriptions:  map[string][]string{"repeat": {rv.URL}},
+		KeyID:          "k1",
+		Secret:         secret,
+		MaxDeadLetters: 2,
+		OnDone:         func(_ webhook.Delivery, delivered bool) { done <- delivered },
+		OnDeadLetters:  func(n int) { sizes = append(sizes, n) },
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, d.Close(context.Background())) })
+
+	for _, id := range []string{"m1", "m2", "m3"} {
+		d.Notify("repeat", id, []byte(`{}`))
+		assert.False(t, wait(t, done))
+	}
+
+	// The oldest dead letter made room for the newest.
+	dead := d.DeadLetters()
+	require.Len(t, dead, 2)
+	assert.Equal(t, "m2", dead[0].Event.MessageID)
+	assert.Equal(t, "m3", dead[1].Event.MessageID)
+	assert.Equal(t, []int{1, 2, 2}, sizes)
+}
+
+func TestSigningKeyRequired(t *testing.T) {
+	_, err := webhook.New(webhook.Options{Subscriptions: map[string][]string{"repeat": {"http://example.com"}}})
+	assert.Error(t, err)
+	_, err = webhook.New(webhook.Options{})
+	assert.NoError(t, err)
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	require.NoError(t, os.WriteFile(path, []byte("# webhooks\nk1 30313233343536373839616263646566\n"), 0o600))
+	keyID, got, err := webhook.LoadSigningKey(path)
+	require.NoError(t, err)
+	assert.Equal(t, "k1", keyID)
+	assert.Equal(t, secret, got)
+
+	require.NoError(t, os.WriteFile(path, []byte("k1 abcd\n"), 0o600))
+	_, _, err = webhook.LoadSigningKey(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,38 @@
+package hmacsign_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcosartorato/myapp/pkg/hmacsign"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	req := httptest.NewRequest(http.MethodPost, "/api/message?x=1", strings.NewReader(`{"type":"time"}`))
+	require.NoError(t, hmacsign.Sign(req, "k1", secret, time.Unix(1_700_000_000, 0)))
+
+	assert.Equal(t, "1700000000", req.Header.Get(hmacsign.HeaderTimestamp))
+	assert.Equal(t, hmacsign.BodyDigest([]byte(`{"type":"time"}`)), req.Header.Get(hmacsign.HeaderDigest))
+
+	keyID, sig, err := hmacsign.ParseSignature(req.Header.Get(hmacsign.HeaderSignature))
+	require.NoError(t, err)
+	assert.Equal(t, "k1", keyID)
+	want := hmacsign.Compute(secret, "POST\n/api/message?x=1\n1700000000\n"+req.Header.Get(hmacsign.HeaderDigest))
+	assert.Equal(t, want, sig)
+
+	// The body is still readable after signing.
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `{"type":"time"}`, string(body))
+
+	_, _, err = hmacsign.ParseSignature("keyId=k1")
+	assert.Error(t, err)
+}